@@ -141,6 +141,7 @@ export function useDDays(currentDate: Date = new Date()) {
                 createdBy: dday.createdBy,
                 connectedUsers: dday.connectedUsers || [],
                 editable: dday.editable,
+                version: dday.version,
             }));
 
             setDdays(formattedDdays);
@@ -260,6 +261,7 @@ export function useDDays(currentDate: Date = new Date()) {
     ): Promise<boolean> => {
         try {
             const payload: { [key: string]: any } = { ...updates };
+            delete payload.version;
 
             if (updates.date !== undefined) {
                 payload.date = formatDateForAPI(updates.date);
@@ -271,16 +273,32 @@ export function useDDays(currentDate: Date = new Date()) {
                 payload.imageUrl = updates.imageUrl;
             }
 
+            // the go side refuses the edit when the event changed since it was loaded
+            const headers: { [key: string]: string } = {
+                "Content-Type": "application/json",
+            };
+            const version = ddays.find((dday) => dday.id === id)?.version;
+            if (version !== undefined) {
+                headers["If-Match"] = `"${version}"`;
+            }
+
             const response = await fetch(
                 `${process.env.NEXT_PUBLIC_BACKEND_URL}/api/ddays/${id}`,
                 {
                     method: "PUT",
-                    headers: { "Content-Type": "application/json" },
+                    headers,
                     credentials: "include",
                     body: JSON.stringify(payload),
                 }
             );
 
+            if (response.status === 409) {
+                toast.error(
+                    "This event was changed in the meantime, showing the latest version"
+                );
+                await fetchDDays();
+                return false;
+            }
             if (!response.ok) {
                 throw new Error(`Failed to update D-day: ${response.status}`);
             }
@@ -12,6 +12,7 @@ export type DDay = {
     connectedUsers?: string[];
     imageUrl?: string;
     editable?: boolean; // if the event can be edited by the user
+    version?: number; // version the event was loaded at, sent back with edits
 };
 
 // defines the visual position of an event in a multiday layout (DDayIndicator, calendar grid)
@@ -0,0 +1,278 @@
+package api_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"calple/api"
+	"calple/config"
+	"calple/server/servertest"
+)
+
+// contract calls routes of /api/v1 and checks every response against the
+// operation of the spec it was served for
+type contract struct {
+	t *testing.T
+	s *servertest.Server
+	// routes that answered with their success status
+	served map[string]bool
+}
+
+// call sends body to path, which fills in the parameters of route ("GET
+// /ddays/:id"), and returns the "data" of the response. the call must
+// succeed, errors are checked against the spec too but fail the test.
+func (ct *contract) call(client *http.Client, route, path string, body interface{}) interface{} {
+	ct.t.Helper()
+	method, tmpl, _ := strings.Cut(route, " ")
+	r := findRoute(method, tmpl)
+	if r == nil {
+		ct.t.Fatalf("%s is not in api.Routes", route)
+	}
+
+	var reqBody io.Reader
+	if body != nil {
+		b, _ := json.Marshal(body)
+		reqBody = bytes.NewReader(b)
+	}
+	req, _ := http.NewRequest(method, ct.s.URL+"/api/v1"+path, reqBody)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		ct.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	raw, _ := io.ReadAll(resp.Body)
+
+	want := http.StatusOK
+	if r.Status != 0 {
+		want = r.Status
+	}
+	if resp.StatusCode != want {
+		ct.t.Errorf("%s %s: %d %s, want %d", method, path, resp.StatusCode, raw, want)
+	} else {
+		ct.served[route] = true
+	}
+
+	op := (*api.Spec().Paths[specPath(tmpl)])[strings.ToLower(method)]
+	spec := op.Responses[strconv.Itoa(resp.StatusCode)]
+	if spec == nil {
+		spec = op.Responses["default"]
+	}
+	if spec.Content == nil {
+		if len(raw) > 0 {
+			ct.t.Errorf("%s %s: the spec has no body for %d, got %s", method, path, resp.StatusCode, raw)
+		}
+		return nil
+	}
+	var v interface{}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		ct.t.Fatalf("%s %s: %d %q is not JSON", method, path, resp.StatusCode, raw)
+	}
+	for _, p := range api.Spec().Validate(spec.Content["application/json"].Schema, v) {
+		ct.t.Errorf("%s %s: %d %s", method, path, resp.StatusCode, p)
+	}
+	if m, ok := v.(map[string]interface{}); ok {
+		return m["data"]
+	}
+	return nil
+}
+
+// field walks a decoded JSON value by object keys and array indexes
+func field(v interface{}, path ...interface{}) string {
+	for _, p := range path {
+		switch k := p.(type) {
+		case string:
+			m, _ := v.(map[string]interface{})
+			v = m[k]
+		case int:
+			a, _ := v.([]interface{})
+			if k >= len(a) {
+				return ""
+			}
+			v = a[k]
+		}
+	}
+	switch v := v.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return ""
+}
+
+func findRoute(method, path string) *api.Route {
+	for i, r := range api.Routes {
+		if r.Method == method && r.Path == path {
+			return &api.Routes[i]
+		}
+	}
+	return nil
+}
+
+// specPath writes gin's :name segments as {name}
+func specPath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, s := range segments {
+		if strings.HasPrefix(s, ":") {
+			segments[i] = "{" + s[1:] + "}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// every route is called through the server as cmd sets it up, a handler
+// that answers differently than the spec says fails the test
+func TestContract(t *testing.T) {
+	s := servertest.Start(t)
+	// presigning happens locally, nothing is uploaded
+	s.Config.R2 = config.R2{AccountID: "account", AccessKeyID: "key", AccessKeySecret: "secret", BucketName: "images", PublicBucketID: "public"}
+	ct := &contract{t: t, s: s, served: map[string]bool{}}
+	ann := s.SignIn(t, "ann@example.com")
+	bob := s.SignIn(t, "bob@example.com")
+	today := time.Now().Format("2006-01-02")
+
+	ct.call(ann, "GET /auth/status", "/auth/status", nil)
+	ct.call(ann, "PUT /user/metadata", "/user/metadata", map[string]string{"sex": "female", "startedDating": "01/02/2020"})
+	ct.call(ann, "GET /user/metadata", "/user/metadata", nil)
+
+	// invitations, each answer once
+	pending := func() string {
+		t.Helper()
+		id := field(ct.call(bob, "GET /connection/pending", "/connection/pending", nil), 0, "id")
+		if id == "" {
+			t.Fatal("bob has no pending invitation")
+		}
+		return id
+	}
+	invite := map[string]string{"email": "bob@example.com"}
+	for _, answer := range []struct {
+		route  string
+		client *http.Client
+	}{
+		{"POST /connection/:id/decline", bob},
+		{"POST /connection/:id/reject", bob},
+		{"POST /connection/:id/cancel", ann},
+		{"POST /connection/:id/accept", bob},
+	} {
+		ct.call(ann, "POST /connection/invite", "/connection/invite", invite)
+		id := pending()
+		ct.call(answer.client, answer.route, strings.Replace(answer.route[strings.Index(answer.route, "/"):], ":id", id, 1), nil)
+	}
+	conn := ct.call(ann, "GET /connection", "/connection", nil)
+	ct.call(bob, "GET /user/partner/metadata", "/user/partner/metadata", nil)
+	ct.call(ann, "POST /connection/:id/unlink", "/connection/"+field(conn, "connectionId")+"/unlink", map[string]string{"ddays": "keep"})
+	ct.call(ann, "GET /connection/history", "/connection/history", nil)
+
+	// invite links
+	revoked := ct.call(ann, "POST /connection/invite-links", "/connection/invite-links", map[string]int{"expiresInHours": 24})
+	ct.call(ann, "DELETE /connection/invite-links/:id", "/connection/invite-links/"+field(revoked, "id"), nil)
+	link := ct.call(ann, "POST /connection/invite-links", "/connection/invite-links", nil)
+	ct.call(ann, "GET /connection/invite-links", "/connection/invite-links", nil)
+	ct.call(bob, "POST /connection/invite-links/redeem", "/connection/invite-links/redeem", map[string]string{"token": field(link, "token")})
+	ct.call(ann, "GET /debug/connection", "/debug/connection", nil)
+
+	blocked := ct.call(ann, "POST /connection/blocked", "/connection/blocked", map[string]string{"email": "carol@example.com"})
+	ct.call(ann, "GET /connection/blocked", "/connection/blocked", nil)
+	ct.call(ann, "DELETE /connection/blocked/:id", "/connection/blocked/"+field(blocked, "id"), nil)
+
+	// events of the couple
+	dday := ct.call(ann, "POST /ddays", "/ddays", map[string]string{"title": "Anniversary", "date": time.Now().Format("20060102")})
+	ddayPath := "/ddays/" + field(dday, "id")
+	ct.call(bob, "GET /ddays", "/ddays?view="+time.Now().Format("200601"), nil)
+	ct.call(ann, "PUT /ddays/:id", ddayPath, map[string]string{"title": "First date"})
+	ct.call(ann, "GET /ddays/:id/revisions", ddayPath+"/revisions", nil)
+	ct.call(ann, "POST /ddays/:id/revisions/:version/revert", ddayPath+"/revisions/1/revert", nil)
+	ct.call(ann, "POST /ddays/upload-url", "/ddays/upload-url", map[string]int{"fileSize": 1024})
+
+	// ideas
+	idea := ct.call(ann, "POST /ideas", "/ideas", map[string]interface{}{"title": "Picnic", "tags": []string{"outside"}})
+	ideaPath := "/ideas/" + field(idea, "id")
+	ct.call(ann, "GET /ideas", "/ideas", nil)
+	ct.call(ann, "GET /ideas/all", "/ideas/all", nil)
+	ct.call(ann, "PUT /ideas/:id", ideaPath, map[string]string{"title": "Picnic by the lake"})
+	roulette := ct.call(ann, "POST /roulette", "/roulette", map[string]string{"title": "Bowling"})
+	roulettePath := "/roulette/" + field(roulette, "id")
+	ct.call(ann, "GET /roulette", "/roulette", nil)
+	ct.call(ann, "PUT /roulette/:id", roulettePath, map[string]string{"title": "Karaoke"})
+	ct.call(ann, "DELETE /roulette/:id", roulettePath, nil)
+
+	// period tracking
+	ct.call(ann, "POST /periods/days", "/periods/days", map[string]interface{}{"date": today, "isPeriod": true, "crampIntensity": 3, "mood": []string{"calm"}})
+	ct.call(ann, "GET /periods/days", "/periods/days", nil)
+	ct.call(bob, "GET /periods/partner/days", "/periods/partner/days", nil)
+	ct.call(ann, "GET /periods/settings", "/periods/settings", nil)
+	ct.call(ann, "PUT /periods/settings", "/periods/settings", map[string]int{"cycleLength": 30, "periodLength": 6})
+	ct.call(ann, "DELETE /periods/days/:date", "/periods/days/"+today, nil)
+
+	// checkins
+	ct.call(ann, "POST /checkin", "/checkin", map[string]string{"date": today, "mood": "happy", "energy": "high"})
+	ct.call(ann, "GET /checkin/:date", "/checkin/"+today, nil)
+	ct.call(bob, "GET /checkin/partner/:date", "/checkin/partner/"+today, nil)
+
+	// pins
+	pin := ct.call(ann, "POST /pins", "/pins", map[string]interface{}{"lat": 37.5, "lng": 127, "title": "Cafe", "date": today})
+	pinPath := "/pins/" + field(pin, "id")
+	ct.call(bob, "GET /pins", "/pins", nil)
+	ct.call(ann, "PUT /pins/:id", pinPath, map[string]interface{}{"lat": 37.5, "lng": 127, "title": "Old cafe", "date": today})
+
+	// deleted things wait in the trash
+	ct.call(ann, "DELETE /ddays/:id", ddayPath, nil)
+	ct.call(ann, "DELETE /ideas/:id", ideaPath, nil)
+	ct.call(ann, "DELETE /checkin/:date", "/checkin/"+today, nil)
+	ct.call(ann, "DELETE /pins/:id", pinPath, nil)
+	ct.call(ann, "GET /trash", "/trash", nil)
+	ct.call(ann, "POST /ddays/:id/restore", ddayPath+"/restore", nil)
+	ct.call(ann, "POST /ideas/:id/restore", ideaPath+"/restore", nil)
+	ct.call(ann, "POST /checkin/:date/restore", "/checkin/"+today+"/restore", nil)
+	ct.call(ann, "POST /pins/:id/restore", pinPath+"/restore", nil)
+
+	ct.call(ann, "POST /feedback", "/feedback", map[string]string{"feedbackText": "Lovely", "category": "general"})
+	ct.call(ann, "GET /feedback", "/feedback", nil)
+
+	// account management. a second sign in method, linked earlier
+	_, err := s.Firestore.Collection("identities").Doc("github_1").Set(context.Background(), map[string]interface{}{
+		"uid": s.UID(t, "ann@example.com"), "provider": "github", "subject": "1", "email": "ann@example.com",
+		"primary": false, "createdAt": time.Now(), "lastLoginAt": time.Now(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ct.call(ann, "GET /auth/identities", "/auth/identities", nil)
+	ct.call(ann, "DELETE /auth/identities/:id", "/auth/identities/github_1", nil)
+
+	s.SignIn(t, "ann@example.com")
+	var other string
+	sessions, _ := ct.call(ann, "GET /sessions", "/sessions", nil).([]interface{})
+	for _, sess := range sessions {
+		if m, _ := sess.(map[string]interface{}); m["current"] != true {
+			other = field(m, "id")
+		}
+	}
+	ct.call(ann, "DELETE /sessions/:id", "/sessions/"+other, nil)
+	s.SignIn(t, "ann@example.com")
+	ct.call(ann, "POST /sessions/revoke-others", "/sessions/revoke-others", nil)
+
+	ct.call(ann, "POST /tokens", "/tokens", map[string]interface{}{"name": "script", "scopes": []string{"read:ddays"}})
+	ct.call(ann, "POST /tokens/app", "/tokens/app", map[string]interface{}{"name": "phone", "scopes": []string{"read:ddays"}})
+	ct.call(ann, "DELETE /tokens/:id", "/tokens/"+field(ct.call(ann, "GET /tokens", "/tokens", nil), 0, "id"), nil)
+	ct.call(ann, "GET /audit", "/audit?limit=10", nil)
+
+	carol := s.SignIn(t, "carol@example.com")
+	ct.call(carol, "DELETE /user", "/user", nil)
+
+	for _, r := range api.Routes {
+		if !r.Stream && !ct.served[r.Method+" "+r.Path] {
+			t.Errorf("%s %s is not covered by the contract test", r.Method, r.Path)
+		}
+	}
+}
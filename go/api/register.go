@@ -0,0 +1,189 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"calple/handlers"
+	"calple/logging"
+	"calple/ratelimit"
+)
+
+type Options struct {
+	// Versioned wraps responses in the v1 envelope and serves the spec at
+	// /openapi.json, without it handlers answer as they always did
+	Versioned bool
+	// CheckContract validates v1 responses against the spec and logs
+	// every mismatch, meant for development
+	CheckContract bool
+	// Limit turns the rate limit policy of a route into middleware
+	Limit func(ratelimit.Policy) gin.HandlerFunc
+}
+
+// StreamPaths are the full paths of the Stream routes under each API root,
+// for handlers.RequestTimeout
+func StreamPaths(roots ...string) []string {
+	var paths []string
+	for _, root := range roots {
+		for _, r := range Routes {
+			if r.Stream {
+				paths = append(paths, root+r.Path)
+			}
+		}
+	}
+	return paths
+}
+
+// Register adds the routes to group
+func Register(group *gin.RouterGroup, opts Options) {
+	if opts.Versioned {
+		group.GET("/openapi.json", serveSpec)
+	}
+	for i := range Routes {
+		r := &Routes[i]
+
+		var chain []gin.HandlerFunc
+		switch {
+		case r.Session:
+			chain = append(chain, handlers.RequireSession())
+		case r.Scope != "":
+			// requests with a bearer token need the scope of the resource,
+			// session requests pass through
+			chain = append(chain, handlers.RequireScope(r.Scope))
+		}
+		if r.Limit != nil && opts.Limit != nil {
+			chain = append(chain, opts.Limit(*r.Limit))
+		}
+		if opts.Versioned && !r.Stream {
+			chain = append(chain, envelope(r, opts.CheckContract))
+		}
+		chain = append(chain, r.Handler)
+		group.Handle(r.Method, r.Path, chain...)
+	}
+}
+
+// envelope holds the handler's response back and rewrites successful JSON
+// bodies into {"data": ..., "meta": ...}. errors pass through untouched,
+// they already share one shape.
+func envelope(r *Route, check bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		w := &bufferedWriter{ResponseWriter: c.Writer, status: http.StatusOK}
+		c.Writer = w
+		// restored before a panic reaches the recovery middleware too
+		defer func() { c.Writer = w.ResponseWriter }()
+		c.Next()
+
+		body := w.body.Bytes()
+		if w.status < 200 || w.status >= 300 || len(body) == 0 ||
+			!strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+			w.flush(body)
+			return
+		}
+		wrapped, err := wrap(body, r.Data)
+		if err != nil {
+			logging.FromContext(c.Request.Context()).Error("failed to wrap response", "route", r.Method+" "+r.Path, "error", err)
+			w.flush(body)
+			return
+		}
+		if check {
+			checkContract(c, r, wrapped)
+		}
+		w.flush(wrapped)
+	}
+}
+
+func wrap(body []byte, key string) ([]byte, error) {
+	var env struct {
+		Data json.RawMessage            `json:"data"`
+		Meta map[string]json.RawMessage `json:"meta,omitempty"`
+	}
+	if key == "" {
+		env.Data = body
+		return json.Marshal(env)
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, err
+	}
+	env.Data = fields[key]
+	delete(fields, key)
+	if len(fields) > 0 {
+		env.Meta = fields
+	}
+	return json.Marshal(env)
+}
+
+// checkContract logs where a v1 response differs from its schema
+func checkContract(c *gin.Context, r *Route, body []byte) {
+	path, _ := pathParams(r.Path)
+	op := (*Spec().Paths[path])[strings.ToLower(r.Method)]
+	ok := op.Responses[strconv.Itoa(r.status())]
+	if ok == nil || ok.Content == nil {
+		return
+	}
+
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err != nil {
+		return
+	}
+	if problems := Spec().Validate(ok.Content["application/json"].Schema, v); len(problems) > 0 {
+		logging.FromContext(c.Request.Context()).Error("response does not match the API spec",
+			"operation", op.OperationID, "problems", problems)
+	}
+}
+
+// bufferedWriter keeps the status and body until flush
+type bufferedWriter struct {
+	gin.ResponseWriter
+	status  int
+	written bool
+	body    bytes.Buffer
+}
+
+func (w *bufferedWriter) WriteHeader(code int) {
+	if code > 0 {
+		w.status = code
+	}
+}
+
+func (w *bufferedWriter) WriteHeaderNow() {
+	w.written = true
+}
+
+func (w *bufferedWriter) Write(b []byte) (int, error) {
+	w.written = true
+	return w.body.Write(b)
+}
+
+func (w *bufferedWriter) WriteString(s string) (int, error) {
+	w.written = true
+	return w.body.WriteString(s)
+}
+
+func (w *bufferedWriter) Status() int {
+	return w.status
+}
+
+func (w *bufferedWriter) Size() int {
+	if !w.written {
+		return -1
+	}
+	return w.body.Len()
+}
+
+func (w *bufferedWriter) Written() bool {
+	return w.written
+}
+
+func (w *bufferedWriter) flush(body []byte) {
+	w.ResponseWriter.WriteHeader(w.status)
+	if len(body) > 0 {
+		w.ResponseWriter.Write(body)
+	}
+}
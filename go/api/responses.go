@@ -0,0 +1,164 @@
+package api
+
+import (
+	"calple/audit"
+	"calple/handlers"
+)
+
+// the bodies handlers build with gin.H, named so the spec can describe them.
+// keep them in step with the handlers, the contract check reports drift.
+
+type Message struct {
+	Message string `json:"message"`
+}
+
+type AuthStatus struct {
+	Authenticated bool                  `json:"authenticated"`
+	User          *handlers.SelfProfile `json:"user,omitempty"`
+}
+
+type DDayList struct {
+	DDays []handlers.DDay `json:"ddays"`
+	// the view query parameter the events were loaded for
+	Date string `json:"date"`
+}
+
+type DDayResult struct {
+	DDay handlers.DDay `json:"dday"`
+}
+
+type DDayRevisionList struct {
+	Revisions []handlers.DDayRevision `json:"revisions"`
+}
+
+type UploadURL struct {
+	UploadURL string `json:"uploadUrl"`
+	PublicURL string `json:"publicUrl"`
+}
+
+type ConnectionStatus struct {
+	Connected    bool                     `json:"connected"`
+	ConnectionID string                   `json:"connectionId,omitempty"`
+	Partner      *handlers.PartnerProfile `json:"partner,omitempty"`
+}
+
+type InvitationList struct {
+	Invitations []handlers.Invitation `json:"invitations"`
+}
+
+type ConnectionHistory struct {
+	History []handlers.ConnectionHistoryEntry `json:"history"`
+}
+
+type BlockedList struct {
+	Blocked []handlers.BlockedUser `json:"blocked"`
+}
+
+type BlockedResult struct {
+	Blocked handlers.BlockedUser `json:"blocked"`
+}
+
+type InviteLinkList struct {
+	Invites []handlers.InviteLink `json:"invites"`
+}
+
+type InviteLinkResult struct {
+	Invite handlers.InviteLink `json:"invite"`
+}
+
+type RedeemedInvite struct {
+	Message      string `json:"message"`
+	ConnectionID string `json:"connectionId"`
+}
+
+type PeriodDayList struct {
+	PeriodDays []handlers.PeriodDay `json:"periodDays"`
+}
+
+type PartnerPeriodDays struct {
+	PeriodDays []handlers.PeriodDay `json:"periodDays"`
+	PartnerSex string               `json:"partnerSex"`
+}
+
+type CycleSettingsResult struct {
+	CycleSettings handlers.CycleSettings `json:"cycleSettings"`
+}
+
+type UserMetadataResult struct {
+	UserMetadata handlers.SelfProfile `json:"userMetadata"`
+}
+
+type PartnerMetadataResult struct {
+	PartnerMetadata handlers.PartnerProfile `json:"partnerMetadata"`
+}
+
+type CheckinResult struct {
+	Checkin handlers.CheckinData `json:"checkin"`
+}
+
+type PartnerCheckinResult struct {
+	PartnerCheckin handlers.PartnerCheckin `json:"partnerCheckin"`
+}
+
+type Pins struct {
+	Pins        []handlers.Pin `json:"pins"`
+	PartnerPins []handlers.Pin `json:"partnerPins"`
+}
+
+type CreatedPin struct {
+	ID string `json:"id"`
+}
+
+type IdentityList struct {
+	Identities []handlers.LinkedIdentity `json:"identities"`
+}
+
+type SessionList struct {
+	Sessions []handlers.SessionInfo `json:"sessions"`
+}
+
+type RevokedSessions struct {
+	Message string `json:"message"`
+	Revoked int    `json:"revoked"`
+}
+
+type APITokenList struct {
+	Tokens          []handlers.APIToken `json:"tokens"`
+	AvailableScopes []string            `json:"availableScopes"`
+}
+
+type CreatedAPIToken struct {
+	// shown once, only its hash is stored
+	Token string            `json:"token"`
+	Info  handlers.APIToken `json:"info"`
+}
+
+// TokenResponse is an OAuth 2 token response
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+	Scope        string `json:"scope"`
+}
+
+// Feedback is a stored feedback document with its ID
+type Feedback map[string]interface{}
+
+type AuditLog struct {
+	Entries []audit.Entry `json:"entries"`
+	// path of the next page, absent on the last one
+	Next string `json:"next,omitempty"`
+}
+
+type DebugConnection struct {
+	UserID        string                 `json:"userId"`
+	UserEmail     string                 `json:"userEmail"`
+	HasConnection bool                   `json:"hasConnection"`
+	ConnectionID  string                 `json:"connectionId,omitempty"`
+	Connection    map[string]interface{} `json:"connection,omitempty"`
+}
+
+type TrashList struct {
+	Items []handlers.TrashItem `json:"items"`
+}
@@ -0,0 +1,230 @@
+// Package api registers the JSON API. one table of routes drives both the
+// router and the OpenAPI document, so a route cannot be served without
+// being described.
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"calple/events"
+	"calple/handlers"
+	"calple/openapi"
+	"calple/ratelimit"
+)
+
+// Route is one operation of the API
+type Route struct {
+	Method  string
+	Path    string // relative to the API root, gin syntax
+	Summary string
+	Tag     string
+	Handler gin.HandlerFunc
+
+	// Scope is the resource an API token needs a read: or write: scope for.
+	// Session routes refuse tokens, Public routes need no sign in.
+	Scope string
+	// ScopeFilter is for routes that serve several resources instead of one
+	// Scope: any token gets in, and sees what its read: scopes cover
+	ScopeFilter []string
+	Session     bool
+	Public      bool
+	Limit       *ratelimit.Policy
+
+	Query []openapi.Parameter
+	// Request is the JSON body, OptionalBody when it may be left out
+	Request      interface{}
+	OptionalBody bool
+	// Response is the body the handler writes, nil when there is none. in v1
+	// the Data property of it becomes "data" and the other properties
+	// "meta", without Data the whole body is "data".
+	Response interface{}
+	Data     string
+	Status   int
+	// Stream routes answer with server-sent events of Response, never
+	// wrapped and without the request deadline
+	Stream bool
+}
+
+// ifMatchVersion makes an edit of an event fail with 409 when the event is
+// no longer at the version of its ETag
+var ifMatchVersion = openapi.Parameter{Name: "If-Match", In: "header", Description: "ETag of the version the edit is based on", Schema: &openapi.Schema{Type: "string"}}
+
+// Routes of the API
+var Routes = []Route{
+	{Method: http.MethodGet, Path: "/auth/status", Summary: "Whether the request is signed in, with the profile when it is", Tag: "auth",
+		Handler: handlers.AuthStatus, Public: true, Response: AuthStatus{}},
+
+	// live updates
+	{Method: http.MethodGet, Path: "/events", Summary: "Stream changes to the user's and the partner's data as server-sent events", Tag: "events",
+		Handler: handlers.Events, ScopeFilter: events.Resources(), Response: events.Event{}, Stream: true,
+		Query: []openapi.Parameter{
+			{Name: "Last-Event-ID", In: "header", Description: "ID of the last event received, the events after it are sent first", Schema: &openapi.Schema{Type: "string"}},
+			{Name: "lastEventId", In: "query", Description: "Last-Event-ID for clients that cannot set headers", Schema: &openapi.Schema{Type: "string"}},
+		}},
+
+	// events
+	{Method: http.MethodGet, Path: "/ddays", Summary: "List events shared with the user", Tag: "ddays",
+		Handler: handlers.GetDDays, Scope: "ddays", Response: DDayList{}, Data: "ddays",
+		Query: []openapi.Parameter{{Name: "view", In: "query", Description: "month the events are viewed for as 200601, echoed back", Required: true,
+			Schema: &openapi.Schema{Type: "string", Pattern: `^[0-9]{4}(0[1-9]|1[0-2])$`}}}},
+	{Method: http.MethodPost, Path: "/ddays", Summary: "Create an event", Tag: "ddays",
+		Handler: handlers.CreateDDay, Scope: "ddays", Request: handlers.DDay{}, Response: DDayResult{}, Data: "dday", Status: http.StatusCreated},
+	{Method: http.MethodPut, Path: "/ddays/:id", Summary: "Update the fields of an event that are present, 409 when it changed since the version sent", Tag: "ddays",
+		Handler: handlers.UpdateDDay, Scope: "ddays", Request: handlers.DDayUpdate{}, Response: DDayResult{}, Data: "dday",
+		Query: []openapi.Parameter{ifMatchVersion}},
+	{Method: http.MethodDelete, Path: "/ddays/:id", Summary: "Delete an event", Tag: "ddays",
+		Handler: handlers.DeleteDDay, Scope: "ddays", Response: Message{}},
+	{Method: http.MethodPost, Path: "/ddays/:id/restore", Summary: "Restore an event of the user from the trash as a new version, the ETag is the new one", Tag: "ddays",
+		Handler: handlers.RestoreDDay, Scope: "ddays", Response: Message{}},
+	{Method: http.MethodGet, Path: "/ddays/:id/revisions", Summary: "Edit history of an event, newest first", Tag: "ddays",
+		Handler: handlers.GetDDayRevisions, Scope: "ddays", Response: DDayRevisionList{}, Data: "revisions"},
+	{Method: http.MethodPost, Path: "/ddays/:id/revisions/:version/revert", Summary: "Set an event back to a revision, as a new revision", Tag: "ddays",
+		Handler: handlers.RevertDDay, Scope: "ddays", Response: DDayResult{}, Data: "dday",
+		Query: []openapi.Parameter{ifMatchVersion}},
+	{Method: http.MethodPost, Path: "/ddays/upload-url", Summary: "Get a presigned URL to upload an event image to", Tag: "ddays",
+		Handler: handlers.GetDDayUploadURL, Scope: "ddays", Limit: &ratelimit.Upload, Request: handlers.UploadRequest{}, Response: UploadURL{}},
+
+	// connection
+	{Method: http.MethodGet, Path: "/connection", Summary: "The active connection and partner", Tag: "connection",
+		Handler: handlers.GetConnection, Scope: "connection", Response: ConnectionStatus{}},
+	{Method: http.MethodPost, Path: "/connection/invite", Summary: "Invite a partner by email, the answer is the same whether the account exists or not", Tag: "connection",
+		Handler: handlers.InviteConnection, Scope: "connection", Limit: &ratelimit.Invite, Request: handlers.InviteRequest{}, Response: Message{}},
+	{Method: http.MethodGet, Path: "/connection/pending", Summary: "Invitations waiting for the user's answer", Tag: "connection",
+		Handler: handlers.GetPendingInvitations, Scope: "connection", Response: InvitationList{}, Data: "invitations"},
+	{Method: http.MethodPost, Path: "/connection/:id/accept", Summary: "Accept an invitation", Tag: "connection",
+		Handler: handlers.AcceptInvitation, Scope: "connection", Response: Message{}},
+	{Method: http.MethodPost, Path: "/connection/:id/reject", Summary: "Reject an invitation", Tag: "connection",
+		Handler: handlers.RejectInvitation, Scope: "connection", Response: Message{}},
+	{Method: http.MethodPost, Path: "/connection/:id/cancel", Summary: "Cancel an invitation the user sent", Tag: "connection",
+		Handler: handlers.CancelInvitation, Scope: "connection", Response: Message{}},
+	{Method: http.MethodPost, Path: "/connection/:id/decline", Summary: "Decline an invitation", Tag: "connection",
+		Handler: handlers.DeclineInvitation, Scope: "connection", Response: Message{}},
+	{Method: http.MethodPost, Path: "/connection/:id/unlink", Summary: "End the active connection", Tag: "connection",
+		Handler: handlers.UnlinkConnection, Scope: "connection", Request: handlers.UnlinkRequest{}, OptionalBody: true, Response: Message{}},
+	{Method: http.MethodGet, Path: "/connection/history", Summary: "Past connections, latest first", Tag: "connection",
+		Handler: handlers.GetConnectionHistory, Scope: "connection", Response: ConnectionHistory{}, Data: "history"},
+	{Method: http.MethodGet, Path: "/connection/blocked", Summary: "Emails the user blocked", Tag: "connection",
+		Handler: handlers.GetBlockedUsers, Scope: "connection", Response: BlockedList{}, Data: "blocked"},
+	{Method: http.MethodPost, Path: "/connection/blocked", Summary: "Block invitations from an email", Tag: "connection",
+		Handler: handlers.BlockUser, Scope: "connection", Request: handlers.BlockRequest{}, Response: BlockedResult{}, Data: "blocked"},
+	{Method: http.MethodDelete, Path: "/connection/blocked/:id", Summary: "Unblock an email", Tag: "connection",
+		Handler: handlers.UnblockUser, Scope: "connection", Response: Message{}},
+	{Method: http.MethodGet, Path: "/connection/invite-links", Summary: "Invite links the user created", Tag: "connection",
+		Handler: handlers.GetInviteLinks, Scope: "connection", Response: InviteLinkList{}, Data: "invites"},
+	{Method: http.MethodPost, Path: "/connection/invite-links", Summary: "Create an invite link", Tag: "connection",
+		Handler: handlers.CreateInviteLink, Scope: "connection", Request: handlers.CreateInviteLinkRequest{}, OptionalBody: true, Response: InviteLinkResult{}, Data: "invite", Status: http.StatusCreated},
+	{Method: http.MethodDelete, Path: "/connection/invite-links/:id", Summary: "Revoke an invite link", Tag: "connection",
+		Handler: handlers.RevokeInviteLink, Scope: "connection", Response: Message{}},
+	{Method: http.MethodPost, Path: "/connection/invite-links/redeem", Summary: "Accept an invite link by token or code", Tag: "connection",
+		Handler: handlers.RedeemInviteLink, Scope: "connection", Limit: &ratelimit.Invite, Request: handlers.RedeemInviteRequest{}, Response: RedeemedInvite{}},
+
+	// ideas
+	{Method: http.MethodGet, Path: "/ideas/all", Summary: "List every idea", Tag: "ideas",
+		Handler: handlers.GetAllPosts, Scope: "ideas", Response: []handlers.Idea{}},
+	{Method: http.MethodGet, Path: "/ideas", Summary: "List the user's ideas", Tag: "ideas",
+		Handler: handlers.GetPost, Scope: "ideas", Response: []handlers.Idea{}},
+	{Method: http.MethodPost, Path: "/ideas", Summary: "Create an idea", Tag: "ideas",
+		Handler: handlers.AddPost, Scope: "ideas", Request: handlers.Idea{}, Response: handlers.Idea{}, Status: http.StatusCreated},
+	{Method: http.MethodPut, Path: "/ideas/:id", Summary: "Update an idea", Tag: "ideas",
+		Handler: handlers.UpdatePost, Scope: "ideas", Request: handlers.Idea{}, Response: Message{}},
+	{Method: http.MethodDelete, Path: "/ideas/:id", Summary: "Delete an idea", Tag: "ideas",
+		Handler: handlers.DeletePost, Scope: "ideas", Response: Message{}},
+	{Method: http.MethodPost, Path: "/ideas/:id/restore", Summary: "Restore an idea from the trash", Tag: "ideas",
+		Handler: handlers.RestorePost, Scope: "ideas", Response: Message{}},
+	{Method: http.MethodGet, Path: "/roulette", Summary: "List roulette ideas", Tag: "ideas",
+		Handler: handlers.GetIdeaRoulette, Scope: "ideas", Response: []handlers.Roulette{}},
+	{Method: http.MethodPost, Path: "/roulette", Summary: "Add a roulette idea", Tag: "ideas",
+		Handler: handlers.AddIdeaRoulette, Scope: "ideas", Request: handlers.Roulette{}, Response: handlers.Roulette{}, Status: http.StatusCreated},
+	{Method: http.MethodPut, Path: "/roulette/:id", Summary: "Update a roulette idea", Tag: "ideas",
+		Handler: handlers.EditIdeaRoulette, Scope: "ideas", Request: handlers.Roulette{}, Response: Message{}},
+	{Method: http.MethodDelete, Path: "/roulette/:id", Summary: "Delete a roulette idea", Tag: "ideas",
+		Handler: handlers.DeleteIdeaRoulette, Scope: "ideas", Response: Message{}},
+
+	// period tracking
+	{Method: http.MethodGet, Path: "/periods/days", Summary: "List the user's period days", Tag: "periods",
+		Handler: handlers.GetPeriodDays, Scope: "periods", Response: PeriodDayList{}, Data: "periodDays"},
+	{Method: http.MethodGet, Path: "/periods/partner/days", Summary: "List the partner's period days", Tag: "periods",
+		Handler: handlers.GetPartnerPeriodDays, Scope: "periods", Response: PartnerPeriodDays{}, Data: "periodDays"},
+	{Method: http.MethodPost, Path: "/periods/days", Summary: "Record a period day, an existing day of the same date is updated", Tag: "periods",
+		Handler: handlers.CreatePeriodDay, Scope: "periods", Request: handlers.PeriodDay{}, Response: handlers.PeriodDay{}, Status: http.StatusCreated},
+	{Method: http.MethodDelete, Path: "/periods/days/:date", Summary: "Delete the period day of a date", Tag: "periods",
+		Handler: handlers.DeletePeriodDay, Scope: "periods", Response: Message{}},
+	{Method: http.MethodGet, Path: "/periods/settings", Summary: "Cycle settings, defaults when none are saved", Tag: "periods",
+		Handler: handlers.GetCycleSettings, Scope: "periods", Response: CycleSettingsResult{}, Data: "cycleSettings"},
+	{Method: http.MethodPut, Path: "/periods/settings", Summary: "Save cycle settings", Tag: "periods",
+		Handler: handlers.UpdateCycleSettings, Scope: "periods", Request: handlers.CycleSettings{}, Response: CycleSettingsResult{}, Data: "cycleSettings"},
+
+	// profile
+	{Method: http.MethodGet, Path: "/user/metadata", Summary: "The user's profile", Tag: "profile",
+		Handler: handlers.GetUserMetadata, Scope: "profile", Response: UserMetadataResult{}, Data: "userMetadata"},
+	{Method: http.MethodPut, Path: "/user/metadata", Summary: "Update the user's profile", Tag: "profile",
+		Handler: handlers.UpdateUserMetadata, Scope: "profile", Request: handlers.UpdateUserMetadataRequest{}, Response: UserMetadataResult{}, Data: "userMetadata"},
+	{Method: http.MethodGet, Path: "/user/partner/metadata", Summary: "The partner's profile", Tag: "profile",
+		Handler: handlers.GetPartnerMetadata, Scope: "profile", Response: PartnerMetadataResult{}, Data: "partnerMetadata"},
+
+	// checkins
+	{Method: http.MethodPost, Path: "/checkin", Summary: "Save the checkin of a date", Tag: "checkins",
+		Handler: handlers.CreateCheckin, Scope: "checkins", Request: handlers.CheckinData{}, Response: CheckinResult{}, Data: "checkin"},
+	{Method: http.MethodGet, Path: "/checkin/:date", Summary: "The user's checkin of a date", Tag: "checkins",
+		Handler: handlers.GetTodayCheckin, Scope: "checkins", Response: CheckinResult{}, Data: "checkin"},
+	{Method: http.MethodDelete, Path: "/checkin/:date", Summary: "Delete the checkin of a date", Tag: "checkins",
+		Handler: handlers.DeleteCheckin, Scope: "checkins", Response: Message{}},
+	{Method: http.MethodPost, Path: "/checkin/:date/restore", Summary: "Restore the latest deleted checkin of a date, unless the date has one again", Tag: "checkins",
+		Handler: handlers.RestoreCheckin, Scope: "checkins", Response: Message{}},
+	{Method: http.MethodGet, Path: "/checkin/partner/:date", Summary: "The partner's checkin of a date", Tag: "checkins",
+		Handler: handlers.GetPartnerCheckin, Scope: "checkins", Response: PartnerCheckinResult{}, Data: "partnerCheckin"},
+
+	// feedback
+	{Method: http.MethodPost, Path: "/feedback", Summary: "Send feedback", Tag: "feedback",
+		Handler: handlers.SubmitFeedback, Scope: "feedback", Limit: &ratelimit.Feedback, Request: handlers.FeedbackPayload{}, Response: Message{}, Status: http.StatusCreated},
+	{Method: http.MethodGet, Path: "/feedback", Summary: "Feedback the user sent", Tag: "feedback",
+		Handler: handlers.GetUserFeedback, Scope: "feedback", Response: []Feedback{}},
+
+	// map pins
+	{Method: http.MethodGet, Path: "/pins", Summary: "Pins of the user and the partner", Tag: "pins",
+		Handler: handlers.GetPins, Scope: "pins", Response: Pins{}},
+	{Method: http.MethodPost, Path: "/pins", Summary: "Create a pin", Tag: "pins",
+		Handler: handlers.CreatePin, Scope: "pins", Request: handlers.PinRequest{}, Response: CreatedPin{}, Status: http.StatusCreated},
+	{Method: http.MethodPut, Path: "/pins/:id", Summary: "Update a pin", Tag: "pins",
+		Handler: handlers.UpdatePin, Scope: "pins", Request: handlers.PinRequest{}, Status: http.StatusNoContent},
+	{Method: http.MethodDelete, Path: "/pins/:id", Summary: "Delete a pin", Tag: "pins",
+		Handler: handlers.DeletePin, Scope: "pins", Status: http.StatusNoContent},
+	{Method: http.MethodPost, Path: "/pins/:id/restore", Summary: "Restore a pin from the trash", Tag: "pins",
+		Handler: handlers.RestorePin, Scope: "pins", Status: http.StatusNoContent},
+
+	// trash
+	{Method: http.MethodGet, Path: "/trash", Summary: "Deleted events, pins, ideas and checkins that can still be restored, latest first", Tag: "trash",
+		Handler: handlers.GetTrash, ScopeFilter: handlers.TrashResources(), Response: TrashList{}, Data: "items"},
+
+	// account management, browser sessions only
+	{Method: http.MethodDelete, Path: "/user", Summary: "Delete the account", Tag: "account",
+		Handler: handlers.DeleteUser, Session: true, Response: Message{}},
+	{Method: http.MethodGet, Path: "/auth/identities", Summary: "Linked sign in methods", Tag: "account",
+		Handler: handlers.GetIdentities, Session: true, Response: IdentityList{}, Data: "identities"},
+	{Method: http.MethodDelete, Path: "/auth/identities/:id", Summary: "Unlink a sign in method", Tag: "account",
+		Handler: handlers.UnlinkIdentity, Session: true, Response: Message{}},
+	{Method: http.MethodGet, Path: "/sessions", Summary: "Signed in sessions", Tag: "account",
+		Handler: handlers.GetSessions, Session: true, Response: SessionList{}, Data: "sessions"},
+	{Method: http.MethodDelete, Path: "/sessions/:id", Summary: "Sign a session out", Tag: "account",
+		Handler: handlers.RevokeSession, Session: true, Response: Message{}},
+	{Method: http.MethodPost, Path: "/sessions/revoke-others", Summary: "Sign every other session out", Tag: "account",
+		Handler: handlers.RevokeOtherSessions, Session: true, Response: RevokedSessions{}},
+	{Method: http.MethodGet, Path: "/tokens", Summary: "Personal access tokens and app grants", Tag: "account",
+		Handler: handlers.GetAPITokens, Session: true, Response: APITokenList{}, Data: "tokens"},
+	{Method: http.MethodPost, Path: "/tokens", Summary: "Create a personal access token", Tag: "account",
+		Handler: handlers.CreateAPIToken, Session: true, Request: handlers.CreateAPITokenRequest{}, Response: CreatedAPIToken{}, Status: http.StatusCreated},
+	{Method: http.MethodPost, Path: "/tokens/app", Summary: "Start a refresh grant for a native client", Tag: "account",
+		Handler: handlers.CreateAppToken, Session: true, Request: handlers.CreateAppTokenRequest{}, Response: TokenResponse{}, Status: http.StatusCreated},
+	{Method: http.MethodDelete, Path: "/tokens/:id", Summary: "Revoke a token or grant", Tag: "account",
+		Handler: handlers.RevokeAPIToken, Session: true, Response: Message{}},
+	{Method: http.MethodGet, Path: "/audit", Summary: "Actions of the user and on the user's data, latest first", Tag: "account",
+		Handler: handlers.GetAuditLog, Session: true, Response: AuditLog{}, Data: "entries",
+		Query: []openapi.Parameter{
+			{Name: "limit", In: "query", Description: "entries per page from 1 to 100, 50 by default", Schema: &openapi.Schema{Type: "integer"}},
+			{Name: "before", In: "query", Description: "ID of the last entry of the previous page, meta.next has it", Schema: &openapi.Schema{Type: "string"}},
+		}},
+	{Method: http.MethodGet, Path: "/debug/connection", Summary: "Connection state for debugging", Tag: "account",
+		Handler: handlers.DebugConnection, Session: true, Response: DebugConnection{}},
+}
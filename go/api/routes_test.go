@@ -0,0 +1,19 @@
+package api
+
+import "testing"
+
+// every route says who may call it, a route without a scope would let any
+// API token in
+func TestRoutesDeclareAccess(t *testing.T) {
+	for _, r := range Routes {
+		declared := 0
+		for _, set := range []bool{r.Scope != "", len(r.ScopeFilter) > 0, r.Session, r.Public} {
+			if set {
+				declared++
+			}
+		}
+		if declared != 1 {
+			t.Errorf("%s %s: needs exactly one of Scope, ScopeFilter, Session and Public", r.Method, r.Path)
+		}
+	}
+}
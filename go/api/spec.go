@@ -0,0 +1,201 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+
+	"calple/apierr"
+	"calple/openapi"
+)
+
+var (
+	specOnce sync.Once
+	spec     *openapi.Document
+	specJSON []byte
+)
+
+// Spec is the OpenAPI document of /api/v1, generated from the route table
+func Spec() *openapi.Document {
+	specOnce.Do(func() {
+		spec = buildSpec(Routes)
+		out, err := json.MarshalIndent(spec, "", "  ")
+		if err != nil {
+			panic("api: spec does not marshal: " + err.Error())
+		}
+		specJSON = append(out, '\n')
+	})
+	return spec
+}
+
+// SpecJSON is Spec as served and committed in api/openapi.json
+func SpecJSON() []byte {
+	Spec()
+	return specJSON
+}
+
+func serveSpec(c *gin.Context) {
+	c.Data(http.StatusOK, "application/json; charset=utf-8", SpecJSON())
+}
+
+func buildSpec(routes []Route) *openapi.Document {
+	g := openapi.NewGenerator()
+	errorSchema := g.Schema(apierr.Error{}, openapi.Output)
+
+	// every response before the first request body, see openapi.Generator
+	responses := make([]*openapi.Schema, len(routes))
+	for i, r := range routes {
+		switch {
+		case r.Stream:
+			responses[i] = g.Schema(r.Response, openapi.Output)
+		case r.Response != nil:
+			responses[i] = envelopeSchema(g, r)
+		}
+	}
+
+	doc := &openapi.Document{
+		OpenAPI: "3.0.3",
+		Info: openapi.Info{
+			Title:   "Calple API",
+			Version: "1",
+			Description: "Successful responses carry their payload in \"data\", with " +
+				"anything about it in \"meta\". Errors are an Error object.",
+		},
+		Servers: []openapi.Server{{URL: "/api/v1"}},
+		Paths:   map[string]*openapi.PathItem{},
+	}
+	for i, r := range routes {
+		op := &openapi.Operation{
+			OperationID: operationID(r.Handler),
+			Summary:     r.Summary,
+			Tags:        []string{r.Tag},
+			Responses: map[string]*openapi.Response{
+				"default": {Description: "Error", Content: jsonContent(errorSchema)},
+			},
+		}
+
+		path, params := pathParams(r.Path)
+		op.Parameters = append(params, r.Query...)
+		if r.Request != nil {
+			op.RequestBody = &openapi.RequestBody{
+				Required: !r.OptionalBody,
+				Content:  jsonContent(g.Schema(r.Request, openapi.Input)),
+			}
+		}
+
+		status := r.status()
+		ok := &openapi.Response{Description: http.StatusText(status)}
+		switch {
+		case r.Stream:
+			ok.Description = "Server-sent events, the data of each is JSON"
+			ok.Content = map[string]openapi.MediaType{"text/event-stream": {Schema: responses[i]}}
+		case responses[i] != nil:
+			ok.Content = jsonContent(responses[i])
+		}
+		op.Responses[strconv.Itoa(status)] = ok
+
+		switch {
+		case r.Public:
+		case r.Session:
+			op.Security = []map[string][]string{{"cookieAuth": {}}}
+		default:
+			op.Security = []map[string][]string{{"cookieAuth": {}}, {"bearerAuth": {}}}
+		}
+		if r.Scope != "" {
+			op.Scope = "write:" + r.Scope
+			if r.Method == http.MethodGet {
+				op.Scope = "read:" + r.Scope
+			}
+		}
+		for _, resource := range r.ScopeFilter {
+			op.ScopeFilter = append(op.ScopeFilter, "read:"+resource)
+		}
+
+		item := doc.Paths[path]
+		if item == nil {
+			item = &openapi.PathItem{}
+			doc.Paths[path] = item
+		}
+		(*item)[strings.ToLower(r.Method)] = op
+	}
+
+	doc.Components.Schemas = g.Schemas()
+	doc.Components.SecuritySchemes = map[string]*openapi.SecurityScheme{
+		"cookieAuth": {Type: "apiKey", In: "cookie", Name: "calple_session", Description: "browser session"},
+		"bearerAuth": {Type: "http", Scheme: "bearer", Description: "personal access token or access token of a native client"},
+	}
+	return doc
+}
+
+// envelopeSchema describes the v1 body of a route, see Route.Response
+func envelopeSchema(g *openapi.Generator, r Route) *openapi.Schema {
+	env := &openapi.Schema{Type: "object", Required: []string{"data"}, Properties: map[string]*openapi.Schema{}}
+	if r.Data == "" {
+		env.Properties["data"] = g.Schema(r.Response, openapi.Output)
+		return env
+	}
+
+	body := g.Inline(r.Response, openapi.Output)
+	data, ok := body.Properties[r.Data]
+	if !ok {
+		panic("api: " + r.Method + " " + r.Path + " has no " + r.Data + " property")
+	}
+	env.Properties["data"] = data
+
+	meta := &openapi.Schema{Type: "object", Properties: map[string]*openapi.Schema{}}
+	for name, prop := range body.Properties {
+		if name != r.Data {
+			meta.Properties[name] = prop
+		}
+	}
+	for _, name := range body.Required {
+		if name != r.Data {
+			meta.Required = append(meta.Required, name)
+		}
+	}
+	if len(meta.Properties) > 0 {
+		env.Properties["meta"] = meta
+	}
+	return env
+}
+
+func jsonContent(s *openapi.Schema) map[string]openapi.MediaType {
+	return map[string]openapi.MediaType{"application/json": {Schema: s}}
+}
+
+// pathParams turns gin's :name segments into {name} and lists them
+func pathParams(path string) (string, []openapi.Parameter) {
+	var params []openapi.Parameter
+	segments := strings.Split(path, "/")
+	for i, s := range segments {
+		if strings.HasPrefix(s, ":") {
+			name := s[1:]
+			segments[i] = "{" + name + "}"
+			params = append(params, openapi.Parameter{Name: name, In: "path", Required: true, Schema: &openapi.Schema{Type: "string"}})
+		}
+	}
+	return strings.Join(segments, "/"), params
+}
+
+// operationID is the handler's name, GetDDays becomes getDDays
+func operationID(h gin.HandlerFunc) string {
+	name := runtime.FuncForPC(reflect.ValueOf(h).Pointer()).Name()
+	name = name[strings.LastIndex(name, ".")+1:]
+	runes := []rune(name)
+	runes[0] = unicode.ToLower(runes[0])
+	return string(runes)
+}
+
+func (r Route) status() int {
+	if r.Status == 0 {
+		return http.StatusOK
+	}
+	return r.Status
+}
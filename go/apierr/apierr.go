@@ -0,0 +1,151 @@
+// Package apierr is the error model of the API. every error response has the
+// same shape:
+//
+//	{"error": "message for people", "code": "not_found", "requestId": "..."}
+//
+// with "fields" listing the invalid inputs of a validation error. the code
+// is stable, clients branch on it; the message may change. internal causes
+// are logged, never sent.
+package apierr
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"calple/logging"
+)
+
+// Code is the stable, machine readable kind of an error
+type Code string
+
+const (
+	CodeBadRequest      Code = "bad_request"
+	CodeValidation      Code = "validation_failed"
+	CodeUnauthorized    Code = "unauthorized"
+	CodeForbidden       Code = "forbidden"
+	CodeNotFound        Code = "not_found"
+	CodeConflict        Code = "conflict"
+	CodeGone            Code = "gone"
+	CodeTooLarge        Code = "too_large"
+	CodeTooManyRequests Code = "rate_limited"
+	CodeInternal        Code = "internal"
+	CodeUpstream        Code = "upstream_failed"
+	CodeUnavailable     Code = "unavailable"
+)
+
+// FieldError is one invalid input, Field is its JSON name
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Error is an API error response
+type Error struct {
+	Status    int          `json:"-"`
+	Code      Code         `json:"code"`
+	Message   string       `json:"error"`
+	Fields    []FieldError `json:"fields,omitempty"`
+	RequestID string       `json:"requestId,omitempty"`
+
+	// what went wrong inside, only logged
+	cause error
+}
+
+func New(status int, code Code, message string) *Error {
+	return &Error{Status: status, Code: code, Message: message}
+}
+
+func (e *Error) Error() string {
+	if e.cause != nil {
+		return e.Message + ": " + e.cause.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.cause
+}
+
+// WithCause keeps the error behind e for the logs
+func (e *Error) WithCause(err error) *Error {
+	out := *e
+	out.cause = err
+	return &out
+}
+
+func BadRequest(message string) *Error {
+	return New(http.StatusBadRequest, CodeBadRequest, message)
+}
+
+func Unauthorized(message string) *Error {
+	return New(http.StatusUnauthorized, CodeUnauthorized, message)
+}
+
+func Forbidden(message string) *Error {
+	return New(http.StatusForbidden, CodeForbidden, message)
+}
+
+func NotFound(message string) *Error {
+	return New(http.StatusNotFound, CodeNotFound, message)
+}
+
+func Conflict(message string) *Error {
+	return New(http.StatusConflict, CodeConflict, message)
+}
+
+func Gone(message string) *Error {
+	return New(http.StatusGone, CodeGone, message)
+}
+
+func TooLarge(message string) *Error {
+	return New(http.StatusRequestEntityTooLarge, CodeTooLarge, message)
+}
+
+func TooManyRequests(message string) *Error {
+	return New(http.StatusTooManyRequests, CodeTooManyRequests, message)
+}
+
+// Internal is a failure on our side, message says what couldn't be done
+func Internal(message string) *Error {
+	return New(http.StatusInternalServerError, CodeInternal, message)
+}
+
+// Upstream is a failure of a service we depend on, like the mail transport
+func Upstream(message string) *Error {
+	return New(http.StatusBadGateway, CodeUpstream, message)
+}
+
+func Unavailable(message string) *Error {
+	return New(http.StatusServiceUnavailable, CodeUnavailable, message)
+}
+
+// Validation lists the invalid inputs of a request
+func Validation(fields ...FieldError) *Error {
+	e := New(http.StatusBadRequest, CodeValidation, "Invalid request")
+	e.Fields = fields
+	if len(fields) == 1 {
+		e.Message = fields[0].Field + " " + fields[0].Message
+	}
+	return e
+}
+
+// Abort ends the request with err. errors that aren't an *Error become an
+// internal error, server errors are logged with their cause.
+func Abort(c *gin.Context, err error) {
+	var e *Error
+	if !errors.As(err, &e) {
+		e = Internal("Something went wrong").WithCause(err)
+	}
+	if e.Status >= 500 {
+		logging.FromContext(c.Request.Context()).Error(e.Message, "code", e.Code, "error", e.cause)
+	}
+	write(c, e)
+}
+
+func write(c *gin.Context, e *Error) {
+	out := *e
+	out.RequestID = c.GetString("requestID")
+	c.AbortWithStatusJSON(out.Status, out)
+}
@@ -0,0 +1,39 @@
+package apierr
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/gin-gonic/gin"
+
+	"calple/logging"
+)
+
+// Recovery turns a panic in a handler into an internal error. the panic and
+// its stack go to the log, the client only gets the request ID to quote.
+func Recovery() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func() {
+			r := recover()
+			if r == nil {
+				return
+			}
+			// the client went away, net/http handles this one quietly
+			if err, ok := r.(error); ok && errors.Is(err, http.ErrAbortHandler) {
+				panic(r)
+			}
+			logging.FromContext(c.Request.Context()).Error("panic serving request",
+				"panic", fmt.Sprint(r),
+				"stack", string(debug.Stack()),
+			)
+			if c.Writer.Written() {
+				c.Abort()
+				return
+			}
+			write(c, Internal("Something went wrong"))
+		}()
+		c.Next()
+	}
+}
@@ -0,0 +1,149 @@
+package apierr
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+// RegisterValidators makes validation errors name fields by their JSON name
+// and adds the validators request structs use besides the built in ones:
+//
+//	notblank     a string that isn't only whitespace
+//	compactdate  empty or a date written like 20060102
+func RegisterValidators() error {
+	v, ok := binding.Validator.Engine().(*validator.Validate)
+	if !ok {
+		return errors.New("apierr: unexpected validator engine")
+	}
+	v.RegisterTagNameFunc(func(f reflect.StructField) string {
+		for _, tag := range []string{"json", "form", "uri"} {
+			name, _, _ := strings.Cut(f.Tag.Get(tag), ",")
+			if name == "-" {
+				return ""
+			}
+			if name != "" {
+				return name
+			}
+		}
+		return f.Name
+	})
+	if err := v.RegisterValidation("notblank", func(fl validator.FieldLevel) bool {
+		return strings.TrimSpace(fl.Field().String()) != ""
+	}); err != nil {
+		return err
+	}
+	return v.RegisterValidation("compactdate", func(fl validator.FieldLevel) bool {
+		s := fl.Field().String()
+		if s == "" {
+			return true
+		}
+		_, err := time.Parse("20060102", s)
+		return err == nil
+	})
+}
+
+// FromBinding turns the error of ShouldBind and friends into an API error,
+// failed validations list every invalid field
+func FromBinding(err error) *Error {
+	var verrs validator.ValidationErrors
+	if errors.As(err, &verrs) {
+		fields := make([]FieldError, 0, len(verrs))
+		for _, fe := range verrs {
+			fields = append(fields, FieldError{Field: fieldName(fe), Message: fieldMessage(fe)})
+		}
+		return Validation(fields...)
+	}
+
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		return Validation(FieldError{Field: typeErr.Field, Message: "must be " + jsonKind(typeErr.Type)})
+	}
+	if errors.Is(err, io.EOF) {
+		return BadRequest("Request body is required")
+	}
+	return BadRequest("Invalid request body")
+}
+
+// fieldName is the JSON path of the field without the struct name
+func fieldName(fe validator.FieldError) string {
+	ns := fe.Namespace()
+	if _, rest, ok := strings.Cut(ns, "."); ok {
+		return rest
+	}
+	return ns
+}
+
+func fieldMessage(fe validator.FieldError) string {
+	isString := fe.Kind() == reflect.String
+	isList := fe.Kind() == reflect.Slice || fe.Kind() == reflect.Map
+	switch fe.Tag() {
+	case "required", "required_if", "notblank":
+		return "is required"
+	case "required_without":
+		return "is required unless " + strings.ToLower(fe.Param()) + " is given"
+	case "max", "lte":
+		if isString {
+			return "must be at most " + fe.Param() + " characters"
+		}
+		if isList {
+			return "must have at most " + fe.Param() + " items"
+		}
+		return "must be at most " + fe.Param()
+	case "min", "gte":
+		if isString {
+			return "must be at least " + fe.Param() + " characters"
+		}
+		if isList {
+			return "must have at least " + fe.Param() + " items"
+		}
+		return "must be at least " + fe.Param()
+	case "len":
+		return "must be " + fe.Param() + " characters"
+	case "eq":
+		return "must be " + fe.Param()
+	case "oneof":
+		return "must be one of " + strings.ReplaceAll(fe.Param(), " ", ", ")
+	case "email":
+		return "must be an email address"
+	case "url", "http_url":
+		return "must be a URL"
+	case "datetime":
+		return "must be formatted like " + fe.Param()
+	case "compactdate":
+		return "must be a date formatted like 20060102"
+	case "latitude":
+		return "must be a latitude"
+	case "longitude":
+		return "must be a longitude"
+	case "hexcolor":
+		return "must be a hex color"
+	case "unique":
+		return "must not contain duplicates"
+	}
+	return fmt.Sprintf("is invalid (%s)", fe.Tag())
+}
+
+func jsonKind(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "true or false"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "a list"
+	}
+	return "an object"
+}
@@ -0,0 +1,82 @@
+// Package apitoken issues and checks the bearer tokens used by scripts and
+// native clients: personal access tokens (PATs) and short lived JWT access
+// tokens with rotating refresh tokens. both carry scopes.
+package apitoken
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// resources a token can be scoped to, each has a read: and a write: scope
+var Resources = []string{
+	"checkins",
+	"connection",
+	"ddays",
+	"feedback",
+	"ideas",
+	"periods",
+	"pins",
+	"profile",
+}
+
+// AllScopes returns every valid scope, sorted
+func AllScopes() []string {
+	scopes := make([]string, 0, len(Resources)*2)
+	for _, r := range Resources {
+		scopes = append(scopes, "read:"+r, "write:"+r)
+	}
+	sort.Strings(scopes)
+	return scopes
+}
+
+// ParseScopes validates and de-duplicates requested scopes
+func ParseScopes(requested []string) ([]string, error) {
+	valid := map[string]bool{}
+	for _, s := range AllScopes() {
+		valid[s] = true
+	}
+	seen := map[string]bool{}
+	scopes := []string{}
+	for _, s := range requested {
+		s = strings.TrimSpace(s)
+		if !valid[s] {
+			return nil, fmt.Errorf("unknown scope %q", s)
+		}
+		if !seen[s] {
+			seen[s] = true
+			scopes = append(scopes, s)
+		}
+	}
+	if len(scopes) == 0 {
+		return nil, fmt.Errorf("at least one scope is required")
+	}
+	sort.Strings(scopes)
+	return scopes, nil
+}
+
+// Allows reports whether scopes permit the access. write implies read.
+func Allows(scopes []string, resource string, write bool) bool {
+	for _, s := range scopes {
+		if s == "write:"+resource || (!write && s == "read:"+resource) {
+			return true
+		}
+	}
+	return false
+}
+
+// Narrow keeps the scopes of requested that are also in granted, used when
+// a refresh asks for less than the grant has
+func Narrow(granted, requested []string) []string {
+	out := []string{}
+	for _, s := range requested {
+		for _, g := range granted {
+			if s == g {
+				out = append(out, s)
+				break
+			}
+		}
+	}
+	return out
+}
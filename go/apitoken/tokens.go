@@ -0,0 +1,151 @@
+package apitoken
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"calple/util"
+)
+
+const (
+	PATPrefix     = "calple_pat_"
+	RefreshPrefix = "calple_rt_"
+	// device codes of the device authorization grant
+	DeviceCodePrefix = "calple_dc_"
+
+	AccessTokenTTL  = 15 * time.Minute
+	RefreshTokenTTL = 60 * 24 * time.Hour
+)
+
+var (
+	ErrInvalid = errors.New("invalid token")
+	ErrExpired = errors.New("token expired")
+)
+
+// opaque tokens are <prefix><docID>_<secret>. the document is found by ID,
+// only a hash of the secret is stored.
+
+// NewOpaque returns a token for the document id and the hash to store
+func NewOpaque(prefix, id string) (token, hash string, err error) {
+	secret, err := util.RandomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+	return prefix + id + "_" + secret, util.HashToken(secret), nil
+}
+
+// ParseOpaque splits a token into document ID and secret hash
+func ParseOpaque(prefix, token string) (id, hash string, err error) {
+	rest, ok := strings.CutPrefix(token, prefix)
+	if !ok {
+		return "", "", ErrInvalid
+	}
+	id, secret, ok := strings.Cut(rest, "_")
+	if !ok || id == "" || secret == "" {
+		return "", "", ErrInvalid
+	}
+	return id, util.HashToken(secret), nil
+}
+
+// SameHash compares two stored hashes in constant time
+func SameHash(a, b string) bool {
+	return hmac.Equal([]byte(a), []byte(b))
+}
+
+// Claims of an access token
+type Claims struct {
+	Issuer    string `json:"iss"`
+	Subject   string `json:"sub"` // user ID
+	GrantID   string `json:"gid"` // the refresh grant it was issued from
+	Scope     string `json:"scope"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+func (c Claims) Scopes() []string {
+	return strings.Fields(c.Scope)
+}
+
+// Signer signs access tokens with HS256. keys are derived from the app
+// secrets so a leaked access token key can't forge session cookies. the
+// first key signs, all of them verify, like the session cookie keys.
+type Signer struct {
+	keys [][]byte
+}
+
+func NewSigner(secrets ...[]byte) *Signer {
+	keys := make([][]byte, 0, len(secrets))
+	for _, secret := range secrets {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte("calple access token"))
+		keys = append(keys, mac.Sum(nil))
+	}
+	return &Signer{keys: keys}
+}
+
+const jwtHeader = `{"alg":"HS256","typ":"JWT"}`
+
+// Issue signs an access token for a grant
+func (s *Signer) Issue(uid, grantID string, scopes []string, now time.Time) (string, time.Time, error) {
+	exp := now.Add(AccessTokenTTL)
+	payload, err := json.Marshal(Claims{
+		Issuer:    "calple",
+		Subject:   uid,
+		GrantID:   grantID,
+		Scope:     strings.Join(scopes, " "),
+		IssuedAt:  now.Unix(),
+		ExpiresAt: exp.Unix(),
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	signing := base64.RawURLEncoding.EncodeToString([]byte(jwtHeader)) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signing + "." + s.sign(s.keys[0], signing), exp, nil
+}
+
+// Verify checks an access token and returns its claims
+func (s *Signer) Verify(token string, now time.Time) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalid
+	}
+	header, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || string(header) != jwtHeader {
+		return nil, ErrInvalid
+	}
+	signing := parts[0] + "." + parts[1]
+	valid := false
+	for _, key := range s.keys {
+		if hmac.Equal([]byte(s.sign(key, signing)), []byte(parts[2])) {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return nil, ErrInvalid
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalid
+	}
+	var claims Claims
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Issuer != "calple" || claims.Subject == "" {
+		return nil, ErrInvalid
+	}
+	if now.Unix() >= claims.ExpiresAt {
+		return nil, ErrExpired
+	}
+	return &claims, nil
+}
+
+func (s *Signer) sign(key []byte, signing string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(signing))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,160 @@
+// Package audit keeps the trail of sensitive actions: who did what to which
+// object, what changed and from where. entries are only ever added, they
+// stay when the objects or the accounts involved are deleted.
+package audit
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/firestore"
+
+	"calple/util"
+)
+
+// Collection holds the entries. listing a trail needs the composite index
+// on subjects (array-contains) and at (descending).
+const Collection = "audit_log"
+
+// Action is what was done, object.verb
+type Action string
+
+const (
+	ConnectionInvite  Action = "connection.invite"
+	ConnectionAccept  Action = "connection.accept"
+	ConnectionDecline Action = "connection.decline"
+	ConnectionCancel  Action = "connection.cancel"
+	ConnectionUnlink  Action = "connection.unlink"
+
+	DDayUpdate  Action = "dday.update"
+	DDayDelete  Action = "dday.delete"
+	DDayRestore Action = "dday.restore"
+	DDayRevert  Action = "dday.revert"
+
+	MetadataUpdate Action = "user.metadata.update"
+	// the partner's startedDating follows the user's
+	PartnerMetadataUpdate Action = "user.metadata.partner_update"
+
+	AccountDelete Action = "account.delete"
+)
+
+// Target is the object acted on
+type Target struct {
+	Type string `json:"type" firestore:"type"`
+	ID   string `json:"id" firestore:"id"`
+}
+
+// Change is one field before and after the action, nil when it was not set
+type Change struct {
+	Before interface{} `json:"before" firestore:"before"`
+	After  interface{} `json:"after" firestore:"after"`
+}
+
+// Entry is one action
+type Entry struct {
+	ID       string            `json:"id" firestore:"-"`
+	Action   Action            `json:"action" firestore:"action"`
+	ActorUID string            `json:"actorUid" firestore:"actorUid"`
+	Target   Target            `json:"target" firestore:"target"`
+	Changes  map[string]Change `json:"changes,omitempty" firestore:"changes,omitempty"`
+	// Subjects are the users whose trail shows the entry, the actor and
+	// whoever the action affected
+	Subjects  []string  `json:"-" firestore:"subjects"`
+	IP        string    `json:"ip,omitempty" firestore:"ip"`
+	UserAgent string    `json:"userAgent,omitempty" firestore:"userAgent"`
+	RequestID string    `json:"requestId,omitempty" firestore:"requestId"`
+	At        time.Time `json:"at" firestore:"at"`
+}
+
+var ErrInvalidCursor = errors.New("audit: invalid cursor")
+
+// Record adds e to the trail
+func Record(ctx context.Context, fsClient *firestore.Client, e Entry) error {
+	if e.At.IsZero() {
+		e.At = time.Now()
+	}
+	subjects := []string{}
+	for _, uid := range append([]string{e.ActorUID}, e.Subjects...) {
+		if uid != "" && !util.Contains(subjects, uid) {
+			subjects = append(subjects, uid)
+		}
+	}
+	e.Subjects = subjects
+	_, _, err := fsClient.Collection(Collection).Add(ctx, e)
+	return err
+}
+
+// List returns the trail of uid, latest first, up to limit entries older
+// than the entry before. next is the cursor of the following page, empty
+// on the last one.
+func List(ctx context.Context, fsClient *firestore.Client, uid string, limit int, before string) (entries []Entry, next string, err error) {
+	q := fsClient.Collection(Collection).
+		Where("subjects", "array-contains", uid).
+		OrderBy("at", firestore.Desc).
+		Limit(limit)
+	if before != "" {
+		cursor, err := fsClient.Collection(Collection).Doc(before).Get(ctx)
+		if err != nil || !util.Contains(util.ToStringSlice(cursor.Data()["subjects"]), uid) {
+			return nil, "", ErrInvalidCursor
+		}
+		q = q.StartAfter(cursor)
+	}
+
+	docs, err := q.Documents(ctx).GetAll()
+	if err != nil {
+		return nil, "", err
+	}
+	entries = make([]Entry, 0, len(docs))
+	for _, doc := range docs {
+		var e Entry
+		if err := doc.DataTo(&e); err != nil {
+			return nil, "", err
+		}
+		e.ID = doc.Ref.ID
+		entries = append(entries, e)
+	}
+	if len(docs) == limit {
+		next = docs[len(docs)-1].Ref.ID
+	}
+	return entries, next, nil
+}
+
+// Diff lists the fields that differ between before and after, either may
+// be nil. with fields only those are compared, otherwise every field but
+// updatedAt.
+func Diff(before, after map[string]interface{}, fields ...string) map[string]Change {
+	if len(fields) == 0 {
+		for k := range before {
+			fields = append(fields, k)
+		}
+		for k := range after {
+			if _, ok := before[k]; !ok {
+				fields = append(fields, k)
+			}
+		}
+		sort.Strings(fields)
+	}
+
+	changes := map[string]Change{}
+	for _, k := range fields {
+		if k == "updatedAt" {
+			continue
+		}
+		b, a := before[k], after[k]
+		if !equal(b, a) {
+			changes[k] = Change{Before: b, After: a}
+		}
+	}
+	return changes
+}
+
+func equal(a, b interface{}) bool {
+	if ta, ok := a.(time.Time); ok {
+		tb, ok := b.(time.Time)
+		return ok && ta.Equal(tb)
+	}
+	return reflect.DeepEqual(a, b)
+}
@@ -0,0 +1,117 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+const appleIssuer = "https://appleid.apple.com"
+
+type AppleConfig struct {
+	ClientID    string // the Services ID
+	TeamID      string
+	KeyID       string
+	PrivateKey  string // contents of the .p8 key
+	RedirectURL string
+}
+
+// Apple is Sign in with Apple. it is OIDC with two quirks: the client
+// secret is a short lived JWT signed with the team's key, and asking for
+// email and name makes Apple POST the callback (response_mode=form_post),
+// with the name only included on the very first sign in.
+type Apple struct {
+	*OIDC
+}
+
+func NewApple(cfg AppleConfig) (*Apple, error) {
+	key, err := parseAppleKey(cfg.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	oidc := NewOIDC(OIDCConfig{
+		Name:        "apple",
+		Issuer:      appleIssuer,
+		ClientID:    cfg.ClientID,
+		RedirectURL: cfg.RedirectURL,
+		Scopes:      []string{"openid", "email", "name"},
+	})
+	oidc.authParams = []oauth2.AuthCodeOption{oauth2.SetAuthURLParam("response_mode", "form_post")}
+	oidc.pkce = false
+	oidc.clientSecret = func() (string, error) {
+		return appleClientSecret(cfg, key)
+	}
+	return &Apple{OIDC: oidc}, nil
+}
+
+func (a *Apple) Exchange(ctx context.Context, params url.Values, req AuthRequest) (*Identity, error) {
+	ident, err := a.OIDC.Exchange(ctx, params, req)
+	if err != nil {
+		return nil, err
+	}
+	if ident.Name == "" {
+		// {"name":{"firstName":"..","lastName":".."},"email":".."}, first sign in only
+		var user struct {
+			Name struct {
+				FirstName string `json:"firstName"`
+				LastName  string `json:"lastName"`
+			} `json:"name"`
+		}
+		if json.Unmarshal([]byte(params.Get("user")), &user) == nil {
+			ident.Name = strings.TrimSpace(user.Name.FirstName + " " + user.Name.LastName)
+		}
+	}
+	return ident, nil
+}
+
+func parseAppleKey(p8 string) (*ecdsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(strings.ReplaceAll(p8, `\n`, "\n")))
+	if block == nil {
+		return nil, errors.New("auth: APPLE_PRIVATE_KEY is not PEM encoded")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := parsed.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("auth: APPLE_PRIVATE_KEY is not an EC key")
+	}
+	return key, nil
+}
+
+// appleClientSecret signs the ES256 JWT Apple expects as client_secret
+func appleClientSecret(cfg AppleConfig, key *ecdsa.PrivateKey) (string, error) {
+	now := time.Now()
+	header, _ := json.Marshal(map[string]string{"alg": "ES256", "kid": cfg.KeyID})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss": cfg.TeamID,
+		"iat": now.Unix(),
+		"exp": now.Add(5 * time.Minute).Unix(),
+		"aud": appleIssuer,
+		"sub": cfg.ClientID,
+	})
+	signing := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+
+	digest := sha256.Sum256([]byte(signing))
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		return "", err
+	}
+	// JWS wants r and s as fixed size big endian, not ASN.1
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+	return signing + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
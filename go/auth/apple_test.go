@@ -0,0 +1,120 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"calple/auth/oidcmock"
+)
+
+// recordSecret keeps the client_secret of token requests
+type recordSecret struct {
+	mu     sync.Mutex
+	secret string
+}
+
+func (rs *recordSecret) RoundTrip(req *http.Request) (*http.Response, error) {
+	if strings.HasSuffix(req.URL.Path, "/token") {
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		form, _ := url.ParseQuery(string(body))
+		secret := form.Get("client_secret")
+		if _, password, ok := req.BasicAuth(); ok {
+			secret, _ = url.QueryUnescape(password)
+		}
+		rs.mu.Lock()
+		rs.secret = secret
+		rs.mu.Unlock()
+	}
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestAppleSignIn(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p8 := string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+
+	mock := startIssuer(t)
+	// Apple sends no name in the ID token and email_verified as a string
+	mock.SetUser(oidcmock.User{Subject: "apple-1", Email: "ann@privaterelay.appleid.com"})
+	mock.Claims = func(c map[string]interface{}) { c["email_verified"] = "true" }
+
+	apple, err := NewApple(AppleConfig{
+		ClientID:    mock.ClientID,
+		TeamID:      "TEAM",
+		KeyID:       "KEY",
+		PrivateKey:  strings.ReplaceAll(p8, "\n", `\n`),
+		RedirectURL: "http://app.test/auth/apple/callback",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	secrets := &recordSecret{}
+	apple.cfg.Issuer = mock.Issuer
+	apple.cfg.HTTPClient = &http.Client{Transport: secrets}
+
+	req := AuthRequest{State: "s1", Nonce: "n1"}
+	authURL, err := apple.AuthCodeURL(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(authURL, "response_mode=form_post") || strings.Contains(authURL, "code_challenge") {
+		t.Errorf("auth URL %s", authURL)
+	}
+
+	params := authorize(t, apple, req)
+	// the name is only posted along on the first sign in
+	params.Set("user", `{"name":{"firstName":"Ann","lastName":"Lee"}}`)
+	ident, err := apple.Exchange(context.Background(), params, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Identity{Provider: "apple", Subject: "apple-1", Email: "ann@privaterelay.appleid.com", EmailVerified: true, Name: "Ann Lee"}
+	ident.Token = nil
+	if *ident != want {
+		t.Errorf("identity = %+v, want %+v", *ident, want)
+	}
+
+	// the client secret is an ES256 JWT signed with the team's key
+	parts := strings.Split(secrets.secret, ".")
+	if len(parts) != 3 {
+		t.Fatalf("client_secret %q is not a JWT", secrets.secret)
+	}
+	var claims struct {
+		Iss string `json:"iss"`
+		Sub string `json:"sub"`
+		Aud string `json:"aud"`
+	}
+	if err := decodeSegment(parts[1], &claims); err != nil || claims.Iss != "TEAM" || claims.Sub != mock.ClientID || claims.Aud != appleIssuer {
+		t.Errorf("client_secret claims %+v, %v", claims, err)
+	}
+	sig, _ := base64.RawURLEncoding.DecodeString(parts[2])
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+	if len(sig) != 64 || !ecdsa.Verify(&key.PublicKey, digest[:], r, s) {
+		t.Error("client_secret signature doesn't verify")
+	}
+}
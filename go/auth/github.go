@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/github"
+)
+
+const githubAPI = "https://api.github.com"
+
+// GitHub signs in with a GitHub OAuth app. GitHub isn't an OIDC provider,
+// the identity comes from the REST API.
+type GitHub struct {
+	config *oauth2.Config
+	client *http.Client
+	api    string
+}
+
+func NewGitHub(clientID, clientSecret, redirectURL string) *GitHub {
+	return &GitHub{
+		config: &oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			RedirectURL:  redirectURL,
+			Scopes:       []string{"read:user", "user:email"},
+			Endpoint:     github.Endpoint,
+		},
+		client: &http.Client{Timeout: 10 * time.Second},
+		api:    githubAPI,
+	}
+}
+
+func (g *GitHub) Name() string { return "github" }
+
+func (g *GitHub) AuthCodeURL(ctx context.Context, req AuthRequest) (string, error) {
+	opts := append(req.options(), oauth2.S256ChallengeOption(req.Verifier))
+	return g.config.AuthCodeURL(req.State, opts...), nil
+}
+
+func (g *GitHub) Exchange(ctx context.Context, params url.Values, req AuthRequest) (*Identity, error) {
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, g.client)
+	token, err := g.config.Exchange(ctx, params.Get("code"), oauth2.VerifierOption(req.Verifier))
+	if err != nil {
+		return nil, fmt.Errorf("token exchange: %w", err)
+	}
+	client := g.config.Client(ctx, token)
+
+	var user struct {
+		ID    int64  `json:"id"`
+		Login string `json:"login"`
+		Name  string `json:"name"`
+	}
+	if err := getJSON(ctx, client, g.api+"/user", &user); err != nil {
+		return nil, fmt.Errorf("fetch user: %w", err)
+	}
+
+	// the profile email is optional and unverified, use the primary verified address
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := getJSON(ctx, client, g.api+"/user/emails", &emails); err != nil {
+		return nil, fmt.Errorf("fetch emails: %w", err)
+	}
+	ident := &Identity{
+		Provider: "github",
+		Subject:  strconv.FormatInt(user.ID, 10),
+		Name:     user.Name,
+		Token:    token,
+	}
+	if ident.Name == "" {
+		ident.Name = user.Login
+	}
+	for _, e := range emails {
+		if e.Primary && e.Verified {
+			ident.Email = e.Email
+			ident.EmailVerified = true
+		}
+	}
+	if ident.Email == "" {
+		return nil, ErrNoEmail
+	}
+	return ident, nil
+}
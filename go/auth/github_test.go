@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+type githubEmail struct {
+	Email    string `json:"email"`
+	Primary  bool   `json:"primary"`
+	Verified bool   `json:"verified"`
+}
+
+// fakeGitHub is the OAuth and REST endpoints of GitHub a sign in uses
+func fakeGitHub(t *testing.T, emails []githubEmail) *GitHub {
+	t.Helper()
+	var challenge string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login/oauth/authorize", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		challenge = q.Get("code_challenge")
+		redirect, _ := url.Parse(q.Get("redirect_uri"))
+		redirect.RawQuery = url.Values{"code": {"gh-code"}, "state": {q.Get("state")}}.Encode()
+		http.Redirect(w, r, redirect.String(), http.StatusFound)
+	})
+	mux.HandleFunc("/login/oauth/access_token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if r.PostForm.Get("code") != "gh-code" || base64.RawURLEncoding.EncodeToString(sum[:]) != challenge {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]string{"error": "bad_verification_code"})
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "gh-token", "token_type": "bearer"})
+	})
+	api := func(v interface{}) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer gh-token" {
+				http.Error(w, "bad credentials", http.StatusUnauthorized)
+				return
+			}
+			json.NewEncoder(w).Encode(v)
+		}
+	}
+	mux.HandleFunc("/user", api(map[string]interface{}{"id": 42, "login": "octo", "name": ""}))
+	mux.HandleFunc("/user/emails", api(emails))
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	g := NewGitHub("gh-client", "gh-secret", "http://app.test/auth/github/callback")
+	g.config.Endpoint = oauth2.Endpoint{
+		AuthURL:   srv.URL + "/login/oauth/authorize",
+		TokenURL:  srv.URL + "/login/oauth/access_token",
+		AuthStyle: oauth2.AuthStyleInParams,
+	}
+	g.api = srv.URL
+	return g
+}
+
+func TestGitHubSignIn(t *testing.T) {
+	g := fakeGitHub(t, []githubEmail{
+		{Email: "old@example.com", Verified: true},
+		{Email: "octo@example.com", Primary: true, Verified: true},
+	})
+	req := AuthRequest{State: "s1", Verifier: "verifier-0123456789-0123456789-0123456789"}
+	ident, err := g.Exchange(context.Background(), authorize(t, g, req), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Identity{Provider: "github", Subject: "42", Email: "octo@example.com", EmailVerified: true, Name: "octo"}
+	ident.Token = nil
+	if *ident != want {
+		t.Errorf("identity = %+v, want %+v", *ident, want)
+	}
+}
+
+func TestGitHubRejects(t *testing.T) {
+	const verifier = "verifier-0123456789-0123456789-0123456789"
+	tests := []struct {
+		name     string
+		emails   []githubEmail
+		verifier string
+		want     error
+	}{
+		{"unverified primary", []githubEmail{{Email: "octo@example.com", Primary: true}, {Email: "other@example.com", Verified: true}}, verifier, ErrNoEmail},
+		{"no emails", nil, verifier, ErrNoEmail},
+		{"wrong code verifier", []githubEmail{{Email: "octo@example.com", Primary: true, Verified: true}}, verifier + "x", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := fakeGitHub(t, tt.emails)
+			params := authorize(t, g, AuthRequest{State: "s1", Verifier: verifier})
+			ident, err := g.Exchange(context.Background(), params, AuthRequest{Verifier: tt.verifier})
+			if err == nil {
+				t.Fatalf("signed in as %+v", ident)
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
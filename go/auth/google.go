@@ -0,0 +1,25 @@
+package auth
+
+import "golang.org/x/oauth2"
+
+const googleIssuer = "https://accounts.google.com"
+
+// Google is Google's OpenID Connect. the subject is the same ID the
+// userinfo API returns, which existing accounts are keyed by.
+type Google struct {
+	*OIDC
+}
+
+func NewGoogle(clientID, clientSecret, redirectURL string) *Google {
+	oidc := NewOIDC(OIDCConfig{
+		Name:         "google",
+		Issuer:       googleIssuer,
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  redirectURL,
+	})
+	// google also issues ID tokens with the scheme left off
+	oidc.altIssuers = []string{"accounts.google.com"}
+	oidc.authParams = []oauth2.AuthCodeOption{oauth2.AccessTypeOffline}
+	return &Google{OIDC: oidc}
+}
@@ -0,0 +1,196 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+type OIDCConfig struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	// defaults to openid, email and profile
+	Scopes []string
+	// HTTPClient is used for discovery, JWKS and the token endpoint
+	HTTPClient *http.Client
+}
+
+// OIDC is a generic OpenID Connect provider. endpoints come from the
+// issuer's discovery document, the code exchange uses PKCE and the ID
+// token is verified against the issuer's JWKS.
+type OIDC struct {
+	cfg OIDCConfig
+
+	// extra parameters on the authorization request, e.g. response_mode
+	authParams []oauth2.AuthCodeOption
+	// when set, called for a fresh client secret on every exchange
+	clientSecret func() (string, error)
+	// when false the verifier is not sent
+	pkce bool
+	// other spellings of the issuer accepted in ID tokens
+	altIssuers []string
+
+	mu        sync.Mutex
+	discovery *discoveryDoc
+	keys      *keySet
+}
+
+type discoveryDoc struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	UserinfoEndpoint      string   `json:"userinfo_endpoint"`
+	CodeChallengeMethods  []string `json:"code_challenge_methods_supported"`
+}
+
+func NewOIDC(cfg OIDCConfig) *OIDC {
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "email", "profile"}
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	cfg.Issuer = strings.TrimRight(cfg.Issuer, "/")
+	return &OIDC{cfg: cfg, pkce: true}
+}
+
+func (o *OIDC) Name() string { return o.cfg.Name }
+
+// discover loads the discovery document once, a failed attempt is retried on the next call
+func (o *OIDC) discover(ctx context.Context) (*discoveryDoc, *keySet, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.discovery != nil {
+		return o.discovery, o.keys, nil
+	}
+
+	var doc discoveryDoc
+	if err := getJSON(ctx, o.cfg.HTTPClient, o.cfg.Issuer+"/.well-known/openid-configuration", &doc); err != nil {
+		return nil, nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+	if strings.TrimRight(doc.Issuer, "/") != o.cfg.Issuer {
+		return nil, nil, fmt.Errorf("oidc discovery: issuer mismatch %q", doc.Issuer)
+	}
+	if doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" || doc.JWKSURI == "" {
+		return nil, nil, errors.New("oidc discovery: incomplete document")
+	}
+	o.discovery = &doc
+	o.keys = newKeySet(doc.JWKSURI, o.cfg.HTTPClient)
+	return o.discovery, o.keys, nil
+}
+
+func (o *OIDC) oauthConfig(doc *discoveryDoc) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     o.cfg.ClientID,
+		ClientSecret: o.cfg.ClientSecret,
+		RedirectURL:  o.cfg.RedirectURL,
+		Scopes:       o.cfg.Scopes,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  doc.AuthorizationEndpoint,
+			TokenURL: doc.TokenEndpoint,
+		},
+	}
+}
+
+func (o *OIDC) AuthCodeURL(ctx context.Context, req AuthRequest) (string, error) {
+	doc, _, err := o.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	opts := append(req.options(), o.authParams...)
+	if o.pkce {
+		opts = append(opts, oauth2.S256ChallengeOption(req.Verifier))
+	}
+	if req.Nonce != "" {
+		opts = append(opts, oauth2.SetAuthURLParam("nonce", req.Nonce))
+	}
+	return o.oauthConfig(doc).AuthCodeURL(req.State, opts...), nil
+}
+
+func (o *OIDC) Exchange(ctx context.Context, params url.Values, req AuthRequest) (*Identity, error) {
+	doc, keys, err := o.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	config := o.oauthConfig(doc)
+	if o.clientSecret != nil {
+		if config.ClientSecret, err = o.clientSecret(); err != nil {
+			return nil, err
+		}
+	}
+	opts := []oauth2.AuthCodeOption{}
+	if o.pkce {
+		opts = append(opts, oauth2.VerifierOption(req.Verifier))
+	}
+
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, o.cfg.HTTPClient)
+	token, err := config.Exchange(ctx, params.Get("code"), opts...)
+	if err != nil {
+		return nil, fmt.Errorf("token exchange: %w", err)
+	}
+	rawID, _ := token.Extra("id_token").(string)
+	if rawID == "" {
+		return nil, errors.New("token response has no id_token")
+	}
+	issuers := append([]string{doc.Issuer}, o.altIssuers...)
+	claims, err := verifyIDToken(ctx, keys, rawID, issuers, o.cfg.ClientID)
+	if err != nil {
+		return nil, err
+	}
+	// a token minted for another login (e.g. replayed from an old one) has another nonce
+	if req.Nonce != "" && subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(req.Nonce)) != 1 {
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrInvalidToken)
+	}
+
+	ident := &Identity{
+		Provider:      o.cfg.Name,
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: bool(claims.EmailVerified),
+		Name:          claims.Name,
+		Token:         token,
+	}
+	// some issuers keep email and name out of the ID token
+	if (ident.Email == "" || ident.Name == "") && doc.UserinfoEndpoint != "" {
+		o.fillFromUserinfo(ctx, config, token, doc.UserinfoEndpoint, ident)
+	}
+	if ident.Email == "" {
+		return nil, ErrNoEmail
+	}
+	return ident, nil
+}
+
+func (o *OIDC) fillFromUserinfo(ctx context.Context, config *oauth2.Config, token *oauth2.Token, endpoint string, ident *Identity) {
+	var info struct {
+		Sub           string   `json:"sub"`
+		Email         string   `json:"email"`
+		EmailVerified flexBool `json:"email_verified"`
+		Name          string   `json:"name"`
+	}
+	if err := getJSON(ctx, config.Client(ctx, token), endpoint, &info); err != nil {
+		return
+	}
+	// userinfo for another subject must not be mixed in
+	if info.Sub != ident.Subject {
+		return
+	}
+	if ident.Email == "" {
+		ident.Email = info.Email
+		ident.EmailVerified = bool(info.EmailVerified)
+	}
+	if ident.Name == "" {
+		ident.Name = info.Name
+	}
+}
@@ -0,0 +1,204 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"calple/auth/oidcmock"
+)
+
+func startIssuer(t *testing.T) *oidcmock.Server {
+	t.Helper()
+	mock, err := oidcmock.Start("calple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(mock.Close)
+	return mock
+}
+
+// authorize follows the provider's authorization URL to the callback and
+// returns the callback parameters
+func authorize(t *testing.T, p Provider, req AuthRequest) url.Values {
+	t.Helper()
+	authURL, err := p.AuthCodeURL(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorize: %s %v", resp.Status, err)
+	}
+	params := callback.Query()
+	if params.Get("state") != req.State {
+		t.Fatalf("state = %q, want %q", params.Get("state"), req.State)
+	}
+	return params
+}
+
+func newTestOIDC(mock *oidcmock.Server, client *http.Client) *OIDC {
+	return NewOIDC(OIDCConfig{
+		Name:        "oidc",
+		Issuer:      mock.Issuer,
+		ClientID:    mock.ClientID,
+		RedirectURL: "http://app.test/auth/oidc/callback",
+		HTTPClient:  client,
+	})
+}
+
+func TestOIDCSignIn(t *testing.T) {
+	mock := startIssuer(t)
+	mock.SetUser(oidcmock.User{Subject: "u1", Email: "ann@example.com", EmailVerified: true, Name: "Ann"})
+	p := newTestOIDC(mock, nil)
+
+	req := AuthRequest{State: "s1", Verifier: "verifier-0123456789-0123456789-0123456789", Nonce: "n1"}
+	ident, err := p.Exchange(context.Background(), authorize(t, p, req), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Identity{Provider: "oidc", Subject: "u1", Email: "ann@example.com", EmailVerified: true, Name: "Ann"}
+	ident.Token = nil
+	if *ident != want {
+		t.Errorf("identity = %+v, want %+v", *ident, want)
+	}
+}
+
+func TestOIDCUnverifiedEmail(t *testing.T) {
+	mock := startIssuer(t)
+	mock.SetUser(oidcmock.User{Subject: "u1", Email: "ann@example.com", Name: "Ann"})
+	p := newTestOIDC(mock, nil)
+
+	req := AuthRequest{State: "s1", Verifier: "verifier-0123456789-0123456789-0123456789", Nonce: "n1"}
+	ident, err := p.Exchange(context.Background(), authorize(t, p, req), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the handlers decide what an unverified address is good for
+	if ident.Email != "ann@example.com" || ident.EmailVerified {
+		t.Errorf("email = %q verified = %v", ident.Email, ident.EmailVerified)
+	}
+}
+
+func TestOIDCRejects(t *testing.T) {
+	const verifier = "verifier-0123456789-0123456789-0123456789"
+	tests := []struct {
+		name   string
+		claims func(map[string]interface{})
+		// the request the callback is exchanged with, the authorization
+		// request is always {State: "s1", Verifier: verifier, Nonce: "n1"}
+		exchange AuthRequest
+		tamper   bool
+		// the code exchange itself fails, before there is an ID token
+		exchangeFails bool
+	}{
+		{name: "bad signature", exchange: AuthRequest{Verifier: verifier, Nonce: "n1"}, tamper: true},
+		{
+			name:     "wrong audience",
+			claims:   func(c map[string]interface{}) { c["aud"] = "someone-else" },
+			exchange: AuthRequest{Verifier: verifier, Nonce: "n1"},
+		},
+		{
+			name:     "wrong issuer",
+			claims:   func(c map[string]interface{}) { c["iss"] = "https://evil.example.com" },
+			exchange: AuthRequest{Verifier: verifier, Nonce: "n1"},
+		},
+		{
+			name:     "expired",
+			claims:   func(c map[string]interface{}) { c["exp"] = time.Now().Add(-time.Hour).Unix() },
+			exchange: AuthRequest{Verifier: verifier, Nonce: "n1"},
+		},
+		{
+			name:     "issued in the future",
+			claims:   func(c map[string]interface{}) { c["iat"] = time.Now().Add(time.Hour).Unix() },
+			exchange: AuthRequest{Verifier: verifier, Nonce: "n1"},
+		},
+		{name: "wrong nonce", exchange: AuthRequest{Verifier: verifier, Nonce: "n2"}},
+		{
+			name:     "no nonce",
+			claims:   func(c map[string]interface{}) { delete(c, "nonce") },
+			exchange: AuthRequest{Verifier: verifier, Nonce: "n1"},
+		},
+		{name: "wrong code verifier", exchange: AuthRequest{Verifier: verifier + "x", Nonce: "n1"}, exchangeFails: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := startIssuer(t)
+			mock.Claims = tt.claims
+			client := &http.Client{}
+			if tt.tamper {
+				client.Transport = tamperIDToken{}
+			}
+			p := newTestOIDC(mock, client)
+
+			params := authorize(t, p, AuthRequest{State: "s1", Verifier: verifier, Nonce: "n1"})
+			ident, err := p.Exchange(context.Background(), params, tt.exchange)
+			switch {
+			case err == nil:
+				t.Fatalf("signed in as %+v", ident)
+			case tt.exchangeFails && !strings.HasPrefix(err.Error(), "token exchange"):
+				t.Errorf("err = %v, want a failed token exchange", err)
+			case !tt.exchangeFails && !errors.Is(err, ErrInvalidToken):
+				t.Errorf("err = %v, want ErrInvalidToken", err)
+			}
+		})
+	}
+}
+
+func TestOIDCCodeSingleUse(t *testing.T) {
+	mock := startIssuer(t)
+	p := newTestOIDC(mock, nil)
+	req := AuthRequest{State: "s1", Verifier: "verifier-0123456789-0123456789-0123456789", Nonce: "n1"}
+
+	params := authorize(t, p, req)
+	if _, err := p.Exchange(context.Background(), params, req); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Exchange(context.Background(), params, req); err == nil {
+		t.Error("a code was redeemed twice")
+	}
+}
+
+// tamperIDToken flips a bit in the signature of the ID tokens the token
+// endpoint returns
+type tamperIDToken struct{}
+
+func (tamperIDToken) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil || !strings.HasSuffix(req.URL.Path, "/token") {
+		return resp, err
+	}
+	defer resp.Body.Close()
+	var body map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	if raw, ok := body["id_token"].(string); ok {
+		b := []byte(raw)
+		i := strings.LastIndexByte(raw, '.') + 1
+		if b[i] == 'A' {
+			b[i] = 'B'
+		} else {
+			b[i] = 'A'
+		}
+		body["id_token"] = string(b)
+	}
+	out, _ := json.Marshal(body)
+	resp.Body = io.NopCloser(bytes.NewReader(out))
+	resp.ContentLength = int64(len(out))
+	resp.Header.Del("Content-Length")
+	return resp, nil
+}
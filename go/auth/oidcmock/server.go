@@ -0,0 +1,296 @@
+// Package oidcmock is a minimal OpenID Connect provider for local
+// development and tests. /authorize signs the configured user in without
+// a login form; everything else (discovery, PKCE, signed ID tokens, JWKS,
+// userinfo) behaves like a real issuer.
+package oidcmock
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// User is who /authorize signs in
+type User struct {
+	Subject       string `json:"sub"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+}
+
+type grant struct {
+	clientID    string
+	redirectURI string
+	challenge   string
+	nonce       string
+	user        User
+	expiresAt   time.Time
+}
+
+type Server struct {
+	Issuer   string
+	ClientID string
+	// Claims, when set, edits the claims of each ID token before it is
+	// signed, tests use it to mint tokens a client has to refuse
+	Claims func(claims map[string]interface{})
+
+	key *rsa.PrivateKey
+	kid string
+
+	mu     sync.Mutex
+	user   User
+	codes  map[string]grant
+	tokens map[string]User
+
+	httptest *httptest.Server
+}
+
+// New creates a provider for issuer, serve it with http.ListenAndServe
+func New(issuer, clientID string) (*Server, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	return &Server{
+		Issuer:   strings.TrimRight(issuer, "/"),
+		ClientID: clientID,
+		key:      key,
+		kid:      randomString(8),
+		user: User{
+			Subject:       "mock-user",
+			Email:         "user@example.com",
+			EmailVerified: true,
+			Name:          "Mock User",
+		},
+		codes:  map[string]grant{},
+		tokens: map[string]User{},
+	}, nil
+}
+
+// Start runs the provider on a local httptest server
+func Start(clientID string) (*Server, error) {
+	s, err := New("", clientID)
+	if err != nil {
+		return nil, err
+	}
+	s.httptest = httptest.NewServer(s)
+	s.Issuer = s.httptest.URL
+	return s, nil
+}
+
+func (s *Server) Close() {
+	if s.httptest != nil {
+		s.httptest.Close()
+	}
+}
+
+// SetUser changes who the next sign in is for
+func (s *Server) SetUser(u User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.user = u
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/.well-known/openid-configuration":
+		s.discovery(w)
+	case "/authorize":
+		s.authorize(w, r)
+	case "/token":
+		s.token(w, r)
+	case "/jwks":
+		s.jwks(w)
+	case "/userinfo":
+		s.userinfo(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) discovery(w http.ResponseWriter) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                s.Issuer,
+		"authorization_endpoint":                s.Issuer + "/authorize",
+		"token_endpoint":                        s.Issuer + "/token",
+		"jwks_uri":                              s.Issuer + "/jwks",
+		"userinfo_endpoint":                     s.Issuer + "/userinfo",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+// authorize signs the current user in right away. login_hint=<email>
+// switches to another user, handy for signing in both partners locally.
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	redirect, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || redirect.Scheme == "" {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	if s.ClientID != "" && q.Get("client_id") != s.ClientID {
+		http.Error(w, "unknown client_id", http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	user := s.user
+	if hint := q.Get("login_hint"); hint != "" {
+		user = User{Subject: "mock-" + hint, Email: hint, EmailVerified: true, Name: strings.Split(hint, "@")[0]}
+	}
+	code := randomString(24)
+	s.codes[code] = grant{
+		clientID:    q.Get("client_id"),
+		redirectURI: q.Get("redirect_uri"),
+		challenge:   q.Get("code_challenge"),
+		nonce:       q.Get("nonce"),
+		user:        user,
+		expiresAt:   time.Now().Add(time.Minute),
+	}
+	s.mu.Unlock()
+
+	params := redirect.Query()
+	params.Set("code", code)
+	params.Set("state", q.Get("state"))
+	redirect.RawQuery = params.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (s *Server) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	r.ParseForm()
+	clientID := r.PostForm.Get("client_id")
+	if id, _, ok := r.BasicAuth(); ok {
+		clientID = id
+	}
+
+	s.mu.Lock()
+	g, ok := s.codes[r.PostForm.Get("code")]
+	delete(s.codes, r.PostForm.Get("code"))
+	s.mu.Unlock()
+
+	tokenError := func(desc string) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": desc})
+	}
+	switch {
+	case r.PostForm.Get("grant_type") != "authorization_code":
+		tokenError("unsupported grant_type")
+		return
+	case !ok || time.Now().After(g.expiresAt):
+		tokenError("unknown or expired code")
+		return
+	case clientID != g.clientID:
+		tokenError("client_id mismatch")
+		return
+	case r.PostForm.Get("redirect_uri") != g.redirectURI:
+		tokenError("redirect_uri mismatch")
+		return
+	}
+	if g.challenge != "" {
+		sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if base64.RawURLEncoding.EncodeToString(sum[:]) != g.challenge {
+			tokenError("code_verifier mismatch")
+			return
+		}
+	}
+
+	accessToken := randomString(32)
+	s.mu.Lock()
+	s.tokens[accessToken] = g.user
+	s.mu.Unlock()
+
+	idToken, err := s.sign(g, clientID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     idToken,
+	})
+}
+
+func (s *Server) jwks(w http.ResponseWriter) {
+	pub := s.key.PublicKey
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"alg": "RS256",
+			"use": "sig",
+			"kid": s.kid,
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	})
+}
+
+func (s *Server) userinfo(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	s.mu.Lock()
+	user, ok := s.tokens[token]
+	s.mu.Unlock()
+	if !ok {
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return
+	}
+	writeJSON(w, http.StatusOK, user)
+}
+
+func (s *Server) sign(g grant, clientID string) (string, error) {
+	now := time.Now()
+	claims := map[string]interface{}{
+		"iss":            s.Issuer,
+		"sub":            g.user.Subject,
+		"aud":            clientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(time.Hour).Unix(),
+		"email":          g.user.Email,
+		"email_verified": g.user.EmailVerified,
+		"name":           g.user.Name,
+	}
+	if g.nonce != "" {
+		claims["nonce"] = g.nonce
+	}
+	if s.Claims != nil {
+		s.Claims(claims)
+	}
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": s.kid})
+	payload, _ := json.Marshal(claims)
+	signing := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signing))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return signing + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func randomString(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
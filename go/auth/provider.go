@@ -0,0 +1,141 @@
+// Package auth implements the external identity providers users can sign
+// in with. a provider turns an authorization code into an Identity, the
+// handlers map identities to accounts.
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"sort"
+	"strings"
+
+	"golang.org/x/oauth2"
+
+	"calple/config"
+)
+
+// Identity is a user as seen by an identity provider
+type Identity struct {
+	Provider      string
+	Subject       string // stable user ID at the provider
+	Email         string
+	EmailVerified bool
+	Name          string
+	Token         *oauth2.Token
+}
+
+// AuthRequest is the per-login data sent to the provider
+type AuthRequest struct {
+	State string
+	// PKCE code verifier, providers that don't support PKCE ignore it
+	Verifier string
+	// expected in the ID token, binds it to this login. ignored without OIDC.
+	Nonce string
+	// optional, pre-fills the account at the provider
+	LoginHint string
+}
+
+// Provider is one way to sign in
+type Provider interface {
+	Name() string
+	// AuthCodeURL is where the browser is sent to sign in
+	AuthCodeURL(ctx context.Context, req AuthRequest) (string, error)
+	// Exchange redeems the callback parameters (query or form_post body)
+	Exchange(ctx context.Context, params url.Values, req AuthRequest) (*Identity, error)
+}
+
+func (req AuthRequest) options() []oauth2.AuthCodeOption {
+	opts := []oauth2.AuthCodeOption{}
+	if req.LoginHint != "" {
+		opts = append(opts, oauth2.SetAuthURLParam("login_hint", req.LoginHint))
+	}
+	return opts
+}
+
+// ProviderEmail is the identity of magic link sign in, the subject is the email address
+const ProviderEmail = "email"
+
+var (
+	ErrUnknownProvider = errors.New("auth: unknown provider")
+	ErrNoEmail         = errors.New("auth: provider returned no email")
+)
+
+// Registry holds the configured providers by name
+type Registry struct {
+	providers map[string]Provider
+}
+
+func NewRegistry(providers ...Provider) *Registry {
+	r := &Registry{providers: map[string]Provider{}}
+	for _, p := range providers {
+		r.providers[p.Name()] = p
+	}
+	return r
+}
+
+func (r *Registry) Get(name string) (Provider, error) {
+	p, ok := r.providers[name]
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+	return p, nil
+}
+
+// Names returns the configured providers, sorted
+func (r *Registry) Names() []string {
+	names := make([]string, 0, len(r.providers))
+	for name := range r.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// FromConfig configures every provider that has credentials. callbacks
+// are {APIBaseURL}/auth/{name}/callback, except google which keeps the
+// callback registered before providers existed.
+func FromConfig(cfg *config.Config) (*Registry, error) {
+	callback := func(name string) string {
+		return cfg.APIBaseURL + "/auth/" + name + "/callback"
+	}
+	providers := []Provider{}
+
+	if cfg.Google.ClientID != "" {
+		redirect := cfg.APIBaseURL + "/google/oauth/callback"
+		providers = append(providers, NewGoogle(cfg.Google.ClientID, cfg.Google.ClientSecret, redirect))
+	}
+
+	if cfg.GitHub.ClientID != "" {
+		providers = append(providers, NewGitHub(cfg.GitHub.ClientID, cfg.GitHub.ClientSecret, callback("github")))
+	}
+
+	if cfg.OIDC.Issuer != "" {
+		// scopes may also be space separated within one entry
+		scopes := strings.Fields(strings.Join(cfg.OIDC.Scopes, " "))
+		providers = append(providers, NewOIDC(OIDCConfig{
+			Name:         cfg.OIDC.Name,
+			Issuer:       cfg.OIDC.Issuer,
+			ClientID:     cfg.OIDC.ClientID,
+			ClientSecret: cfg.OIDC.ClientSecret,
+			RedirectURL:  callback(cfg.OIDC.Name),
+			Scopes:       scopes,
+		}))
+	}
+
+	if cfg.Apple.ClientID != "" {
+		apple, err := NewApple(AppleConfig{
+			ClientID:    cfg.Apple.ClientID,
+			TeamID:      cfg.Apple.TeamID,
+			KeyID:       cfg.Apple.KeyID,
+			PrivateKey:  cfg.Apple.PrivateKey,
+			RedirectURL: callback("apple"),
+		})
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, apple)
+	}
+
+	return NewRegistry(providers...), nil
+}
@@ -0,0 +1,64 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"calple/api"
+	"calple/apierr"
+	"calple/handlers"
+)
+
+// AuthStatus says whether the client is signed in and as whom
+func (c *Client) AuthStatus(ctx context.Context) (*api.AuthStatus, error) {
+	var status api.AuthStatus
+	if _, err := c.do(ctx, http.MethodGet, "/auth/status", nil, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// StartDeviceLogin begins a device sign in. show the user code and the
+// verification URI to the user, then wait with WaitDeviceLogin. an empty
+// scope asks for every scope.
+func (c *Client) StartDeviceLogin(ctx context.Context, clientName, scope string) (*handlers.DeviceCodeResponse, error) {
+	var device handlers.DeviceCodeResponse
+	form := url.Values{"client_name": {clientName}, "scope": {scope}}
+	if err := c.postForm(ctx, "/auth/device/code", form, &device); err != nil {
+		return nil, err
+	}
+	return &device, nil
+}
+
+// WaitDeviceLogin polls until the user approved or denied the device, or
+// the code expired. the refresh token of the response signs in later runs
+// with WithRefreshToken.
+func (c *Client) WaitDeviceLogin(ctx context.Context, device *handlers.DeviceCodeResponse) (*api.TokenResponse, error) {
+	interval := time.Duration(device.Interval) * time.Second
+	form := url.Values{"grant_type": {handlers.DeviceGrantType}, "device_code": {device.DeviceCode}}
+	for {
+		if err := sleep(ctx, interval); err != nil {
+			return nil, err
+		}
+
+		var tokens api.TokenResponse
+		err := c.postForm(ctx, "/auth/token", form, &tokens)
+		var apiErr *apierr.Error
+		if !errors.As(err, &apiErr) {
+			if err != nil {
+				return nil, err
+			}
+			return &tokens, nil
+		}
+		switch apiErr.Code {
+		case "authorization_pending":
+		case "slow_down", apierr.CodeTooManyRequests:
+			interval += 5 * time.Second
+		default:
+			return nil, err
+		}
+	}
+}
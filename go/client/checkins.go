@@ -0,0 +1,41 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"calple/handlers"
+)
+
+// SaveCheckin saves the checkin of checkin.Date, replacing an earlier one
+func (c *Client) SaveCheckin(ctx context.Context, checkin handlers.CheckinData) (*handlers.CheckinData, error) {
+	var saved handlers.CheckinData
+	if _, err := c.do(ctx, http.MethodPost, "/checkin", checkin, &saved); err != nil {
+		return nil, err
+	}
+	return &saved, nil
+}
+
+// Checkin is the user's checkin of date (2006-01-02)
+func (c *Client) Checkin(ctx context.Context, date string) (*handlers.CheckinData, error) {
+	var checkin handlers.CheckinData
+	if _, err := c.do(ctx, http.MethodGet, "/checkin/"+url.PathEscape(date), nil, &checkin); err != nil {
+		return nil, err
+	}
+	return &checkin, nil
+}
+
+func (c *Client) DeleteCheckin(ctx context.Context, date string) error {
+	_, err := c.do(ctx, http.MethodDelete, "/checkin/"+url.PathEscape(date), nil, nil)
+	return err
+}
+
+// PartnerCheckin is the partner's checkin of date (2006-01-02)
+func (c *Client) PartnerCheckin(ctx context.Context, date string) (*handlers.PartnerCheckin, error) {
+	var checkin handlers.PartnerCheckin
+	if _, err := c.do(ctx, http.MethodGet, "/checkin/partner/"+url.PathEscape(date), nil, &checkin); err != nil {
+		return nil, err
+	}
+	return &checkin, nil
+}
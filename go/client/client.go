@@ -0,0 +1,301 @@
+// Package client is a Go client of the Calple API for scripts and bots. it
+// talks to /api/v1 and unwraps its envelopes, the payloads are the types
+// the handlers use.
+//
+//	c := client.New("https://api.calple.app", client.WithToken(os.Getenv("CALPLE_TOKEN")))
+//	ddays, err := c.ListDDays(ctx, "")
+//
+// failed requests return an *apierr.Error with the status, code and message
+// of the response.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"calple/api"
+	"calple/apierr"
+)
+
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	retries    int
+
+	mu sync.Mutex
+	// a personal access token, or the access token of the refresh grant
+	token     string
+	expires   time.Time
+	refresh   string
+	onRefresh func(refreshToken string)
+}
+
+type Option func(*Client)
+
+// WithToken authenticates with a personal access token
+func WithToken(token string) Option {
+	return func(c *Client) { c.token = token }
+}
+
+// WithRefreshToken authenticates with the refresh token of an app grant.
+// refresh tokens are single use, save is called with every new one so it
+// can be stored for the next run.
+func WithRefreshToken(refreshToken string, save func(refreshToken string)) Option {
+	return func(c *Client) {
+		c.refresh = refreshToken
+		c.onRefresh = save
+	}
+}
+
+// WithHTTPClient sends requests with hc, e.g. one with a cookie jar that
+// holds a browser session
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.httpClient = hc }
+}
+
+// WithRetries sets how often a request is retried after a network error,
+// a rate limit or an unavailable server, 3 by default
+func WithRetries(n int) Option {
+	return func(c *Client) { c.retries = n }
+}
+
+// New creates a client of the API at baseURL, the address of the backend
+// without /api
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+		retries:    3,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// meta is the "meta" object of a response
+type meta map[string]json.RawMessage
+
+func (m meta) string(key string) string {
+	var s string
+	json.Unmarshal(m[key], &s)
+	return s
+}
+
+// do sends a request to /api/v1 and decodes the "data" of the response
+// into out
+func (c *Client) do(ctx context.Context, method, path string, in, out interface{}) (meta, error) {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return nil, err
+		}
+	}
+
+	refreshed := false
+	for attempt := 0; ; attempt++ {
+		resp, err := c.send(ctx, method, path, body, refreshed)
+		if err != nil {
+			// a request that may have reached the server is only sent
+			// again when repeating it is harmless
+			var apiErr *apierr.Error
+			if ctx.Err() != nil || errors.As(err, &apiErr) || attempt >= c.retries || !idempotent(method) {
+				return nil, err
+			}
+			if err := sleep(ctx, backoff(attempt)); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		// an access token the server no longer accepts, e.g. after its
+		// signing key was rotated
+		if resp.StatusCode == http.StatusUnauthorized && c.refresh != "" && !refreshed {
+			resp.Body.Close()
+			refreshed = true
+			attempt--
+			continue
+		}
+		if attempt < c.retries && retryable(method, resp.StatusCode) {
+			delay := retryAfter(resp, attempt)
+			resp.Body.Close()
+			if err := sleep(ctx, delay); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		return decode(resp, out)
+	}
+}
+
+func (c *Client) send(ctx context.Context, method, path string, body []byte, refresh bool) (*http.Response, error) {
+	token, err := c.accessToken(ctx, refresh)
+	if err != nil {
+		return nil, err
+	}
+
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+"/api/v1"+path, r)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return c.httpClient.Do(req)
+}
+
+// accessToken is the bearer token of the next request. with a refresh
+// token a new access token is fetched when the current one is about to
+// expire or force is set.
+func (c *Client) accessToken(ctx context.Context, force bool) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.refresh == "" || (!force && c.token != "" && time.Until(c.expires) > 30*time.Second) {
+		return c.token, nil
+	}
+
+	var tokens api.TokenResponse
+	form := url.Values{"grant_type": {"refresh_token"}, "refresh_token": {c.refresh}}
+	if err := c.postForm(ctx, "/auth/token", form, &tokens); err != nil {
+		return "", err
+	}
+	c.token = tokens.AccessToken
+	c.expires = time.Now().Add(time.Duration(tokens.ExpiresIn) * time.Second)
+	c.refresh = tokens.RefreshToken
+	if c.onRefresh != nil {
+		c.onRefresh(tokens.RefreshToken)
+	}
+	return c.token, nil
+}
+
+// postForm posts to the auth endpoints outside /api/v1, their responses
+// are not wrapped
+func (c *Client) postForm(ctx context.Context, path string, form url.Values, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func decode(resp *http.Response, out interface{}) (meta, error) {
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, responseError(resp)
+	}
+	if resp.StatusCode == http.StatusNoContent {
+		return nil, nil
+	}
+
+	var env struct {
+		Data json.RawMessage `json:"data"`
+		Meta meta            `json:"meta"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&env); err != nil {
+		return nil, err
+	}
+	if out != nil && len(env.Data) > 0 {
+		if err := json.Unmarshal(env.Data, out); err != nil {
+			return nil, err
+		}
+	}
+	return env.Meta, nil
+}
+
+// responseError reads the error of a failed response
+func responseError(resp *http.Response) error {
+	apiErr := &apierr.Error{}
+	if err := json.NewDecoder(resp.Body).Decode(apiErr); err != nil || apiErr.Message == "" {
+		apiErr = &apierr.Error{Message: http.StatusText(resp.StatusCode)}
+	}
+	apiErr.Status = resp.StatusCode
+	return apiErr
+}
+
+func idempotent(method string) bool {
+	return method != http.MethodPost
+}
+
+// retryable reports whether a response is worth another try. rate limited
+// requests were not handled at all, anything else is only repeated when
+// doing so twice is harmless.
+func retryable(method string, status int) bool {
+	switch status {
+	case http.StatusTooManyRequests:
+		return true
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return idempotent(method)
+	}
+	return false
+}
+
+// retryAfter is the delay the server asked for, or the backoff
+func retryAfter(resp *http.Response, attempt int) time.Duration {
+	if s, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && s >= 0 {
+		return time.Duration(s) * time.Second
+	}
+	return backoff(attempt)
+}
+
+// backoff doubles from half a second up to 10 seconds, with jitter
+func backoff(attempt int) time.Duration {
+	d := min(500*time.Millisecond<<attempt, 10*time.Second)
+	return d/2 + rand.N(d/2+1)
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// list fetches every page of a list. the API sends lists whole today, when
+// one is cut into pages meta.next holds the path of the next page.
+func list[T any](ctx context.Context, c *Client, path string) ([]T, meta, error) {
+	var all []T
+	for {
+		var page []T
+		m, err := c.do(ctx, http.MethodGet, path, nil, &page)
+		if err != nil {
+			return nil, nil, err
+		}
+		all = append(all, page...)
+		if path = m.string("next"); path == "" {
+			return all, m, nil
+		}
+	}
+}
@@ -0,0 +1,377 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"calple/apierr"
+	"calple/apitoken"
+	"calple/audit"
+	"calple/handlers"
+	"calple/server/servertest"
+)
+
+// couple signs ann and bob in, connects them and returns a client with a
+// personal access token for each
+func couple(t *testing.T, s *servertest.Server) (ann, bob *Client) {
+	t.Helper()
+	ctx := context.Background()
+	ann = New(s.URL, WithToken(s.Token(t, s.SignIn(t, "ann@example.com"), apitoken.AllScopes()...)))
+	bob = New(s.URL, WithToken(s.Token(t, s.SignIn(t, "bob@example.com"), apitoken.AllScopes()...)))
+
+	if err := ann.Invite(ctx, "bob@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	invites, err := bob.PendingInvitations(ctx)
+	if err != nil || len(invites) != 1 {
+		t.Fatalf("pending invitations %+v, %v", invites, err)
+	}
+	if err := bob.AcceptInvitation(ctx, invites[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	return ann, bob
+}
+
+func TestDDays(t *testing.T) {
+	s := servertest.Start(t)
+	ann, bob := couple(t, s)
+	ctx := context.Background()
+
+	conn, err := ann.Connection(ctx)
+	if err != nil || !conn.Connected || conn.Partner == nil || conn.Partner.Email != "bob@example.com" {
+		t.Fatalf("connection %+v, %v", conn, err)
+	}
+
+	created, err := ann.CreateDDay(ctx, handlers.DDay{Title: "Anniversary", Date: time.Now().Format("20060102")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the partner sees shared events, by default of the current month
+	ddays, err := bob.ListDDays(ctx, "")
+	if err != nil || len(ddays) != 1 || ddays[0].ID != created.ID || ddays[0].Title != "Anniversary" {
+		t.Fatalf("bob's ddays %+v, %v", ddays, err)
+	}
+
+	title := "First date"
+	updated, err := ann.UpdateDDay(ctx, created.ID, handlers.DDayUpdate{Title: &title, Version: &created.Version})
+	if err != nil || updated.Title != title || updated.Version <= created.Version {
+		t.Fatalf("update %+v, %v", updated, err)
+	}
+	// an edit based on the old version conflicts
+	stale := "Stale"
+	_, err = ann.UpdateDDay(ctx, created.ID, handlers.DDayUpdate{Title: &stale, Version: &created.Version})
+	var apiErr *apierr.Error
+	if !errors.As(err, &apiErr) || apiErr.Status != http.StatusConflict || apiErr.Code != apierr.CodeConflict {
+		t.Fatalf("stale update: %v", err)
+	}
+
+	revisions, err := ann.DDayRevisions(ctx, created.ID)
+	if err != nil || len(revisions) < 2 {
+		t.Fatalf("revisions %+v, %v", revisions, err)
+	}
+	reverted, err := ann.RevertDDay(ctx, created.ID, created.Version)
+	if err != nil || reverted.Title != "Anniversary" {
+		t.Fatalf("revert %+v, %v", reverted, err)
+	}
+
+	if err := ann.DeleteDDay(ctx, created.ID); err != nil {
+		t.Fatal(err)
+	}
+	_, err = ann.UpdateDDay(ctx, created.ID, handlers.DDayUpdate{Title: &title})
+	if !errors.As(err, &apiErr) || apiErr.Status != http.StatusNotFound {
+		t.Errorf("update of a deleted event: %v", err)
+	}
+}
+
+func TestPeriodsAndCheckins(t *testing.T) {
+	s := servertest.Start(t)
+	ann, bob := couple(t, s)
+	ctx := context.Background()
+
+	day := handlers.PeriodDay{Date: "2026-10-01", IsPeriod: true, CrampIntensity: 4, Mood: []string{"tired"}}
+	if _, err := ann.SavePeriodDay(ctx, day); err != nil {
+		t.Fatal(err)
+	}
+	// the same date again updates the day
+	day.CrampIntensity = 2
+	if _, err := ann.SavePeriodDay(ctx, day); err != nil {
+		t.Fatal(err)
+	}
+	days, err := ann.PeriodDays(ctx)
+	if err != nil || len(days) != 1 || days[0].CrampIntensity != 2 {
+		t.Fatalf("period days %+v, %v", days, err)
+	}
+	partnerDays, _, err := bob.PartnerPeriodDays(ctx)
+	if err != nil || len(partnerDays) != 1 || partnerDays[0].Date != day.Date {
+		t.Fatalf("partner period days %+v, %v", partnerDays, err)
+	}
+
+	settings, err := ann.UpdateCycleSettings(ctx, handlers.CycleSettings{CycleLength: 30, PeriodLength: 6})
+	if err != nil || settings.CycleLength != 30 {
+		t.Fatalf("cycle settings %+v, %v", settings, err)
+	}
+	if err := ann.DeletePeriodDay(ctx, day.Date); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ann.SaveCheckin(ctx, handlers.CheckinData{Date: "2026-10-01", Mood: "happy", Energy: "high"}); err != nil {
+		t.Fatal(err)
+	}
+	checkin, err := ann.Checkin(ctx, "2026-10-01")
+	if err != nil || checkin.Mood != "happy" {
+		t.Fatalf("checkin %+v, %v", checkin, err)
+	}
+	partner, err := bob.PartnerCheckin(ctx, "2026-10-01")
+	if err != nil || partner.Mood != "happy" || partner.UserEmail != "ann@example.com" {
+		t.Fatalf("partner checkin %+v, %v", partner, err)
+	}
+	if err := ann.DeleteCheckin(ctx, "2026-10-01"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPinsIdeasFeedback(t *testing.T) {
+	s := servertest.Start(t)
+	ann, bob := couple(t, s)
+	ctx := context.Background()
+
+	id, err := ann.CreatePin(ctx, handlers.PinRequest{Lat: 37.5, Lng: 127, Title: "Cafe", Date: "2026-10-01"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ann.UpdatePin(ctx, id, handlers.PinRequest{Lat: 37.5, Lng: 127, Title: "Old cafe", Date: "2026-10-01"}); err != nil {
+		t.Fatal(err)
+	}
+	pins, err := bob.Pins(ctx)
+	if err != nil || len(pins.PartnerPins) != 1 || pins.PartnerPins[0].Title != "Old cafe" {
+		t.Fatalf("bob's pins %+v, %v", pins, err)
+	}
+	if err := ann.DeletePin(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+
+	idea, err := ann.CreateIdea(ctx, handlers.Idea{Title: "Picnic"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ann.UpdateIdea(ctx, idea.ID, handlers.Idea{Title: "Picnic by the lake"}); err != nil {
+		t.Fatal(err)
+	}
+	ideas, err := ann.Ideas(ctx)
+	if err != nil || len(ideas) != 1 || ideas[0].Title != "Picnic by the lake" {
+		t.Fatalf("ideas %+v, %v", ideas, err)
+	}
+	// only the author edits an idea
+	var apiErr *apierr.Error
+	if err := bob.UpdateIdea(ctx, idea.ID, handlers.Idea{Title: "Mine"}); !errors.As(err, &apiErr) || apiErr.Status != http.StatusNotFound {
+		t.Errorf("bob's edit of ann's idea: %v", err)
+	}
+	if err := ann.DeleteIdea(ctx, idea.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	roulette, err := ann.AddRouletteIdea(ctx, handlers.Roulette{Title: "Bowling"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ann.UpdateRouletteIdea(ctx, roulette.ID, handlers.Roulette{Title: "Karaoke"}); err != nil {
+		t.Fatal(err)
+	}
+	if list, err := ann.RouletteIdeas(ctx); err != nil || len(list) != 1 || list[0].Title != "Karaoke" {
+		t.Fatalf("roulette %+v, %v", list, err)
+	}
+	if err := ann.DeleteRouletteIdea(ctx, roulette.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ann.SubmitFeedback(ctx, "Lovely", "general"); err != nil {
+		t.Fatal(err)
+	}
+	if feedback, err := ann.Feedback(ctx); err != nil || len(feedback) != 1 {
+		t.Fatalf("feedback %+v, %v", feedback, err)
+	}
+}
+
+func TestAuth(t *testing.T) {
+	s := servertest.Start(t)
+	ctx := context.Background()
+	session := s.SignIn(t, "ann@example.com")
+
+	// a browser session through WithHTTPClient
+	status, err := New(s.URL, WithHTTPClient(session)).AuthStatus(ctx)
+	if err != nil || !status.Authenticated || status.User.Email != "ann@example.com" {
+		t.Fatalf("session status %+v, %v", status, err)
+	}
+	if status, err := New(s.URL).AuthStatus(ctx); err != nil || status.Authenticated {
+		t.Fatalf("anonymous status %+v, %v", status, err)
+	}
+
+	// a token without the scope of a resource
+	ro := New(s.URL, WithToken(s.Token(t, session, "read:ddays")))
+	if _, err := ro.ListDDays(ctx, ""); err != nil {
+		t.Fatal(err)
+	}
+	var apiErr *apierr.Error
+	if _, err := ro.CreateDDay(ctx, handlers.DDay{Title: "Nope"}); !errors.As(err, &apiErr) || apiErr.Status != http.StatusForbidden {
+		t.Errorf("create with a read only token: %v", err)
+	}
+	if _, err := New(s.URL, WithToken("calple_pat_nope")).ListDDays(ctx, ""); !errors.As(err, &apiErr) || apiErr.Status != http.StatusUnauthorized {
+		t.Errorf("unknown token: %v", err)
+	}
+}
+
+// refresh tokens are single use, every new one is handed to save
+func TestRefreshToken(t *testing.T) {
+	s := servertest.Start(t)
+	ctx := context.Background()
+	session := New(s.URL, WithHTTPClient(s.SignIn(t, "ann@example.com")))
+
+	var grant struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	req := handlers.CreateAppTokenRequest{Name: "bot", Scopes: []string{"read:ddays", "write:ddays"}}
+	if _, err := session.do(ctx, http.MethodPost, "/tokens/app", req, &grant); err != nil {
+		t.Fatal(err)
+	}
+
+	var saved []string
+	c := New(s.URL, WithRefreshToken(grant.RefreshToken, func(token string) { saved = append(saved, token) }))
+	if _, err := c.CreateDDay(ctx, handlers.DDay{Title: "Trip"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(saved) != 1 || saved[0] == grant.RefreshToken {
+		t.Fatalf("saved %v", saved)
+	}
+
+	// the next run starts from the saved token, the first one is spent
+	if _, err := New(s.URL, WithRefreshToken(saved[0], nil)).ListDDays(ctx, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(s.URL, WithRefreshToken(grant.RefreshToken, nil)).ListDDays(ctx, ""); err == nil {
+		t.Error("a used refresh token still works")
+	}
+}
+
+func TestDeviceLogin(t *testing.T) {
+	if testing.Short() {
+		t.Skip("waits for the poll interval")
+	}
+	s := servertest.Start(t)
+	ctx := context.Background()
+	session := s.SignIn(t, "ann@example.com")
+
+	c := New(s.URL)
+	device, err := c.StartDeviceLogin(ctx, "test bot", "read:ddays")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.ApproveDevice(t, session, device.UserCode)
+	tokens, err := c.WaitDeviceLogin(ctx, device)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, err := New(s.URL, WithRefreshToken(tokens.RefreshToken, nil)).AuthStatus(ctx)
+	if err != nil || !status.Authenticated || status.User.Email != "ann@example.com" {
+		t.Errorf("status %+v, %v", status, err)
+	}
+}
+
+// flaky answers the first n requests it gets itself and passes the rest on
+// to the server
+func flaky(t *testing.T, s *servertest.Server, n int32, status int) (*httptest.Server, *atomic.Int32) {
+	target, _ := url.Parse(s.URL)
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	var seen atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if seen.Add(1) <= n {
+			w.Header().Set("Retry-After", "0")
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			w.Write([]byte(`{"code":"unavailable","error":"try again"}`))
+			return
+		}
+		proxy.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &seen
+}
+
+func TestRetries(t *testing.T) {
+	s := servertest.Start(t)
+	ctx := context.Background()
+	token := s.Token(t, s.SignIn(t, "ann@example.com"), apitoken.AllScopes()...)
+
+	// rate limited requests are sent again, whatever the method
+	srv, seen := flaky(t, s, 2, http.StatusTooManyRequests)
+	if _, err := New(srv.URL, WithToken(token)).CreateDDay(ctx, handlers.DDay{Title: "Trip"}); err != nil {
+		t.Fatal(err)
+	}
+	if n := seen.Load(); n != 3 {
+		t.Errorf("%d requests, want 3", n)
+	}
+
+	// an unavailable server may have handled a POST, only reads are repeated
+	srv, seen = flaky(t, s, 1, http.StatusServiceUnavailable)
+	if ddays, err := New(srv.URL, WithToken(token)).ListDDays(ctx, ""); err != nil || len(ddays) != 1 {
+		t.Fatalf("ddays %+v, %v", ddays, err)
+	}
+	srv, seen = flaky(t, s, 1, http.StatusServiceUnavailable)
+	var apiErr *apierr.Error
+	if _, err := New(srv.URL, WithToken(token)).CreateDDay(ctx, handlers.DDay{Title: "Twice"}); !errors.As(err, &apiErr) || apiErr.Status != http.StatusServiceUnavailable {
+		t.Errorf("create on an unavailable server: %v", err)
+	}
+	if n := seen.Load(); n != 1 {
+		t.Errorf("%d requests, want 1", n)
+	}
+
+	// retries stop at the limit
+	srv, seen = flaky(t, s, 10, http.StatusTooManyRequests)
+	if _, err := New(srv.URL, WithToken(token), WithRetries(2)).ListDDays(ctx, ""); !errors.As(err, &apiErr) || apiErr.Status != http.StatusTooManyRequests {
+		t.Errorf("list past the retries: %v", err)
+	}
+	if n := seen.Load(); n != 3 {
+		t.Errorf("%d requests, want 3", n)
+	}
+}
+
+// lists cut into pages are followed through meta.next
+func TestPagination(t *testing.T) {
+	s := servertest.Start(t)
+	ctx := context.Background()
+	session := New(s.URL, WithHTTPClient(s.SignIn(t, "ann@example.com")))
+	dday, err := session.CreateDDay(ctx, handlers.DDay{Title: "Trip"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every edit is an audit entry
+	for _, title := range []string{"One", "Two", "Three", "Four", "Five"} {
+		if _, err := session.UpdateDDay(ctx, dday.ID, handlers.DDayUpdate{Title: &title}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	all, _, err := list[audit.Entry](ctx, session, "/audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	paged, _, err := list[audit.Entry](ctx, session, "/audit?limit=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) < 5 || len(paged) != len(all) {
+		t.Fatalf("%d entries in pages of 2, %d in one", len(paged), len(all))
+	}
+	for i := range all {
+		if paged[i].ID != all[i].ID {
+			t.Errorf("entry %d is %s, want %s", i, paged[i].ID, all[i].ID)
+		}
+	}
+}
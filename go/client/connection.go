@@ -0,0 +1,115 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"calple/api"
+	"calple/handlers"
+)
+
+// Connection is the active connection, Connected is false without one
+func (c *Client) Connection(ctx context.Context) (*api.ConnectionStatus, error) {
+	var status api.ConnectionStatus
+	if _, err := c.do(ctx, http.MethodGet, "/connection", nil, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// Invite invites a partner by email. the answer does not say whether the
+// account exists.
+func (c *Client) Invite(ctx context.Context, email string) error {
+	_, err := c.do(ctx, http.MethodPost, "/connection/invite", handlers.InviteRequest{Email: email}, nil)
+	return err
+}
+
+// PendingInvitations are the invitations waiting for the user's answer
+func (c *Client) PendingInvitations(ctx context.Context) ([]handlers.Invitation, error) {
+	invitations, _, err := list[handlers.Invitation](ctx, c, "/connection/pending")
+	return invitations, err
+}
+
+func (c *Client) AcceptInvitation(ctx context.Context, id string) error {
+	return c.connectionAction(ctx, id, "accept")
+}
+
+func (c *Client) RejectInvitation(ctx context.Context, id string) error {
+	return c.connectionAction(ctx, id, "reject")
+}
+
+// CancelInvitation withdraws an invitation the user sent
+func (c *Client) CancelInvitation(ctx context.Context, id string) error {
+	return c.connectionAction(ctx, id, "cancel")
+}
+
+func (c *Client) DeclineInvitation(ctx context.Context, id string) error {
+	return c.connectionAction(ctx, id, "decline")
+}
+
+func (c *Client) connectionAction(ctx context.Context, id, action string) error {
+	_, err := c.do(ctx, http.MethodPost, "/connection/"+url.PathEscape(id)+"/"+action, nil, nil)
+	return err
+}
+
+// Unlink ends the active connection, ddays is "keep" or "remove" and says
+// what happens to the shared events
+func (c *Client) Unlink(ctx context.Context, id, ddays string) error {
+	_, err := c.do(ctx, http.MethodPost, "/connection/"+url.PathEscape(id)+"/unlink", handlers.UnlinkRequest{DDays: ddays}, nil)
+	return err
+}
+
+// ConnectionHistory lists past connections, latest first
+func (c *Client) ConnectionHistory(ctx context.Context) ([]handlers.ConnectionHistoryEntry, error) {
+	history, _, err := list[handlers.ConnectionHistoryEntry](ctx, c, "/connection/history")
+	return history, err
+}
+
+func (c *Client) BlockedUsers(ctx context.Context) ([]handlers.BlockedUser, error) {
+	blocked, _, err := list[handlers.BlockedUser](ctx, c, "/connection/blocked")
+	return blocked, err
+}
+
+// Block stops invitations from email and declines the pending ones
+func (c *Client) Block(ctx context.Context, email string) (*handlers.BlockedUser, error) {
+	var blocked handlers.BlockedUser
+	if _, err := c.do(ctx, http.MethodPost, "/connection/blocked", handlers.BlockRequest{Email: email}, &blocked); err != nil {
+		return nil, err
+	}
+	return &blocked, nil
+}
+
+func (c *Client) Unblock(ctx context.Context, id string) error {
+	_, err := c.do(ctx, http.MethodDelete, "/connection/blocked/"+url.PathEscape(id), nil, nil)
+	return err
+}
+
+func (c *Client) InviteLinks(ctx context.Context) ([]handlers.InviteLink, error) {
+	links, _, err := list[handlers.InviteLink](ctx, c, "/connection/invite-links")
+	return links, err
+}
+
+// CreateInviteLink creates an invite link, 0 hours is the default lifetime
+func (c *Client) CreateInviteLink(ctx context.Context, expiresInHours int) (*handlers.InviteLink, error) {
+	var link handlers.InviteLink
+	req := handlers.CreateInviteLinkRequest{ExpiresInHours: expiresInHours}
+	if _, err := c.do(ctx, http.MethodPost, "/connection/invite-links", req, &link); err != nil {
+		return nil, err
+	}
+	return &link, nil
+}
+
+func (c *Client) RevokeInviteLink(ctx context.Context, id string) error {
+	_, err := c.do(ctx, http.MethodDelete, "/connection/invite-links/"+url.PathEscape(id), nil, nil)
+	return err
+}
+
+// RedeemInviteLink accepts an invite link by its token or its code
+func (c *Client) RedeemInviteLink(ctx context.Context, req handlers.RedeemInviteRequest) (*api.RedeemedInvite, error) {
+	var redeemed api.RedeemedInvite
+	if _, err := c.do(ctx, http.MethodPost, "/connection/invite-links/redeem", req, &redeemed); err != nil {
+		return nil, err
+	}
+	return &redeemed, nil
+}
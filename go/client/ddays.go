@@ -0,0 +1,76 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"calple/api"
+	"calple/handlers"
+)
+
+// ListDDays lists the events shared with the user for the month view
+// (200601) is viewed in, the current month when view is empty
+func (c *Client) ListDDays(ctx context.Context, view string) ([]handlers.DDay, error) {
+	if view == "" {
+		view = time.Now().Format("200601")
+	}
+	path := "/ddays?" + url.Values{"view": {view}}.Encode()
+	ddays, _, err := list[handlers.DDay](ctx, c, path)
+	return ddays, err
+}
+
+func (c *Client) CreateDDay(ctx context.Context, dday handlers.DDay) (*handlers.DDay, error) {
+	var created handlers.DDay
+	if _, err := c.do(ctx, http.MethodPost, "/ddays", dday, &created); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+// UpdateDDay changes the fields of update that are set. with
+// update.Version set it fails with a conflict error when the event changed
+// since that version.
+func (c *Client) UpdateDDay(ctx context.Context, id string, update handlers.DDayUpdate) (*handlers.DDay, error) {
+	var updated handlers.DDay
+	if _, err := c.do(ctx, http.MethodPut, "/ddays/"+url.PathEscape(id), update, &updated); err != nil {
+		return nil, err
+	}
+	return &updated, nil
+}
+
+// DDayRevisions lists the edit history of an event, newest first
+func (c *Client) DDayRevisions(ctx context.Context, id string) ([]handlers.DDayRevision, error) {
+	var revisions []handlers.DDayRevision
+	if _, err := c.do(ctx, http.MethodGet, "/ddays/"+url.PathEscape(id)+"/revisions", nil, &revisions); err != nil {
+		return nil, err
+	}
+	return revisions, nil
+}
+
+// RevertDDay sets an event back to the fields it had at version
+func (c *Client) RevertDDay(ctx context.Context, id string, version int64) (*handlers.DDay, error) {
+	var reverted handlers.DDay
+	path := "/ddays/" + url.PathEscape(id) + "/revisions/" + strconv.FormatInt(version, 10) + "/revert"
+	if _, err := c.do(ctx, http.MethodPost, path, nil, &reverted); err != nil {
+		return nil, err
+	}
+	return &reverted, nil
+}
+
+func (c *Client) DeleteDDay(ctx context.Context, id string) error {
+	_, err := c.do(ctx, http.MethodDelete, "/ddays/"+url.PathEscape(id), nil, nil)
+	return err
+}
+
+// DDayUploadURL returns where to PUT an image of size bytes, and the URL it
+// can be shown from afterwards
+func (c *Client) DDayUploadURL(ctx context.Context, size int64) (*api.UploadURL, error) {
+	var upload api.UploadURL
+	if _, err := c.do(ctx, http.MethodPost, "/ddays/upload-url", handlers.UploadRequest{FileSize: size}, &upload); err != nil {
+		return nil, err
+	}
+	return &upload, nil
+}
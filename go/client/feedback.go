@@ -0,0 +1,22 @@
+package client
+
+import (
+	"context"
+	"net/http"
+
+	"calple/api"
+	"calple/handlers"
+)
+
+// SubmitFeedback sends feedback, category is free text such as "bug"
+func (c *Client) SubmitFeedback(ctx context.Context, text, category string) error {
+	payload := handlers.FeedbackPayload{FeedbackText: text, Category: category}
+	_, err := c.do(ctx, http.MethodPost, "/feedback", payload, nil)
+	return err
+}
+
+// Feedback lists the feedback the user sent
+func (c *Client) Feedback(ctx context.Context) ([]api.Feedback, error) {
+	feedback, _, err := list[api.Feedback](ctx, c, "/feedback")
+	return feedback, err
+}
@@ -0,0 +1,61 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"calple/handlers"
+)
+
+// AllIdeas lists every idea, Ideas only the user's
+func (c *Client) AllIdeas(ctx context.Context) ([]handlers.Idea, error) {
+	ideas, _, err := list[handlers.Idea](ctx, c, "/ideas/all")
+	return ideas, err
+}
+
+func (c *Client) Ideas(ctx context.Context) ([]handlers.Idea, error) {
+	ideas, _, err := list[handlers.Idea](ctx, c, "/ideas")
+	return ideas, err
+}
+
+func (c *Client) CreateIdea(ctx context.Context, idea handlers.Idea) (*handlers.Idea, error) {
+	var created handlers.Idea
+	if _, err := c.do(ctx, http.MethodPost, "/ideas", idea, &created); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+func (c *Client) UpdateIdea(ctx context.Context, id string, idea handlers.Idea) error {
+	_, err := c.do(ctx, http.MethodPut, "/ideas/"+url.PathEscape(id), idea, nil)
+	return err
+}
+
+func (c *Client) DeleteIdea(ctx context.Context, id string) error {
+	_, err := c.do(ctx, http.MethodDelete, "/ideas/"+url.PathEscape(id), nil, nil)
+	return err
+}
+
+func (c *Client) RouletteIdeas(ctx context.Context) ([]handlers.Roulette, error) {
+	ideas, _, err := list[handlers.Roulette](ctx, c, "/roulette")
+	return ideas, err
+}
+
+func (c *Client) AddRouletteIdea(ctx context.Context, idea handlers.Roulette) (*handlers.Roulette, error) {
+	var created handlers.Roulette
+	if _, err := c.do(ctx, http.MethodPost, "/roulette", idea, &created); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+func (c *Client) UpdateRouletteIdea(ctx context.Context, id string, idea handlers.Roulette) error {
+	_, err := c.do(ctx, http.MethodPut, "/roulette/"+url.PathEscape(id), idea, nil)
+	return err
+}
+
+func (c *Client) DeleteRouletteIdea(ctx context.Context, id string) error {
+	_, err := c.do(ctx, http.MethodDelete, "/roulette/"+url.PathEscape(id), nil, nil)
+	return err
+}
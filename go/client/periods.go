@@ -0,0 +1,55 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"calple/handlers"
+)
+
+func (c *Client) PeriodDays(ctx context.Context) ([]handlers.PeriodDay, error) {
+	days, _, err := list[handlers.PeriodDay](ctx, c, "/periods/days")
+	return days, err
+}
+
+// PartnerPeriodDays lists the partner's period days with the partner's sex
+func (c *Client) PartnerPeriodDays(ctx context.Context) ([]handlers.PeriodDay, string, error) {
+	days, m, err := list[handlers.PeriodDay](ctx, c, "/periods/partner/days")
+	if err != nil {
+		return nil, "", err
+	}
+	return days, m.string("partnerSex"), nil
+}
+
+// SavePeriodDay records a period day, a day already recorded for the same
+// date is updated
+func (c *Client) SavePeriodDay(ctx context.Context, day handlers.PeriodDay) (*handlers.PeriodDay, error) {
+	var saved handlers.PeriodDay
+	if _, err := c.do(ctx, http.MethodPost, "/periods/days", day, &saved); err != nil {
+		return nil, err
+	}
+	return &saved, nil
+}
+
+// DeletePeriodDay deletes the period day of date (2006-01-02)
+func (c *Client) DeletePeriodDay(ctx context.Context, date string) error {
+	_, err := c.do(ctx, http.MethodDelete, "/periods/days/"+url.PathEscape(date), nil, nil)
+	return err
+}
+
+func (c *Client) CycleSettings(ctx context.Context) (*handlers.CycleSettings, error) {
+	var settings handlers.CycleSettings
+	if _, err := c.do(ctx, http.MethodGet, "/periods/settings", nil, &settings); err != nil {
+		return nil, err
+	}
+	return &settings, nil
+}
+
+func (c *Client) UpdateCycleSettings(ctx context.Context, settings handlers.CycleSettings) (*handlers.CycleSettings, error) {
+	var saved handlers.CycleSettings
+	if _, err := c.do(ctx, http.MethodPut, "/periods/settings", settings, &saved); err != nil {
+		return nil, err
+	}
+	return &saved, nil
+}
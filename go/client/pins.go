@@ -0,0 +1,38 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"calple/api"
+	"calple/handlers"
+)
+
+// Pins are the map pins of the user and of the partner
+func (c *Client) Pins(ctx context.Context) (*api.Pins, error) {
+	var pins api.Pins
+	if _, err := c.do(ctx, http.MethodGet, "/pins", nil, &pins); err != nil {
+		return nil, err
+	}
+	return &pins, nil
+}
+
+// CreatePin creates a pin and returns its ID
+func (c *Client) CreatePin(ctx context.Context, pin handlers.PinRequest) (string, error) {
+	var created api.CreatedPin
+	if _, err := c.do(ctx, http.MethodPost, "/pins", pin, &created); err != nil {
+		return "", err
+	}
+	return created.ID, nil
+}
+
+func (c *Client) UpdatePin(ctx context.Context, id string, pin handlers.PinRequest) error {
+	_, err := c.do(ctx, http.MethodPut, "/pins/"+url.PathEscape(id), pin, nil)
+	return err
+}
+
+func (c *Client) DeletePin(ctx context.Context, id string) error {
+	_, err := c.do(ctx, http.MethodDelete, "/pins/"+url.PathEscape(id), nil, nil)
+	return err
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+
+	"calple/handlers"
+)
+
+func (a *app) checkin(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("checkin", flag.ContinueOnError)
+	date := flags.String("date", today().Format(dateLayout), "the day, 2025-06-01")
+	mood := flags.String("mood", "", "how you feel")
+	energy := flags.String("energy", "", "your energy level")
+	periodStatus := flags.String("period-status", "", "period status")
+	sexualMood := flags.String("sexual-mood", "", "sexual mood")
+	note := flags.String("note", "", "a note for your partner")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *mood == "" || *energy == "" {
+		return errors.New("-mood and -energy are required")
+	}
+	if _, err := parseDate(*date); err != nil {
+		return err
+	}
+
+	c, err := a.client()
+	if err != nil {
+		return err
+	}
+	saved, err := c.SaveCheckin(ctx, handlers.CheckinData{
+		Date:         *date,
+		Mood:         *mood,
+		Energy:       *energy,
+		PeriodStatus: *periodStatus,
+		SexualMood:   *sexualMood,
+		Note:         *note,
+	})
+	if err != nil {
+		return err
+	}
+	return a.print(saved, []string{"DATE", "MOOD", "ENERGY", "NOTE"}, [][]string{{saved.Date, saved.Mood, saved.Energy, saved.Note}})
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"calple/client"
+)
+
+// credentials are saved in <user config dir>/calple/credentials.json,
+// readable only by the user
+type credentials struct {
+	Server string `json:"server"`
+	// a personal access token, or the refresh token of a device sign in
+	Token        string `json:"token,omitempty"`
+	RefreshToken string `json:"refreshToken,omitempty"`
+}
+
+func credentialsPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "calple", "credentials.json"), nil
+}
+
+func loadCredentials() (*credentials, error) {
+	path, err := credentialsPath()
+	if err != nil {
+		return nil, err
+	}
+	creds := &credentials{}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return creds, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, creds); err != nil {
+		return nil, errors.New(path + " is not valid JSON, remove it and sign in again")
+	}
+	return creds, nil
+}
+
+func (c *credentials) save() error {
+	path, err := credentialsPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o600)
+}
+
+// client is an API client signed in with the saved credentials
+func (a *app) client() (*client.Client, error) {
+	if token := os.Getenv("CALPLE_TOKEN"); token != "" {
+		return client.New(a.server, client.WithToken(token)), nil
+	}
+	switch {
+	case a.creds.Token != "":
+		return client.New(a.server, client.WithToken(a.creds.Token)), nil
+	case a.creds.RefreshToken != "":
+		// refresh tokens are single use, every new one replaces the saved one
+		return client.New(a.server, client.WithRefreshToken(a.creds.RefreshToken, func(refresh string) {
+			a.creds.RefreshToken = refresh
+			a.creds.save()
+		})), nil
+	}
+	return nil, errors.New("not signed in, run calple login")
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"calple/client"
+	"calple/handlers"
+)
+
+// events store their dates as 20060102
+const ddayDateLayout = "20060102"
+
+func (a *app) ddays(ctx context.Context, args []string) error {
+	if len(args) == 0 || args[0] == "list" {
+		return a.listDDays(ctx)
+	}
+	switch args[0] {
+	case "add":
+		return a.addDDay(ctx, args[1:])
+	case "rm":
+		if len(args) != 2 {
+			return errors.New("usage: calple ddays rm ID")
+		}
+		c, err := a.client()
+		if err != nil {
+			return err
+		}
+		return c.DeleteDDay(ctx, args[1])
+	}
+	return fmt.Errorf("unknown ddays command %q, use list, add or rm", args[0])
+}
+
+func (a *app) listDDays(ctx context.Context) error {
+	c, err := a.client()
+	if err != nil {
+		return err
+	}
+	ddays, err := c.ListDDays(ctx, "")
+	if err != nil {
+		return err
+	}
+	sort.Slice(ddays, func(i, j int) bool { return ddays[i].Date < ddays[j].Date })
+
+	rows := [][]string{}
+	for _, d := range ddays {
+		annual := ""
+		if d.IsAnnual {
+			annual = "yearly"
+		}
+		rows = append(rows, []string{d.ID, displayDate(d.Date), d.Title, d.Group, annual})
+	}
+	return a.print(ddays, []string{"ID", "DATE", "TITLE", "GROUP", "REPEATS"}, rows)
+}
+
+func (a *app) addDDay(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("ddays add", flag.ContinueOnError)
+	title := flags.String("title", "", "title of the event")
+	date := flags.String("date", "", "date of the event, 2025-06-01")
+	endDate := flags.String("end-date", "", "last day of an event over several days")
+	annual := flags.Bool("annual", false, "the event repeats every year")
+	group := flags.String("group", "", "group of the event")
+	description := flags.String("description", "", "description of the event")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *title == "" || *date == "" {
+		return errors.New("-title and -date are required")
+	}
+
+	dday := handlers.DDay{Title: *title, IsAnnual: *annual, Group: *group, Description: *description}
+	start, err := parseDate(*date)
+	if err != nil {
+		return err
+	}
+	dday.Date = start.Format(ddayDateLayout)
+	if *endDate != "" {
+		end, err := parseDate(*endDate)
+		if err != nil {
+			return err
+		}
+		dday.EndDate = end.Format(ddayDateLayout)
+	}
+
+	c, err := a.client()
+	if err != nil {
+		return err
+	}
+	created, err := c.CreateDDay(ctx, dday)
+	if err != nil {
+		return err
+	}
+	return a.print(created, []string{"ID", "DATE", "TITLE"}, [][]string{{created.ID, displayDate(created.Date), created.Title}})
+}
+
+// Countdown is an upcoming event
+type Countdown struct {
+	ID     string `json:"id"`
+	Title  string `json:"title"`
+	Date   string `json:"date"`
+	Days   int    `json:"days"`
+	Annual bool   `json:"annual"`
+}
+
+func (a *app) countdown(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("countdown", flag.ContinueOnError)
+	limit := flags.Int("limit", 10, "how many events to show, 0 for all")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	c, err := a.client()
+	if err != nil {
+		return err
+	}
+	// events are listed by month, a year of them has every anniversary
+	ddays, err := listMonths(ctx, c, today(), 12)
+	if err != nil {
+		return err
+	}
+	upcoming := countdowns(ddays, today())
+	if *limit > 0 && len(upcoming) > *limit {
+		upcoming = upcoming[:*limit]
+	}
+
+	rows := [][]string{}
+	for _, u := range upcoming {
+		left := strconv.Itoa(u.Days) + " days"
+		switch u.Days {
+		case 0:
+			left = "today"
+		case 1:
+			left = "tomorrow"
+		}
+		rows = append(rows, []string{left, u.Date, u.Title})
+	}
+	return a.print(upcoming, []string{"IN", "DATE", "TITLE"}, rows)
+}
+
+// listMonths lists the events of months months from the month of from,
+// events over several months are listed once
+func listMonths(ctx context.Context, c *client.Client, from time.Time, months int) ([]handlers.DDay, error) {
+	seen := map[string]bool{}
+	out := []handlers.DDay{}
+	first := time.Date(from.Year(), from.Month(), 1, 0, 0, 0, 0, time.UTC)
+	for m := 0; m < months; m++ {
+		ddays, err := c.ListDDays(ctx, first.AddDate(0, m, 0).Format("200601"))
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range ddays {
+			if !seen[d.ID] {
+				seen[d.ID] = true
+				out = append(out, d)
+			}
+		}
+	}
+	return out, nil
+}
+
+// countdowns are the events on or after today, soonest first. yearly
+// events count down to their next anniversary.
+func countdowns(ddays []handlers.DDay, today time.Time) []Countdown {
+	out := []Countdown{}
+	for _, d := range ddays {
+		date, err := time.Parse(ddayDateLayout, d.Date)
+		if err != nil {
+			continue
+		}
+		if d.IsAnnual {
+			date = time.Date(today.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)
+			if date.Before(today) {
+				date = date.AddDate(1, 0, 0)
+			}
+		}
+		if date.Before(today) {
+			continue
+		}
+		out = append(out, Countdown{
+			ID:     d.ID,
+			Title:  d.Title,
+			Date:   date.Format(dateLayout),
+			Days:   daysBetween(today, date),
+			Annual: d.IsAnnual,
+		})
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Days < out[j].Days })
+	return out
+}
+
+// displayDate shows 20250601 as 2025-06-01
+func displayDate(d string) string {
+	t, err := time.Parse(ddayDateLayout, d)
+	if err != nil {
+		return d
+	}
+	return t.Format(dateLayout)
+}
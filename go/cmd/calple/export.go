@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"calple/api"
+	"calple/handlers"
+)
+
+// Export is everything of the user the API can list. checkins are only
+// fetched by date, so they are not part of it. events are listed by month
+// from the month the account was created until a year ahead.
+type Export struct {
+	ExportedAt    time.Time               `json:"exportedAt"`
+	User          *handlers.SelfProfile   `json:"user"`
+	Connection    *api.ConnectionStatus   `json:"connection"`
+	DDays         []handlers.DDay         `json:"ddays"`
+	PeriodDays    []handlers.PeriodDay    `json:"periodDays"`
+	CycleSettings *handlers.CycleSettings `json:"cycleSettings"`
+	Pins          []handlers.Pin          `json:"pins"`
+	Ideas         []handlers.Idea         `json:"ideas"`
+	Roulette      []handlers.Roulette     `json:"roulette"`
+	Feedback      []api.Feedback          `json:"feedback"`
+}
+
+func (a *app) export(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	file := flags.String("file", "", "write to this file instead of the terminal")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	c, err := a.client()
+	if err != nil {
+		return err
+	}
+	export := Export{ExportedAt: time.Now().UTC()}
+	status, err := c.AuthStatus(ctx)
+	if err != nil {
+		return err
+	}
+	if !status.Authenticated || status.User == nil {
+		return errors.New("not signed in, run calple login")
+	}
+	export.User = status.User
+	if export.Connection, err = c.Connection(ctx); err != nil {
+		return err
+	}
+	from, months := today(), 12
+	if created := status.User.CreatedAt; created != nil && created.Before(from) {
+		months += (from.Year()-created.Year())*12 + int(from.Month()-created.Month())
+		from = *created
+	}
+	if export.DDays, err = listMonths(ctx, c, from, months); err != nil {
+		return err
+	}
+	if export.PeriodDays, err = c.PeriodDays(ctx); err != nil {
+		return err
+	}
+	if export.CycleSettings, err = c.CycleSettings(ctx); err != nil {
+		return err
+	}
+	pins, err := c.Pins(ctx)
+	if err != nil {
+		return err
+	}
+	export.Pins = pins.Pins
+	if export.Ideas, err = c.Ideas(ctx); err != nil {
+		return err
+	}
+	if export.Roulette, err = c.RouletteIdeas(ctx); err != nil {
+		return err
+	}
+	if export.Feedback, err = c.Feedback(ctx); err != nil {
+		return err
+	}
+
+	// always JSON, -format only changes what is printed about it
+	data, err := json.MarshalIndent(export, "", "  ")
+	if err != nil {
+		return err
+	}
+	if *file == "" {
+		_, err = fmt.Fprintln(a.out, string(data))
+		return err
+	}
+	if err := os.WriteFile(*file, append(data, '\n'), 0o600); err != nil {
+		return err
+	}
+	summary := map[string]int{"ddays": len(export.DDays), "periodDays": len(export.PeriodDays), "pins": len(export.Pins), "ideas": len(export.Ideas)}
+	return a.print(summary, []string{"FILE", "DDAYS", "PERIOD DAYS", "PINS", "IDEAS"}, [][]string{{
+		*file, fmt.Sprint(len(export.DDays)), fmt.Sprint(len(export.PeriodDays)), fmt.Sprint(len(export.Pins)), fmt.Sprint(len(export.Ideas)),
+	}})
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"calple/client"
+)
+
+func (a *app) login(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("login", flag.ContinueOnError)
+	token := flags.String("token", "", "sign in with a personal access token instead of a device code")
+	scope := flags.String("scope", "", "space separated scopes to ask for, every scope by default")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	creds := &credentials{Server: a.server}
+	if *token != "" {
+		creds.Token = *token
+	} else {
+		host, _ := os.Hostname()
+		name := "calple CLI"
+		if host != "" {
+			name += " on " + host
+		}
+
+		c := client.New(a.server)
+		device, err := c.StartDeviceLogin(ctx, name, *scope)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(a.out, "Open %s\nand confirm the code %s\n", device.VerificationURIComplete, device.UserCode)
+		tokens, err := c.WaitDeviceLogin(ctx, device)
+		if err != nil {
+			return err
+		}
+		creds.RefreshToken = tokens.RefreshToken
+	}
+
+	// check the credentials work before saving them
+	a.creds = creds
+	c, err := a.client()
+	if err != nil {
+		return err
+	}
+	status, err := c.AuthStatus(ctx)
+	if err != nil {
+		return err
+	}
+	if !status.Authenticated || status.User == nil {
+		return errors.New("the server did not accept the token")
+	}
+	if err := creds.save(); err != nil {
+		return err
+	}
+	fmt.Fprintf(a.out, "Signed in as %s <%s>\n", status.User.Name, status.User.Email)
+	return nil
+}
+
+// logout forgets the credentials. tokens stay valid until they are revoked
+// in the app's settings.
+func (a *app) logout() error {
+	path, err := credentialsPath()
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	os.Remove(filepath.Dir(path))
+	fmt.Fprintln(a.out, "Signed out")
+	return nil
+}
+
+func (a *app) whoami(ctx context.Context) error {
+	c, err := a.client()
+	if err != nil {
+		return err
+	}
+	status, err := c.AuthStatus(ctx)
+	if err != nil {
+		return err
+	}
+	if !status.Authenticated || status.User == nil {
+		return errors.New("not signed in, run calple login")
+	}
+	u := status.User
+	return a.print(u, []string{"NAME", "EMAIL", "ID"}, [][]string{{u.Name, u.Email, u.ID}})
+}
@@ -0,0 +1,118 @@
+// calple is the command line client of the API
+//
+//	calple login                     sign in with a code confirmed in the browser
+//	calple login -token calple_pat_… sign in with a personal access token
+//	calple ddays                     list events
+//	calple ddays add -title … -date 2025-06-01 [-annual]
+//	calple countdown                 upcoming events, soonest first
+//	calple period log [-date …]      log a period day
+//	calple period next               the next predicted period
+//	calple checkin -mood … -energy …
+//	calple export [-file out.json]
+//
+// -format json prints JSON instead of tables. the server and credentials
+// are saved in the user config directory, CALPLE_SERVER and CALPLE_TOKEN
+// override them.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+
+	"calple/apierr"
+)
+
+const usage = `usage: calple [-server URL] [-format table|json] <command>
+
+commands:
+  login       sign in, by device code or with -token
+  logout      forget the saved credentials
+  whoami      show the signed in user
+  ddays       list events, "ddays add" and "ddays rm ID" change them
+  countdown   upcoming events and the days left until them
+  period      "period log", "period list" and "period next"
+  checkin     save today's checkin
+  export      write all your data as JSON
+`
+
+// app is the state shared by the commands
+type app struct {
+	server string
+	format string
+	out    io.Writer
+	creds  *credentials
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if err := run(ctx, os.Args[1:], os.Stdout); err != nil {
+		var apiErr *apierr.Error
+		if errors.As(err, &apiErr) && apiErr.Status == 401 {
+			err = fmt.Errorf("%w, sign in again with calple login", err)
+		}
+		fmt.Fprintln(os.Stderr, "calple:", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("calple", flag.ContinueOnError)
+	flags.Usage = func() { fmt.Fprint(flags.Output(), usage) }
+	server := flags.String("server", "", "address of the backend")
+	format := flags.String("format", "table", "output format, table or json")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *format != "table" && *format != "json" {
+		return fmt.Errorf("unknown format %q", *format)
+	}
+
+	creds, err := loadCredentials()
+	if err != nil {
+		return err
+	}
+	a := &app{server: creds.Server, format: *format, out: out, creds: creds}
+	if s := os.Getenv("CALPLE_SERVER"); s != "" {
+		a.server = s
+	}
+	if *server != "" {
+		a.server = *server
+	}
+	if a.server == "" {
+		a.server = "http://localhost:5000"
+	}
+
+	args = flags.Args()
+	if len(args) == 0 {
+		flags.Usage()
+		return flag.ErrHelp
+	}
+	cmd, args := args[0], args[1:]
+	switch cmd {
+	case "login":
+		return a.login(ctx, args)
+	case "logout":
+		return a.logout()
+	case "whoami":
+		return a.whoami(ctx)
+	case "ddays":
+		return a.ddays(ctx, args)
+	case "countdown":
+		return a.countdown(ctx, args)
+	case "period":
+		return a.period(ctx, args)
+	case "checkin":
+		return a.checkin(ctx, args)
+	case "export":
+		return a.export(ctx, args)
+	}
+	flags.Usage()
+	return fmt.Errorf("unknown command %q", cmd)
+}
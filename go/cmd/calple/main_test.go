@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"calple/apitoken"
+	"calple/client"
+	"calple/handlers"
+	"calple/server/servertest"
+)
+
+// cli runs the commands against a server in process, with the credentials
+// in a temporary config directory
+type cli struct {
+	t     *testing.T
+	s     *servertest.Server
+	token string
+}
+
+func startCLI(t *testing.T) *cli {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	t.Setenv("HOME", dir)
+	t.Setenv("CALPLE_SERVER", "")
+	t.Setenv("CALPLE_TOKEN", "")
+	return &cli{t: t, s: servertest.Start(t)}
+}
+
+// run runs a command and returns what it printed
+func (c *cli) run(args ...string) (string, error) {
+	var out bytes.Buffer
+	err := run(context.Background(), append([]string{"-server", c.s.URL}, args...), &out)
+	return out.String(), err
+}
+
+func (c *cli) must(args ...string) string {
+	c.t.Helper()
+	out, err := c.run(args...)
+	if err != nil {
+		c.t.Fatalf("calple %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return out
+}
+
+// json runs a command with -format json and decodes what it printed
+func (c *cli) json(v interface{}, args ...string) {
+	c.t.Helper()
+	out := c.must(append([]string{"-format", "json"}, args...)...)
+	if err := json.Unmarshal([]byte(out), v); err != nil {
+		c.t.Fatalf("calple %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+}
+
+// login signs in with a personal access token of email
+func (c *cli) login(email string) {
+	c.t.Helper()
+	c.token = c.s.Token(c.t, c.s.SignIn(c.t, email), apitoken.AllScopes()...)
+	c.must("login", "-token", c.token)
+}
+
+func savedCredentials(t *testing.T) credentials {
+	t.Helper()
+	path, err := credentialsPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var creds credentials
+	if err := json.Unmarshal(data, &creds); err != nil {
+		t.Fatal(err)
+	}
+	return creds
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+var userCodeLine = regexp.MustCompile(`confirm the code (\S+)`)
+
+func TestDeviceLogin(t *testing.T) {
+	if testing.Short() {
+		t.Skip("waits for the poll interval")
+	}
+	c := startCLI(t)
+	browser := c.s.SignIn(t, "ann@example.com")
+
+	// the command waits until the code is confirmed in the browser
+	r, w := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- run(context.Background(), []string{"-server", c.s.URL, "login"}, w)
+		w.Close()
+	}()
+	lines := bufio.NewScanner(r)
+	var userCode string
+	var printed []string
+	for lines.Scan() {
+		printed = append(printed, lines.Text())
+		if m := userCodeLine.FindStringSubmatch(lines.Text()); m != nil {
+			userCode = m[1]
+			c.s.ApproveDevice(t, browser, userCode)
+		}
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("login: %v\n%s", err, strings.Join(printed, "\n"))
+	}
+	if userCode == "" || !strings.HasPrefix(printed[len(printed)-1], "Signed in as") {
+		t.Fatalf("login printed %q", printed)
+	}
+
+	first := savedCredentials(t)
+	if first.Server != c.s.URL || first.RefreshToken == "" || first.Token != "" {
+		t.Fatalf("saved %+v", first)
+	}
+	if out := c.must("whoami"); !strings.Contains(out, "ann@example.com") {
+		t.Errorf("whoami printed %q", out)
+	}
+	// refresh tokens are single use, the next one replaces the saved one
+	if next := savedCredentials(t); next.RefreshToken == first.RefreshToken {
+		t.Error("the refresh token was not replaced")
+	}
+	c.must("whoami")
+
+	c.must("logout")
+	if path, _ := credentialsPath(); fileExists(path) {
+		t.Error("logout left the credentials")
+	}
+	if _, err := c.run("whoami"); err == nil || !strings.Contains(err.Error(), "not signed in") {
+		t.Errorf("whoami after logout: %v", err)
+	}
+}
+
+func TestTokenLogin(t *testing.T) {
+	c := startCLI(t)
+	if _, err := c.run("login", "-token", "calple_pat_nope"); err == nil {
+		t.Fatal("signed in with an unknown token")
+	}
+	if path, _ := credentialsPath(); fileExists(path) {
+		t.Fatal("saved credentials the server did not accept")
+	}
+
+	c.login("ann@example.com")
+	if creds := savedCredentials(t); creds.Token == "" || creds.Server != c.s.URL {
+		t.Errorf("saved %+v", creds)
+	}
+	var user struct {
+		Email string `json:"email"`
+	}
+	c.json(&user, "whoami")
+	if user.Email != "ann@example.com" {
+		t.Errorf("whoami %+v", user)
+	}
+}
+
+func TestCountdown(t *testing.T) {
+	c := startCLI(t)
+	c.login("ann@example.com")
+	day := func(days int) string { return today().AddDate(0, 0, days).Format(dateLayout) }
+
+	c.must("ddays", "add", "-title", "Concert", "-date", day(3))
+	c.must("ddays", "add", "-title", "Today", "-date", day(0))
+	c.must("ddays", "add", "-title", "Trip", "-date", day(45))
+	c.must("ddays", "add", "-title", "Past", "-date", day(-2))
+	// yesterday's anniversary is a year away
+	c.must("ddays", "add", "-title", "Anniversary", "-date", today().AddDate(-3, 0, -1).Format(dateLayout), "-annual")
+
+	var upcoming []Countdown
+	c.json(&upcoming, "countdown", "-limit", "0")
+	var got []string
+	for _, u := range upcoming {
+		got = append(got, u.Title)
+	}
+	if strings.Join(got, ",") != "Today,Concert,Trip,Anniversary" {
+		t.Fatalf("countdown %+v", upcoming)
+	}
+	if upcoming[1].Days != 3 || upcoming[1].Date != day(3) || upcoming[2].Days != 45 {
+		t.Errorf("countdown %+v", upcoming)
+	}
+	if a := upcoming[3]; !a.Annual || a.Date != today().AddDate(1, 0, -1).Format(dateLayout) {
+		t.Errorf("anniversary %+v", a)
+	}
+
+	out := c.must("countdown", "-limit", "2")
+	if !strings.Contains(out, "today") || !strings.Contains(out, "3 days") || strings.Contains(out, "Trip") {
+		t.Errorf("countdown table:\n%s", out)
+	}
+}
+
+func TestPeriodNext(t *testing.T) {
+	c := startCLI(t)
+	c.login("ann@example.com")
+	day := func(days int) string { return today().AddDate(0, 0, days).Format(dateLayout) }
+
+	if _, err := c.run("period", "next"); err == nil || !strings.Contains(err.Error(), "no period days") {
+		t.Fatalf("prediction without period days: %v", err)
+	}
+
+	// two periods, the second one started 10 days ago
+	for _, d := range []int{-38, -37, -36, -10, -9} {
+		c.must("period", "log", "-date", day(d), "-cramps", "2")
+	}
+	c.must("period", "log", "-date", day(-1), "-period=false", "-symptoms", "headache")
+
+	var p Prediction
+	c.json(&p, "period", "next")
+	if p.LastStart != day(-10) || p.Start != day(18) || p.End != day(22) || p.Days != 18 || p.CycleLength != 28 {
+		t.Errorf("default cycle: %+v", p)
+	}
+
+	// the saved cycle settings count
+	api := client.New(c.s.URL, client.WithToken(c.token))
+	if _, err := api.UpdateCycleSettings(context.Background(), handlers.CycleSettings{CycleLength: 30, PeriodLength: 4}); err != nil {
+		t.Fatal(err)
+	}
+	c.json(&p, "period", "next")
+	if p.Start != day(20) || p.End != day(23) || p.CycleLength != 30 {
+		t.Errorf("30 day cycle: %+v", p)
+	}
+
+	var days []struct {
+		Date     string `json:"date"`
+		IsPeriod bool   `json:"isPeriod"`
+	}
+	c.json(&days, "period", "list")
+	if len(days) != 6 || days[0].Date != day(-1) || days[0].IsPeriod {
+		t.Errorf("period list %+v", days)
+	}
+}
+
+func TestExport(t *testing.T) {
+	c := startCLI(t)
+	c.login("ann@example.com")
+	c.must("ddays", "add", "-title", "Concert", "-date", today().Format(dateLayout))
+	c.must("ddays", "add", "-title", "Trip", "-date", today().AddDate(0, 3, 0).Format(dateLayout))
+	c.must("period", "log")
+
+	file := filepath.Join(t.TempDir(), "export.json")
+	if out := c.must("export", "-file", file); !strings.Contains(out, file) {
+		t.Errorf("export printed %q", out)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var export Export
+	if err := json.Unmarshal(data, &export); err != nil {
+		t.Fatal(err)
+	}
+	if export.User == nil || export.User.Email != "ann@example.com" ||
+		len(export.DDays) != 2 || export.DDays[0].Title != "Concert" || export.DDays[1].Title != "Trip" ||
+		len(export.PeriodDays) != 1 || export.CycleSettings == nil ||
+		export.Connection == nil || export.Connection.Connected {
+		t.Errorf("export %s", data)
+	}
+	if info, err := os.Stat(file); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("export file mode %v, %v", info.Mode(), err)
+	}
+
+	// without -file it goes to the terminal
+	var printed Export
+	if err := json.Unmarshal([]byte(c.must("export")), &printed); err != nil || len(printed.DDays) != 2 {
+		t.Errorf("printed export %+v, %v", printed, err)
+	}
+}
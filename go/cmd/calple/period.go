@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"calple/handlers"
+)
+
+func (a *app) period(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: calple period log|list|next")
+	}
+	switch args[0] {
+	case "log":
+		return a.logPeriodDay(ctx, args[1:])
+	case "list":
+		return a.listPeriodDays(ctx)
+	case "next":
+		return a.nextPeriod(ctx)
+	}
+	return fmt.Errorf("unknown period command %q, use log, list or next", args[0])
+}
+
+func (a *app) logPeriodDay(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("period log", flag.ContinueOnError)
+	date := flags.String("date", today().Format(dateLayout), "the day, 2025-06-01")
+	isPeriod := flags.Bool("period", true, "whether it is a period day, -period=false logs symptoms only")
+	symptoms := flags.String("symptoms", "", "comma separated symptoms")
+	mood := flags.String("mood", "", "comma separated moods")
+	activities := flags.String("activities", "", "comma separated activities")
+	cramps := flags.Int64("cramps", 0, "cramp intensity from 0 to 10")
+	notes := flags.String("notes", "", "notes")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if _, err := parseDate(*date); err != nil {
+		return err
+	}
+
+	c, err := a.client()
+	if err != nil {
+		return err
+	}
+	saved, err := c.SavePeriodDay(ctx, handlers.PeriodDay{
+		Date:           *date,
+		IsPeriod:       *isPeriod,
+		Symptoms:       splitList(*symptoms),
+		Mood:           splitList(*mood),
+		Activities:     splitList(*activities),
+		CrampIntensity: *cramps,
+		Notes:          *notes,
+	})
+	if err != nil {
+		return err
+	}
+	return a.print(saved, []string{"DATE", "PERIOD", "SYMPTOMS"}, [][]string{periodRow(*saved)[:3]})
+}
+
+func (a *app) listPeriodDays(ctx context.Context) error {
+	c, err := a.client()
+	if err != nil {
+		return err
+	}
+	days, err := c.PeriodDays(ctx)
+	if err != nil {
+		return err
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i].Date > days[j].Date })
+
+	rows := [][]string{}
+	for _, d := range days {
+		rows = append(rows, periodRow(d))
+	}
+	return a.print(days, []string{"DATE", "PERIOD", "SYMPTOMS", "CRAMPS", "NOTES"}, rows)
+}
+
+func periodRow(d handlers.PeriodDay) []string {
+	period := "no"
+	if d.IsPeriod {
+		period = "yes"
+	}
+	return []string{d.Date, period, strings.Join(d.Symptoms, ", "), strconv.FormatInt(d.CrampIntensity, 10), d.Notes}
+}
+
+// Prediction is the next expected period
+type Prediction struct {
+	Start       string `json:"start"`
+	End         string `json:"end"`
+	Days        int    `json:"days"`
+	LastStart   string `json:"lastStart"`
+	CycleLength int64  `json:"cycleLength"`
+}
+
+func (a *app) nextPeriod(ctx context.Context) error {
+	c, err := a.client()
+	if err != nil {
+		return err
+	}
+	days, err := c.PeriodDays(ctx)
+	if err != nil {
+		return err
+	}
+	settings, err := c.CycleSettings(ctx)
+	if err != nil {
+		return err
+	}
+	p, err := predictPeriod(days, *settings, today())
+	if err != nil {
+		return err
+	}
+	return a.print(p, []string{"START", "END", "IN", "CYCLE"}, [][]string{{p.Start, p.End, strconv.Itoa(p.Days) + " days", strconv.FormatInt(p.CycleLength, 10) + " days"}})
+}
+
+// predictPeriod counts cycles from the start of the last logged period,
+// a start is a period day whose day before is not one
+func predictPeriod(days []handlers.PeriodDay, settings handlers.CycleSettings, today time.Time) (*Prediction, error) {
+	periodDays := map[string]bool{}
+	for _, d := range days {
+		if d.IsPeriod {
+			periodDays[d.Date] = true
+		}
+	}
+	var last time.Time
+	for date := range periodDays {
+		t, err := time.Parse(dateLayout, date)
+		if err != nil || periodDays[t.AddDate(0, 0, -1).Format(dateLayout)] {
+			continue
+		}
+		if t.After(last) {
+			last = t
+		}
+	}
+	if last.IsZero() {
+		return nil, errors.New("no period days logged yet, log one with calple period log")
+	}
+
+	cycle, length := settings.CycleLength, settings.PeriodLength
+	if cycle <= 0 {
+		cycle = 28
+	}
+	if length <= 0 {
+		length = 5
+	}
+	next := last.AddDate(0, 0, int(cycle))
+	for next.Before(today) {
+		next = next.AddDate(0, 0, int(cycle))
+	}
+	return &Prediction{
+		Start:       next.Format(dateLayout),
+		End:         next.AddDate(0, 0, int(length)-1).Format(dateLayout),
+		Days:        daysBetween(today, next),
+		LastStart:   last.Format(dateLayout),
+		CycleLength: cycle,
+	}, nil
+}
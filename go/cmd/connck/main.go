@@ -0,0 +1,74 @@
+// connck finds (and with -repair fixes) inconsistent connection pairs and
+// pending invitations past their expiry, run it on a schedule with -repair
+// so unread invitations expire
+//
+//	go run ./cmd/connck           report only
+//	go run ./cmd/connck -repair   report and repair
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"calple/config"
+	"calple/firebase"
+	"calple/handlers"
+
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	_ = godotenv.Load()
+
+	repair := flag.Bool("repair", false, "repair the problems that are found")
+	asJSON := flag.Bool("json", false, "print the report as JSON")
+	flag.Parse()
+
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	ctx := context.Background()
+	fsClient, err := firebase.InitFirebase(ctx, cfg.Firebase)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed to initialize firestore:", err)
+		os.Exit(1)
+	}
+	defer fsClient.Close()
+
+	report, err := handlers.CheckConnections(ctx, fsClient, *repair)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "check failed:", err)
+		os.Exit(1)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	} else {
+		fmt.Printf("checked %d connections, found %d issues\n", report.Connections, len(report.Issues))
+		for _, issue := range report.Issues {
+			state := ""
+			if *repair {
+				state = " [not repaired]"
+				if issue.Repaired {
+					state = " [repaired]"
+				}
+			}
+			fmt.Printf("%-20s %s %v: %s%s\n", issue.Kind, issue.ConnectionID, issue.Users, issue.Detail, state)
+		}
+	}
+
+	// non-zero exit when something is still broken so it can run in CI/cron
+	for _, issue := range report.Issues {
+		if !issue.Repaired {
+			os.Exit(2)
+		}
+	}
+}
@@ -2,19 +2,29 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
-	"strings"
+	"os/signal"
+	"syscall"
 	"time"
 
+	"calple/apierr"
+	"calple/auth"
+	"calple/config"
+	"calple/events"
 	"calple/firebase"
-	"calple/handlers"
+	"calple/logging"
+	"calple/mailer"
+	"calple/ratelimit"
+	"calple/secrets"
+	"calple/server"
+	"calple/sessionstore"
+	"calple/telemetry"
+	"calple/trash"
 
-	"cloud.google.com/go/firestore"
-	"github.com/gin-contrib/cors"
-	"github.com/gin-contrib/sessions"
-	"github.com/gin-contrib/sessions/cookie"
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
 )
@@ -22,212 +32,145 @@ import (
 func main() {
 	_ = godotenv.Load()
 
-	env := os.Getenv("ENV")
-	fmt.Println("ENV:", env)
-
-	if os.Getenv("SECRET_KEY") == "" {
-		panic("FATAL: SECRET_KEY environment variable is not set")
+	// settings come from the environment and the optional CONFIG_FILE,
+	// every problem is reported before anything starts
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
 
-	// create context
-	ctx := context.Background()
+	// structured logs, JSON outside development
+	logger := logging.New(os.Stdout, cfg.Log)
+	slog.SetDefault(logger)
+	logger.Info("starting", "env", cfg.Env, "port", cfg.Port)
+
+	// cancelled on SIGINT or SIGTERM, which starts the shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// traces go to an OTLP collector when OTEL_EXPORTER_OTLP_ENDPOINT is set
+	shutdownTracing, err := telemetry.SetupTracing(ctx, cfg.Tracing, cfg.Env)
+	if err != nil {
+		logger.Error("failed to set up tracing", "error", err)
+		os.Exit(1)
+	}
+	// flush the remaining spans even though ctx is cancelled by then
+	defer shutdownTracing(context.WithoutCancel(ctx))
 
-	// initialize firestore client
-	fsClient, err := firebase.InitFirebase(ctx)
+	// initialize firestore client, its calls are counted and traced
+	fsClient, err := firebase.InitFirebase(ctx, cfg.Firebase, telemetry.FirestoreOptions()...)
 	if err != nil {
-		panic(err)
+		logger.Error("failed to initialize firestore", "error", err)
+		os.Exit(1)
 	}
 	defer fsClient.Close()
+	go telemetry.TrackActiveCouples(ctx, fsClient, 5*time.Minute)
+	// deleted data stays restorable for TRASH_RETENTION
+	go trash.Run(ctx, fsClient, cfg.Trash.Retention.D(), cfg.Trash.PurgeInterval.D())
 
-	router := gin.Default()
+	// oauth tokens are envelope encrypted with TOKEN_ENCRYPTION_KEY, without
+	// it logins still work but the tokens are not stored
+	tokenKeys, err := secrets.FromConfig(cfg.TokenEncryption)
+	if err != nil {
+		if !errors.Is(err, secrets.ErrNoKey) {
+			logger.Error("invalid token encryption keys", "error", err)
+			os.Exit(1)
+		}
+		logger.Warn("TOKEN_ENCRYPTION_KEY is not set, oauth tokens will not be stored")
+	}
 
-	// trusted proxies for prod environment
-	if os.Getenv("ENV") != "development" {
-		router.SetTrustedProxies([]string{"0.0.0.0/0"})
+	// sign in providers with credentials in the configuration
+	authProviders, err := auth.FromConfig(cfg)
+	if err != nil {
+		logger.Error("failed to configure sign in providers", "error", err)
+		os.Exit(1)
+	}
+
+	// sign in by email, nil when no mail transport is configured
+	mail := mailer.FromConfig(cfg, logger)
+
+	// live updates, handlers publish changes to the streams of both partners
+	hub := events.NewHub()
+
+	// request structs validate themselves with binding tags
+	if err := apierr.RegisterValidators(); err != nil {
+		logger.Error("failed to register validators", "error", err)
+		os.Exit(1)
 	}
 
 	// set gin mode for prod
 	// in development mode, gin will log requests and errors
 	// in production avoid logging requests for performance and security
-	if os.Getenv("ENV") != "development" {
+	if !cfg.IsDevelopment() {
 		gin.SetMode(gin.ReleaseMode)
 	}
 
-	// session
-	store := cookie.NewStore([]byte(os.Getenv("SECRET_KEY")))
-	store.Options(sessions.Options{
-		Path:     "/",
-		HttpOnly: true,
-		Secure:   os.Getenv("ENV") != "development",
-		SameSite: func() http.SameSite {
-			if os.Getenv("ENV") == "development" {
-				return http.SameSiteLaxMode
-			}
-			return http.SameSiteNoneMode
-		}(),
-		Domain: func() string {
-			if os.Getenv("ENV") == "development" {
-				return ""
-			}
-			return ".calple.date"
-		}(),
-		MaxAge: 12 * 60 * 60,
-	})
-	router.Use(sessions.Sessions("calple_session", store))
-
-	// CORS
-	corsConfig := cors.Config{
-		AllowOrigins:     []string{os.Getenv("FRONTEND_URL"), "https://www.calple.date", "https://calple.date"},
-		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE"},
-		AllowHeaders:     []string{"Content-Type", "Authorization"},
-		ExposeHeaders:    []string{"Set-Cookie"},
-		AllowCredentials: true,
-		MaxAge:           12 * time.Hour,
+	// sessions live where SESSION_STORE says
+	var sessionBackend sessionstore.Backend = sessionstore.NewFirestoreBackend(fsClient)
+	if cfg.Session.Store == "memory" {
+		sessionBackend = sessionstore.NewMemoryBackend()
 	}
-	router.Use(cors.New(corsConfig))
-
-	// firestore into context
-	// this middleware sets the firestore client in the context for use in handlers
-	router.Use(func(c *gin.Context) {
-		c.Set("firestore", fsClient)
-		c.Next()
-	})
 
-	// logging middleware for debugging
-	router.Use(func(c *gin.Context) {
-		start := time.Now()
-		path := c.Request.URL.Path
-		method := c.Request.Method
-
-		// process request
-		c.Next()
-
-		status := c.Writer.Status()
-		latency := time.Since(start)
-
-		fmt.Printf("DEBUG: %s %s - Status: %d, Latency: %v\n", method, path, status, latency)
-
-		if status >= 400 {
-			fmt.Printf("DEBUG: Error request - Method: %s, Path: %s, Status: %d, User-Agent: %s\n",
-				method, path, status, c.Request.UserAgent())
-		}
-	})
-
-	// auth routes
-	router.GET("/google/oauth/login", handlers.Login)
-	router.GET("/google/oauth/callback", handlers.Callback)
-	router.GET("/api/auth/status", handlers.AuthStatus)
-	router.GET("/google/oauth/logout", handlers.Logout)
-
-	// health check endpoint
-	router.GET("/health", func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{
-			"status":      "healthy",
-			"timestamp":   time.Now().UTC(),
-			"environment": os.Getenv("ENV"),
-		})
-	})
-
-	// firebase connectivity test endpoint
-	router.GET("/api/health/firebase", func(c *gin.Context) {
-		fsClient := c.MustGet("firestore").(*firestore.Client)
-		ctx := context.Background()
-
-		// try to access Firestore to test connectivity
-		_, err := fsClient.Collection("_health_check").Doc("test").Get(ctx)
-		if err != nil {
-			// this is expected to fail since the document doesn't exist
-			if strings.Contains(err.Error(), "NotFound") {
-				c.JSON(http.StatusOK, gin.H{
-					"status":  "firebase_connected",
-					"message": "Firebase connection is working (document not found is expected)",
-				})
-			} else {
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"status": "firebase_error",
-					"error":  err.Error(),
-				})
-			}
-			return
-		}
+	// rate limits, the buckets live where RATE_LIMIT_STORE says
+	var limiter ratelimit.Store
+	switch cfg.RateLimitStore {
+	case "memory":
+		limiter = ratelimit.NewMemoryStore()
+	case "firestore":
+		limiter = ratelimit.NewFirestoreStore(fsClient)
+	}
 
-		c.JSON(http.StatusOK, gin.H{
-			"status":  "firebase_connected",
-			"message": "Firebase connection is working",
-		})
-	})
-
-	api := router.Group("/api")
-	{
-		// dday event routes
-		api.GET("/ddays", handlers.GetDDays)
-		api.POST("/ddays", handlers.CreateDDay)
-		api.PUT("/ddays/:id", handlers.UpdateDDay)
-		api.DELETE("/ddays/:id", handlers.DeleteDDay)
-		api.POST("/ddays/upload-url", handlers.GetDDayUploadURL)
-
-		// connection routes
-		api.GET("/connection", handlers.GetConnection)
-		api.POST("/connection/invite", handlers.InviteConnection)
-		api.GET("/connection/pending", handlers.GetPendingInvitations)
-		api.POST("/connection/:id/accept", handlers.AcceptInvitation)
-		api.POST("/connection/:id/reject", handlers.RejectInvitation)
-
-		// idea routes
-		api.GET("/ideas/all", handlers.GetAllPosts)
-		api.GET("/ideas", handlers.GetPost)
-		api.POST("/ideas", handlers.AddPost)
-		api.PUT("/ideas/:id", handlers.UpdatePost)
-		api.DELETE("/ideas/:id", handlers.DeletePost)
-
-		// roulette routes
-		api.GET("/roulette", handlers.GetIdeaRoulette)
-		api.POST("/roulette", handlers.AddIdeaRoulette)
-		api.PUT("/roulette/:id", handlers.EditIdeaRoulette)
-		api.DELETE("/roulette/:id", handlers.DeleteIdeaRoulette)
-
-		// period tracking routes
-		api.GET("/periods/days", handlers.GetPeriodDays)
-		api.GET("/periods/partner/days", handlers.GetPartnerPeriodDays)
-		api.POST("/periods/days", handlers.CreatePeriodDay)
-		api.DELETE("/periods/days/:date", handlers.DeletePeriodDay)
-
-		api.GET("/periods/settings", handlers.GetCycleSettings)
-		api.PUT("/periods/settings", handlers.UpdateCycleSettings)
-
-		// user routes
-		api.GET("/user/metadata", handlers.GetUserMetadata)
-		api.PUT("/user/metadata", handlers.UpdateUserMetadata)
-		api.GET("/user/partner/metadata", handlers.GetPartnerMetadata)
-		api.DELETE("/user", handlers.DeleteUser)
-
-		// checkin routes
-		api.POST("/checkin", handlers.CreateCheckin)
-		api.GET("/checkin/:date", handlers.GetTodayCheckin)
-		api.DELETE("/checkin/:date", handlers.DeleteCheckin)
-		api.GET("/checkin/partner/:date", handlers.GetPartnerCheckin)
-
-		// debug route
-		api.GET("/debug/connection", handlers.DebugConnection)
-
-		// feedback routes
-		api.POST("/feedback", handlers.SubmitFeedback)
-		api.GET("/feedback", handlers.GetUserFeedback)
-
-		// map pin routes
-		pins := api.Group("/pins")
-		{
-			pins.GET("", handlers.GetPins)
-			pins.POST("", handlers.CreatePin)
-			pins.PUT("/:id", handlers.UpdatePin)
-			pins.DELETE("/:id", handlers.DeletePin)
-		}
+	deps := server.Deps{
+		Config:    cfg,
+		Logger:    logger,
+		Firestore: fsClient,
+		Sessions:  sessionBackend,
+		Providers: authProviders,
+		Events:    hub,
+		Mailer:    mail,
+		Limiter:   limiter,
+	}
+	if tokenKeys != nil {
+		deps.Secrets = tokenKeys
+	}
+	router, err := server.New(deps)
+	if err != nil {
+		logger.Error("failed to set up the router", "error", err)
+		os.Exit(1)
 	}
 
 	// run server
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "5000"
+	srv := &http.Server{
+		Addr:              ":" + cfg.Port,
+		Handler:           router,
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout.D(),
+		ReadTimeout:       cfg.Server.ReadTimeout.D(),
+		WriteTimeout:      cfg.Server.WriteTimeout.D(),
+		IdleTimeout:       cfg.Server.IdleTimeout.D(),
+	}
+	// event streams never finish on their own, end them so shutdown doesn't
+	// wait for them
+	srv.RegisterOnShutdown(hub.Close)
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
+		logger.Error("server stopped", "error", err)
+		os.Exit(1)
+	case <-ctx.Done():
+	}
+
+	// stop accepting connections and let in flight requests finish
+	logger.Info("shutting down", "timeout", cfg.Server.ShutdownTimeout.D())
+	stop()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout.D())
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		logger.Error("shutdown did not finish", "error", err)
 	}
-	router.Run(":" + port)
+	logger.Info("stopped")
 }
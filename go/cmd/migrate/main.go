@@ -0,0 +1,143 @@
+// migrate applies and rolls back the data migrations registered in calple/migrate
+//
+//	go run ./cmd/migrate status           list migrations and their state
+//	go run ./cmd/migrate up               apply every pending migration
+//	go run ./cmd/migrate up <id>          apply a single migration
+//	go run ./cmd/migrate down <id>        roll back a migration
+//
+// -dry-run reports what would change without writing anything.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"calple/config"
+	"calple/firebase"
+	"calple/migrate"
+
+	"github.com/joho/godotenv"
+)
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: migrate [-dry-run] [-batch n] status | up [id] | down <id>")
+	flag.PrintDefaults()
+}
+
+func main() {
+	_ = godotenv.Load()
+
+	dryRun := flag.Bool("dry-run", false, "only report what would change")
+	batch := flag.Int("batch", 200, "documents per batch")
+	flag.Usage = usage
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	ctx := context.Background()
+	fsClient, err := firebase.InitFirebase(ctx, cfg.Firebase)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed to initialize firestore:", err)
+		os.Exit(1)
+	}
+	defer fsClient.Close()
+
+	runner := migrate.NewRunner(fsClient, migrate.Options{
+		DryRun:    *dryRun,
+		BatchSize: *batch,
+		Config:    cfg,
+		Log: func(format string, args ...interface{}) {
+			fmt.Printf(format+"\n", args...)
+		},
+	})
+
+	lookup := func(id string) migrate.Migration {
+		m, ok := migrate.Get(id)
+		if !ok {
+			fmt.Fprintln(os.Stderr, "unknown migration:", id)
+			os.Exit(2)
+		}
+		return m
+	}
+
+	switch args[0] {
+	case "status":
+		for _, m := range migrate.All() {
+			state, err := runner.LoadState(ctx, m.ID)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to load state of %s: %v\n", m.ID, err)
+				os.Exit(1)
+			}
+			status := "pending"
+			if state != nil {
+				status = state.Status
+				if state.Status == migrate.StatusRunning {
+					status = fmt.Sprintf("%s (%s, %d processed)", state.Status, state.Direction, state.Processed)
+				}
+			}
+			fmt.Printf("%-32s %-28s %s\n", m.ID, status, m.Description)
+		}
+
+	case "up":
+		var results []migrate.Result
+		if len(args) > 1 {
+			var res migrate.Result
+			res, err = runner.Apply(ctx, lookup(args[1]))
+			results = []migrate.Result{res}
+		} else {
+			results, err = runner.Up(ctx)
+		}
+		report(results, *dryRun)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "migration failed:", err)
+			os.Exit(1)
+		}
+
+	case "down":
+		if len(args) < 2 {
+			usage()
+			os.Exit(2)
+		}
+		res, err := runner.Rollback(ctx, lookup(args[1]))
+		if errors.Is(err, migrate.ErrIrreversible) {
+			fmt.Fprintf(os.Stderr, "%s can't be rolled back\n", args[1])
+			os.Exit(1)
+		}
+		report([]migrate.Result{res}, *dryRun)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "rollback failed:", err)
+			os.Exit(1)
+		}
+
+	default:
+		usage()
+		os.Exit(2)
+	}
+}
+
+func report(results []migrate.Result, dryRun bool) {
+	prefix := ""
+	if dryRun {
+		prefix = "[dry-run] "
+	}
+	for _, res := range results {
+		if res.Skipped != "" {
+			fmt.Printf("%s%s: %s\n", prefix, res.ID, res.Skipped)
+			continue
+		}
+		fmt.Printf("%s%s: %d processed, %d updated\n", prefix, res.ID, res.Processed, res.Updated)
+	}
+}
@@ -0,0 +1,36 @@
+// mockoidc runs a local OpenID Connect provider that signs everyone in
+// without a password. point the API at it to try the OIDC flow:
+//
+//	go run ./cmd/mockoidc -addr :9000
+//	OIDC_ISSUER=http://localhost:9000 OIDC_CLIENT_ID=calple OIDC_CLIENT_SECRET=dev go run ./cmd
+//
+// sign in as someone else with /auth/oidc/login?login_hint=partner@example.com
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+
+	"calple/auth/oidcmock"
+)
+
+func main() {
+	addr := flag.String("addr", ":9000", "listen address")
+	issuer := flag.String("issuer", "http://localhost:9000", "issuer URL, must match how the API reaches this server")
+	clientID := flag.String("client-id", "calple", "accepted client_id")
+	flag.Parse()
+
+	server, err := oidcmock.New(*issuer, *clientID)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed to create provider:", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("mock OIDC issuer %s (client_id %s) listening on %s\n", server.Issuer, *clientID, *addr)
+	if err := http.ListenAndServe(*addr, server); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
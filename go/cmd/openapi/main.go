@@ -0,0 +1,37 @@
+// openapi prints the OpenAPI document of /api/v1, or checks the committed
+// one is current. the check fails when a handler's request or response
+// types changed without the spec being regenerated.
+//
+//	go run ./cmd/openapi > api/openapi.json   regenerate
+//	go run ./cmd/openapi -check api/openapi.json
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+
+	"calple/api"
+)
+
+func main() {
+	check := flag.String("check", "", "compare with this file instead of printing")
+	flag.Parse()
+
+	spec := api.SpecJSON()
+	if *check == "" {
+		os.Stdout.Write(spec)
+		return
+	}
+
+	committed, err := os.ReadFile(*check)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if !bytes.Equal(committed, spec) {
+		fmt.Fprintf(os.Stderr, "%s is out of date with the handlers, regenerate it with go run ./cmd/openapi > %s\n", *check, *check)
+		os.Exit(1)
+	}
+}
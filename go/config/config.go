@@ -0,0 +1,352 @@
+// Package config loads the server settings once at startup: defaults, then
+// an optional YAML or TOML file (CONFIG_FILE), then environment variables.
+// everything is validated up front and all problems are reported together.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	Development = "development"
+	Staging     = "staging"
+	Production  = "production"
+)
+
+// Config is the typed configuration of the API server. file keys are the
+// yaml/toml names, env tags name the variable that overrides them. env
+// tags on structs are prefixes for their fields.
+type Config struct {
+	// development relaxes cookies and validation, anything else is deployed
+	Env  string `yaml:"env" toml:"env" env:"ENV"`
+	Port string `yaml:"port" toml:"port" env:"PORT"`
+
+	// public URL of this API, callbacks and emailed links point here
+	APIBaseURL  string `yaml:"apiBaseURL" toml:"apiBaseURL" env:"API_BASE_URL"`
+	FrontendURL string `yaml:"frontendURL" toml:"frontendURL" env:"FRONTEND_URL"`
+	// origins besides FRONTEND_URL allowed by CORS and as sign in redirects
+	CORSOrigins []string `yaml:"corsOrigins" toml:"corsOrigins" env:"CORS_ORIGINS"`
+	// origins only allowed as sign in redirects, e.g. preview deployments
+	RedirectOrigins []string `yaml:"redirectOrigins" toml:"redirectOrigins" env:"ALLOWED_REDIRECT_ORIGINS"`
+
+	Server   Server   `yaml:"server" toml:"server"`
+	Log      Log      `yaml:"log" toml:"log" env:"LOG_"`
+	Session  Session  `yaml:"session" toml:"session"`
+	Firebase Firebase `yaml:"firebase" toml:"firebase" env:"FIREBASE_"`
+	// TOKEN_ENCRYPTION_KEY envelope encrypts stored oauth tokens
+	TokenEncryption KeySet `yaml:"tokenEncryption" toml:"tokenEncryption" env:"TOKEN_ENCRYPTION_"`
+
+	Google OAuthClient `yaml:"google" toml:"google" env:"OAUTH2_"`
+	GitHub OAuthClient `yaml:"github" toml:"github" env:"GITHUB_"`
+	OIDC   OIDC        `yaml:"oidc" toml:"oidc" env:"OIDC_"`
+	Apple  Apple       `yaml:"apple" toml:"apple" env:"APPLE_"`
+
+	Mail Mail `yaml:"mail" toml:"mail"`
+	R2   R2   `yaml:"r2" toml:"r2" env:"R2_"`
+
+	Trash Trash `yaml:"trash" toml:"trash" env:"TRASH_"`
+
+	Metrics Metrics `yaml:"metrics" toml:"metrics" env:"METRICS_"`
+	Tracing Tracing `yaml:"tracing" toml:"tracing" env:"OTEL_"`
+
+	// rate limit buckets are kept in "memory", in "firestore" to share them
+	// between instances, or limiting is "off". the global and per user API
+	// limits count in memory either way.
+	RateLimitStore string `yaml:"rateLimitStore" toml:"rateLimitStore" env:"RATE_LIMIT_STORE"`
+}
+
+// Server holds the http.Server timeouts. RequestTimeout is the deadline
+// handlers and their Firestore calls get, it has to fit in WriteTimeout.
+type Server struct {
+	ReadHeaderTimeout Duration `yaml:"readHeaderTimeout" toml:"readHeaderTimeout" env:"READ_HEADER_TIMEOUT"`
+	ReadTimeout       Duration `yaml:"readTimeout" toml:"readTimeout" env:"READ_TIMEOUT"`
+	WriteTimeout      Duration `yaml:"writeTimeout" toml:"writeTimeout" env:"WRITE_TIMEOUT"`
+	IdleTimeout       Duration `yaml:"idleTimeout" toml:"idleTimeout" env:"IDLE_TIMEOUT"`
+	RequestTimeout    Duration `yaml:"requestTimeout" toml:"requestTimeout" env:"REQUEST_TIMEOUT"`
+	// how long in-flight requests may take to finish after SIGTERM
+	ShutdownTimeout Duration `yaml:"shutdownTimeout" toml:"shutdownTimeout" env:"SHUTDOWN_TIMEOUT"`
+
+	// the client IP keys rate limits and is shown in the session list.
+	// X-Forwarded-For is only believed from TRUSTED_PROXIES, addresses or
+	// CIDRs of the proxies in front of the server, "none" when clients
+	// connect directly. TRUSTED_PLATFORM takes it from the header of a
+	// platform that sets it: cloudflare, appengine or flyio.
+	TrustedProxies  []string `yaml:"trustedProxies" toml:"trustedProxies" env:"TRUSTED_PROXIES"`
+	TrustedPlatform string   `yaml:"trustedPlatform" toml:"trustedPlatform" env:"TRUSTED_PLATFORM"`
+}
+
+// Duration is a time.Duration written like "15s" in files and variables
+type Duration time.Duration
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (d Duration) D() time.Duration {
+	return time.Duration(d)
+}
+
+type Log struct {
+	// debug, info, warn or error
+	Level string `yaml:"level" toml:"level" env:"LEVEL"`
+	// json or text
+	Format string `yaml:"format" toml:"format" env:"FORMAT"`
+}
+
+type Session struct {
+	// SECRET_KEY signs session cookies, invite and magic links. previous
+	// keys keep cookies signed before a rotation valid.
+	SecretKey         string   `yaml:"secretKey" toml:"secretKey" env:"SECRET_KEY"`
+	SecretKeyPrevious []string `yaml:"secretKeyPrevious" toml:"secretKeyPrevious" env:"SECRET_KEY_PREVIOUS"`
+	// "firestore" or "memory"
+	Store        string `yaml:"store" toml:"store" env:"SESSION_STORE"`
+	CookieDomain string `yaml:"cookieDomain" toml:"cookieDomain" env:"COOKIE_DOMAIN"`
+}
+
+type Firebase struct {
+	CredentialsJSON string `yaml:"credentialsJSON" toml:"credentialsJSON" env:"CREDENTIALS_JSON"`
+	CredentialsFile string `yaml:"credentialsFile" toml:"credentialsFile" env:"CREDENTIALS_FILE"`
+}
+
+// KeySet is a base64 encoded 32 byte key and the keys it replaced
+type KeySet struct {
+	Key      string   `yaml:"key" toml:"key" env:"KEY"`
+	Previous []string `yaml:"previous" toml:"previous" env:"KEY_PREVIOUS"`
+}
+
+type OAuthClient struct {
+	ClientID     string `yaml:"clientID" toml:"clientID" env:"CLIENT_ID"`
+	ClientSecret string `yaml:"clientSecret" toml:"clientSecret" env:"CLIENT_SECRET"`
+}
+
+type OIDC struct {
+	Issuer       string   `yaml:"issuer" toml:"issuer" env:"ISSUER"`
+	Name         string   `yaml:"name" toml:"name" env:"NAME"`
+	Scopes       []string `yaml:"scopes" toml:"scopes" env:"SCOPES"`
+	ClientID     string   `yaml:"clientID" toml:"clientID" env:"CLIENT_ID"`
+	ClientSecret string   `yaml:"clientSecret" toml:"clientSecret" env:"CLIENT_SECRET"`
+}
+
+type Apple struct {
+	ClientID string `yaml:"clientID" toml:"clientID" env:"CLIENT_ID"`
+	TeamID   string `yaml:"teamID" toml:"teamID" env:"TEAM_ID"`
+	KeyID    string `yaml:"keyID" toml:"keyID" env:"KEY_ID"`
+	// PEM encoded ES256 key
+	PrivateKey string `yaml:"privateKey" toml:"privateKey" env:"PRIVATE_KEY"`
+}
+
+type Mail struct {
+	From         string `yaml:"from" toml:"from" env:"MAIL_FROM"`
+	SMTPHost     string `yaml:"smtpHost" toml:"smtpHost" env:"SMTP_HOST"`
+	SMTPPort     string `yaml:"smtpPort" toml:"smtpPort" env:"SMTP_PORT"`
+	SMTPUser     string `yaml:"smtpUser" toml:"smtpUser" env:"SMTP_USER"`
+	SMTPPassword string `yaml:"smtpPassword" toml:"smtpPassword" env:"SMTP_PASSWORD"`
+}
+
+// R2 is the cloudflare bucket dday images are uploaded to
+type R2 struct {
+	AccountID       string `yaml:"accountID" toml:"accountID" env:"ACCOUNT_ID"`
+	AccessKeyID     string `yaml:"accessKeyID" toml:"accessKeyID" env:"ACCESS_KEY_ID"`
+	AccessKeySecret string `yaml:"accessKeySecret" toml:"accessKeySecret" env:"ACCESS_KEY_SECRET"`
+	BucketName      string `yaml:"bucketName" toml:"bucketName" env:"BUCKET_NAME"`
+	PublicBucketID  string `yaml:"publicBucketID" toml:"publicBucketID" env:"PUBLIC_BUCKET_ID"`
+}
+
+// Trash is how long deleted ddays, pins, ideas and check-ins can be
+// restored before the purge, which runs every PurgeInterval, removes them
+type Trash struct {
+	Retention     Duration `yaml:"retention" toml:"retention" env:"RETENTION"`
+	PurgeInterval Duration `yaml:"purgeInterval" toml:"purgeInterval" env:"PURGE_INTERVAL"`
+}
+
+type Metrics struct {
+	// when set, /metrics requires it as a bearer token
+	Token string `yaml:"token" toml:"token" env:"TOKEN"`
+}
+
+// Tracing uses the standard OTEL_ variables
+type Tracing struct {
+	// OTLP/HTTP collector URL, tracing is off without one
+	Endpoint    string `yaml:"endpoint" toml:"endpoint" env:"EXPORTER_OTLP_ENDPOINT"`
+	ServiceName string `yaml:"serviceName" toml:"serviceName" env:"SERVICE_NAME"`
+	// fraction of traces sampled, 0 to 1
+	SampleRatio string `yaml:"sampleRatio" toml:"sampleRatio" env:"TRACES_SAMPLER_ARG"`
+}
+
+// Load reads CONFIG_FILE when set, applies the environment on top and
+// validates the result
+func Load() (*Config, error) {
+	return load(os.Getenv("CONFIG_FILE"), os.LookupEnv)
+}
+
+func load(path string, lookup func(string) (string, bool)) (*Config, error) {
+	cfg := &Config{}
+	if path != "" {
+		if err := readFile(path, cfg); err != nil {
+			return nil, err
+		}
+	}
+	e := applyEnv(cfg, lookup)
+	cfg.setDefaults()
+	e = append(e, cfg.validate()...)
+	if err := e.join(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func readFile(path string, cfg *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = decodeYAML(data, cfg)
+	case ".toml":
+		err = decodeTOML(data, cfg)
+	default:
+		return fmt.Errorf("config: %s: unsupported file type, use .yaml or .toml", path)
+	}
+	if err != nil {
+		return fmt.Errorf("config: %s: %w", path, err)
+	}
+	return nil
+}
+
+// setDefaults fills what neither the file nor the environment set. an
+// unset ENV is treated as production so a missing variable can't relax
+// cookies or validation in a deployment.
+func (c *Config) setDefaults() {
+	if c.Env == "" {
+		c.Env = Production
+	}
+	if c.Port == "" {
+		c.Port = "5000"
+	}
+	if c.APIBaseURL == "" {
+		if c.IsDevelopment() {
+			c.APIBaseURL = "http://localhost:" + c.Port
+		} else {
+			c.APIBaseURL = "https://api.calple.date"
+		}
+	}
+	c.APIBaseURL = strings.TrimRight(c.APIBaseURL, "/")
+	c.FrontendURL = strings.TrimRight(c.FrontendURL, "/")
+	if c.CORSOrigins == nil {
+		c.CORSOrigins = []string{"https://www.calple.date", "https://calple.date"}
+	}
+	setDuration(&c.Server.ReadHeaderTimeout, 5*time.Second)
+	setDuration(&c.Server.ReadTimeout, 15*time.Second)
+	setDuration(&c.Server.WriteTimeout, 30*time.Second)
+	setDuration(&c.Server.IdleTimeout, 2*time.Minute)
+	setDuration(&c.Server.RequestTimeout, 20*time.Second)
+	setDuration(&c.Server.ShutdownTimeout, 20*time.Second)
+	setDuration(&c.Trash.Retention, 30*24*time.Hour)
+	setDuration(&c.Trash.PurgeInterval, time.Hour)
+	if c.Log.Level == "" {
+		c.Log.Level = "info"
+		if c.IsDevelopment() {
+			c.Log.Level = "debug"
+		}
+	}
+	if c.Log.Format == "" {
+		c.Log.Format = "json"
+		if c.IsDevelopment() {
+			c.Log.Format = "text"
+		}
+	}
+	if c.Session.Store == "" {
+		c.Session.Store = "firestore"
+	}
+	if c.RateLimitStore == "" {
+		c.RateLimitStore = "memory"
+	}
+	if c.Session.CookieDomain == "" && !c.IsDevelopment() {
+		c.Session.CookieDomain = ".calple.date"
+	}
+	if c.Firebase.CredentialsFile == "" {
+		c.Firebase.CredentialsFile = "firebase_credentials.json"
+	}
+	if c.OIDC.Issuer != "" && c.OIDC.Name == "" {
+		c.OIDC.Name = "oidc"
+	}
+	if c.Mail.SMTPPort == "" {
+		c.Mail.SMTPPort = "587"
+	}
+	if c.Tracing.ServiceName == "" {
+		c.Tracing.ServiceName = "calple-api"
+	}
+	if c.Tracing.SampleRatio == "" {
+		c.Tracing.SampleRatio = "1"
+	}
+}
+
+func setDuration(d *Duration, def time.Duration) {
+	if *d == 0 {
+		*d = Duration(def)
+	}
+}
+
+func (c *Config) IsDevelopment() bool {
+	return c.Env == Development
+}
+
+// Proxies are the trusted proxies, nil when there are none
+func (c *Config) Proxies() []string {
+	if len(c.Server.TrustedProxies) == 1 && c.Server.TrustedProxies[0] == "none" {
+		return nil
+	}
+	return c.Server.TrustedProxies
+}
+
+// SessionKeys are the cookie signing keys, the first one signs
+func (c *Config) SessionKeys() [][]byte {
+	keys := [][]byte{[]byte(c.Session.SecretKey)}
+	for _, key := range c.Session.SecretKeyPrevious {
+		keys = append(keys, []byte(key))
+	}
+	return keys
+}
+
+// AllowedOrigins are the frontends allowed by CORS
+func (c *Config) AllowedOrigins() []string {
+	origins := []string{}
+	if c.FrontendURL != "" {
+		origins = append(origins, c.FrontendURL)
+	}
+	return append(origins, c.CORSOrigins...)
+}
+
+// AllowedRedirectOrigins are the frontends a sign in may return to
+func (c *Config) AllowedRedirectOrigins() []string {
+	return append(c.AllowedOrigins(), c.RedirectOrigins...)
+}
+
+// errs collects validation errors so all of them are reported at once
+type errs []error
+
+func (e *errs) add(format string, args ...interface{}) {
+	*e = append(*e, fmt.Errorf(format, args...))
+}
+
+func (e errs) join() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return fmt.Errorf("invalid configuration:\n%w", errors.Join(e...))
+}
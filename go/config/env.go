@@ -0,0 +1,61 @@
+package config
+
+import (
+	"encoding"
+	"reflect"
+	"strings"
+)
+
+var textUnmarshaler = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+// applyEnv overrides fields from the variables named by their env tags.
+// empty variables count as unset, list variables are comma separated.
+// values that don't parse are reported together.
+func applyEnv(cfg *Config, lookup func(string) (string, bool)) errs {
+	var e errs
+	applyEnvTo(reflect.ValueOf(cfg).Elem(), "", lookup, &e)
+	return e
+}
+
+func applyEnvTo(v reflect.Value, prefix string, lookup func(string) (string, bool), e *errs) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := prefix + field.Tag.Get("env")
+		value := v.Field(i)
+
+		if value.Kind() == reflect.Struct {
+			applyEnvTo(value, name, lookup, e)
+			continue
+		}
+		if field.Tag.Get("env") == "" {
+			continue
+		}
+		raw, ok := lookup(name)
+		if !ok || strings.TrimSpace(raw) == "" {
+			continue
+		}
+
+		if value.Addr().Type().Implements(textUnmarshaler) {
+			if err := value.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(raw)); err != nil {
+				e.add("%s: %v", name, err)
+			}
+			continue
+		}
+
+		switch value.Kind() {
+		case reflect.String:
+			value.SetString(raw)
+		case reflect.Slice:
+			list := []string{}
+			for _, item := range strings.Split(raw, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					list = append(list, item)
+				}
+			}
+			value.Set(reflect.ValueOf(list))
+		default:
+			e.add("%s: unsupported field type %s", name, value.Kind())
+		}
+	}
+}
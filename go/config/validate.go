@@ -0,0 +1,229 @@
+package config
+
+import (
+	"encoding/base64"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Validate checks the configuration for the environment it is for and
+// returns every problem found, joined
+func (c *Config) Validate() error {
+	return c.validate().join()
+}
+
+func (c *Config) validate() errs {
+	var e errs
+	deployed := !c.IsDevelopment()
+
+	switch c.Env {
+	case Development, Staging, Production:
+	default:
+		e.add("ENV must be development, staging or production, got %q", c.Env)
+	}
+	if n, err := strconv.Atoi(c.Port); err != nil || n < 1 || n > 65535 {
+		e.add("PORT must be a port number, got %q", c.Port)
+	}
+
+	for _, d := range []struct {
+		name  string
+		value Duration
+	}{
+		{"READ_HEADER_TIMEOUT", c.Server.ReadHeaderTimeout},
+		{"READ_TIMEOUT", c.Server.ReadTimeout},
+		{"WRITE_TIMEOUT", c.Server.WriteTimeout},
+		{"IDLE_TIMEOUT", c.Server.IdleTimeout},
+		{"REQUEST_TIMEOUT", c.Server.RequestTimeout},
+		{"SHUTDOWN_TIMEOUT", c.Server.ShutdownTimeout},
+		{"TRASH_RETENTION", c.Trash.Retention},
+		{"TRASH_PURGE_INTERVAL", c.Trash.PurgeInterval},
+	} {
+		if d.value < 0 {
+			e.add("%s can't be negative", d.name)
+		}
+	}
+	if c.Server.RequestTimeout >= c.Server.WriteTimeout {
+		e.add("REQUEST_TIMEOUT (%s) must be shorter than WRITE_TIMEOUT (%s), or responses to slow requests are cut off",
+			c.Server.RequestTimeout.D(), c.Server.WriteTimeout.D())
+	}
+
+	// trusting every address would let any client pick its IP
+	if deployed && len(c.Server.TrustedProxies) == 0 && c.Server.TrustedPlatform == "" {
+		e.add("TRUSTED_PROXIES or TRUSTED_PLATFORM must be set outside development, TRUSTED_PROXIES=none when clients connect directly")
+	}
+	for _, p := range c.Server.TrustedProxies {
+		switch {
+		case p == "none" && len(c.Server.TrustedProxies) > 1:
+			e.add("TRUSTED_PROXIES=none can't be combined with addresses")
+		case p == "none":
+		case net.ParseIP(p) == nil && !isCIDR(p):
+			e.add("TRUSTED_PROXIES: %q is not an IP address or CIDR", p)
+		case strings.HasSuffix(p, "/0"):
+			e.add("TRUSTED_PROXIES: %q trusts every address", p)
+		}
+	}
+	switch c.Server.TrustedPlatform {
+	case "", "cloudflare", "appengine", "flyio":
+	default:
+		e.add("TRUSTED_PLATFORM must be cloudflare, appengine or flyio, got %q", c.Server.TrustedPlatform)
+	}
+
+	switch strings.ToLower(c.Log.Level) {
+	case "debug", "info", "warn", "warning", "error":
+	default:
+		e.add("LOG_LEVEL must be debug, info, warn or error, got %q", c.Log.Level)
+	}
+	if c.Log.Format != "json" && c.Log.Format != "text" {
+		e.add("LOG_FORMAT must be json or text, got %q", c.Log.Format)
+	}
+
+	// urls
+	checkURL(&e, "API_BASE_URL", c.APIBaseURL, deployed)
+	if c.FrontendURL == "" {
+		e.add("FRONTEND_URL is required")
+	} else {
+		checkURL(&e, "FRONTEND_URL", c.FrontendURL, deployed)
+	}
+	for _, origin := range c.CORSOrigins {
+		checkOrigin(&e, "CORS_ORIGINS", origin)
+	}
+	for _, origin := range c.RedirectOrigins {
+		checkOrigin(&e, "ALLOWED_REDIRECT_ORIGINS", origin)
+	}
+
+	// sessions
+	switch {
+	case c.Session.SecretKey == "":
+		e.add("SECRET_KEY is required")
+	case deployed && len(c.Session.SecretKey) < 32:
+		e.add("SECRET_KEY must be at least 32 characters outside development")
+	}
+	switch c.Session.Store {
+	case "firestore":
+	case "memory":
+		if c.Env == Production {
+			e.add("SESSION_STORE=memory loses sessions on restart and can't be shared, it is not allowed in production")
+		}
+	default:
+		e.add("SESSION_STORE must be firestore or memory, got %q", c.Session.Store)
+	}
+	switch c.RateLimitStore {
+	case "memory", "firestore", "off":
+	default:
+		e.add("RATE_LIMIT_STORE must be memory, firestore or off, got %q", c.RateLimitStore)
+	}
+
+	// token encryption is optional, but a configured key has to be usable
+	if c.TokenEncryption.Key == "" && len(c.TokenEncryption.Previous) > 0 {
+		e.add("TOKEN_ENCRYPTION_KEY_PREVIOUS is set without TOKEN_ENCRYPTION_KEY")
+	}
+	for i, key := range append([]string{c.TokenEncryption.Key}, c.TokenEncryption.Previous...) {
+		if key == "" {
+			continue
+		}
+		if b, err := base64.StdEncoding.DecodeString(key); err != nil || len(b) != 32 {
+			name := "TOKEN_ENCRYPTION_KEY"
+			if i > 0 {
+				name = "TOKEN_ENCRYPTION_KEY_PREVIOUS"
+			}
+			e.add("%s must be 32 base64 encoded bytes", name)
+		}
+	}
+
+	// sign in providers, each either fully configured or not at all
+	if c.Google.ClientID != "" && c.Google.ClientSecret == "" {
+		e.add("OAUTH2_CLIENT_SECRET is required with OAUTH2_CLIENT_ID")
+	}
+	if c.GitHub.ClientID != "" && c.GitHub.ClientSecret == "" {
+		e.add("GITHUB_CLIENT_SECRET is required with GITHUB_CLIENT_ID")
+	}
+	if c.OIDC.Issuer != "" {
+		checkURL(&e, "OIDC_ISSUER", c.OIDC.Issuer, deployed)
+		if c.OIDC.ClientID == "" {
+			e.add("OIDC_CLIENT_ID is required with OIDC_ISSUER")
+		}
+		if c.OIDC.Name == "google" || c.OIDC.Name == "email" {
+			e.add("OIDC_NAME can't be %q, the name is taken", c.OIDC.Name)
+		}
+	}
+	if c.Apple.ClientID != "" {
+		for _, missing := range unset(
+			"APPLE_TEAM_ID", c.Apple.TeamID,
+			"APPLE_KEY_ID", c.Apple.KeyID,
+			"APPLE_PRIVATE_KEY", c.Apple.PrivateKey,
+		) {
+			e.add("%s is required with APPLE_CLIENT_ID", missing)
+		}
+	}
+
+	// mail
+	if c.Mail.SMTPHost != "" {
+		if c.Mail.From == "" {
+			e.add("MAIL_FROM is required with SMTP_HOST")
+		}
+		if n, err := strconv.Atoi(c.Mail.SMTPPort); err != nil || n < 1 || n > 65535 {
+			e.add("SMTP_PORT must be a port number, got %q", c.Mail.SMTPPort)
+		}
+	}
+
+	// dday image uploads need all of the bucket settings, production
+	// can't run without them
+	missing := unset(
+		"R2_ACCOUNT_ID", c.R2.AccountID,
+		"R2_ACCESS_KEY_ID", c.R2.AccessKeyID,
+		"R2_ACCESS_KEY_SECRET", c.R2.AccessKeySecret,
+		"R2_BUCKET_NAME", c.R2.BucketName,
+		"R2_PUBLIC_BUCKET_ID", c.R2.PublicBucketID,
+	)
+	if len(missing) > 0 && (len(missing) < 5 || c.Env == Production) {
+		e.add("%s must be set for image uploads", strings.Join(missing, ", "))
+	}
+
+	// tracing
+	if c.Tracing.Endpoint != "" {
+		checkURL(&e, "OTEL_EXPORTER_OTLP_ENDPOINT", c.Tracing.Endpoint, false)
+	}
+	if r, err := strconv.ParseFloat(c.Tracing.SampleRatio, 64); err != nil || r < 0 || r > 1 {
+		e.add("OTEL_TRACES_SAMPLER_ARG must be between 0 and 1, got %q", c.Tracing.SampleRatio)
+	}
+
+	return e
+}
+
+// checkURL requires an absolute http(s) URL, https outside development
+func checkURL(e *errs, name, value string, requireHTTPS bool) {
+	u, err := url.Parse(value)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		e.add("%s must be an absolute http(s) URL, got %q", name, value)
+		return
+	}
+	if requireHTTPS && u.Scheme != "https" {
+		e.add("%s must use https outside development", name)
+	}
+}
+
+// checkOrigin requires scheme://host[:port] without a path
+func checkOrigin(e *errs, name, value string) {
+	u, err := url.Parse(value)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") || (u.Path != "" && u.Path != "/") || u.RawQuery != "" {
+		e.add("%s: %q is not an origin like https://example.com", name, value)
+	}
+}
+
+func isCIDR(s string) bool {
+	_, _, err := net.ParseCIDR(s)
+	return err == nil
+}
+
+// unset takes name, value pairs and returns the names with empty values
+func unset(pairs ...string) []string {
+	names := []string{}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if pairs[i+1] == "" {
+			names = append(names, pairs[i])
+		}
+	}
+	return names
+}
@@ -0,0 +1,300 @@
+// Package events fans changes out to the open event streams of a user. the
+// hub lives in the process, a deployment with several instances only
+// reaches the streams connected to the instance that made the change.
+package events
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Type is what changed, resource.action
+type Type string
+
+const (
+	DDayCreated Type = "dday.created"
+	DDayUpdated Type = "dday.updated"
+	DDayDeleted Type = "dday.deleted"
+	// out of the trash
+	DDayRestored Type = "dday.restored"
+
+	ConnectionInvited   Type = "connection.invited"
+	ConnectionAccepted  Type = "connection.accepted"
+	ConnectionDeclined  Type = "connection.declined"
+	ConnectionCancelled Type = "connection.cancelled"
+	ConnectionUnlinked  Type = "connection.unlinked"
+
+	CheckinSaved   Type = "checkin.saved"
+	CheckinDeleted Type = "checkin.deleted"
+
+	PeriodDaySaved   Type = "period_day.saved"
+	PeriodDayDeleted Type = "period_day.deleted"
+
+	PinCreated  Type = "pin.created"
+	PinUpdated  Type = "pin.updated"
+	PinDeleted  Type = "pin.deleted"
+	PinRestored Type = "pin.restored"
+
+	// Ready opens a stream, its ID is where a reconnect picks up from
+	Ready Type = "ready"
+	// Resync tells a reconnecting client the events it missed are gone and
+	// it has to load everything again
+	Resync Type = "resync"
+)
+
+// resources are the token scopes that can read each kind of event
+var resources = map[string]string{
+	"dday":       "ddays",
+	"connection": "connection",
+	"checkin":    "checkins",
+	"period_day": "periods",
+	"pin":        "pins",
+}
+
+// Resources are the API resources events are filtered by for tokens, sorted
+func Resources() []string {
+	out := make([]string, 0, len(resources))
+	for _, r := range resources {
+		out = append(out, r)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Resource is the API resource a token needs read access to for events of
+// type t, empty when every client may see them
+func (t Type) Resource() string {
+	kind, _, _ := strings.Cut(string(t), ".")
+	return resources[kind]
+}
+
+// Event is one change, sent as the data of a server-sent event
+type Event struct {
+	ID   string    `json:"id"`
+	Type Type      `json:"type"`
+	Time time.Time `json:"time"`
+	Data *Change   `json:"data,omitempty"`
+
+	seq uint64
+}
+
+// Change says what changed, clients load it again through the API
+type Change struct {
+	// ID of the event, pin or connection
+	ID string `json:"id,omitempty"`
+	// Date of the check-in or period day
+	Date string `json:"date,omitempty"`
+	// UserID is whose data changed, the user's own or the partner's
+	UserID string `json:"userId"`
+}
+
+var (
+	ErrClosed             = errors.New("events: hub is closed")
+	ErrTooManySubscribers = errors.New("events: too many open streams")
+)
+
+const (
+	// events kept per user for clients that reconnect
+	backlog = 100
+	// how long they are kept
+	backlogAge = 10 * time.Minute
+	// open streams per user, every tab of the app holds one
+	maxSubscribers = 10
+	// events queued for a stream, one that falls further behind is dropped
+	// and catches up from the backlog when it reconnects
+	queueSize = 32
+)
+
+// Hub keeps the subscribers and recent events of every user
+type Hub struct {
+	mu     sync.Mutex
+	seq    uint64
+	boot   string
+	users  map[string]*inbox
+	swept  time.Time
+	closed bool
+}
+
+type inbox struct {
+	recent []Event
+	// seq of the newest event that fell out of recent
+	dropped uint64
+	subs    map[*Subscription]struct{}
+}
+
+func NewHub() *Hub {
+	return &Hub{
+		// IDs of an earlier process mean nothing to this one
+		boot:  strconv.FormatInt(time.Now().UnixNano(), 36),
+		users: map[string]*inbox{},
+	}
+}
+
+// Publish sends an event to every user in uids, empty and repeated UIDs
+// are skipped
+func (h *Hub) Publish(t Type, change Change, uids ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return
+	}
+
+	now := time.Now()
+	h.sweep(now)
+	seen := map[string]bool{}
+	for _, uid := range uids {
+		if uid == "" || seen[uid] {
+			continue
+		}
+		seen[uid] = true
+
+		h.seq++
+		ev := Event{ID: h.id(h.seq), Type: t, Time: now, Data: &change, seq: h.seq}
+		in := h.inbox(uid)
+		in.recent = append(in.recent, ev)
+		in.trim(now)
+		for sub := range in.subs {
+			select {
+			case sub.ch <- ev:
+			default:
+				h.drop(uid, sub)
+			}
+		}
+	}
+}
+
+// Subscribe opens a stream of the events of uid and returns the events to
+// send first: Ready, or with the ID of the last event a client saw the
+// events after it. when those are no longer kept it is Resync instead.
+func (h *Hub) Subscribe(uid, lastEventID string) (*Subscription, []Event, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return nil, nil, ErrClosed
+	}
+
+	in := h.inbox(uid)
+	if len(in.subs) >= maxSubscribers {
+		return nil, nil, ErrTooManySubscribers
+	}
+	now := time.Now()
+	in.trim(now)
+
+	replay := []Event{{ID: h.id(h.seq), Type: Ready, Time: now}}
+	if lastEventID != "" {
+		boot, n, _ := strings.Cut(lastEventID, "-")
+		seq, err := strconv.ParseUint(n, 10, 64)
+		switch {
+		case boot != h.boot || err != nil || seq < in.dropped:
+			replay[0].Type = Resync
+		default:
+			replay = replay[:0]
+			for _, ev := range in.recent {
+				if ev.seq > seq {
+					replay = append(replay, ev)
+				}
+			}
+		}
+	}
+
+	sub := &Subscription{hub: h, uid: uid, ch: make(chan Event, queueSize), done: make(chan struct{})}
+	in.subs[sub] = struct{}{}
+	return sub, replay, nil
+}
+
+// Close ends every stream, for shutting down
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for uid, in := range h.users {
+		for sub := range in.subs {
+			h.drop(uid, sub)
+		}
+	}
+}
+
+func (h *Hub) inbox(uid string) *inbox {
+	in := h.users[uid]
+	if in == nil {
+		in = &inbox{subs: map[*Subscription]struct{}{}}
+		h.users[uid] = in
+	}
+	return in
+}
+
+func (h *Hub) id(seq uint64) string {
+	return h.boot + "-" + strconv.FormatUint(seq, 10)
+}
+
+// sweep forgets users whose events all expired, at most once per backlog
+// age. the caller holds h.mu.
+func (h *Hub) sweep(now time.Time) {
+	if now.Sub(h.swept) < backlogAge {
+		return
+	}
+	h.swept = now
+	for uid, in := range h.users {
+		in.trim(now)
+		if len(in.subs) == 0 && len(in.recent) == 0 {
+			delete(h.users, uid)
+		}
+	}
+}
+
+// drop removes sub, the caller holds h.mu
+func (h *Hub) drop(uid string, sub *Subscription) {
+	in := h.users[uid]
+	if in == nil {
+		return
+	}
+	if _, ok := in.subs[sub]; ok {
+		delete(in.subs, sub)
+		close(sub.done)
+	}
+	if len(in.subs) == 0 && len(in.recent) == 0 {
+		delete(h.users, uid)
+	}
+}
+
+// trim forgets events past the backlog
+func (in *inbox) trim(now time.Time) {
+	n := 0
+	for n < len(in.recent) && (len(in.recent)-n > backlog || now.Sub(in.recent[n].Time) > backlogAge) {
+		n++
+	}
+	if n > 0 {
+		in.dropped = in.recent[n-1].seq
+		in.recent = append([]Event(nil), in.recent[n:]...)
+	}
+}
+
+// Subscription is one open stream
+type Subscription struct {
+	hub  *Hub
+	uid  string
+	ch   chan Event
+	done chan struct{}
+}
+
+// Events delivers the events of the user as they are published
+func (s *Subscription) Events() <-chan Event {
+	return s.ch
+}
+
+// Done is closed when the hub stops delivering, because the stream fell
+// behind or the hub closed
+func (s *Subscription) Done() <-chan struct{} {
+	return s.done
+}
+
+// Close stops the subscription
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	s.hub.drop(s.uid, s)
+}
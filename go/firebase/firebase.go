@@ -3,45 +3,44 @@ package firebase
 import (
 	"context"
 	"fmt"
+	"log/slog"
 	"os"
 
 	"cloud.google.com/go/firestore"
 	firebase "firebase.google.com/go"
 	"google.golang.org/api/option"
+
+	"calple/config"
 )
 
-// initialize firebase/firestore client
-func InitFirebase(ctx context.Context) (*firestore.Client, error) {
+// initialize firebase/firestore client, extra options are passed to the
+// client, e.g. interceptors
+func InitFirebase(ctx context.Context, cfg config.Firebase, extra ...option.ClientOption) (*firestore.Client, error) {
 	var opt option.ClientOption
-	credFile := "firebase_credentials.json"
+	credFile := cfg.CredentialsFile
 
-	if content := os.Getenv("FIREBASE_CREDENTIALS_JSON"); content != "" {
-		fmt.Printf("DEBUG: Initializing Firebase with credentials from environment variable\n")
+	if content := cfg.CredentialsJSON; content != "" {
+		slog.Debug("initializing firebase with FIREBASE_CREDENTIALS_JSON")
 		opt = option.WithCredentialsJSON([]byte(content))
 	} else {
-		fmt.Printf("DEBUG: No FIREBASE_CREDENTIALS_JSON environment variable found, using local file\n")
+		slog.Debug("initializing firebase with credentials file", "file", credFile)
 		// Check if credentials file exists
 		if _, err := os.Stat(credFile); os.IsNotExist(err) {
-			fmt.Printf("ERROR: Firebase credentials file does not exist: %s\n", credFile)
 			return nil, fmt.Errorf("firebase credentials file not found: %s", credFile)
 		}
 		opt = option.WithCredentialsFile(credFile)
 	}
 
-	fmt.Printf("DEBUG: Creating Firebase app\n")
-	app, err := firebase.NewApp(ctx, nil, opt)
+	app, err := firebase.NewApp(ctx, nil, append([]option.ClientOption{opt}, extra...)...)
 	if err != nil {
-		fmt.Printf("ERROR: Failed to create Firebase app: %v\n", err)
-		return nil, err
+		return nil, fmt.Errorf("create firebase app: %w", err)
 	}
 
-	fmt.Printf("DEBUG: Creating Firestore client\n")
 	client, err := app.Firestore(ctx)
 	if err != nil {
-		fmt.Printf("ERROR: Failed to create Firestore client: %v\n", err)
-		return nil, err
+		return nil, fmt.Errorf("create firestore client: %w", err)
 	}
 
-	fmt.Printf("DEBUG: Firebase/Firestore initialized successfully\n")
+	slog.Debug("firestore initialized")
 	return client, nil
 }
@@ -0,0 +1,265 @@
+package firestoremock
+
+import (
+	"sort"
+	"strings"
+
+	pb "cloud.google.com/go/firestore/apiv1/firestorepb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const nameField = "__name__"
+
+// inCollection tells whether the document name is in collection id under
+// parent, at any depth when all is set
+func inCollection(name, parent, id string, all bool) bool {
+	if !strings.HasPrefix(name, parent+"/") {
+		return false
+	}
+	segs := strings.Split(strings.TrimPrefix(name, parent+"/"), "/")
+	if len(segs)%2 != 0 || segs[len(segs)-2] != id {
+		return false
+	}
+	return all || len(segs) == 2
+}
+
+func fieldValue(name string, fields map[string]*pb.Value, path string) (*pb.Value, bool) {
+	if path == nameField {
+		return &pb.Value{ValueType: &pb.Value_ReferenceValue{ReferenceValue: name}}, true
+	}
+	return getPath(fields, splitPath(path))
+}
+
+func matches(name string, fields map[string]*pb.Value, f *pb.StructuredQuery_Filter) (bool, error) {
+	if f == nil {
+		return true, nil
+	}
+	switch ft := f.GetFilterType().(type) {
+	case *pb.StructuredQuery_Filter_CompositeFilter:
+		and := ft.CompositeFilter.GetOp() != pb.StructuredQuery_CompositeFilter_OR
+		for _, sub := range ft.CompositeFilter.GetFilters() {
+			ok, err := matches(name, fields, sub)
+			if err != nil {
+				return false, err
+			}
+			if ok != and {
+				return ok, nil
+			}
+		}
+		return and, nil
+	case *pb.StructuredQuery_Filter_FieldFilter:
+		ff := ft.FieldFilter
+		v, ok := fieldValue(name, fields, ff.GetField().GetFieldPath())
+		if !ok {
+			return false, nil
+		}
+		return fieldMatches(v, ff.GetOp(), ff.GetValue())
+	case *pb.StructuredQuery_Filter_UnaryFilter:
+		uf := ft.UnaryFilter
+		v, ok := fieldValue(name, fields, uf.GetField().GetFieldPath())
+		if !ok {
+			return false, nil
+		}
+		switch uf.GetOp() {
+		case pb.StructuredQuery_UnaryFilter_IS_NULL:
+			return isNull(v), nil
+		case pb.StructuredQuery_UnaryFilter_IS_NOT_NULL:
+			return !isNull(v), nil
+		case pb.StructuredQuery_UnaryFilter_IS_NAN:
+			return isNaN(v), nil
+		case pb.StructuredQuery_UnaryFilter_IS_NOT_NAN:
+			return !isNaN(v), nil
+		}
+	}
+	return false, status.Errorf(codes.Unimplemented, "unsupported filter %v", f)
+}
+
+func fieldMatches(v *pb.Value, op pb.StructuredQuery_FieldFilter_Operator, want *pb.Value) (bool, error) {
+	// range filters only match values of the same type
+	ordered := func(ok func(int) bool) bool {
+		return typeOrder(v) == typeOrder(want) && ok(compare(v, want))
+	}
+	switch op {
+	case pb.StructuredQuery_FieldFilter_EQUAL:
+		return equal(v, want), nil
+	case pb.StructuredQuery_FieldFilter_NOT_EQUAL:
+		return !isNull(v) && !equal(v, want), nil
+	case pb.StructuredQuery_FieldFilter_LESS_THAN:
+		return ordered(func(c int) bool { return c < 0 }), nil
+	case pb.StructuredQuery_FieldFilter_LESS_THAN_OR_EQUAL:
+		return ordered(func(c int) bool { return c <= 0 }), nil
+	case pb.StructuredQuery_FieldFilter_GREATER_THAN:
+		return ordered(func(c int) bool { return c > 0 }), nil
+	case pb.StructuredQuery_FieldFilter_GREATER_THAN_OR_EQUAL:
+		return ordered(func(c int) bool { return c >= 0 }), nil
+	case pb.StructuredQuery_FieldFilter_ARRAY_CONTAINS:
+		return containsAny(v.GetArrayValue().GetValues(), want), nil
+	case pb.StructuredQuery_FieldFilter_IN:
+		return containsAny(want.GetArrayValue().GetValues(), v), nil
+	case pb.StructuredQuery_FieldFilter_NOT_IN:
+		return !isNull(v) && !containsAny(want.GetArrayValue().GetValues(), v), nil
+	case pb.StructuredQuery_FieldFilter_ARRAY_CONTAINS_ANY:
+		for _, w := range want.GetArrayValue().GetValues() {
+			if containsAny(v.GetArrayValue().GetValues(), w) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	return false, status.Errorf(codes.Unimplemented, "unsupported operator %v", op)
+}
+
+func containsAny(values []*pb.Value, v *pb.Value) bool {
+	for _, x := range values {
+		if equal(x, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// firstInequality is the field of the first range filter, which Firestore
+// orders by when the query has no order
+func firstInequality(f *pb.StructuredQuery_Filter) string {
+	switch ft := f.GetFilterType().(type) {
+	case *pb.StructuredQuery_Filter_CompositeFilter:
+		for _, sub := range ft.CompositeFilter.GetFilters() {
+			if path := firstInequality(sub); path != "" {
+				return path
+			}
+		}
+	case *pb.StructuredQuery_Filter_FieldFilter:
+		switch ft.FieldFilter.GetOp() {
+		case pb.StructuredQuery_FieldFilter_EQUAL,
+			pb.StructuredQuery_FieldFilter_ARRAY_CONTAINS,
+			pb.StructuredQuery_FieldFilter_IN,
+			pb.StructuredQuery_FieldFilter_ARRAY_CONTAINS_ANY:
+		default:
+			return ft.FieldFilter.GetField().GetFieldPath()
+		}
+	}
+	return ""
+}
+
+// orders are the orders of q with the implicit ones added
+func orders(q *pb.StructuredQuery) []*pb.StructuredQuery_Order {
+	out := append([]*pb.StructuredQuery_Order{}, q.GetOrderBy()...)
+	if len(out) == 0 {
+		if path := firstInequality(q.GetWhere()); path != "" {
+			out = append(out, &pb.StructuredQuery_Order{
+				Field:     &pb.StructuredQuery_FieldReference{FieldPath: path},
+				Direction: pb.StructuredQuery_ASCENDING,
+			})
+		}
+	}
+	if len(out) == 0 || out[len(out)-1].GetField().GetFieldPath() != nameField {
+		dir := pb.StructuredQuery_ASCENDING
+		if len(out) > 0 {
+			dir = out[len(out)-1].GetDirection()
+		}
+		out = append(out, &pb.StructuredQuery_Order{
+			Field:     &pb.StructuredQuery_FieldReference{FieldPath: nameField},
+			Direction: dir,
+		})
+	}
+	return out
+}
+
+type row struct {
+	doc *pb.Document
+	key []*pb.Value
+}
+
+func compareKeys(a, b []*pb.Value, orders []*pb.StructuredQuery_Order) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		c := compare(a[i], b[i])
+		if orders[i].GetDirection() == pb.StructuredQuery_DESCENDING {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// query runs q under parent, s.mu is held
+func (s *Server) query(parent string, q *pb.StructuredQuery) ([]*pb.Document, error) {
+	if len(q.GetFrom()) != 1 {
+		return nil, status.Error(codes.Unimplemented, "queries need exactly one collection")
+	}
+	from := q.GetFrom()[0]
+	ords := orders(q)
+
+	var rows []row
+	for name, d := range s.docs {
+		if !inCollection(name, parent, from.GetCollectionId(), from.GetAllDescendants()) {
+			continue
+		}
+		ok, err := matches(name, d.fields, q.GetWhere())
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		key := make([]*pb.Value, 0, len(ords))
+		for _, o := range ords {
+			v, ok := fieldValue(name, d.fields, o.GetField().GetFieldPath())
+			if !ok {
+				break
+			}
+			key = append(key, v)
+		}
+		// documents without a field of the order are left out
+		if len(key) < len(ords) {
+			continue
+		}
+		rows = append(rows, row{doc: d.proto(name), key: key})
+	}
+	sort.Slice(rows, func(i, j int) bool { return compareKeys(rows[i].key, rows[j].key, ords) < 0 })
+
+	if start := q.GetStartAt(); start != nil {
+		for len(rows) > 0 {
+			c := compareKeys(rows[0].key, start.GetValues(), ords)
+			if c > 0 || (c == 0 && start.GetBefore()) {
+				break
+			}
+			rows = rows[1:]
+		}
+	}
+	if end := q.GetEndAt(); end != nil {
+		for len(rows) > 0 {
+			c := compareKeys(rows[len(rows)-1].key, end.GetValues(), ords)
+			if c < 0 || (c == 0 && !end.GetBefore()) {
+				break
+			}
+			rows = rows[:len(rows)-1]
+		}
+	}
+	if off := int(q.GetOffset()); off > 0 {
+		if off > len(rows) {
+			off = len(rows)
+		}
+		rows = rows[off:]
+	}
+	if q.GetLimit() != nil && int(q.GetLimit().GetValue()) < len(rows) {
+		rows = rows[:q.GetLimit().GetValue()]
+	}
+
+	docs := make([]*pb.Document, len(rows))
+	for i, r := range rows {
+		docs[i] = r.doc
+		if sel := q.GetSelect(); sel != nil {
+			paths := make([]string, 0, len(sel.GetFields()))
+			for _, f := range sel.GetFields() {
+				if f.GetFieldPath() != nameField {
+					paths = append(paths, f.GetFieldPath())
+				}
+			}
+			docs[i].Fields = project(r.doc.Fields, paths)
+		}
+	}
+	return docs, nil
+}
@@ -0,0 +1,546 @@
+// Package firestoremock is an in-memory Firestore for tests. It speaks the
+// Firestore gRPC API, so the real client runs against it: documents, writes
+// with preconditions and transforms, queries, transactions and bulk writes.
+// Transactions are optimistic, a commit whose reads changed is aborted and
+// the client runs it again.
+package firestoremock
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	pb "cloud.google.com/go/firestore/apiv1/firestorepb"
+	"google.golang.org/api/option"
+	rpcstatus "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// ProjectID is the project the clients of the server use
+const ProjectID = "calple-test"
+
+type document struct {
+	fields     map[string]*pb.Value
+	createTime time.Time
+	updateTime time.Time
+}
+
+func (d *document) proto(name string) *pb.Document {
+	return &pb.Document{
+		Name:       name,
+		Fields:     cloneFields(d.fields),
+		CreateTime: timestamppb.New(d.createTime),
+		UpdateTime: timestamppb.New(d.updateTime),
+	}
+}
+
+// transaction remembers what it read, to check at commit that none of it
+// changed
+type transaction struct {
+	readOnly bool
+	reads    map[string]time.Time
+	queries  []readQuery
+}
+
+type readQuery struct {
+	parent string
+	query  *pb.StructuredQuery
+	result string
+}
+
+type Server struct {
+	pb.UnimplementedFirestoreServer
+
+	mu   sync.Mutex
+	docs map[string]*document
+	txs  map[string]*transaction
+	last time.Time
+
+	grpc  *grpc.Server
+	lis   net.Listener
+	conns []*grpc.ClientConn
+}
+
+// Start runs an empty database on a local port
+func Start() (*Server, error) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		docs: map[string]*document{},
+		txs:  map[string]*transaction{},
+		grpc: grpc.NewServer(),
+		lis:  lis,
+	}
+	pb.RegisterFirestoreServer(s.grpc, s)
+	go s.grpc.Serve(lis)
+	return s, nil
+}
+
+// Client is a Firestore client of the database
+func (s *Server) Client(ctx context.Context) (*firestore.Client, error) {
+	conn, err := grpc.NewClient(s.lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	s.conns = append(s.conns, conn)
+	s.mu.Unlock()
+	return firestore.NewClient(ctx, ProjectID, option.WithGRPCConn(conn))
+}
+
+func (s *Server) Close() {
+	s.mu.Lock()
+	conns := s.conns
+	s.conns = nil
+	s.mu.Unlock()
+	for _, conn := range conns {
+		conn.Close()
+	}
+	s.grpc.Stop()
+}
+
+// Reset drops every document
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.docs = map[string]*document{}
+	s.txs = map[string]*transaction{}
+}
+
+// tick is a new update time, later than every one before, s.mu is held
+func (s *Server) tick() time.Time {
+	t := time.Now().UTC().Truncate(time.Microsecond)
+	if !t.After(s.last) {
+		t = s.last.Add(time.Microsecond)
+	}
+	s.last = t
+	return t
+}
+
+// tx is the transaction with id, s.mu is held
+func (s *Server) tx(id []byte) (*transaction, error) {
+	if len(id) == 0 {
+		return nil, nil
+	}
+	tx, ok := s.txs[string(id)]
+	if !ok {
+		return nil, status.Error(codes.InvalidArgument, "unknown transaction")
+	}
+	return tx, nil
+}
+
+func (s *Server) BeginTransaction(ctx context.Context, req *pb.BeginTransactionRequest) (*pb.BeginTransactionResponse, error) {
+	id := make([]byte, 16)
+	rand.Read(id)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.txs[string(id)] = &transaction{
+		readOnly: req.GetOptions().GetReadOnly() != nil,
+		reads:    map[string]time.Time{},
+	}
+	return &pb.BeginTransactionResponse{Transaction: id}, nil
+}
+
+func (s *Server) Rollback(ctx context.Context, req *pb.RollbackRequest) (*emptypb.Empty, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.txs, string(req.GetTransaction()))
+	return &emptypb.Empty{}, nil
+}
+
+func (s *Server) GetDocument(ctx context.Context, req *pb.GetDocumentRequest) (*pb.Document, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.docs[req.GetName()]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "%s not found", req.GetName())
+	}
+	return d.proto(req.GetName()), nil
+}
+
+func (s *Server) BatchGetDocuments(req *pb.BatchGetDocumentsRequest, stream pb.Firestore_BatchGetDocumentsServer) error {
+	s.mu.Lock()
+	tx, err := s.tx(req.GetTransaction())
+	if err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	readTime := timestamppb.New(s.tick())
+	seen := map[string]bool{}
+	var resps []*pb.BatchGetDocumentsResponse
+	for _, name := range req.GetDocuments() {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		d, ok := s.docs[name]
+		if tx != nil {
+			tx.reads[name] = time.Time{}
+			if ok {
+				tx.reads[name] = d.updateTime
+			}
+		}
+		if !ok {
+			resps = append(resps, &pb.BatchGetDocumentsResponse{
+				Result:   &pb.BatchGetDocumentsResponse_Missing{Missing: name},
+				ReadTime: readTime,
+			})
+			continue
+		}
+		doc := d.proto(name)
+		if mask := req.GetMask(); mask != nil {
+			doc.Fields = project(doc.Fields, mask.GetFieldPaths())
+		}
+		resps = append(resps, &pb.BatchGetDocumentsResponse{
+			Result:   &pb.BatchGetDocumentsResponse_Found{Found: doc},
+			ReadTime: readTime,
+		})
+	}
+	s.mu.Unlock()
+
+	for _, resp := range resps {
+		if err := stream.Send(resp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Server) RunQuery(req *pb.RunQueryRequest, stream pb.Firestore_RunQueryServer) error {
+	s.mu.Lock()
+	tx, err := s.tx(req.GetTransaction())
+	if err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	docs, err := s.query(req.GetParent(), req.GetStructuredQuery())
+	if err == nil && tx != nil {
+		tx.queries = append(tx.queries, readQuery{
+			parent: req.GetParent(),
+			query:  req.GetStructuredQuery(),
+			result: fingerprint(docs),
+		})
+	}
+	readTime := timestamppb.New(s.tick())
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if len(docs) == 0 {
+		return stream.Send(&pb.RunQueryResponse{ReadTime: readTime})
+	}
+	for _, doc := range docs {
+		if err := stream.Send(&pb.RunQueryResponse{Document: doc, ReadTime: readTime}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Server) RunAggregationQuery(req *pb.RunAggregationQueryRequest, stream pb.Firestore_RunAggregationQueryServer) error {
+	agg := req.GetStructuredAggregationQuery()
+	s.mu.Lock()
+	docs, err := s.query(req.GetParent(), agg.GetStructuredQuery())
+	readTime := timestamppb.New(s.tick())
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	fields := map[string]*pb.Value{}
+	for _, a := range agg.GetAggregations() {
+		count := a.GetCount()
+		if count == nil {
+			return status.Error(codes.Unimplemented, "only count aggregations are supported")
+		}
+		n := int64(len(docs))
+		if count.GetUpTo() != nil && count.GetUpTo().GetValue() < n {
+			n = count.GetUpTo().GetValue()
+		}
+		fields[a.GetAlias()] = &pb.Value{ValueType: &pb.Value_IntegerValue{IntegerValue: n}}
+	}
+	return stream.Send(&pb.RunAggregationQueryResponse{
+		Result:   &pb.AggregationResult{AggregateFields: fields},
+		ReadTime: readTime,
+	})
+}
+
+// ListDocuments lists the documents of a collection, with the ones that only
+// have subcollections when show_missing is set
+func (s *Server) ListDocuments(ctx context.Context, req *pb.ListDocumentsRequest) (*pb.ListDocumentsResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prefix := req.GetParent() + "/" + req.GetCollectionId() + "/"
+	found := map[string]*pb.Document{}
+	for name, d := range s.docs {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		id, rest, nested := strings.Cut(strings.TrimPrefix(name, prefix), "/")
+		switch {
+		case !nested:
+			found[name] = d.proto(name)
+		case req.GetShowMissing() && rest != "":
+			if _, ok := found[prefix+id]; !ok {
+				found[prefix+id] = &pb.Document{Name: prefix + id}
+			}
+		}
+	}
+	resp := &pb.ListDocumentsResponse{}
+	for _, doc := range found {
+		if mask := req.GetMask(); mask != nil && doc.Fields != nil {
+			doc.Fields = project(doc.Fields, mask.GetFieldPaths())
+		}
+		resp.Documents = append(resp.Documents, doc)
+	}
+	sort.Slice(resp.Documents, func(i, j int) bool {
+		return compareNames(resp.Documents[i].Name, resp.Documents[j].Name) < 0
+	})
+	return resp, nil
+}
+
+func (s *Server) ListCollectionIds(ctx context.Context, req *pb.ListCollectionIdsRequest) (*pb.ListCollectionIdsResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := map[string]bool{}
+	for name := range s.docs {
+		if rest, ok := strings.CutPrefix(name, req.GetParent()+"/"); ok {
+			ids[strings.Split(rest, "/")[0]] = true
+		}
+	}
+	resp := &pb.ListCollectionIdsResponse{}
+	for id := range ids {
+		resp.CollectionIds = append(resp.CollectionIds, id)
+	}
+	sort.Strings(resp.CollectionIds)
+	return resp, nil
+}
+
+func (s *Server) Commit(ctx context.Context, req *pb.CommitRequest) (*pb.CommitResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tx, err := s.tx(req.GetTransaction())
+	if err != nil {
+		return nil, err
+	}
+	if tx != nil {
+		delete(s.txs, string(req.GetTransaction()))
+		if !tx.readOnly {
+			if err := s.validate(tx); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	now := s.tick()
+	staged := map[string]*document{}
+	resp := &pb.CommitResponse{CommitTime: timestamppb.New(now)}
+	for _, w := range req.GetWrites() {
+		res, err := s.apply(staged, w, now)
+		if err != nil {
+			return nil, err
+		}
+		resp.WriteResults = append(resp.WriteResults, res)
+	}
+	s.flush(staged)
+	return resp, nil
+}
+
+// BatchWrite applies each write on its own, as the bulk writer expects
+func (s *Server) BatchWrite(ctx context.Context, req *pb.BatchWriteRequest) (*pb.BatchWriteResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	resp := &pb.BatchWriteResponse{}
+	for _, w := range req.GetWrites() {
+		staged := map[string]*document{}
+		res, err := s.apply(staged, w, s.tick())
+		if err != nil {
+			resp.WriteResults = append(resp.WriteResults, &pb.WriteResult{})
+			resp.Status = append(resp.Status, &rpcstatus.Status{Code: int32(status.Code(err)), Message: err.Error()})
+			continue
+		}
+		s.flush(staged)
+		resp.WriteResults = append(resp.WriteResults, res)
+		resp.Status = append(resp.Status, &rpcstatus.Status{Code: int32(codes.OK)})
+	}
+	return resp, nil
+}
+
+// validate aborts tx when a document or query it read changed since
+func (s *Server) validate(tx *transaction) error {
+	for name, read := range tx.reads {
+		var current time.Time
+		if d, ok := s.docs[name]; ok {
+			current = d.updateTime
+		}
+		if !current.Equal(read) {
+			return status.Errorf(codes.Aborted, "%s changed during the transaction", name)
+		}
+	}
+	for _, q := range tx.queries {
+		docs, err := s.query(q.parent, q.query)
+		if err != nil {
+			return err
+		}
+		if fingerprint(docs) != q.result {
+			return status.Error(codes.Aborted, "a query result changed during the transaction")
+		}
+	}
+	return nil
+}
+
+func fingerprint(docs []*pb.Document) string {
+	var b strings.Builder
+	for _, doc := range docs {
+		fmt.Fprintf(&b, "%s@%d;", doc.GetName(), doc.GetUpdateTime().AsTime().UnixNano())
+	}
+	return b.String()
+}
+
+// flush stores the staged documents, nil ones are deleted, s.mu is held
+func (s *Server) flush(staged map[string]*document) {
+	for name, d := range staged {
+		if d == nil {
+			delete(s.docs, name)
+		} else {
+			s.docs[name] = d
+		}
+	}
+}
+
+// apply stages the write w, s.mu is held
+func (s *Server) apply(staged map[string]*document, w *pb.Write, now time.Time) (*pb.WriteResult, error) {
+	var name string
+	switch op := w.GetOperation().(type) {
+	case *pb.Write_Update:
+		name = op.Update.GetName()
+	case *pb.Write_Delete:
+		name = op.Delete
+	default:
+		return nil, status.Error(codes.Unimplemented, "unsupported write")
+	}
+
+	current, ok := staged[name]
+	if !ok {
+		current = s.docs[name]
+	}
+	if pre := w.GetCurrentDocument(); pre != nil {
+		switch cond := pre.GetConditionType().(type) {
+		case *pb.Precondition_Exists:
+			if cond.Exists && current == nil {
+				return nil, status.Errorf(codes.NotFound, "%s not found", name)
+			}
+			if !cond.Exists && current != nil {
+				return nil, status.Errorf(codes.AlreadyExists, "%s already exists", name)
+			}
+		case *pb.Precondition_UpdateTime:
+			if current == nil || !current.updateTime.Equal(cond.UpdateTime.AsTime()) {
+				return nil, status.Errorf(codes.FailedPrecondition, "%s was updated", name)
+			}
+		}
+	}
+
+	if w.GetDelete() != "" {
+		staged[name] = nil
+		return &pb.WriteResult{UpdateTime: timestamppb.New(now)}, nil
+	}
+
+	update := w.GetUpdate()
+	next := &document{createTime: now, updateTime: now}
+	if current != nil {
+		next.createTime = current.createTime
+	}
+	if mask := w.GetUpdateMask(); mask == nil || current == nil {
+		next.fields = map[string]*pb.Value{}
+	} else {
+		next.fields = cloneFields(current.fields)
+	}
+	if mask := w.GetUpdateMask(); mask == nil {
+		for k, v := range update.GetFields() {
+			next.fields[k] = proto.Clone(v).(*pb.Value)
+		}
+	} else {
+		for _, path := range mask.GetFieldPaths() {
+			segs := splitPath(path)
+			if v, ok := getPath(update.GetFields(), segs); ok {
+				setPath(next.fields, segs, proto.Clone(v).(*pb.Value))
+			} else {
+				deletePath(next.fields, segs)
+			}
+		}
+	}
+
+	res := &pb.WriteResult{UpdateTime: timestamppb.New(now)}
+	for _, t := range w.GetUpdateTransforms() {
+		v, err := transform(next.fields, t, now)
+		if err != nil {
+			return nil, err
+		}
+		setPath(next.fields, splitPath(t.GetFieldPath()), v)
+		res.TransformResults = append(res.TransformResults, proto.Clone(v).(*pb.Value))
+	}
+	staged[name] = next
+	return res, nil
+}
+
+// transform is the value t leaves at its field
+func transform(fields map[string]*pb.Value, t *pb.DocumentTransform_FieldTransform, now time.Time) (*pb.Value, error) {
+	current, _ := getPath(fields, splitPath(t.GetFieldPath()))
+	switch tt := t.GetTransformType().(type) {
+	case *pb.DocumentTransform_FieldTransform_SetToServerValue:
+		return &pb.Value{ValueType: &pb.Value_TimestampValue{TimestampValue: timestamppb.New(now)}}, nil
+	case *pb.DocumentTransform_FieldTransform_Increment:
+		if typeOrder(current) != 2 || current == nil {
+			return tt.Increment, nil
+		}
+		a, aInt := current.GetValueType().(*pb.Value_IntegerValue)
+		b, bInt := tt.Increment.GetValueType().(*pb.Value_IntegerValue)
+		if aInt && bInt {
+			return &pb.Value{ValueType: &pb.Value_IntegerValue{IntegerValue: a.IntegerValue + b.IntegerValue}}, nil
+		}
+		return &pb.Value{ValueType: &pb.Value_DoubleValue{DoubleValue: number(current) + number(tt.Increment)}}, nil
+	case *pb.DocumentTransform_FieldTransform_Maximum:
+		if current == nil || typeOrder(current) != 2 || compare(tt.Maximum, current) > 0 {
+			return tt.Maximum, nil
+		}
+		return current, nil
+	case *pb.DocumentTransform_FieldTransform_Minimum:
+		if current == nil || typeOrder(current) != 2 || compare(tt.Minimum, current) < 0 || math.IsNaN(number(tt.Minimum)) {
+			return tt.Minimum, nil
+		}
+		return current, nil
+	case *pb.DocumentTransform_FieldTransform_AppendMissingElements:
+		values := append([]*pb.Value{}, current.GetArrayValue().GetValues()...)
+		for _, v := range tt.AppendMissingElements.GetValues() {
+			if !containsAny(values, v) {
+				values = append(values, v)
+			}
+		}
+		return &pb.Value{ValueType: &pb.Value_ArrayValue{ArrayValue: &pb.ArrayValue{Values: values}}}, nil
+	case *pb.DocumentTransform_FieldTransform_RemoveAllFromArray:
+		var values []*pb.Value
+		for _, v := range current.GetArrayValue().GetValues() {
+			if !containsAny(tt.RemoveAllFromArray.GetValues(), v) {
+				values = append(values, v)
+			}
+		}
+		return &pb.Value{ValueType: &pb.Value_ArrayValue{ArrayValue: &pb.ArrayValue{Values: values}}}, nil
+	}
+	return nil, status.Errorf(codes.Unimplemented, "unsupported transform of %s", t.GetFieldPath())
+}
@@ -0,0 +1,164 @@
+package firestoremock
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func start(t *testing.T) *firestore.Client {
+	t.Helper()
+	srv, err := Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := srv.Client(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		client.Close()
+		srv.Close()
+	})
+	return client
+}
+
+func TestWrites(t *testing.T) {
+	client := start(t)
+	ctx := context.Background()
+	ref := client.Collection("c").Doc("a")
+
+	if _, err := ref.Create(ctx, map[string]interface{}{"n": 1, "tags": []string{"x"}, "m": map[string]interface{}{"k": "v"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ref.Create(ctx, map[string]interface{}{}); status.Code(err) != codes.AlreadyExists {
+		t.Fatalf("second create: %v", err)
+	}
+	_, err := ref.Update(ctx, []firestore.Update{
+		{Path: "n", Value: firestore.Increment(2)},
+		{Path: "tags", Value: firestore.ArrayUnion("x", "y")},
+		{Path: "m.k", Value: firestore.Delete},
+		{Path: "m.j", Value: "w"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ref.Set(ctx, map[string]interface{}{"extra": true}, firestore.MergeAll); err != nil {
+		t.Fatal(err)
+	}
+	snap, err := ref.Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := snap.Data()
+	if data["n"] != int64(3) || len(data["tags"].([]interface{})) != 2 || data["extra"] != true {
+		t.Errorf("data = %v", data)
+	}
+	if m := data["m"].(map[string]interface{}); m["k"] != nil || m["j"] != "w" {
+		t.Errorf("m = %v", m)
+	}
+
+	if _, err := client.Collection("c").Doc("missing").Update(ctx, []firestore.Update{{Path: "n", Value: 1}}); status.Code(err) != codes.NotFound {
+		t.Errorf("update of a missing doc: %v", err)
+	}
+	if _, err := ref.Delete(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ref.Get(ctx); status.Code(err) != codes.NotFound {
+		t.Errorf("get after delete: %v", err)
+	}
+}
+
+func TestQuery(t *testing.T) {
+	client := start(t)
+	ctx := context.Background()
+	col := client.Collection("c")
+	for id, n := range map[string]int{"a": 3, "b": 1, "c": 2, "d": 5} {
+		if _, err := col.Doc(id).Set(ctx, map[string]interface{}{"n": n, "even": n%2 == 0}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a doc in a subcollection of the same name is not in the collection
+	if _, err := col.Doc("a").Collection("c").Doc("x").Set(ctx, map[string]interface{}{"n": 4}); err != nil {
+		t.Fatal(err)
+	}
+
+	ids := func(q firestore.Query) []string {
+		t.Helper()
+		docs, err := q.Documents(ctx).GetAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out []string
+		for _, d := range docs {
+			out = append(out, d.Ref.ID)
+		}
+		return out
+	}
+	tests := []struct {
+		name string
+		q    firestore.Query
+		want string
+	}{
+		{"all by name", col.Query, "[a b c d]"},
+		{"range", col.Where("n", ">", 1), "[c a d]"},
+		{"order desc limit", col.OrderBy("n", firestore.Desc).Limit(2), "[d a]"},
+		{"in", col.Where("n", "in", []int{1, 5}), "[b d]"},
+		{"start after", col.OrderBy("n", firestore.Asc).StartAfter(2), "[a d]"},
+		{"equality", col.Where("even", "==", true), "[c]"},
+		{"group", client.CollectionGroup("c").Where("n", ">=", 4), "[x d]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := fmt.Sprint(ids(tt.q)); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// concurrent increments inside transactions conflict, the client retries
+// the aborted ones and none is lost
+func TestTransactionConflicts(t *testing.T) {
+	client := start(t)
+	ctx := context.Background()
+	ref := client.Collection("c").Doc("counter")
+	if _, err := ref.Set(ctx, map[string]interface{}{"n": 0}); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 3)
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+				snap, err := tx.Get(ref)
+				if err != nil {
+					return err
+				}
+				return tx.Update(ref, []firestore.Update{{Path: "n", Value: snap.Data()["n"].(int64) + 1}})
+			})
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	snap, err := ref.Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := snap.Data()["n"]; n != int64(3) {
+		t.Errorf("n = %v, want 3", n)
+	}
+}
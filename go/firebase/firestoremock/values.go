@@ -0,0 +1,255 @@
+package firestoremock
+
+import (
+	"bytes"
+	"math"
+	"sort"
+	"strings"
+
+	pb "cloud.google.com/go/firestore/apiv1/firestorepb"
+	"google.golang.org/protobuf/proto"
+)
+
+// splitPath splits a field path into its segments, `quoted` segments may
+// contain dots
+func splitPath(path string) []string {
+	var segs []string
+	var cur strings.Builder
+	quoted := false
+	for i := 0; i < len(path); i++ {
+		ch := path[i]
+		switch {
+		case ch == '\\' && quoted && i+1 < len(path):
+			i++
+			cur.WriteByte(path[i])
+		case ch == '`':
+			quoted = !quoted
+		case ch == '.' && !quoted:
+			segs = append(segs, cur.String())
+			cur.Reset()
+		default:
+			cur.WriteByte(ch)
+		}
+	}
+	return append(segs, cur.String())
+}
+
+func getPath(fields map[string]*pb.Value, segs []string) (*pb.Value, bool) {
+	v, ok := fields[segs[0]]
+	if !ok {
+		return nil, false
+	}
+	if len(segs) == 1 {
+		return v, true
+	}
+	m := v.GetMapValue()
+	if m == nil {
+		return nil, false
+	}
+	return getPath(m.Fields, segs[1:])
+}
+
+func setPath(fields map[string]*pb.Value, segs []string, v *pb.Value) {
+	if len(segs) == 1 {
+		fields[segs[0]] = v
+		return
+	}
+	m := fields[segs[0]].GetMapValue()
+	if m == nil {
+		m = &pb.MapValue{}
+		fields[segs[0]] = &pb.Value{ValueType: &pb.Value_MapValue{MapValue: m}}
+	}
+	if m.Fields == nil {
+		m.Fields = map[string]*pb.Value{}
+	}
+	setPath(m.Fields, segs[1:], v)
+}
+
+func deletePath(fields map[string]*pb.Value, segs []string) {
+	if len(segs) == 1 {
+		delete(fields, segs[0])
+		return
+	}
+	if m := fields[segs[0]].GetMapValue(); m != nil {
+		deletePath(m.Fields, segs[1:])
+	}
+}
+
+func cloneFields(fields map[string]*pb.Value) map[string]*pb.Value {
+	out := make(map[string]*pb.Value, len(fields))
+	for k, v := range fields {
+		out[k] = proto.Clone(v).(*pb.Value)
+	}
+	return out
+}
+
+// project keeps only paths of fields
+func project(fields map[string]*pb.Value, paths []string) map[string]*pb.Value {
+	out := map[string]*pb.Value{}
+	for _, p := range paths {
+		segs := splitPath(p)
+		if v, ok := getPath(fields, segs); ok {
+			setPath(out, segs, proto.Clone(v).(*pb.Value))
+		}
+	}
+	return out
+}
+
+// typeOrder is the rank of the type of v in Firestore's ordering, ints and
+// doubles are both numbers
+func typeOrder(v *pb.Value) int {
+	switch v.GetValueType().(type) {
+	case *pb.Value_NullValue:
+		return 0
+	case *pb.Value_BooleanValue:
+		return 1
+	case *pb.Value_IntegerValue, *pb.Value_DoubleValue:
+		return 2
+	case *pb.Value_TimestampValue:
+		return 3
+	case *pb.Value_StringValue:
+		return 4
+	case *pb.Value_BytesValue:
+		return 5
+	case *pb.Value_ReferenceValue:
+		return 6
+	case *pb.Value_GeoPointValue:
+		return 7
+	case *pb.Value_ArrayValue:
+		return 8
+	default:
+		return 9
+	}
+}
+
+func number(v *pb.Value) float64 {
+	if d, ok := v.GetValueType().(*pb.Value_DoubleValue); ok {
+		return d.DoubleValue
+	}
+	return float64(v.GetIntegerValue())
+}
+
+func cmpInt(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func cmpFloat(a, b float64) int {
+	switch {
+	case math.IsNaN(a) && math.IsNaN(b):
+		return 0
+	case math.IsNaN(a):
+		return -1
+	case math.IsNaN(b):
+		return 1
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// compare orders a and b the way Firestore does
+func compare(a, b *pb.Value) int {
+	if ta, tb := typeOrder(a), typeOrder(b); ta != tb {
+		return cmpInt(int64(ta), int64(tb))
+	}
+	switch av := a.GetValueType().(type) {
+	case *pb.Value_NullValue:
+		return 0
+	case *pb.Value_BooleanValue:
+		x, y := av.BooleanValue, b.GetBooleanValue()
+		switch {
+		case x == y:
+			return 0
+		case !x:
+			return -1
+		}
+		return 1
+	case *pb.Value_IntegerValue:
+		if bi, ok := b.GetValueType().(*pb.Value_IntegerValue); ok {
+			return cmpInt(av.IntegerValue, bi.IntegerValue)
+		}
+		return cmpFloat(number(a), number(b))
+	case *pb.Value_DoubleValue:
+		return cmpFloat(number(a), number(b))
+	case *pb.Value_TimestampValue:
+		x, y := av.TimestampValue, b.GetTimestampValue()
+		if c := cmpInt(x.GetSeconds(), y.GetSeconds()); c != 0 {
+			return c
+		}
+		return cmpInt(int64(x.GetNanos()), int64(y.GetNanos()))
+	case *pb.Value_StringValue:
+		return strings.Compare(av.StringValue, b.GetStringValue())
+	case *pb.Value_BytesValue:
+		return bytes.Compare(av.BytesValue, b.GetBytesValue())
+	case *pb.Value_ReferenceValue:
+		return compareNames(av.ReferenceValue, b.GetReferenceValue())
+	case *pb.Value_GeoPointValue:
+		x, y := av.GeoPointValue, b.GetGeoPointValue()
+		if c := cmpFloat(x.GetLatitude(), y.GetLatitude()); c != 0 {
+			return c
+		}
+		return cmpFloat(x.GetLongitude(), y.GetLongitude())
+	case *pb.Value_ArrayValue:
+		x, y := av.ArrayValue.GetValues(), b.GetArrayValue().GetValues()
+		for i := 0; i < len(x) && i < len(y); i++ {
+			if c := compare(x[i], y[i]); c != 0 {
+				return c
+			}
+		}
+		return cmpInt(int64(len(x)), int64(len(y)))
+	default:
+		x, y := a.GetMapValue().GetFields(), b.GetMapValue().GetFields()
+		xk, yk := sortedKeys(x), sortedKeys(y)
+		for i := 0; i < len(xk) && i < len(yk); i++ {
+			if c := strings.Compare(xk[i], yk[i]); c != 0 {
+				return c
+			}
+			if c := compare(x[xk[i]], y[yk[i]]); c != 0 {
+				return c
+			}
+		}
+		return cmpInt(int64(len(xk)), int64(len(yk)))
+	}
+}
+
+// compareNames orders document names segment by segment
+func compareNames(a, b string) int {
+	x, y := strings.Split(a, "/"), strings.Split(b, "/")
+	for i := 0; i < len(x) && i < len(y); i++ {
+		if c := strings.Compare(x[i], y[i]); c != 0 {
+			return c
+		}
+	}
+	return cmpInt(int64(len(x)), int64(len(y)))
+}
+
+func sortedKeys(m map[string]*pb.Value) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func equal(a, b *pb.Value) bool {
+	return typeOrder(a) == typeOrder(b) && compare(a, b) == 0
+}
+
+func isNull(v *pb.Value) bool {
+	_, ok := v.GetValueType().(*pb.Value_NullValue)
+	return ok
+}
+
+func isNaN(v *pb.Value) bool {
+	d, ok := v.GetValueType().(*pb.Value_DoubleValue)
+	return ok && math.IsNaN(d.DoubleValue)
+}
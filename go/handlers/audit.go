@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/gin-gonic/gin"
+
+	"calple/apierr"
+	"calple/audit"
+)
+
+// the trail is written after the action, even if the client went away
+const auditTimeout = 10 * time.Second
+
+// AuditQuery pages through GetAuditLog
+type AuditQuery struct {
+	Limit  int    `form:"limit" binding:"omitempty,min=1,max=100"`
+	Before string `form:"before" binding:"max=100"`
+}
+
+// audited records an action of the signed in user in the trail of the
+// actor and of the users in subjects. a failure is logged, the action
+// itself already happened.
+func audited(c *gin.Context, fsClient *firestore.Client, action audit.Action, target audit.Target, changes map[string]audit.Change, subjects ...string) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(c.Request.Context()), auditTimeout)
+	defer cancel()
+
+	err := audit.Record(ctx, fsClient, audit.Entry{
+		Action:    action,
+		ActorUID:  currentUID(c),
+		Target:    target,
+		Changes:   changes,
+		Subjects:  subjects,
+		IP:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+		RequestID: c.GetString("requestID"),
+	})
+	if err != nil {
+		requestLogger(c).Error("failed to record audit entry", "action", action, "target", target.Type, "error", err)
+	}
+}
+
+// GetAuditLog lists what the user did and what was done to their data,
+// latest first. where the partner acted their IP and user agent are left
+// out.
+func GetAuditLog(c *gin.Context) {
+	uid := currentUID(c)
+	if uid == "" {
+		apierr.Abort(c, apierr.Unauthorized("Unauthorized"))
+		return
+	}
+
+	var q AuditQuery
+	if err := c.ShouldBindQuery(&q); err != nil {
+		apierr.Abort(c, apierr.FromBinding(err))
+		return
+	}
+	if q.Limit == 0 {
+		q.Limit = 50
+	}
+
+	fsClient := c.MustGet("firestore").(*firestore.Client)
+	entries, next, err := audit.List(c.Request.Context(), fsClient, uid, q.Limit, q.Before)
+	switch {
+	case errors.Is(err, audit.ErrInvalidCursor):
+		apierr.Abort(c, apierr.BadRequest("Invalid before cursor"))
+		return
+	case err != nil:
+		apierr.Abort(c, apierr.Internal("Failed to load the audit log").WithCause(err))
+		return
+	}
+
+	for i := range entries {
+		if entries[i].ActorUID != uid {
+			entries[i].IP = ""
+			entries[i].UserAgent = ""
+		}
+	}
+
+	body := gin.H{"entries": entries}
+	if next != "" {
+		body["next"] = "/audit?limit=" + strconv.Itoa(q.Limit) + "&before=" + next
+	}
+	c.JSON(http.StatusOK, body)
+}
+
+// auditConnection records a connection going from one status to another
+func auditConnection(c *gin.Context, fsClient *firestore.Client, action audit.Action, connID, from, to, partnerUID string) {
+	var before interface{}
+	if from != "" {
+		before = from
+	}
+	audited(c, fsClient, action, audit.Target{Type: "connection", ID: connID},
+		map[string]audit.Change{"status": {Before: before, After: to}}, partnerUID)
+}
@@ -1,13 +1,15 @@
 package handlers
 
 import (
+	"calple/apierr"
+	"calple/events"
+	"calple/telemetry"
+	"calple/trash"
 	"calple/util"
-	"context"
 	"net/http"
 	"time"
 
 	"cloud.google.com/go/firestore"
-	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
 )
 
@@ -15,16 +17,21 @@ import (
 type CheckinData struct {
 	ID           string    `json:"id"`
 	UserID       string    `json:"userId"`
-	Date         string    `json:"date"`
-	Mood         string    `json:"mood"`
-	Energy       string    `json:"energy"`
-	PeriodStatus string    `json:"periodStatus"`
-	SexualMood   string    `json:"sexualMood"`
-	Note         string    `json:"note"`
+	Date         string    `json:"date" binding:"required,datetime=2006-01-02"`
+	Mood         string    `json:"mood" binding:"required,max=50"`
+	Energy       string    `json:"energy" binding:"required,max=50"`
+	PeriodStatus string    `json:"periodStatus" binding:"max=50"`
+	SexualMood   string    `json:"sexualMood" binding:"max=50"`
+	Note         string    `json:"note" binding:"max=1000"`
 	CreatedAt    time.Time `json:"createdAt"`
 	UpdatedAt    time.Time `json:"updatedAt"`
 }
 
+// dateParam is the :date of the check-in and period day routes
+type dateParam struct {
+	Date string `uri:"date" binding:"required,datetime=2006-01-02"`
+}
+
 type PartnerCheckin struct {
 	ID           string    `json:"id"`
 	UserID       string    `json:"userId"`
@@ -41,53 +48,31 @@ type PartnerCheckin struct {
 }
 
 func CreateCheckin(c *gin.Context) {
-	session := sessions.Default(c)
-	uid := session.Get("user_id")
-	if uid == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+	uid := currentUID(c)
+	if uid == "" {
+		apierr.Abort(c, apierr.Unauthorized("Unauthorized"))
 		return
 	}
 
 	fsClient := c.MustGet("firestore").(*firestore.Client)
-	ctx := context.Background()
+	ctx := c.Request.Context()
 
 	var checkinData CheckinData
 	if err := c.ShouldBindJSON(&checkinData); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
-		return
-	}
-
-	if checkinData.Date == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Date is required"})
-		return
-	}
-
-	if checkinData.Mood == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Mood is required"})
-		return
-	}
-
-	if checkinData.Energy == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Energy is required"})
-		return
-	}
-
-	// YYYY-MM-DD format validation
-	if len(checkinData.Date) != 10 || checkinData.Date[4] != '-' || checkinData.Date[7] != '-' {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid date format. Use YYYY-MM-DD"})
+		apierr.Abort(c, apierr.FromBinding(err))
 		return
 	}
 
-	userID := uid.(string)
-
-	existingDocs, err := fsClient.Collection("users").Doc(userID).Collection("checkins").
+	existingDocs, err := fsClient.Collection("users").Doc(uid).Collection("checkins").
 		Where("date", "==", checkinData.Date).
 		Documents(ctx).GetAll()
 
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check existing checkin"})
+		apierr.Abort(c, apierr.Internal("Failed to check existing checkin").WithCause(err))
 		return
 	}
+	// a trashed check-in of the day stays restorable, a new one is created
+	existingDocs = trash.Live(existingDocs)
 
 	now := time.Now()
 	var docRef *firestore.DocumentRef
@@ -95,11 +80,11 @@ func CreateCheckin(c *gin.Context) {
 	if len(existingDocs) > 0 {
 		docRef = existingDocs[0].Ref
 	} else {
-		docRef = fsClient.Collection("users").Doc(userID).Collection("checkins").NewDoc()
+		docRef = fsClient.Collection("users").Doc(uid).Collection("checkins").NewDoc()
 	}
 
 	firestoreData := map[string]interface{}{
-		"userId":    userID,
+		"userId":    uid,
 		"date":      checkinData.Date,
 		"mood":      checkinData.Mood,
 		"energy":    checkinData.Energy,
@@ -124,178 +109,158 @@ func CreateCheckin(c *gin.Context) {
 
 	_, err = docRef.Set(ctx, firestoreData)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save checkin"})
+		apierr.Abort(c, apierr.Internal("Failed to save checkin").WithCause(err))
 		return
 	}
+	// updating the day's check-in doesn't count as another one
+	if len(existingDocs) == 0 {
+		telemetry.CheckinsCreated.Inc()
+	}
 
 	savedDoc, err := docRef.Get(ctx)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch saved checkin"})
+		apierr.Abort(c, apierr.Internal("Failed to fetch saved checkin").WithCause(err))
 		return
 	}
 
 	savedData := savedDoc.Data()
-	createdAt := now
-	if savedData["createdAt"] != nil {
-		createdAt = savedData["createdAt"].(time.Time)
+	createdAt := util.GetTimeValue(savedData, "createdAt")
+	if createdAt.IsZero() {
+		createdAt = now
 	}
 
 	responseCheckin := CheckinData{
 		ID:           docRef.ID,
-		UserID:       userID,
-		Date:         savedData["date"].(string),
-		Mood:         savedData["mood"].(string),
-		Energy:       savedData["energy"].(string),
+		UserID:       uid,
+		Date:         util.GetStringValue(savedData, "date"),
+		Mood:         util.GetStringValue(savedData, "mood"),
+		Energy:       util.GetStringValue(savedData, "energy"),
 		PeriodStatus: util.GetStringValue(savedData, "periodStatus"),
 		SexualMood:   util.GetStringValue(savedData, "sexualMood"),
 		Note:         util.GetStringValue(savedData, "note"),
 		CreatedAt:    createdAt,
-		UpdatedAt:    savedData["updatedAt"].(time.Time),
+		UpdatedAt:    util.GetTimeValue(savedData, "updatedAt"),
 	}
 
+	publishToCouple(c, fsClient, uid, events.CheckinSaved, events.Change{ID: docRef.ID, Date: responseCheckin.Date, UserID: uid})
 	c.JSON(http.StatusOK, gin.H{"checkin": responseCheckin})
 }
 
 func GetTodayCheckin(c *gin.Context) {
-	session := sessions.Default(c)
-	uid := session.Get("user_id")
-	if uid == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+	uid := currentUID(c)
+	if uid == "" {
+		apierr.Abort(c, apierr.Unauthorized("Unauthorized"))
 		return
 	}
 
 	fsClient := c.MustGet("firestore").(*firestore.Client)
-	ctx := context.Background()
-
-	date := c.Param("date")
-	if date == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Date parameter is required"})
-		return
-	}
+	ctx := c.Request.Context()
 
-	// YYYY-MM-DD format validation
-	if len(date) != 10 || date[4] != '-' || date[7] != '-' {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid date format. Use YYYY-MM-DD"})
+	var param dateParam
+	if err := c.ShouldBindUri(&param); err != nil {
+		apierr.Abort(c, apierr.FromBinding(err))
 		return
 	}
+	date := param.Date
 
-	userID := uid.(string)
-
-	checkinDocs, err := fsClient.Collection("users").Doc(userID).Collection("checkins").
+	checkinDocs, err := fsClient.Collection("users").Doc(uid).Collection("checkins").
 		Where("date", "==", date).
 		Documents(ctx).GetAll()
 
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch checkin data"})
+		apierr.Abort(c, apierr.Internal("Failed to fetch checkin data").WithCause(err))
 		return
 	}
+	checkinDocs = trash.Live(checkinDocs)
 
 	if len(checkinDocs) == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Checkin not found for the specified date"})
+		apierr.Abort(c, apierr.NotFound("Checkin not found for the specified date"))
 		return
 	}
 
 	data := checkinDocs[0].Data()
-	createdAt := time.Now()
-	if data["createdAt"] != nil {
-		createdAt = data["createdAt"].(time.Time)
+	createdAt := util.GetTimeValue(data, "createdAt")
+	if createdAt.IsZero() {
+		createdAt = time.Now()
 	}
 
 	checkin := CheckinData{
 		ID:           checkinDocs[0].Ref.ID,
-		UserID:       userID,
-		Date:         data["date"].(string),
-		Mood:         data["mood"].(string),
-		Energy:       data["energy"].(string),
+		UserID:       uid,
+		Date:         util.GetStringValue(data, "date"),
+		Mood:         util.GetStringValue(data, "mood"),
+		Energy:       util.GetStringValue(data, "energy"),
 		PeriodStatus: util.GetStringValue(data, "periodStatus"),
 		SexualMood:   util.GetStringValue(data, "sexualMood"),
 		Note:         util.GetStringValue(data, "note"),
 		CreatedAt:    createdAt,
-		UpdatedAt:    data["updatedAt"].(time.Time),
+		UpdatedAt:    util.GetTimeValue(data, "updatedAt"),
 	}
 
 	c.JSON(http.StatusOK, gin.H{"checkin": checkin})
 }
 
 func GetPartnerCheckin(c *gin.Context) {
-	session := sessions.Default(c)
-	uid := session.Get("user_id")
-	if uid == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+	uid := currentUID(c)
+	if uid == "" {
+		apierr.Abort(c, apierr.Unauthorized("Unauthorized"))
 		return
 	}
 
 	fsClient := c.MustGet("firestore").(*firestore.Client)
-	ctx := context.Background()
+	ctx := c.Request.Context()
 
-	date := c.Param("date")
-	if date == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Date parameter is required"})
+	var param dateParam
+	if err := c.ShouldBindUri(&param); err != nil {
+		apierr.Abort(c, apierr.FromBinding(err))
 		return
 	}
+	date := param.Date
 
-	connectionDocs, err := fsClient.Collection("users").Doc(uid.(string)).Collection("connections").
-		Where("status", "==", "active").
-		Documents(ctx).GetAll()
-
+	partnerID, err := activePartnerUID(ctx, fsClient, uid)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch connection"})
+		apierr.Abort(c, apierr.Internal("Failed to fetch connection").WithCause(err))
 		return
 	}
 
-	if len(connectionDocs) == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "No partner connection found"})
+	if partnerID == "" {
+		apierr.Abort(c, apierr.NotFound("No partner connection found"))
 		return
 	}
 
-	connectionData := connectionDocs[0].Data()
-	partnerEmail := connectionData["partnerEmail"].(string)
-
-	// decided to use partner's email to fetch their checkin,
-	// this is more reliable cause partner's id can change if they delete their account
-	partnerUserDocs, err := fsClient.Collection("users").
-		Where("email", "==", partnerEmail).
-		Documents(ctx).GetAll()
-
+	// partner is resolved by UID, which stays stable even if their email changes
+	partnerUserDoc, err := fsClient.Collection("users").Doc(partnerID).Get(ctx)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch partner user info"})
-		return
-	}
-
-	if len(partnerUserDocs) == 0 {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch partner user info"})
+		apierr.Abort(c, apierr.Internal("Failed to fetch partner user info").WithCause(err))
 		return
 	}
 
-	partnerUserData := partnerUserDocs[0].Data()
-	partnerName := partnerUserData["name"].(string)
-	partnerID := partnerUserDocs[0].Ref.ID
-
-	partnerSex := ""
-	if partnerUserData["sex"] != nil {
-		partnerSex = partnerUserData["sex"].(string)
-	}
+	partnerUserData := partnerUserDoc.Data()
+	partnerName := util.GetStringValue(partnerUserData, "name")
+	partnerEmail := util.GetStringValue(partnerUserData, "email")
+	partnerSex := util.GetStringValue(partnerUserData, "sex")
 
 	checkinDocs, err := fsClient.Collection("users").Doc(partnerID).Collection("checkins").
 		Where("date", "==", date).
 		Documents(ctx).GetAll()
 
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch partner checkin"})
+		apierr.Abort(c, apierr.Internal("Failed to fetch partner checkin").WithCause(err))
 		return
 	}
+	checkinDocs = trash.Live(checkinDocs)
 
 	if len(checkinDocs) == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Partner checkin not found"})
+		apierr.Abort(c, apierr.NotFound("Partner checkin not found"))
 		return
 	}
 
 	data := checkinDocs[0].Data()
 
 	// optional timestamp fields
-	createdAt := time.Now()
-	if data["createdAt"] != nil {
-		createdAt = data["createdAt"].(time.Time)
+	createdAt := util.GetTimeValue(data, "createdAt")
+	if createdAt.IsZero() {
+		createdAt = time.Now()
 	}
 
 	partnerCheckin := PartnerCheckin{
@@ -304,9 +269,9 @@ func GetPartnerCheckin(c *gin.Context) {
 		UserName:     partnerName,
 		UserEmail:    partnerEmail,
 		UserSex:      partnerSex,
-		Date:         data["date"].(string),
-		Mood:         data["mood"].(string),
-		Energy:       data["energy"].(string),
+		Date:         util.GetStringValue(data, "date"),
+		Mood:         util.GetStringValue(data, "mood"),
+		Energy:       util.GetStringValue(data, "energy"),
 		PeriodStatus: util.GetStringValue(data, "periodStatus"),
 		SexualMood:   util.GetStringValue(data, "sexualMood"),
 		Note:         util.GetStringValue(data, "note"),
@@ -317,52 +282,53 @@ func GetPartnerCheckin(c *gin.Context) {
 }
 
 func DeleteCheckin(c *gin.Context) {
-	session := sessions.Default(c)
-	uid := session.Get("user_id")
-	if uid == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+	uid := currentUID(c)
+	if uid == "" {
+		apierr.Abort(c, apierr.Unauthorized("Unauthorized"))
 		return
 	}
 
 	fsClient := c.MustGet("firestore").(*firestore.Client)
-	ctx := context.Background()
+	ctx := c.Request.Context()
 
-	date := c.Param("date")
-	if date == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Date parameter is required"})
+	var param dateParam
+	if err := c.ShouldBindUri(&param); err != nil {
+		apierr.Abort(c, apierr.FromBinding(err))
 		return
 	}
+	date := param.Date
 
 	// YYYY-MM-DD
 	if len(date) != 10 || date[4] != '-' || date[7] != '-' {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid date format. Use YYYY-MM-DD"})
+		apierr.Abort(c, apierr.BadRequest("Invalid date format. Use YYYY-MM-DD"))
 		return
 	}
 
-	userID := uid.(string)
-
 	// find the checkin document for the specified date
-	checkinDocs, err := fsClient.Collection("users").Doc(userID).Collection("checkins").
+	checkinDocs, err := fsClient.Collection("users").Doc(uid).Collection("checkins").
 		Where("date", "==", date).
 		Documents(ctx).GetAll()
 
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch checkin data"})
+		apierr.Abort(c, apierr.Internal("Failed to fetch checkin data").WithCause(err))
 		return
 	}
+	checkinDocs = trash.Live(checkinDocs)
 
 	if len(checkinDocs) == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Checkin not found for the specified date"})
+		apierr.Abort(c, apierr.NotFound("Checkin not found for the specified date"))
 		return
 	}
 
-	// delete checkin document
+	// move the checkin to the trash
 	checkinDoc := checkinDocs[0]
-	_, err = checkinDoc.Ref.Delete(ctx)
+	_, err = checkinDoc.Ref.Update(ctx, trash.Delete(uid, time.Now()))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete checkin"})
+		apierr.Abort(c, apierr.Internal("Failed to delete checkin").WithCause(err))
 		return
 	}
 
+	publishToCouple(c, fsClient, uid, events.CheckinDeleted, events.Change{ID: checkinDoc.Ref.ID, Date: date, UserID: uid})
+
 	c.JSON(http.StatusOK, gin.H{"message": "Checkin deleted successfully"})
 }
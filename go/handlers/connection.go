@@ -2,15 +2,19 @@ package handlers
 
 import (
 	"context"
-	"fmt"
+	"errors"
 	"net/http"
-	"strings"
+	"sort"
 	"time"
 
 	"cloud.google.com/go/firestore"
-	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
+	"calple/apierr"
+	"calple/audit"
+	"calple/events"
 	"calple/util"
 )
 
@@ -22,14 +26,37 @@ type Invitation struct {
 	CreatedAt time.Time `json:"createdAt"`
 }
 
+// activeConnection returns the user's active connection, or nil when there is none
+func activeConnection(ctx context.Context, fsClient *firestore.Client, uid string) (*firestore.DocumentSnapshot, error) {
+	docs, err := fsClient.Collection("users").Doc(uid).Collection("connections").
+		Where("status", "==", "active").
+		Limit(1).
+		Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(docs) == 0 {
+		return nil, nil
+	}
+	return docs[0], nil
+}
+
+// activePartnerUID returns the UID of the user's active partner, or "" when not connected
+func activePartnerUID(ctx context.Context, fsClient *firestore.Client, uid string) (string, error) {
+	conn, err := activeConnection(ctx, fsClient, uid)
+	if err != nil || conn == nil {
+		return "", err
+	}
+	return util.GetStringValue(conn.Data(), "partnerUID"), nil
+}
+
 // get active connection for current user
 func GetConnection(c *gin.Context) {
 	// check session for user ID
 	// to check if user is logged in
-	session := sessions.Default(c)
-	uid := session.Get("user_id")
-	if uid == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+	uid := currentUID(c)
+	if uid == "" {
+		apierr.Abort(c, apierr.Unauthorized("Unauthorized"))
 		return
 	}
 	// get firestore client from context
@@ -38,31 +65,25 @@ func GetConnection(c *gin.Context) {
 	fsClient := c.MustGet("firestore").(*firestore.Client)
 
 	// find active connection in the user's subcollection
-	conns, _ := fsClient.Collection("users").Doc(uid.(string)).Collection("connections").Where("status", "==", "active").Documents(context.Background()).GetAll()
+	conn, _ := activeConnection(c.Request.Context(), fsClient, uid)
 
 	// if still no connections found, return false
-	if len(conns) == 0 {
+	if conn == nil {
 		c.JSON(http.StatusOK, gin.H{"connected": false})
 		return
 	}
 
-	// if there are multiple connections, return the first one
-	conn := conns[0]
-	data := conn.Data()
-
-	partnerEmail, ok := data["partnerEmail"].(string)
-	if !ok {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid connection data"})
+	partnerUID := util.GetStringValue(conn.Data(), "partnerUID")
+	if partnerUID == "" {
+		apierr.Abort(c, apierr.Internal("Invalid connection data"))
 		return
 	}
 
 	// fetch partner info
-	partnerDocs, _ := fsClient.Collection("users").Where("email", "==", partnerEmail).Documents(context.Background()).GetAll()
-	var partnerInfo map[string]interface{}
-	if len(partnerDocs) > 0 {
-		partnerInfo = partnerDocs[0].Data()
-		// removing sensitive data
-		delete(partnerInfo, "passwordHash")
+	var partnerInfo *PartnerProfile
+	if partnerDoc, err := fsClient.Collection("users").Doc(partnerUID).Get(c.Request.Context()); err == nil {
+		profile := partnerProfile(partnerDoc)
+		partnerInfo = &profile
 	}
 
 	c.JSON(http.StatusOK, gin.H{
@@ -76,124 +97,200 @@ func GetConnection(c *gin.Context) {
 // this creates a pending connection that the other user can accept
 // if the connection already exists, return an error
 func InviteConnection(c *gin.Context) {
-	session := sessions.Default(c)
-	uid := session.Get("user_id")
-	if uid == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+	uid := currentUID(c)
+	if uid == "" {
+		apierr.Abort(c, apierr.Unauthorized("Unauthorized"))
 		return
 	}
 	fsClient := c.MustGet("firestore").(*firestore.Client)
-	userDoc, _ := fsClient.Collection("users").Doc(uid.(string)).Get(context.Background())
-	userEmail := userDoc.Data()["email"].(string)
+	userDoc, err := fsClient.Collection("users").Doc(uid).Get(c.Request.Context())
+	if err != nil {
+		apierr.Abort(c, apierr.Internal("Failed to retrieve user").WithCause(err))
+		return
+	}
+	userEmail := util.GetStringValue(userDoc.Data(), "email")
 
 	// parse request body
 	// expecting JSON body with email field
-	var body struct {
-		Email string `json:"email" binding:"required"`
-	}
+	var body InviteRequest
 
 	if err := c.ShouldBindJSON(&body); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
-
-	// validate email format
-	if !util.IsValidEmail(body.Email) {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid email format"})
+		apierr.Abort(c, apierr.FromBinding(err))
 		return
 	}
 
 	// normalize email to lowercase and trim whitespace
 	// to avoid case sensitivity issues
-	target := strings.ToLower(strings.TrimSpace(body.Email))
-	if target == userEmail {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot connect to yourself"})
+	target := util.NormalizeEmail(body.Email)
+	if target == util.NormalizeEmail(userEmail) {
+		apierr.Abort(c, apierr.BadRequest("Cannot connect to yourself"))
 		return
 	}
 
-	// check if target user exists
-	targets, _ := fsClient.Collection("users").Where("email", "==", target).Documents(context.Background()).GetAll()
+	// check if target user exists. the response is the same whether or not
+	// an invitation was created, so the endpoint can't be used to find out
+	// which emails have an account
+	targets, err := fsClient.Collection("users").Where("email", "==", target).Documents(c.Request.Context()).GetAll()
+	if err != nil {
+		apierr.Abort(c, apierr.Internal("Failed to send invitation").WithCause(err))
+		return
+	}
 	if len(targets) == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		invitationSent(c, fsClient, target)
 		return
 	}
 	targetUser := targets[0]
 	targetID := targetUser.Ref.ID
 
-	// check if connection already exists in either user's subcollection
-	existing, _ := fsClient.Collection("users").Doc(uid.(string)).Collection("connections").Where("partnerEmail", "==", target).Documents(context.Background()).GetAll()
-	if len(existing) > 0 {
-		status := existing[0].Data()["status"].(string)
-		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Connection %s already", status)})
+	// target has blocked invitations from this user
+	if isBlocked(c.Request.Context(), fsClient, targetID, userEmail) {
+		invitationSent(c, fsClient, target)
 		return
 	}
 
 	// create a new connection document in both users' subcollections
 	now := time.Now()
-	initiatorConnRef := fsClient.Collection("users").Doc(uid.(string)).Collection("connections").NewDoc()
+	userConns := fsClient.Collection("users").Doc(uid).Collection("connections")
+	targetConns := fsClient.Collection("users").Doc(targetID).Collection("connections")
+	initiatorConnRef := userConns.NewDoc()
+	expired := []firestore.Update{
+		{Path: "status", Value: connectionExpired},
+		{Path: "endedAt", Value: now},
+		{Path: "updatedAt", Value: now},
+	}
+
+	err = fsClient.RunTransaction(c.Request.Context(), func(ctx context.Context, tx *firestore.Transaction) error {
+		// check if connection already exists, in the transaction so two
+		// invitations at once can't both pass. ended connections are
+		// history and don't prevent a new invitation, pending ones past
+		// their expiry are ended on both sides.
+		existing, err := tx.Documents(userConns.Where("partnerUID", "==", targetID)).GetAll()
+		if err != nil {
+			return err
+		}
+		var ended []*firestore.DocumentRef
+		for _, doc := range existing {
+			status := util.GetStringValue(doc.Data(), "status")
+			if status == "pending" && pendingExpired(doc.Data()) {
+				ended = append(ended, doc.Ref)
+				// partner side may be missing if their account was deleted
+				if _, err := tx.Get(targetConns.Doc(doc.Ref.ID)); err == nil {
+					ended = append(ended, targetConns.Doc(doc.Ref.ID))
+				}
+				continue
+			}
+			if status == "pending" || status == "active" {
+				return errAlreadyConnected
+			}
+		}
+		for _, ref := range ended {
+			if err := tx.Update(ref, expired); err != nil {
+				return err
+			}
+		}
 
-	err := fsClient.RunTransaction(context.Background(), func(ctx context.Context, tx *firestore.Transaction) error {
 		// document for initiator
 		if err := tx.Set(initiatorConnRef, map[string]interface{}{
 			"partnerEmail": target,
+			"partnerUID":   targetID,
 			"role":         "initiator", // user1
 			"status":       "pending",
 			"createdAt":    now,
 			"updatedAt":    now,
+			"expiresAt":    now.Add(pendingInvitationTTL),
 		}); err != nil {
 			return err
 		}
 
 		// document for target
-		targetConnRef := fsClient.Collection("users").Doc(targetID).Collection("connections").Doc(initiatorConnRef.ID)
-		return tx.Set(targetConnRef, map[string]interface{}{
+		return tx.Set(targetConns.Doc(initiatorConnRef.ID), map[string]interface{}{
 			"partnerEmail": userEmail,
+			"partnerUID":   uid,
 			"role":         "receiver", // user2
 			"status":       "pending",
 			"createdAt":    now,
 			"updatedAt":    now,
+			"expiresAt":    now.Add(pendingInvitationTTL),
 		})
 	})
 
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send invitation"})
+	switch {
+	case errors.Is(err, errAlreadyConnected):
+		invitationSent(c, fsClient, target)
+		return
+	case err != nil:
+		apierr.Abort(c, apierr.Internal("Failed to send invitation").WithCause(err))
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Invitation sent", "connectionId": initiatorConnRef.ID})
+	// only the invited user hears of it, like the answer it must not tell
+	// the inviter whether the account exists
+	publish(c, events.ConnectionInvited, events.Change{ID: initiatorConnRef.ID, UserID: uid}, targetID)
+	invitationSent(c, fsClient, target, targetID)
+}
+
+// invitationSent is the answer to every invitation that passed validation,
+// the inviter sees the connection in their list once it exists. the
+// inviter's trail gets the same entry either way, it names the invited
+// email and not the connection. the invited user sees it in their trail
+// when the invitation was created.
+func invitationSent(c *gin.Context, fsClient *firestore.Client, email string, invited ...string) {
+	audited(c, fsClient, audit.ConnectionInvite, audit.Target{Type: "email", ID: email}, nil, invited...)
+	c.JSON(http.StatusOK, gin.H{"message": "Invitation sent"})
 }
 
 // list invitation for current user
 // this returns all pending invitations where the user is user2
 func GetPendingInvitations(c *gin.Context) {
-	session := sessions.Default(c)
-	uid := session.Get("user_id")
-	if uid == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+	uid := currentUID(c)
+	if uid == "" {
+		apierr.Abort(c, apierr.Unauthorized("Unauthorized"))
 		return
 	}
 	fsClient := c.MustGet("firestore").(*firestore.Client)
 
-	// find all pending connections where the current user is the receiver
-	pending, _ := fsClient.Collection("users").Doc(uid.(string)).Collection("connections").Where("status", "==", "pending").Documents(context.Background()).GetAll()
+	// find all pending connections where the current user is the receiver.
+	// the inviter's side is left out, it exists only when the invited
+	// address has an account
+	pending, err := fsClient.Collection("users").Doc(uid).Collection("connections").
+		Where("status", "==", "pending").
+		Where("role", "==", "receiver").
+		Documents(c.Request.Context()).GetAll()
+	if err != nil {
+		apierr.Abort(c, apierr.Internal("Failed to fetch invitations").WithCause(err))
+		return
+	}
 	invites := []Invitation{}
 
 	// iterate over pending connections and build the response
 	for _, doc := range pending {
 		data := doc.Data()
-		inviter := data["partnerEmail"].(string)
-		userDocs, _ := fsClient.Collection("users").Where("email", "==", inviter).Documents(context.Background()).GetAll()
+
+		// invitations nobody answered in time are moved to history
+		if pendingExpired(data) {
+			endConnection(c.Request.Context(), fsClient, uid, doc.Ref.ID, "pending", "", connectionExpired)
+			continue
+		}
+
+		inviter := util.GetStringValue(data, "partnerEmail")
 		inviterName := ""
-		if len(userDocs) > 0 {
-			inviterName = userDocs[0].Data()["name"].(string)
+		if inviterUID := util.GetStringValue(data, "partnerUID"); inviterUID != "" {
+			if inviterDoc, err := fsClient.Collection("users").Doc(inviterUID).Get(c.Request.Context()); err == nil {
+				profile := publicProfile(inviterDoc)
+				inviter = profile.Email
+				inviterName = profile.Name
+			}
 		}
-		invites = append(invites, Invitation{
+		invite := Invitation{
 			ID:        doc.Ref.ID,
 			FromEmail: inviter,
 			FromName:  inviterName,
-			Role:      data["role"].(string),
-			CreatedAt: data["createdAt"].(time.Time),
-		})
+			Role:      util.GetStringValue(data, "role"),
+		}
+		if t, ok := data["createdAt"].(time.Time); ok {
+			invite.CreatedAt = t
+		}
+		invites = append(invites, invite)
 	}
 	c.JSON(http.StatusOK, gin.H{"invitations": invites})
 }
@@ -202,45 +299,73 @@ func GetPendingInvitations(c *gin.Context) {
 // this updates the connection status to "active"
 // access to each others events as well
 func AcceptInvitation(c *gin.Context) {
-	session := sessions.Default(c)
-	uid := session.Get("user_id")
-	if uid == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+	uid := currentUID(c)
+	if uid == "" {
+		apierr.Abort(c, apierr.Unauthorized("Unauthorized"))
 		return
 	}
 	fsClient := c.MustGet("firestore").(*firestore.Client)
-	userDoc, _ := fsClient.Collection("users").Doc(uid.(string)).Get(context.Background())
-	userEmail := userDoc.Data()["email"].(string)
 
 	// get the connection from the current user's subcollection
 	connID := c.Param("id")
-	connRef := fsClient.Collection("users").Doc(uid.(string)).Collection("connections").Doc(connID)
-	connSnap, err := connRef.Get(context.Background())
+	connRef := fsClient.Collection("users").Doc(uid).Collection("connections").Doc(connID)
+	connSnap, err := connRef.Get(c.Request.Context())
 	// check if connection exists
 	if err != nil || !connSnap.Exists() {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Invitation not found"})
+		apierr.Abort(c, apierr.NotFound("Invitation not found"))
 		return
 	}
 
 	data := connSnap.Data()
 	// check if user is the receiver of the invitation
-	if data["role"].(string) != "receiver" {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Not authorized"})
+	if util.GetStringValue(data, "role") != "receiver" {
+		apierr.Abort(c, apierr.Forbidden("Not authorized"))
+		return
+	}
+	if util.GetStringValue(data, "status") != "pending" {
+		apierr.Abort(c, apierr.Conflict("Invitation is no longer pending"))
 		return
 	}
 
-	inviterEmail := data["partnerEmail"].(string)
-	// find inviter's user document to get their ID
-	inviterDocs, _ := fsClient.Collection("users").Where("email", "==", inviterEmail).Documents(context.Background()).GetAll()
-	if len(inviterDocs) == 0 {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Inviting user not found"})
+	inviterID := util.GetStringValue(data, "partnerUID")
+	if inviterID == "" {
+		apierr.Abort(c, apierr.Internal("Inviting user not found"))
 		return
 	}
-	inviterID := inviterDocs[0].Ref.ID
 
 	// using transaction to update both connection documents atomically
+	// the state of both invitation documents and both users' active connections
+	// is re-read inside the transaction so two concurrent accepts can't both win
 	now := time.Now()
-	err = fsClient.RunTransaction(context.Background(), func(ctx context.Context, tx *firestore.Transaction) error {
+	inviterConnRef := fsClient.Collection("users").Doc(inviterID).Collection("connections").Doc(connID)
+	err = fsClient.RunTransaction(c.Request.Context(), func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := tx.Get(connRef)
+		if err != nil {
+			return errConnectionNotFound
+		}
+		if util.GetStringValue(snap.Data(), "status") != "pending" {
+			return errConnectionState
+		}
+		if pendingExpired(snap.Data()) {
+			return errInviteExpired
+		}
+
+		inviterSnap, err := tx.Get(inviterConnRef)
+		if err != nil || util.GetStringValue(inviterSnap.Data(), "status") != "pending" {
+			return errConnectionState
+		}
+
+		for _, id := range []string{uid, inviterID} {
+			active, err := tx.Documents(fsClient.Collection("users").Doc(id).Collection("connections").
+				Where("status", "==", "active").Limit(1)).GetAll()
+			if err != nil {
+				return err
+			}
+			if len(active) > 0 {
+				return errAlreadyConnected
+			}
+		}
+
 		// update the current user's connection document
 		if err := tx.Update(connRef, []firestore.Update{
 			{Path: "status", Value: "active"},
@@ -251,125 +376,502 @@ func AcceptInvitation(c *gin.Context) {
 		}
 
 		// update the inviter's connection document
-		inviterConnRef := fsClient.Collection("users").Doc(inviterID).Collection("connections").Doc(connID)
 		return tx.Update(inviterConnRef, []firestore.Update{
 			{Path: "status", Value: "active"},
 			{Path: "updatedAt", Value: now},
-			{Path: "partnerUID", Value: uid.(string)},
+			{Path: "partnerUID", Value: uid},
 		})
 	})
 
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to accept invitation"})
+	switch {
+	case err == nil:
+	case errors.Is(err, errAlreadyConnected):
+		apierr.Abort(c, apierr.Conflict("You or your partner already have an active connection"))
+		return
+	case errors.Is(err, errInviteExpired):
+		endConnection(c.Request.Context(), fsClient, uid, connID, "pending", "", connectionExpired)
+		apierr.Abort(c, apierr.Gone("Invitation expired"))
+		return
+	case errors.Is(err, errConnectionNotFound), errors.Is(err, errConnectionState):
+		apierr.Abort(c, apierr.Conflict("Invitation is no longer pending"))
+		return
+	default:
+		apierr.Abort(c, apierr.Internal("Failed to accept invitation").WithCause(err))
 		return
 	}
 
 	// give access to each others events
-	// = add the userEmail to the connectedUsers array in each others events
-	// inviters events
-	it1, _ := fsClient.Collection("ddays").Where("createdBy", "==", inviterEmail).Documents(context.Background()).GetAll()
-	for _, doc := range it1 {
-		d := doc.Data()
-		users := util.ToStringSlice(d["connectedUsers"])
-		if !util.Contains(users, userEmail) {
-			users = append(users, userEmail)
-			fsClient.Collection("ddays").Doc(doc.Ref.ID).Update(context.Background(), []firestore.Update{{Path: "connectedUsers", Value: users}})
+	shareEvents(c.Request.Context(), fsClient, inviterID, uid)
+
+	publish(c, events.ConnectionAccepted, events.Change{ID: connID, UserID: uid}, uid, inviterID)
+	auditConnection(c, fsClient, audit.ConnectionAccept, connID, "pending", "active", inviterID)
+	c.JSON(http.StatusOK, gin.H{"message": "Invitation accepted"})
+}
+
+// shareEvents gives both users access to each others events
+// = add each user to the sharedWith array of the other's events.
+// connectedUsers keeps the matching emails for display only.
+func shareEvents(ctx context.Context, fsClient *firestore.Client, uidA, uidB string) {
+	updateEventSharing(ctx, fsClient, uidA, uidB, true)
+}
+
+// unshareEvents removes both users from each others events
+func unshareEvents(ctx context.Context, fsClient *firestore.Client, uidA, uidB string) {
+	updateEventSharing(ctx, fsClient, uidA, uidB, false)
+}
+
+func updateEventSharing(ctx context.Context, fsClient *firestore.Client, uidA, uidB string, share bool) {
+	op := func(v interface{}) interface{} {
+		if share {
+			return firestore.ArrayUnion(v)
 		}
+		return firestore.ArrayRemove(v)
 	}
 
-	// invitees events
-	it2, _ := fsClient.Collection("ddays").Where("createdBy", "==", userEmail).Documents(context.Background()).GetAll()
-	for _, doc := range it2 {
-		d := doc.Data()
-		users := util.ToStringSlice(d["connectedUsers"])
-		if !util.Contains(users, inviterEmail) {
-			users = append(users, inviterEmail)
-			fsClient.Collection("ddays").Doc(doc.Ref.ID).Update(context.Background(), []firestore.Update{{Path: "connectedUsers", Value: users}})
+	emails := map[string]string{}
+	for _, id := range []string{uidA, uidB} {
+		if doc, err := fsClient.Collection("users").Doc(id).Get(ctx); err == nil {
+			emails[id] = util.GetStringValue(doc.Data(), "email")
 		}
 	}
-	c.JSON(http.StatusOK, gin.H{"message": "Invitation accepted"})
+
+	apply := func(owner, target string) {
+		docs, _ := fsClient.Collection("ddays").Where("ownerUID", "==", owner).Documents(ctx).GetAll()
+		for _, doc := range docs {
+			updates := []firestore.Update{{Path: "sharedWith", Value: op(target)}}
+			if emails[target] != "" {
+				updates = append(updates, firestore.Update{Path: "connectedUsers", Value: op(emails[target])})
+			}
+			doc.Ref.Update(ctx, updates)
+		}
+	}
+	apply(uidA, uidB)
+	apply(uidB, uidA)
 }
 
-// reject/remote the invitation
-// delete the connection document
-// and remove access from each others events
-func RejectInvitation(c *gin.Context) {
-	session := sessions.Default(c)
-	uid := session.Get("user_id")
-	if uid == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+// connection lifecycle
+// pending -> active -> unlinked
+// pending -> cancelled (by initiator) | declined (by receiver)
+// ended connections are kept as history instead of being deleted
+const (
+	connectionCancelled = "cancelled"
+	connectionDeclined  = "declined"
+	connectionUnlinked  = "unlinked"
+	connectionExpired   = "expired"
+)
+
+// pending invitations that are not answered within this window expire
+const pendingInvitationTTL = 14 * 24 * time.Hour
+
+// pendingExpired reports whether a pending connection is past its expiry
+// older documents without expiresAt fall back to createdAt + TTL
+func pendingExpired(data map[string]interface{}) bool {
+	if exp, ok := data["expiresAt"].(time.Time); ok {
+		return time.Now().After(exp)
+	}
+	if created, ok := data["createdAt"].(time.Time); ok {
+		return time.Now().After(created.Add(pendingInvitationTTL))
+	}
+	return false
+}
+
+// what happens to shared ddays when an active connection is unlinked
+const (
+	ddayRetentionRemove = "remove" // stop sharing events in both directions
+	ddayRetentionKeep   = "keep"   // both users keep seeing what was shared
+)
+
+var (
+	errConnectionNotFound = errors.New("connection not found")
+	errConnectionState    = errors.New("connection is not in a state that allows this")
+	errConnectionRole     = errors.New("not authorized")
+)
+
+type ConnectionHistoryEntry struct {
+	ID           string    `json:"id"`
+	PartnerEmail string    `json:"partnerEmail"`
+	Role         string    `json:"role"`
+	Status       string    `json:"status"`
+	EndedBy      string    `json:"endedBy,omitempty"`
+	CreatedAt    time.Time `json:"createdAt"`
+	EndedAt      time.Time `json:"endedAt"`
+}
+
+type InviteRequest struct {
+	Email string `json:"email" binding:"required,email,max=254"`
+}
+
+type UnlinkRequest struct {
+	// keep | remove, defaults to remove
+	DDays string `json:"ddays" binding:"omitempty,oneof=keep remove"`
+}
+
+type BlockRequest struct {
+	Email string `json:"email" binding:"required,email,max=254"`
+}
+
+type BlockedUser struct {
+	ID        string    `json:"id"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// endConnection moves a connection into one of the archived states on both sides.
+// allowedFrom is the status the connection must currently have and role,
+// when not empty, is the role the current user must have on it. both are
+// checked inside the transaction, so a concurrent change of the
+// connection is never overwritten.
+func endConnection(ctx context.Context, fsClient *firestore.Client, uid, connID, allowedFrom, role, newStatus string) (map[string]interface{}, error) {
+	connRef := fsClient.Collection("users").Doc(uid).Collection("connections").Doc(connID)
+
+	now := time.Now()
+	updates := []firestore.Update{
+		{Path: "status", Value: newStatus},
+		{Path: "endedAt", Value: now},
+		{Path: "updatedAt", Value: now},
+	}
+	// expiry is not something either user did
+	if newStatus != connectionExpired {
+		updates = append(updates, firestore.Update{Path: "endedBy", Value: uid})
+	}
+
+	var data map[string]interface{}
+	err := fsClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		connSnap, err := tx.Get(connRef)
+		if err != nil || !connSnap.Exists() {
+			return errConnectionNotFound
+		}
+		data = connSnap.Data()
+		if util.GetStringValue(data, "status") != allowedFrom {
+			return errConnectionState
+		}
+		if role != "" && util.GetStringValue(data, "role") != role {
+			return errConnectionRole
+		}
+
+		var partnerConnRef *firestore.DocumentRef
+		if partnerID := util.GetStringValue(data, "partnerUID"); partnerID != "" {
+			partnerConnRef = fsClient.Collection("users").Doc(partnerID).Collection("connections").Doc(connID)
+			// partner side may be missing if their account was deleted
+			if _, err := tx.Get(partnerConnRef); err != nil {
+				partnerConnRef = nil
+			}
+		}
+		if err := tx.Update(connRef, updates); err != nil {
+			return err
+		}
+		if partnerConnRef != nil {
+			return tx.Update(partnerConnRef, updates)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func respondConnectionError(c *gin.Context, err error, fallback string) {
+	switch {
+	case errors.Is(err, errConnectionNotFound):
+		apierr.Abort(c, apierr.NotFound("Connection not found"))
+	case errors.Is(err, errConnectionRole):
+		apierr.Abort(c, apierr.Forbidden("Not authorized"))
+	case errors.Is(err, errConnectionState):
+		apierr.Abort(c, apierr.Conflict("Connection cannot be changed in its current state"))
+	default:
+		apierr.Abort(c, apierr.Internal(fallback).WithCause(err))
+	}
+}
+
+// cancel an invitation the current user has sent
+func CancelInvitation(c *gin.Context) {
+	uid := currentUID(c)
+	if uid == "" {
+		apierr.Abort(c, apierr.Unauthorized("Unauthorized"))
+		return
+	}
+	fsClient := c.MustGet("firestore").(*firestore.Client)
+
+	data, err := endConnection(c.Request.Context(), fsClient, uid, c.Param("id"), "pending", "initiator", connectionCancelled)
+	if err != nil {
+		respondConnectionError(c, err, "Failed to cancel invitation")
+		return
+	}
+	publish(c, events.ConnectionCancelled, events.Change{ID: c.Param("id"), UserID: uid}, uid, util.GetStringValue(data, "partnerUID"))
+	auditConnection(c, fsClient, audit.ConnectionCancel, c.Param("id"), "pending", connectionCancelled, util.GetStringValue(data, "partnerUID"))
+	c.JSON(http.StatusOK, gin.H{"message": "Invitation cancelled"})
+}
+
+// decline an invitation the current user has received
+func DeclineInvitation(c *gin.Context) {
+	uid := currentUID(c)
+	if uid == "" {
+		apierr.Abort(c, apierr.Unauthorized("Unauthorized"))
+		return
+	}
+	fsClient := c.MustGet("firestore").(*firestore.Client)
+
+	data, err := endConnection(c.Request.Context(), fsClient, uid, c.Param("id"), "pending", "receiver", connectionDeclined)
+	if err != nil {
+		respondConnectionError(c, err, "Failed to decline invitation")
+		return
+	}
+	publish(c, events.ConnectionDeclined, events.Change{ID: c.Param("id"), UserID: uid}, uid, util.GetStringValue(data, "partnerUID"))
+	auditConnection(c, fsClient, audit.ConnectionDecline, c.Param("id"), "pending", connectionDeclined, util.GetStringValue(data, "partnerUID"))
+	c.JSON(http.StatusOK, gin.H{"message": "Invitation declined"})
+}
+
+// unlink an active partner
+// shared ddays are unshared unless the request asks to keep them
+func UnlinkConnection(c *gin.Context) {
+	uid := currentUID(c)
+	if uid == "" {
+		apierr.Abort(c, apierr.Unauthorized("Unauthorized"))
 		return
 	}
+
+	req := UnlinkRequest{DDays: ddayRetentionRemove}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apierr.Abort(c, apierr.FromBinding(err))
+			return
+		}
+	}
+	if req.DDays == "" {
+		req.DDays = ddayRetentionRemove
+	}
+
 	fsClient := c.MustGet("firestore").(*firestore.Client)
-	userDoc, _ := fsClient.Collection("users").Doc(uid.(string)).Get(context.Background())
-	userEmail := userDoc.Data()["email"].(string)
-	if userEmail == "" {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+	partnerUID, err := unlinkConnection(c.Request.Context(), fsClient, uid, c.Param("id"), req.DDays)
+	if err != nil {
+		respondConnectionError(c, err, "Failed to remove connection")
 		return
 	}
+	publish(c, events.ConnectionUnlinked, events.Change{ID: c.Param("id"), UserID: uid}, uid, partnerUID)
+	auditConnection(c, fsClient, audit.ConnectionUnlink, c.Param("id"), "active", connectionUnlinked, partnerUID)
+	c.JSON(http.StatusOK, gin.H{"message": "Connection removed"})
+}
+
+// unlinkConnection ends the active connection and returns the partner
+func unlinkConnection(ctx context.Context, fsClient *firestore.Client, uid, connID, retention string) (string, error) {
+	data, err := endConnection(ctx, fsClient, uid, connID, "active", "", connectionUnlinked)
+	if err != nil {
+		return "", err
+	}
+
+	partnerUID := util.GetStringValue(data, "partnerUID")
+	if retention == ddayRetentionRemove && partnerUID != "" {
+		unshareEvents(ctx, fsClient, uid, partnerUID)
+	}
+	return partnerUID, nil
+}
+
+// reject/remove the invitation
+// kept for older clients, dispatches to cancel, decline or unlink
+// depending on the connection's state and the user's role
+func RejectInvitation(c *gin.Context) {
+	uid := currentUID(c)
+	if uid == "" {
+		apierr.Abort(c, apierr.Unauthorized("Unauthorized"))
+		return
+	}
+	fsClient := c.MustGet("firestore").(*firestore.Client)
 
 	connID := c.Param("id")
-	// get connection from the current user's subcollection
-	connRef := fsClient.Collection("users").Doc(uid.(string)).Collection("connections").Doc(connID)
-	connSnap, err := connRef.Get(context.Background())
+	connSnap, err := fsClient.Collection("users").Doc(uid).Collection("connections").Doc(connID).Get(c.Request.Context())
 	if err != nil || !connSnap.Exists() {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Invitation not found"})
+		apierr.Abort(c, apierr.NotFound("Invitation not found"))
 		return
 	}
 	data := connSnap.Data()
-	partnerEmail := data["partnerEmail"].(string)
 
-	var user1, user2 string
-	if data["role"].(string) == "initiator" {
-		user1 = userEmail
-		user2 = partnerEmail
-	} else {
-		user1 = partnerEmail
-		user2 = userEmail
+	switch util.GetStringValue(data, "status") {
+	case "active":
+		UnlinkConnection(c)
+	case "pending":
+		if util.GetStringValue(data, "role") == "initiator" {
+			CancelInvitation(c)
+		} else {
+			DeclineInvitation(c)
+		}
+	default:
+		apierr.Abort(c, apierr.Conflict("Connection has already ended"))
 	}
+}
 
-	// remove access from each others events
-	// remove userEmail from connectedUsers array in each other's events
-	removeFromEvents := func(owner, target string) {
-		docs, _ := fsClient.Collection("ddays").Where("createdBy", "==", owner).Documents(context.Background()).GetAll()
-		for _, doc := range docs {
-			d := doc.Data()
-			users := util.ToStringSlice(d["connectedUsers"])
-			if util.Contains(users, target) {
-				users = util.Remove(users, target)
-				fsClient.Collection("ddays").Doc(doc.Ref.ID).Update(context.Background(), []firestore.Update{{Path: "connectedUsers", Value: users}})
+// list past connections of the current user
+func GetConnectionHistory(c *gin.Context) {
+	uid := currentUID(c)
+	if uid == "" {
+		apierr.Abort(c, apierr.Unauthorized("Unauthorized"))
+		return
+	}
+	fsClient := c.MustGet("firestore").(*firestore.Client)
+
+	docs, err := fsClient.Collection("users").Doc(uid).Collection("connections").
+		Where("status", "in", []string{connectionCancelled, connectionDeclined, connectionUnlinked, connectionExpired}).
+		Documents(c.Request.Context()).GetAll()
+	if err != nil {
+		apierr.Abort(c, apierr.Internal("Failed to fetch connection history").WithCause(err))
+		return
+	}
+
+	history := []ConnectionHistoryEntry{}
+	for _, doc := range docs {
+		data := doc.Data()
+		entry := ConnectionHistoryEntry{
+			ID:           doc.Ref.ID,
+			PartnerEmail: util.GetStringValue(data, "partnerEmail"),
+			Role:         util.GetStringValue(data, "role"),
+			Status:       util.GetStringValue(data, "status"),
+		}
+		// invitations the user sent are history only once they were
+		// accepted, before that they exist only when the invited address
+		// has an account
+		if entry.Role == "initiator" && entry.Status != connectionUnlinked {
+			continue
+		}
+		if endedBy := util.GetStringValue(data, "endedBy"); endedBy != "" {
+			if endedBy == uid {
+				entry.EndedBy = "me"
+			} else {
+				entry.EndedBy = "partner"
 			}
 		}
+		if t, ok := data["createdAt"].(time.Time); ok {
+			entry.CreatedAt = t
+		}
+		if t, ok := data["endedAt"].(time.Time); ok {
+			entry.EndedAt = t
+		}
+		history = append(history, entry)
 	}
-	removeFromEvents(user1, user2)
-	removeFromEvents(user2, user1)
 
-	// find partner's user document to get ID
-	partnerDocs, _ := fsClient.Collection("users").Where("email", "==", partnerEmail).Documents(context.Background()).GetAll()
-	if len(partnerDocs) == 0 {
-		// Partner not found, just delete current user's doc
-		connRef.Delete(context.Background())
-		c.JSON(http.StatusOK, gin.H{"message": "Connection removed"})
+	sort.Slice(history, func(i, j int) bool { return history[i].EndedAt.After(history[j].EndedAt) })
+	c.JSON(http.StatusOK, gin.H{"history": history})
+}
+
+// blockedRef is where uid's block of email is kept, keyed by the hash of
+// the normalized email so any spelling of the address matches
+func blockedRef(fsClient *firestore.Client, uid, email string) *firestore.DocumentRef {
+	return fsClient.Collection("users").Doc(uid).Collection("blocked").Doc(util.HashToken(util.NormalizeEmail(email)))
+}
+
+// isBlocked reports whether uid has blocked invitations from email
+func isBlocked(ctx context.Context, fsClient *firestore.Client, uid, email string) bool {
+	snap, err := blockedRef(fsClient, uid, email).Get(ctx)
+	return err == nil && snap.Exists()
+}
+
+// block an email from inviting the current user
+// pending invitations from that email are declined
+func BlockUser(c *gin.Context) {
+	uid := currentUID(c)
+	if uid == "" {
+		apierr.Abort(c, apierr.Unauthorized("Unauthorized"))
 		return
 	}
-	partnerID := partnerDocs[0].Ref.ID
 
-	// delete the connection document from both users' subcollections atomically
-	err = fsClient.RunTransaction(context.Background(), func(ctx context.Context, tx *firestore.Transaction) error {
-		// current user's connection doc
-		if err := tx.Delete(connRef); err != nil {
-			return err
+	var body BlockRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		apierr.Abort(c, apierr.FromBinding(err))
+		return
+	}
+	email := util.NormalizeEmail(body.Email)
+
+	fsClient := c.MustGet("firestore").(*firestore.Client)
+	ctx := c.Request.Context()
+
+	now := time.Now()
+	ref := blockedRef(fsClient, uid, email)
+	if _, err := ref.Set(ctx, map[string]interface{}{
+		"email":     email,
+		"createdAt": now,
+	}); err != nil {
+		apierr.Abort(c, apierr.Internal("Failed to block user").WithCause(err))
+		return
+	}
+
+	pending, err := fsClient.Collection("users").Doc(uid).Collection("connections").
+		Where("partnerEmail", "==", email).
+		Where("status", "==", "pending").
+		Documents(ctx).GetAll()
+	if err != nil {
+		apierr.Abort(c, apierr.Internal("Failed to end pending invitations").WithCause(err))
+		return
+	}
+	for _, doc := range pending {
+		role := util.GetStringValue(doc.Data(), "role")
+		status := connectionDeclined
+		if role == "initiator" {
+			status = connectionCancelled
+		}
+		data, err := endConnection(ctx, fsClient, uid, doc.Ref.ID, "pending", role, status)
+		// it was answered or cancelled in the meantime
+		if errors.Is(err, errConnectionState) || errors.Is(err, errConnectionNotFound) {
+			continue
+		}
+		if err != nil {
+			respondConnectionError(c, err, "Failed to end pending invitations")
+			return
 		}
+		t, action := events.ConnectionDeclined, audit.ConnectionDecline
+		if status == connectionCancelled {
+			t, action = events.ConnectionCancelled, audit.ConnectionCancel
+		}
+		partnerUID := util.GetStringValue(data, "partnerUID")
+		publish(c, t, events.Change{ID: doc.Ref.ID, UserID: uid}, uid, partnerUID)
+		auditConnection(c, fsClient, action, doc.Ref.ID, "pending", status, partnerUID)
+	}
 
-		// partner's connection doc
-		partnerConnRef := fsClient.Collection("users").Doc(partnerID).Collection("connections").Doc(connID)
-		return tx.Delete(partnerConnRef)
-	})
+	c.JSON(http.StatusOK, gin.H{"blocked": BlockedUser{ID: ref.ID, Email: email, CreatedAt: now}})
+}
 
+// list emails blocked by the current user
+func GetBlockedUsers(c *gin.Context) {
+	uid := currentUID(c)
+	if uid == "" {
+		apierr.Abort(c, apierr.Unauthorized("Unauthorized"))
+		return
+	}
+	fsClient := c.MustGet("firestore").(*firestore.Client)
+
+	docs, err := fsClient.Collection("users").Doc(uid).Collection("blocked").Documents(c.Request.Context()).GetAll()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove connection"})
+		apierr.Abort(c, apierr.Internal("Failed to fetch blocked users").WithCause(err))
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Connection removed"})
+	blocked := []BlockedUser{}
+	for _, doc := range docs {
+		data := doc.Data()
+		entry := BlockedUser{ID: doc.Ref.ID, Email: util.GetStringValue(data, "email")}
+		if t, ok := data["createdAt"].(time.Time); ok {
+			entry.CreatedAt = t
+		}
+		blocked = append(blocked, entry)
+	}
+	c.JSON(http.StatusOK, gin.H{"blocked": blocked})
+}
+
+// unblock a previously blocked email
+func UnblockUser(c *gin.Context) {
+	uid := currentUID(c)
+	if uid == "" {
+		apierr.Abort(c, apierr.Unauthorized("Unauthorized"))
+		return
+	}
+	fsClient := c.MustGet("firestore").(*firestore.Client)
+
+	_, err := fsClient.Collection("users").Doc(uid).Collection("blocked").Doc(c.Param("id")).Delete(c.Request.Context(), firestore.Exists)
+	if status.Code(err) == codes.NotFound {
+		apierr.Abort(c, apierr.NotFound("Blocked user not found"))
+		return
+	}
+	if err != nil {
+		apierr.Abort(c, apierr.Internal("Failed to unblock user").WithCause(err))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "User unblocked"})
 }
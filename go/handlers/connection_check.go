@@ -0,0 +1,285 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/firestore"
+
+	"calple/util"
+)
+
+// kinds of problems the connection checker looks for
+const (
+	IssueHalfWritten     = "half_written"      // only one side of the pair exists
+	IssueStatusMismatch  = "status_mismatch"   // the two sides disagree on status
+	IssueDuplicateActive = "duplicate_active"  // a user has more than one active partner
+	IssueDuplicatePair   = "duplicate_pending" // the same two users have several open invitations
+	IssueMissingUID      = "missing_partner_uid"
+	IssueExpiredPending  = "expired_pending" // a pending invitation is past its expiry
+)
+
+type ConnectionIssue struct {
+	Kind         string   `json:"kind"`
+	ConnectionID string   `json:"connectionId"`
+	Users        []string `json:"users"`
+	Detail       string   `json:"detail"`
+	Repaired     bool     `json:"repaired"`
+}
+
+type ConnectionReport struct {
+	Connections int               `json:"connections"`
+	Issues      []ConnectionIssue `json:"issues"`
+}
+
+// one side of a connection pair, users/{owner}/connections/{id}
+type connectionSide struct {
+	owner string
+	ref   *firestore.DocumentRef
+	data  map[string]interface{}
+}
+
+func (s connectionSide) status() string { return util.GetStringValue(s.data, "status") }
+
+func (s connectionSide) createdAt() time.Time {
+	t, _ := s.data["createdAt"].(time.Time)
+	return t
+}
+
+// CheckConnections scans every connection document and reports pairs that
+// are inconsistent. when repair is true the problems are fixed in place:
+// open half-written or mismatched pairs are ended, pending invitations past
+// their expiry expire, duplicate active partnerships keep the oldest ones
+// and missing partnerUIDs are filled in. every check sees the state the
+// earlier ones left, without repair the report is what a repair would do.
+func CheckConnections(ctx context.Context, fsClient *firestore.Client, repair bool) (*ConnectionReport, error) {
+	users, err := fsClient.Collection("users").Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("list users: %w", err)
+	}
+	uidByEmail := map[string]string{}
+	for _, u := range users {
+		if email := util.GetStringValue(u.Data(), "email"); email != "" {
+			uidByEmail[email] = u.Ref.ID
+		}
+	}
+
+	docs, err := fsClient.CollectionGroup("connections").Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("list connections: %w", err)
+	}
+
+	pairs := map[string][]connectionSide{}
+	for _, doc := range docs {
+		side := connectionSide{owner: doc.Ref.Parent.Parent.ID, ref: doc.Ref, data: doc.Data()}
+		pairs[doc.Ref.ID] = append(pairs[doc.Ref.ID], side)
+	}
+
+	report := &ConnectionReport{Connections: len(pairs), Issues: []ConnectionIssue{}}
+	add := func(issue ConnectionIssue, fix func() error) {
+		issue.Repaired = fix() == nil && repair
+		report.Issues = append(report.Issues, issue)
+	}
+
+	// set writes the updates when repairing and applies them to the data
+	// the following checks read either way
+	now := time.Now()
+	set := func(s connectionSide, updates []firestore.Update) error {
+		if repair {
+			if _, err := s.ref.Update(ctx, updates); err != nil {
+				return err
+			}
+		}
+		for _, u := range updates {
+			s.data[u.Path] = u.Value
+		}
+		return nil
+	}
+	end := func(s connectionSide, status, reason string) error {
+		return set(s, []firestore.Update{
+			{Path: "status", Value: status},
+			{Path: "endedAt", Value: now},
+			{Path: "endReason", Value: reason},
+			{Path: "updatedAt", Value: now},
+		})
+	}
+	endPair := func(id, status, reason string) func() error {
+		return func() error {
+			for _, s := range pairs[id] {
+				if err := end(s, status, reason); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+	isOpen := func(status string) bool { return status == "pending" || status == "active" }
+
+	ids := make([]string, 0, len(pairs))
+	for id := range pairs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	// pass 1: each pair on its own
+	for _, id := range ids {
+		sides := pairs[id]
+
+		if len(sides) == 1 {
+			side := sides[0]
+			if !isOpen(side.status()) {
+				continue
+			}
+			add(ConnectionIssue{
+				Kind:         IssueHalfWritten,
+				ConnectionID: id,
+				Users:        []string{side.owner},
+				Detail:       fmt.Sprintf("%s connection has no partner side", side.status()),
+			}, func() error {
+				return end(side, connectionUnlinked, "repair_half_written")
+			})
+			continue
+		}
+
+		a, b := sides[0], sides[1]
+		if a.status() != b.status() {
+			add(ConnectionIssue{
+				Kind:         IssueStatusMismatch,
+				ConnectionID: id,
+				Users:        []string{a.owner, b.owner},
+				Detail:       fmt.Sprintf("%s vs %s", a.status(), b.status()),
+			}, func() error {
+				// an ended side wins, otherwise fall back to pending so the receiver can accept again
+				target := "pending"
+				for _, s := range sides {
+					if !isOpen(s.status()) {
+						target = s.status()
+					}
+				}
+				for _, s := range sides {
+					if s.status() == target {
+						continue
+					}
+					var err error
+					if isOpen(target) {
+						err = set(s, []firestore.Update{{Path: "status", Value: target}, {Path: "updatedAt", Value: now}})
+					} else {
+						err = end(s, target, "repair_status_mismatch")
+					}
+					if err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+		}
+
+		// invitations are only expired when they are read, ones nobody
+		// looks at again stay pending until the checker ends them
+		if a.status() == "pending" && b.status() == "pending" && (pendingExpired(a.data) || pendingExpired(b.data)) {
+			add(ConnectionIssue{
+				Kind:         IssueExpiredPending,
+				ConnectionID: id,
+				Users:        []string{a.owner, b.owner},
+				Detail:       "pending invitation is past its expiry",
+			}, endPair(id, connectionExpired, "expired"))
+		}
+
+		if a.status() == "active" && b.status() == "active" {
+			for _, pair := range [][2]connectionSide{{a, b}, {b, a}} {
+				side, other := pair[0], pair[1]
+				if util.GetStringValue(side.data, "partnerUID") != "" {
+					continue
+				}
+				add(ConnectionIssue{
+					Kind:         IssueMissingUID,
+					ConnectionID: id,
+					Users:        []string{side.owner},
+					Detail:       "active connection without partnerUID",
+				}, func() error {
+					return set(side, []firestore.Update{{Path: "partnerUID", Value: other.owner}})
+				})
+			}
+		}
+	}
+
+	// pass 2: active partnerships across pairs. the oldest ones are kept
+	// first, a later one is ended when either user already has a partner,
+	// so a user only loses a partnership to an older one of their own or of
+	// their partner
+	active := []string{}
+	for _, id := range ids {
+		if sides := pairs[id]; len(sides) == 2 && sides[0].status() == "active" && sides[1].status() == "active" {
+			active = append(active, id)
+		}
+	}
+	sort.SliceStable(active, func(i, j int) bool {
+		return pairs[active[i]][0].createdAt().Before(pairs[active[j]][0].createdAt())
+	})
+	partnerOf := map[string]string{}
+	for _, id := range active {
+		a, b := pairs[id][0].owner, pairs[id][1].owner
+		if partnerOf[a] == "" && partnerOf[b] == "" {
+			partnerOf[a], partnerOf[b] = id, id
+			continue
+		}
+		kept := partnerOf[a]
+		if kept == "" {
+			kept = partnerOf[b]
+		}
+		add(ConnectionIssue{
+			Kind:         IssueDuplicateActive,
+			ConnectionID: id,
+			Users:        []string{a, b},
+			Detail:       fmt.Sprintf("a user already has the older active connection %s", kept),
+		}, endPair(id, connectionUnlinked, "repair_duplicate_active"))
+	}
+
+	// pass 3: open invitations between the same two users
+	pendingByPair := map[string][]connectionSide{}
+	for _, id := range ids {
+		for _, side := range pairs[id] {
+			if side.status() != "pending" {
+				continue
+			}
+			partner := util.GetStringValue(side.data, "partnerUID")
+			if partner == "" {
+				partner = uidByEmail[util.GetStringValue(side.data, "partnerEmail")]
+			}
+			key := side.owner + "|" + partner
+			pendingByPair[key] = append(pendingByPair[key], side)
+		}
+	}
+	keys := make([]string, 0, len(pendingByPair))
+	for key := range pendingByPair {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	handled := map[string]bool{}
+	for _, key := range keys {
+		sides := pendingByPair[key]
+		if len(sides) < 2 {
+			continue
+		}
+		// keep the newest invitation between the same two users
+		sort.SliceStable(sides, func(i, j int) bool { return sides[i].createdAt().After(sides[j].createdAt()) })
+		for _, extra := range sides[1:] {
+			id := extra.ref.ID
+			if handled[id] {
+				continue
+			}
+			handled[id] = true
+			add(ConnectionIssue{
+				Kind:         IssueDuplicatePair,
+				ConnectionID: id,
+				Users:        []string{extra.owner},
+				Detail:       fmt.Sprintf("%d open invitations for %s, keeping %s", len(sides), key, sides[0].ref.ID),
+			}, endPair(id, connectionCancelled, "repair_duplicate_pending"))
+		}
+	}
+
+	return report, nil
+}
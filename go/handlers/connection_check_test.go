@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckConnections(t *testing.T) {
+	fsClient := testFirestore(t)
+	ctx := context.Background()
+	base := time.Now().Add(-24 * time.Hour)
+
+	// pair writes both sides of a connection, status of the second side
+	// when given
+	pair := func(id, a, b string, created time.Time, status string, statusB ...string) {
+		t.Helper()
+		for i, owner := range []string{a, b} {
+			partner, role, st := b, "initiator", status
+			if i == 1 {
+				partner, role = a, "receiver"
+				if len(statusB) > 0 {
+					st = statusB[0]
+				}
+			}
+			data := map[string]interface{}{
+				"partnerUID": partner,
+				"role":       role,
+				"status":     st,
+				"createdAt":  created,
+				"expiresAt":  created.Add(pendingInvitationTTL),
+			}
+			if _, err := fsClient.Collection("users").Doc(owner).Collection("connections").Doc(id).Set(ctx, data); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	// c keeps the oldest partnership, a loses a-c to it and keeps a-b
+	pair("cd", "c", "d", base, "active")
+	pair("ac", "a", "c", base.Add(time.Hour), "active")
+	pair("ab", "a", "b", base.Add(2*time.Hour), "active")
+	// e-f is ended by the mismatch repair, so e-g is e's only partnership
+	pair("ef", "e", "f", base, "active", connectionUnlinked)
+	pair("eg", "e", "g", base.Add(time.Hour), "active")
+	// nobody read the invitation until it expired
+	pair("hi", "h", "i", base.Add(-pendingInvitationTTL), "pending")
+
+	statuses := func() map[string]string {
+		t.Helper()
+		docs, err := fsClient.CollectionGroup("connections").Documents(ctx).GetAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := map[string]string{}
+		for _, doc := range docs {
+			out[doc.Ref.Parent.Parent.ID+"/"+doc.Ref.ID] = doc.Data()["status"].(string)
+		}
+		return out
+	}
+	issues := func(report *ConnectionReport) []string {
+		out := []string{}
+		for _, issue := range report.Issues {
+			out = append(out, issue.Kind+" "+issue.ConnectionID)
+		}
+		sort.Strings(out)
+		return out
+	}
+	want := []string{
+		IssueDuplicateActive + " ac",
+		IssueExpiredPending + " hi",
+		IssueStatusMismatch + " ef",
+	}
+	sort.Strings(want)
+
+	before := statuses()
+	report, err := CheckConnections(ctx, fsClient, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := issues(report); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("issues %v, want %v", got, want)
+	}
+	for key, status := range statuses() {
+		if before[key] != status {
+			t.Errorf("the report changed %s to %s", key, status)
+		}
+	}
+
+	report, err = CheckConnections(ctx, fsClient, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := issues(report); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("repair issues %v, want %v", got, want)
+	}
+	for _, issue := range report.Issues {
+		if !issue.Repaired {
+			t.Errorf("not repaired: %+v", issue)
+		}
+	}
+	after := statuses()
+	for key, status := range map[string]string{
+		"c/cd": "active", "d/cd": "active",
+		"a/ac": connectionUnlinked, "c/ac": connectionUnlinked,
+		"a/ab": "active", "b/ab": "active",
+		"e/ef": connectionUnlinked, "f/ef": connectionUnlinked,
+		"e/eg": "active", "g/eg": "active",
+		"h/hi": connectionExpired, "i/hi": connectionExpired,
+	} {
+		if after[key] != status {
+			t.Errorf("%s is %s, want %s", key, after[key], status)
+		}
+	}
+
+	if report, err := CheckConnections(ctx, fsClient, false); err != nil || len(report.Issues) != 0 {
+		t.Errorf("after the repair: %+v, %v", report, err)
+	}
+}
@@ -0,0 +1,234 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// the inviter's pending list is the same whether or not the invited
+// address has an account, only the invited user sees the invitation
+func TestPendingInvitationsOnlyReceived(t *testing.T) {
+	fsClient := testFirestore(t)
+	ctx := context.Background()
+	for uid, email := range map[string]string{"ann": "ann@example.com", "bob": "bob@example.com"} {
+		if _, err := fsClient.Collection("users").Doc(uid).Set(ctx, map[string]interface{}{"email": email}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	router := testRouter(fsClient)
+	router.POST("/connections/invite", InviteConnection)
+	router.GET("/connections/invitations", GetPendingInvitations)
+
+	do := func(uid, method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(testUIDHeader, uid)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	pending := func(uid string) []Invitation {
+		t.Helper()
+		w := do(uid, http.MethodGet, "/connections/invitations", "")
+		var resp struct {
+			Invitations []Invitation `json:"invitations"`
+		}
+		if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &resp) != nil {
+			t.Fatalf("invitations of %s: %d %s", uid, w.Code, w.Body)
+		}
+		return resp.Invitations
+	}
+
+	for _, email := range []string{"bob@example.com", "nobody@example.com"} {
+		if w := do("ann", http.MethodPost, "/connections/invite", `{"email":"`+email+`"}`); w.Code != http.StatusOK {
+			t.Fatalf("invite %s: %d %s", email, w.Code, w.Body)
+		}
+	}
+	if got := pending("ann"); len(got) != 0 {
+		t.Errorf("inviter sees %+v", got)
+	}
+	got := pending("bob")
+	if len(got) != 1 || got[0].FromEmail != "ann@example.com" || got[0].Role != "receiver" {
+		t.Errorf("invited user sees %+v", got)
+	}
+}
+
+// the inviter's trail is the same whether or not the invited address has
+// an account
+func TestInviteAuditSameWithoutAccount(t *testing.T) {
+	fsClient := testFirestore(t)
+	ctx := context.Background()
+	users := fsClient.Collection("users")
+	for uid, email := range map[string]string{"ann": "ann@example.com", "cat": "cat@example.com"} {
+		if _, err := users.Doc(uid).Set(ctx, map[string]interface{}{"email": email}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	router := testRouter(fsClient)
+	router.POST("/connections/invite", InviteConnection)
+	router.GET("/audit", GetAuditLog)
+
+	do := func(uid, method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(testUIDHeader, uid)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	invite := func(uid string) {
+		t.Helper()
+		if w := do(uid, http.MethodPost, "/connections/invite", `{"email":"bob@example.com"}`); w.Code != http.StatusOK {
+			t.Fatalf("invite by %s: %d %s", uid, w.Code, w.Body)
+		}
+	}
+	// the trail without what differs between any two entries
+	trail := func(uid string) string {
+		t.Helper()
+		w := do(uid, http.MethodGet, "/audit", "")
+		var resp struct {
+			Entries []map[string]interface{} `json:"entries"`
+		}
+		if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &resp) != nil {
+			t.Fatalf("trail of %s: %d %s", uid, w.Code, w.Body)
+		}
+		for _, e := range resp.Entries {
+			for _, k := range []string{"id", "actorUid", "at"} {
+				delete(e, k)
+			}
+		}
+		out, _ := json.Marshal(resp.Entries)
+		return string(out)
+	}
+
+	invite("ann")
+	if _, err := users.Doc("bob").Set(ctx, map[string]interface{}{"email": "bob@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	invite("cat")
+
+	without, with := trail("ann"), trail("cat")
+	if without != with {
+		t.Errorf("trail without the account %s\nwith it %s", without, with)
+	}
+	if !strings.Contains(with, `"connection.invite"`) {
+		t.Errorf("the invitation is not in the trail: %s", with)
+	}
+	if got := trail("bob"); !strings.Contains(got, `"connection.invite"`) {
+		t.Errorf("the invited user's trail %s", got)
+	}
+}
+
+// invitations the user sent are left out of their history, the invited
+// user sees the ones they answered
+func TestHistoryLeavesOutSentInvitations(t *testing.T) {
+	fsClient := testFirestore(t)
+	ctx := context.Background()
+	for uid, email := range map[string]string{"ann": "ann@example.com", "bob": "bob@example.com"} {
+		if _, err := fsClient.Collection("users").Doc(uid).Set(ctx, map[string]interface{}{"email": email}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	router := testRouter(fsClient)
+	router.POST("/connections/invite", InviteConnection)
+	router.GET("/connections/invitations", GetPendingInvitations)
+	router.POST("/connections/:id/decline", DeclineInvitation)
+	router.GET("/connections/history", GetConnectionHistory)
+
+	do := func(uid, method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(testUIDHeader, uid)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	history := func(uid string) []ConnectionHistoryEntry {
+		t.Helper()
+		w := do(uid, http.MethodGet, "/connections/history", "")
+		var resp struct {
+			History []ConnectionHistoryEntry `json:"history"`
+		}
+		if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &resp) != nil {
+			t.Fatalf("history of %s: %d %s", uid, w.Code, w.Body)
+		}
+		return resp.History
+	}
+
+	for _, email := range []string{"bob@example.com", "nobody@example.com"} {
+		if w := do("ann", http.MethodPost, "/connections/invite", `{"email":"`+email+`"}`); w.Code != http.StatusOK {
+			t.Fatalf("invite %s: %d %s", email, w.Code, w.Body)
+		}
+	}
+	var pending struct {
+		Invitations []Invitation `json:"invitations"`
+	}
+	w := do("bob", http.MethodGet, "/connections/invitations", "")
+	if json.Unmarshal(w.Body.Bytes(), &pending) != nil || len(pending.Invitations) != 1 {
+		t.Fatalf("invitations of bob: %d %s", w.Code, w.Body)
+	}
+	if w := do("bob", http.MethodPost, "/connections/"+pending.Invitations[0].ID+"/decline", ""); w.Code != http.StatusOK {
+		t.Fatalf("decline: %d %s", w.Code, w.Body)
+	}
+
+	if got := history("ann"); len(got) != 0 {
+		t.Errorf("inviter's history %+v", got)
+	}
+	if got := history("bob"); len(got) != 1 || got[0].Status != connectionDeclined || got[0].EndedBy != "me" {
+		t.Errorf("invited user's history %+v", got)
+	}
+}
+
+// invitations at the same time create one pending connection, and the
+// inviter's own address is recognized however it is spelled
+func TestInviteOnce(t *testing.T) {
+	fsClient := testFirestore(t)
+	ctx := context.Background()
+	for uid, email := range map[string]string{"ann": "Ann@Example.com", "bob": "bob@example.com"} {
+		if _, err := fsClient.Collection("users").Doc(uid).Set(ctx, map[string]interface{}{"email": email}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	router := testRouter(fsClient)
+	router.POST("/connections/invite", InviteConnection)
+
+	invite := func(email string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/connections/invite", strings.NewReader(`{"email":"`+email+`"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(testUIDHeader, "ann")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := invite(" ann@example.COM"); w.Code != http.StatusBadRequest {
+		t.Errorf("self invite: %d %s", w.Code, w.Body)
+	}
+
+	var wg sync.WaitGroup
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if w := invite("bob@example.com"); w.Code != http.StatusOK {
+				t.Errorf("invite: %d %s", w.Code, w.Body)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, uid := range []string{"ann", "bob"} {
+		docs, err := fsClient.Collection("users").Doc(uid).Collection("connections").Where("status", "==", "pending").Documents(ctx).GetAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(docs) != 1 {
+			t.Errorf("%s has %d pending connections", uid, len(docs))
+		}
+	}
+}
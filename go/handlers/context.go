@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+
+	"calple/config"
+	"calple/logging"
+)
+
+// currentUID is the signed in user of the request, from a bearer token or
+// the session cookie. empty when the request is anonymous.
+func currentUID(c *gin.Context) string {
+	if uid := c.GetString("authUID"); uid != "" {
+		return uid
+	}
+	uid, _ := sessions.Default(c).Get("user_id").(string)
+	return uid
+}
+
+// requestLogger is the logger of the request, it carries the request ID
+func requestLogger(c *gin.Context) *slog.Logger {
+	return logging.FromContext(c.Request.Context())
+}
+
+// appConfig is the configuration loaded at startup
+func appConfig(c *gin.Context) *config.Config {
+	return c.MustGet("config").(*config.Config)
+}
+
+// RequestTimeout puts a deadline on the request context so storage calls
+// give up when the handler takes too long or the client goes away. the
+// routes in streams stay open as long as the client listens.
+func RequestTimeout(d time.Duration, streams ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if d <= 0 || slices.Contains(streams, c.FullPath()) {
+			c.Next()
+			return
+		}
+		ctx, cancel := context.WithTimeout(c.Request.Context(), d)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
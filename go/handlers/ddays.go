@@ -1,10 +1,8 @@
 package handlers
 
 import (
-	"context"
 	"fmt"
 	"net/http"
-	"os"
 	"strconv"
 	"time"
 
@@ -15,64 +13,104 @@ import (
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 
-	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
 
 	"github.com/google/uuid"
 
+	"calple/apierr"
+	"calple/audit"
+	"calple/events"
+	"calple/telemetry"
+	"calple/trash"
 	"calple/util"
 )
 
 type DDay struct {
 	ID             string    `json:"id"`
-	Title          string    `json:"title"`
-	Group          string    `json:"group"`
-	Description    string    `json:"description"`
-	Date           string    `json:"date,omitempty"`
-	EndDate        string    `json:"endDate,omitempty"`
-	ImageURL       string    `json:"imageUrl,omitempty"`
+	Title          string    `json:"title" binding:"required,notblank,max=100"`
+	Group          string    `json:"group" binding:"max=50"`
+	Description    string    `json:"description" binding:"max=2000"`
+	Date           string    `json:"date,omitempty" binding:"compactdate"`
+	EndDate        string    `json:"endDate,omitempty" binding:"compactdate"`
+	ImageURL       string    `json:"imageUrl,omitempty" binding:"max=500"`
 	IsAnnual       bool      `json:"isAnnual"`
-	CreatedBy      string    `json:"createdBy"`
-	ConnectedUsers []string  `json:"connectedUsers"`
+	CreatedBy      string    `json:"createdBy"`      // creator's email, for display
+	ConnectedUsers []string  `json:"connectedUsers"` // emails, for display
+	OwnerUID       string    `json:"ownerUid,omitempty"`
+	SharedWith     []string  `json:"sharedWith,omitempty"` // UIDs that can see the event
 	CreatedAt      time.Time `json:"createdAt"`
 	UpdatedAt      time.Time `json:"updatedAt"`
+	Version        int64     `json:"version"`            // bumped by every edit, the ETag of the event
 	Editable       bool      `json:"editable,omitempty"` // if the event can be edited by the user
 }
 
+// DDayUpdate is the body of UpdateDDay, only the fields that are present
+// change. sharing and ownership are not client editable.
+type DDayUpdate struct {
+	// Version the edit is based on, for clients that cannot send If-Match
+	Version     *int64  `json:"version" binding:"omitempty,min=0"`
+	Title       *string `json:"title" binding:"omitempty,notblank,max=100"`
+	Group       *string `json:"group" binding:"omitempty,max=50"`
+	Description *string `json:"description" binding:"omitempty,max=2000"`
+	Date        *string `json:"date" binding:"omitempty,compactdate"`
+	EndDate     *string `json:"endDate" binding:"omitempty,compactdate"`
+	ImageURL    *string `json:"imageUrl" binding:"omitempty,max=500"`
+	IsAnnual    *bool   `json:"isAnnual"`
+}
+
+func (u DDayUpdate) updates() []firestore.Update {
+	updates := []firestore.Update{}
+	fields := []struct {
+		path  string
+		value *string
+	}{
+		{"title", u.Title},
+		{"group", u.Group},
+		{"description", u.Description},
+		{"date", u.Date},
+		{"endDate", u.EndDate},
+		{"imageUrl", u.ImageURL},
+	}
+	for _, f := range fields {
+		if f.value != nil {
+			updates = append(updates, firestore.Update{Path: f.path, Value: *f.value})
+		}
+	}
+	if u.IsAnnual != nil {
+		updates = append(updates, firestore.Update{Path: "isAnnual", Value: *u.IsAnnual})
+	}
+	return updates
+}
+
 type UploadRequest struct {
-	FileSize int64 `json:"fileSize"`
+	FileSize int64 `json:"fileSize" binding:"required,min=1"`
+}
+
+// DDayQuery is the month GetDDays lists the events of, ex) "202507"
+type DDayQuery struct {
+	View string `form:"view" binding:"required,datetime=200601"`
 }
 
 // fetch all events for the current user
 func GetDDays(c *gin.Context) {
-	session := sessions.Default(c)
-	uid := session.Get("user_id")
-	if uid == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+	uid := currentUID(c)
+	if uid == "" {
+		apierr.Abort(c, apierr.Unauthorized("Unauthorized"))
 		return
 	}
 
 	// firestore client from context
 	fsClient := c.MustGet("firestore").(*firestore.Client)
 
-	// user email from firestore
-	userDoc, err := fsClient.Collection("users").Doc(uid.(string)).Get(context.Background())
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve user"})
-		return
-	}
-	userEmail := userDoc.Data()["email"].(string)
-
 	// parse view date from query params
-	viewDate := c.Query("view")
-	// ex) "202507"
-
-	if viewDate == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing view date parameter"})
+	var q DDayQuery
+	if err := c.ShouldBindQuery(&q); err != nil {
+		apierr.Abort(c, apierr.FromBinding(err))
 		return
 	}
+	viewDate := q.View
 
-	ctx := context.Background()
+	ctx := c.Request.Context()
 	events := []DDay{}
 	seen := make(map[string]bool)
 
@@ -90,55 +128,34 @@ func GetDDays(c *gin.Context) {
 	lastDayOfMonth := time.Date(year, time.Month(month)+1, 0, 0, 0, 0, 0, time.UTC).Day()
 	viewMonthEndStr := fmt.Sprintf("%s%02d", viewDate, lastDayOfMonth)
 
-	fmt.Printf("DEBUG: GetDDays - userEmail: %s, viewMonthStartStr: %s, viewMonthEndStr: %s\n", userEmail, viewMonthStartStr, viewMonthEndStr)
-
-	// debug: if user has any active connections
-	connectionDocs, err := fsClient.Collection("users").Doc(uid.(string)).Collection("connections").
-		Where("status", "==", "active").
-		Documents(ctx).GetAll()
-
-	if err == nil && len(connectionDocs) > 0 {
-		connectionData := connectionDocs[0].Data()
-		partnerEmail := connectionData["partnerEmail"].(string)
-		fmt.Printf("DEBUG: User %s has active connection with %s\n", userEmail, partnerEmail)
-	} else {
-		fmt.Printf("DEBUG: User %s has no active connections\n", userEmail)
-	}
+	log := requestLogger(c)
+	log.Debug("listing ddays", "from", viewMonthStartStr, "to", viewMonthEndStr)
 
 	queries := []firestore.Query{
 		// Q1: events created by the user that start before the end of the month
 		fsClient.Collection("ddays").
-			Where("createdBy", "==", userEmail).
+			Where("ownerUID", "==", uid).
 			Where("date", "<=", viewMonthEndStr),
 
-		// Q2: events the user is connected to that start before the end of the month
+		// Q2: events shared with the user that start before the end of the month
 		fsClient.Collection("ddays").
-			Where("connectedUsers", "array-contains", userEmail).
+			Where("sharedWith", "array-contains", uid).
 			Where("date", "<=", viewMonthEndStr),
 
 		// Q3: annual events created by the user
 		fsClient.Collection("ddays").
-			Where("createdBy", "==", userEmail).
+			Where("ownerUID", "==", uid).
 			Where("isAnnual", "==", true),
 	}
 
 	for i, q := range queries {
 		docs, err := q.Documents(ctx).GetAll()
 		if err != nil {
-			fmt.Printf("ERROR: Firestore query failed: %v\n", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch events from database."})
+			log.Error("dday query failed", "query", i+1, "error", err)
+			apierr.Abort(c, apierr.Internal("Failed to fetch events from database.").WithCause(err))
 			return // stop execution if a query fails
 		}
-		fmt.Printf("DEBUG: Query %d found %d documents\n", i+1, len(docs))
-
-		// debug: show details of each event found
-		for _, doc := range docs {
-			data := doc.Data()
-			title, _ := data["title"].(string)
-			createdBy, _ := data["createdBy"].(string)
-			connectedUsers := util.ToStringSlice(data["connectedUsers"])
-			fmt.Printf("DEBUG: Event '%s' - createdBy: %s, connectedUsers: %v\n", title, createdBy, connectedUsers)
-		}
+		log.Debug("dday query done", "query", i+1, "documents", len(docs))
 
 		for _, doc := range docs {
 			if seen[doc.Ref.ID] {
@@ -146,6 +163,9 @@ func GetDDays(c *gin.Context) {
 			}
 
 			data := doc.Data()
+			if trash.Trashed(data) {
+				continue
+			}
 			dateStr, _ := data["date"].(string)
 			endDateStr, _ := data["endDate"].(string)
 			if endDateStr == "" {
@@ -189,7 +209,8 @@ func GetDDays(c *gin.Context) {
 			}
 
 			connectedUsers := util.ToStringSlice(data["connectedUsers"])
-			fmt.Printf("DEBUG: Event '%s' - createdBy: %s, connectedUsers: %v\n", title, createdBy, connectedUsers)
+			ownerUID, _ := data["ownerUID"].(string)
+			sharedWith := util.ToStringSlice(data["sharedWith"])
 
 			editable := true
 			if val, ok := data["editable"]; ok {
@@ -209,8 +230,11 @@ func GetDDays(c *gin.Context) {
 				IsAnnual:       isAnnual,
 				CreatedBy:      createdBy,
 				ConnectedUsers: connectedUsers,
+				OwnerUID:       ownerUID,
+				SharedWith:     sharedWith,
 				CreatedAt:      createdAt,
 				UpdatedAt:      updatedAt,
+				Version:        util.GetInt64Value(data, "version"),
 				Editable:       editable,
 			})
 		}
@@ -224,10 +248,9 @@ func GetDDays(c *gin.Context) {
 
 // create new event
 func CreateDDay(c *gin.Context) {
-	session := sessions.Default(c)
-	uid := session.Get("user_id")
-	if uid == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+	uid := currentUID(c)
+	if uid == "" {
+		apierr.Abort(c, apierr.Unauthorized("Unauthorized"))
 		return
 	}
 
@@ -235,72 +258,36 @@ func CreateDDay(c *gin.Context) {
 	fsClient := c.MustGet("firestore").(*firestore.Client)
 
 	// get user email from firestore
-	userDoc, err := fsClient.Collection("users").Doc(uid.(string)).Get(context.Background())
+	userDoc, err := fsClient.Collection("users").Doc(uid).Get(c.Request.Context())
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve user"})
+		apierr.Abort(c, apierr.Internal("Failed to retrieve user").WithCause(err))
 		return
 	}
-	userEmail := userDoc.Data()["email"].(string)
+	userEmail := util.GetStringValue(userDoc.Data(), "email")
 
-	// parse request body
+	// parse request body, dates are YYYYMMDD
 	var dday DDay
 	if err := c.ShouldBindJSON(&dday); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
-		return
-	}
-
-	if dday.Date != "" {
-		// validate date format
-		// expected format: YYYYMMDD
-		if len(dday.Date) != 8 {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid date format. Use YYYYMMDD"})
-			fmt.Println("Invalid date format:", dday.Date)
-			return
-		}
-		_, err1 := strconv.Atoi(dday.Date[0:4])
-		month, err2 := strconv.Atoi(dday.Date[4:6])
-		day, err3 := strconv.Atoi(dday.Date[6:8])
-		if err1 != nil || err2 != nil || err3 != nil || month < 1 || month > 12 || day < 1 || day > 31 {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid date values"})
-			return
-		}
-	}
-
-	// validate title
-	if dday.Title == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Title is required"})
+		apierr.Abort(c, apierr.FromBinding(err))
 		return
 	}
 
-	fmt.Printf("DEBUG: CreateDDay - userEmail: %s, original connectedUsers: %v\n", userEmail, dday.ConnectedUsers)
-
-	connectedUsers := dday.ConnectedUsers
-	connectionDocs, err := fsClient.Collection("users").Doc(uid.(string)).Collection("connections").
-		Where("status", "==", "active").
-		Documents(context.Background()).GetAll()
-
-	if err == nil && len(connectionDocs) > 0 {
-		connectionData := connectionDocs[0].Data()
-		partnerEmail := connectionData["partnerEmail"].(string)
-
-		fmt.Printf("DEBUG: CreateDDay - found partner: %s\n", partnerEmail)
-
-		// add partner to connectedUsers if not already present
-		if !util.Contains(connectedUsers, partnerEmail) {
-			connectedUsers = append(connectedUsers, partnerEmail)
-			fmt.Printf("DEBUG: CreateDDay - added partner to connectedUsers: %v\n", connectedUsers)
-		} else {
-			fmt.Printf("DEBUG: CreateDDay - partner already in connectedUsers\n")
+	// events are shared with the active partner by UID
+	// connectedUsers only mirrors the partner's email for display
+	connectedUsers := []string{}
+	sharedWith := []string{}
+	conn, err := activeConnection(c.Request.Context(), fsClient, uid)
+	if err == nil && conn != nil {
+		connectionData := conn.Data()
+		if partnerUID := util.GetStringValue(connectionData, "partnerUID"); partnerUID != "" {
+			sharedWith = append(sharedWith, partnerUID)
+			connectedUsers = append(connectedUsers, util.GetStringValue(connectionData, "partnerEmail"))
 		}
-	} else {
-		fmt.Printf("DEBUG: CreateDDay - no active connection found\n")
 	}
 
 	// set current time for timestamps
 	now := time.Now()
 
-	fmt.Printf("DEBUG: CreateDDay - final connectedUsers: %v\n", connectedUsers)
-
 	// create a new document in the ddays collection
 	newDDay := map[string]interface{}{
 		"title":          dday.Title,
@@ -312,166 +299,120 @@ func CreateDDay(c *gin.Context) {
 		"isAnnual":       dday.IsAnnual,
 		"createdBy":      userEmail,
 		"connectedUsers": connectedUsers,
+		"ownerUID":       uid,
+		"sharedWith":     sharedWith,
 		"createdAt":      now,
 		"updatedAt":      now,
+		"version":        int64(1),
 		"editable":       dday.Editable || true,
 	}
 
 	// add document to Firestore
-	newDoc, _, err := fsClient.Collection("ddays").Add(context.Background(), newDDay)
+	newDoc, _, err := fsClient.Collection("ddays").Add(c.Request.Context(), newDDay)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create event: " + err.Error()})
+		apierr.Abort(c, apierr.Internal("Failed to create event").WithCause(err))
 		return
 	}
 
+	telemetry.DDaysCreated.Inc()
+
 	// return created evetn
 	dday.ID = newDoc.ID
 	dday.CreatedBy = userEmail
+	dday.ConnectedUsers = connectedUsers
+	dday.OwnerUID = uid
+	dday.SharedWith = sharedWith
 	dday.CreatedAt = now
 	dday.UpdatedAt = now
+	dday.Version = 1
 
+	publish(c, events.DDayCreated, events.Change{ID: dday.ID, UserID: uid}, append(sharedWith, uid)...)
+	c.Header("ETag", ddayETag(dday.Version))
 	c.JSON(http.StatusCreated, gin.H{"dday": dday})
 }
 
-// update existing event
+// update existing event, when the request says which version it edits
+// and the event changed since, nothing is updated and the answer is 409
 func UpdateDDay(c *gin.Context) {
-	session := sessions.Default(c)
-	uid := session.Get("user_id")
-	if uid == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+	uid := currentUID(c)
+	if uid == "" {
+		apierr.Abort(c, apierr.Unauthorized("Unauthorized"))
 		return
 	}
 
 	// get firestore client from context
 	fsClient := c.MustGet("firestore").(*firestore.Client)
 
-	// get user email from firestore
-	userDoc, err := fsClient.Collection("users").Doc(uid.(string)).Get(context.Background())
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve user"})
+	var body DDayUpdate
+	if err := c.ShouldBindJSON(&body); err != nil {
+		apierr.Abort(c, apierr.FromBinding(err))
 		return
 	}
-	userEmail := userDoc.Data()["email"].(string)
-
-	var updates map[string]interface{}
-	if err := c.ShouldBindJSON(&updates); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+	expected, apiErr := expectedVersion(c, body.Version)
+	if apiErr != nil {
+		apierr.Abort(c, apiErr)
 		return
 	}
 
-	if dateVal, ok := updates["date"]; ok {
-		dateStr, isString := dateVal.(string)
-		if !isString {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "date field must be a string"})
-			return
-		}
-		// only validate non-empty date strings.
-		if dateStr != "" {
-			if len(dateStr) != 8 {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid date format. Use YYYYMMDD"})
-				return
-			}
-			_, err1 := strconv.Atoi(dateStr[0:4])
-			month, err2 := strconv.Atoi(dateStr[4:6])
-			day, err3 := strconv.Atoi(dateStr[6:8])
-			if err1 != nil || err2 != nil || err3 != nil || month < 1 || month > 12 || day < 1 || day > 31 {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid date values"})
-				return
-			}
-		}
-	}
-
-	if titleVal, ok := updates["title"]; ok {
-		if titleStr, isString := titleVal.(string); !isString || titleStr == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Title cannot be empty if provided"})
-			return
-		}
-	}
-
 	// get event ID from URL
 	id := c.Param("id")
 	ddayRef := fsClient.Collection("ddays").Doc(id)
-	docSnap, err := ddayRef.Get(context.Background())
-	if err != nil || !docSnap.Exists() {
-		c.JSON(http.StatusNotFound, gin.H{"error": "D-Day not found"})
-		return
-	}
-	if docSnap.Data()["createdBy"].(string) != userEmail {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Only creator can update"})
-		return
-	}
-
-	firestoreUpdates := []firestore.Update{}
-	for key, value := range updates {
-		// prevent users from updating protected fields
-		if key == "id" || key == "createdBy" || key == "createdAt" {
-			continue
-		}
-		firestoreUpdates = append(firestoreUpdates, firestore.Update{Path: key, Value: value})
-	}
-	// always update 'updatedAt' timestamp
-	firestoreUpdates = append(firestoreUpdates, firestore.Update{Path: "updatedAt", Value: time.Now()})
-
-	if _, err := ddayRef.Update(context.Background(), firestoreUpdates); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update event: " + err.Error()})
-		return
-	}
-
-	updatedDoc, err := ddayRef.Get(context.Background())
+	before, after, err := editDDay(c.Request.Context(), fsClient, ddayRef, uid, expected, body.updates(), nil)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve updated event"})
-		return
-	}
-
-	var updatedDDay DDay
-	if err := updatedDoc.DataTo(&updatedDDay); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse updated event data"})
+		abortDDayEdit(c, err)
 		return
 	}
-	updatedDDay.ID = updatedDoc.Ref.ID
-
-	c.JSON(http.StatusOK, gin.H{"dday": updatedDDay})
 
+	sharedWith := util.ToStringSlice(before["sharedWith"])
+	publish(c, events.DDayUpdated, events.Change{ID: id, UserID: uid}, append(sharedWith, uid)...)
+	audited(c, fsClient, audit.DDayUpdate, audit.Target{Type: "dday", ID: id}, audit.Diff(before, after, ddayEditable...), sharedWith...)
+	c.Header("ETag", ddayETag(util.GetInt64Value(after, "version")))
+	c.JSON(http.StatusOK, gin.H{"dday": ddayFromData(id, after)})
 }
 
 // delete existing event
 func DeleteDDay(c *gin.Context) {
 	// get user ID from session
-	session := sessions.Default(c)
-	uid := session.Get("user_id")
-	if uid == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+	uid := currentUID(c)
+	if uid == "" {
+		apierr.Abort(c, apierr.Unauthorized("Unauthorized"))
 		return
 	}
 	// get firestore client from context
 	fsClient := c.MustGet("firestore").(*firestore.Client)
-	// get user email from firestore
-	userDoc, _ := fsClient.Collection("users").Doc(uid.(string)).Get(context.Background())
-	userEmail := userDoc.Data()["email"].(string)
 
 	id := c.Param("id")
 	ddayRef := fsClient.Collection("ddays").Doc(id)
-	docSnap, err := ddayRef.Get(context.Background())
-	if err != nil || !docSnap.Exists() {
-		c.JSON(http.StatusNotFound, gin.H{"error": "D-Day not found"})
+	docSnap, err := ddayRef.Get(c.Request.Context())
+	if err != nil || !docSnap.Exists() || trash.Trashed(docSnap.Data()) {
+		apierr.Abort(c, apierr.NotFound("D-Day not found"))
 		return
 	}
-	if docSnap.Data()["createdBy"].(string) != userEmail {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Only creator can delete"})
+	if util.GetStringValue(docSnap.Data(), "ownerUID") != uid {
+		apierr.Abort(c, apierr.Forbidden("Only creator can delete"))
 		return
 	}
-	if _, err := ddayRef.Delete(context.Background()); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	// the event stays in the owner's trash until it is purged
+	if _, err := ddayRef.Update(c.Request.Context(), trash.Delete(uid, time.Now())); err != nil {
+		apierr.Abort(c, apierr.Internal("Failed to delete event").WithCause(err))
 		return
 	}
+	sharedWith := util.ToStringSlice(docSnap.Data()["sharedWith"])
+	publish(c, events.DDayDeleted, events.Change{ID: id, UserID: uid}, append(sharedWith, uid)...)
+	audited(c, fsClient, audit.DDayDelete, audit.Target{Type: "dday", ID: id}, audit.Diff(docSnap.Data(), nil), sharedWith...)
 	c.JSON(http.StatusOK, gin.H{"message": "D-Day deleted"})
 }
 
 // generate presigned URL for upload to R2
 func GetDDayUploadURL(c *gin.Context) {
+	if currentUID(c) == "" {
+		apierr.Abort(c, apierr.Unauthorized("Unauthorized"))
+		return
+	}
+
 	var req UploadRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: missing fileSize"})
+		apierr.Abort(c, apierr.FromBinding(err))
 		return
 	}
 
@@ -480,28 +421,29 @@ func GetDDayUploadURL(c *gin.Context) {
 
 	// enforce the size limit on the backend before generating the URL
 	if req.FileSize > maxUploadSize {
-		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "File size exceeds the 5MB limit"})
+		apierr.Abort(c, apierr.TooLarge("File size exceeds the 5MB limit"))
 		return
 	}
 
-	accountID := os.Getenv("R2_ACCOUNT_ID")
-	accessKeyID := os.Getenv("R2_ACCESS_KEY_ID")
-	accessKeySecret := os.Getenv("R2_ACCESS_KEY_SECRET")
-	bucketName := os.Getenv("R2_BUCKET_NAME")
+	r2 := appConfig(c).R2
+	if r2.BucketName == "" {
+		apierr.Abort(c, apierr.Unavailable("Image uploads are not configured"))
+		return
+	}
 
 	// AWS config loader
-	cfg, err := config.LoadDefaultConfig(context.TODO(),
-		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(accessKeyID, accessKeySecret, "")),
+	cfg, err := config.LoadDefaultConfig(c.Request.Context(),
+		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(r2.AccessKeyID, r2.AccessKeySecret, "")),
 		config.WithRegion("auto"),
 	)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to configure R2 client"})
+		apierr.Abort(c, apierr.Internal("Failed to configure R2 client").WithCause(err))
 		return
 	}
 
 	// create S3 client
 	s3Client := s3.NewFromConfig(cfg, func(o *s3.Options) {
-		o.BaseEndpoint = aws.String(fmt.Sprintf("https://%s.r2.cloudflarestorage.com", accountID))
+		o.BaseEndpoint = aws.String(fmt.Sprintf("https://%s.r2.cloudflarestorage.com", r2.AccountID))
 	})
 
 	presignClient := s3.NewPresignClient(s3Client)
@@ -509,20 +451,20 @@ func GetDDayUploadURL(c *gin.Context) {
 	// generate unique key (filename)
 	objectKey := "ddays/" + uuid.New().String()
 
-	presignedURL, err := presignClient.PresignPutObject(context.TODO(), &s3.PutObjectInput{
-		Bucket: aws.String(bucketName),
+	presignedURL, err := presignClient.PresignPutObject(c.Request.Context(), &s3.PutObjectInput{
+		Bucket: aws.String(r2.BucketName),
 		Key:    aws.String(objectKey),
 		// while PresignPutObject doesn't directly enforce a range,
 		// the client MUST set the Content-Length header, which will be checked on the frontend
 	}, s3.WithPresignExpires(time.Minute*15))
 
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create presigned URL"})
+		apierr.Abort(c, apierr.Internal("Failed to create presigned URL").WithCause(err))
 		return
 	}
 
 	// public URL stored in firestore
-	publicURL := fmt.Sprintf("https://pub-%s.r2.dev/%s", os.Getenv("R2_PUBLIC_BUCKET_ID"), objectKey)
+	publicURL := fmt.Sprintf("https://pub-%s.r2.dev/%s", r2.PublicBucketID, objectKey)
 
 	c.JSON(http.StatusOK, gin.H{
 		"uploadUrl": presignedURL.URL,
@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// the month to list is validated before it is sliced into year and month
+func TestGetDDaysView(t *testing.T) {
+	router := testRouter(testFirestore(t))
+	router.GET("/ddays", GetDDays)
+
+	for view, want := range map[string]int{
+		"":        http.StatusBadRequest,
+		"2025":    http.StatusBadRequest,
+		"2025ab":  http.StatusBadRequest,
+		"202513":  http.StatusBadRequest,
+		"2025070": http.StatusBadRequest,
+		"202507":  http.StatusOK,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/ddays?view="+view, nil)
+		req.Header.Set(testUIDHeader, "ann")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != want {
+			t.Errorf("view %q: %d %s", view, w.Code, w.Body)
+		}
+	}
+}
@@ -0,0 +1,350 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/gin-gonic/gin"
+
+	"calple/apierr"
+	"calple/apitoken"
+	"calple/util"
+)
+
+// the device authorization grant (RFC 8628) signs in clients that can't
+// show a browser, like the CLI. the client gets a device code it polls
+// /auth/token with and a short user code the user enters on /auth/device
+// in a signed in browser. device_codes/{id} holds the hash of the device
+// code, the user code, the requested scopes and whether it was approved.
+const (
+	deviceCodeTTL = 10 * time.Minute
+	// seconds between polls, polling faster is answered with slow_down
+	devicePollInterval = 5
+
+	DeviceGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+	deviceStatusPending  = "pending"
+	deviceStatusApproved = "approved"
+	deviceStatusDenied   = "denied"
+	deviceStatusUsed     = "used"
+)
+
+// the OAuth errors of a device token request, clients branch on the code
+var (
+	errAuthorizationPending = apierr.New(http.StatusBadRequest, "authorization_pending", "The request has not been approved yet")
+	errSlowDown             = apierr.New(http.StatusBadRequest, "slow_down", "Polling too often")
+	errAccessDenied         = apierr.New(http.StatusBadRequest, "access_denied", "The request was denied")
+	errDeviceCodeExpired    = apierr.New(http.StatusBadRequest, "expired_token", "The device code expired")
+)
+
+type DeviceCodeRequest struct {
+	// shown on the approval page and in the list of tokens
+	ClientName string `form:"client_name" json:"client_name" binding:"max=100"`
+	// space separated, every scope when empty
+	Scope string `form:"scope" json:"scope" binding:"max=1000"`
+}
+
+type DeviceCodeResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+// StartDeviceAuthorization issues a device code and a user code
+func StartDeviceAuthorization(c *gin.Context) {
+	var req DeviceCodeRequest
+	if err := c.ShouldBind(&req); err != nil {
+		apierr.Abort(c, apierr.FromBinding(err))
+		return
+	}
+	scopes := apitoken.AllScopes()
+	if req.Scope != "" {
+		var err error
+		if scopes, err = apitoken.ParseScopes(strings.Fields(req.Scope)); err != nil {
+			apierr.Abort(c, scopesError(err))
+			return
+		}
+	}
+	name := strings.TrimSpace(req.ClientName)
+	if name == "" {
+		name = "Device"
+	}
+
+	fsClient := c.MustGet("firestore").(*firestore.Client)
+	ref := fsClient.Collection("device_codes").NewDoc()
+	deviceCode, hash, err := apitoken.NewOpaque(apitoken.DeviceCodePrefix, ref.ID)
+	if err != nil {
+		apierr.Abort(c, apierr.Internal("Failed to create device code").WithCause(err))
+		return
+	}
+	code, err := util.RandomString(8, util.CodeAlphabet)
+	if err != nil {
+		apierr.Abort(c, apierr.Internal("Failed to create device code").WithCause(err))
+		return
+	}
+	userCode := code[:4] + "-" + code[4:]
+
+	now := time.Now()
+	_, err = ref.Set(c.Request.Context(), map[string]interface{}{
+		"hash":      hash,
+		"userCode":  userCode,
+		"name":      name,
+		"scopes":    scopes,
+		"status":    deviceStatusPending,
+		"createdAt": now,
+		"expiresAt": now.Add(deviceCodeTTL),
+	})
+	if err != nil {
+		apierr.Abort(c, apierr.Internal("Failed to create device code").WithCause(err))
+		return
+	}
+
+	verification := appConfig(c).APIBaseURL + "/auth/device"
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, DeviceCodeResponse{
+		DeviceCode:              deviceCode,
+		UserCode:                userCode,
+		VerificationURI:         verification,
+		VerificationURIComplete: verification + "?user_code=" + url.QueryEscape(userCode),
+		ExpiresIn:               int(deviceCodeTTL.Seconds()),
+		Interval:                devicePollInterval,
+	})
+}
+
+var devicePage = template.Must(template.New("device").Parse(`<!doctype html>
+<html><head><meta charset="utf-8"><meta name="viewport" content="width=device-width, initial-scale=1"><title>Connect a device - Calple</title></head>
+<body style="font-family: sans-serif; text-align: center; margin-top: 20vh">
+<h1>Connect a device</h1>
+{{if .Message}}<p>{{.Message}}</p>{{end}}
+{{if .SignInURL}}<p><a href="{{.SignInURL}}">Sign in to continue</a></p>
+{{else if not .Done}}<form method="post" action="/auth/device">
+<input type="hidden" name="csrf" value="{{.CSRF}}">
+{{if .Client}}<p><b>{{.Client}}</b> asks for: {{range .Scopes}}{{.}} {{end}}</p>{{end}}
+<p><input name="user_code" value="{{.UserCode}}" placeholder="XXXX-XXXX" autocomplete="off" style="font-size: 1.2em; text-align: center"></p>
+<button type="submit" name="action" value="approve" style="font-size: 1.2em; padding: .6em 1.4em">Approve</button>
+<button type="submit" name="action" value="deny" style="font-size: 1.2em; padding: .6em 1.4em">Deny</button>
+</form>{{end}}
+</body></html>`))
+
+type devicePageData struct {
+	Message   string
+	SignInURL string
+	UserCode  string
+	CSRF      string
+	Client    string
+	Scopes    []string
+	Done      bool
+}
+
+func renderDevicePage(c *gin.Context, status int, data devicePageData) {
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Header("Cache-Control", "no-store")
+	c.Header("X-Frame-Options", "DENY")
+	c.Status(status)
+	devicePage.Execute(c.Writer, data)
+}
+
+// deviceCSRF ties the approval form to the signed in user, another site
+// can't make the browser approve a code of its own
+func deviceCSRF(c *gin.Context, uid string) string {
+	return util.Sign([]byte(appConfig(c).Session.SecretKey), "device:"+uid)
+}
+
+// browserUID is the user of a browser session, bearer tokens can't approve
+// devices
+func browserUID(c *gin.Context) string {
+	if _, ok := c.Get("authScopes"); ok {
+		return ""
+	}
+	return currentUID(c)
+}
+
+func normalizeUserCode(code string) string {
+	code = strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+	if len(code) != 8 {
+		return code
+	}
+	return code[:4] + "-" + code[4:]
+}
+
+// findDeviceCode looks up a pending, unexpired code by its user code
+func findDeviceCode(ctx context.Context, fsClient *firestore.Client, userCode string) (*firestore.DocumentSnapshot, error) {
+	docs, err := fsClient.Collection("device_codes").
+		Where("userCode", "==", userCode).
+		Where("status", "==", deviceStatusPending).
+		Limit(1).
+		Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(docs) == 0 || time.Now().After(util.GetTimeValue(docs[0].Data(), "expiresAt")) {
+		return nil, nil
+	}
+	return docs[0], nil
+}
+
+// ShowDeviceAuthorization is the page a user code is entered on
+func ShowDeviceAuthorization(c *gin.Context) {
+	userCode := normalizeUserCode(c.Query("user_code"))
+	uid := browserUID(c)
+	if uid == "" {
+		back := appConfig(c).APIBaseURL + "/auth/device?user_code=" + url.QueryEscape(userCode)
+		renderDevicePage(c, http.StatusOK, devicePageData{
+			Message:   "Sign in to Calple in this browser, then come back to this page.",
+			SignInURL: appConfig(c).APIBaseURL + "/auth/login?redirect=" + url.QueryEscape(back),
+		})
+		return
+	}
+
+	data := devicePageData{UserCode: userCode, CSRF: deviceCSRF(c, uid)}
+	if userCode != "" {
+		fsClient := c.MustGet("firestore").(*firestore.Client)
+		doc, err := findDeviceCode(c.Request.Context(), fsClient, userCode)
+		if err != nil {
+			renderDevicePage(c, http.StatusInternalServerError, devicePageData{Message: "Something went wrong, please try again."})
+			return
+		}
+		if doc == nil {
+			data.Message = "This code is invalid or expired. Start over on your device."
+		} else {
+			data.Client = util.GetStringValue(doc.Data(), "name")
+			data.Scopes = util.ToStringSlice(doc.Data()["scopes"])
+		}
+	}
+	renderDevicePage(c, http.StatusOK, data)
+}
+
+// ConfirmDeviceAuthorization approves or denies a user code
+func ConfirmDeviceAuthorization(c *gin.Context) {
+	uid := browserUID(c)
+	if uid == "" || !util.Verify([]byte(appConfig(c).Session.SecretKey), "device:"+uid, c.PostForm("csrf")) {
+		renderDevicePage(c, http.StatusForbidden, devicePageData{Message: "Your session changed, open this page again."})
+		return
+	}
+	userCode := normalizeUserCode(c.PostForm("user_code"))
+	status := deviceStatusApproved
+	if c.PostForm("action") == "deny" {
+		status = deviceStatusDenied
+	}
+
+	fsClient := c.MustGet("firestore").(*firestore.Client)
+	ctx := c.Request.Context()
+	doc, err := findDeviceCode(ctx, fsClient, userCode)
+	if err == nil && doc != nil {
+		err = fsClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+			snap, err := tx.Get(doc.Ref)
+			if err != nil {
+				return err
+			}
+			if util.GetStringValue(snap.Data(), "status") != deviceStatusPending {
+				return errTokenInvalid
+			}
+			return tx.Update(doc.Ref, []firestore.Update{
+				{Path: "status", Value: status},
+				{Path: "uid", Value: uid},
+				{Path: "decidedAt", Value: time.Now()},
+			})
+		})
+	}
+	if doc == nil || errors.Is(err, errTokenInvalid) {
+		renderDevicePage(c, http.StatusBadRequest, devicePageData{
+			Message:  "This code is invalid or expired. Start over on your device.",
+			UserCode: userCode,
+			CSRF:     deviceCSRF(c, uid),
+		})
+		return
+	}
+	if err != nil {
+		requestLogger(c).Error("failed to confirm device code", "error", err)
+		renderDevicePage(c, http.StatusInternalServerError, devicePageData{Message: "Something went wrong, please try again."})
+		return
+	}
+
+	message := "Your device is connected, you can go back to it."
+	if status == deviceStatusDenied {
+		message = "The request was denied, the device was not connected."
+	}
+	renderDevicePage(c, http.StatusOK, devicePageData{Message: message, Done: true})
+}
+
+// deviceToken answers a poll with a device code, once the code is approved
+// it becomes an app grant like CreateAppToken makes
+func deviceToken(c *gin.Context, deviceCode string) {
+	id, hash, err := apitoken.ParseOpaque(apitoken.DeviceCodePrefix, deviceCode)
+	if err != nil {
+		apierr.Abort(c, apierr.BadRequest("Invalid device code"))
+		return
+	}
+
+	fsClient := c.MustGet("firestore").(*firestore.Client)
+	ref := fsClient.Collection("device_codes").Doc(id)
+
+	var uid, name string
+	var scopes []string
+	var pollErr *apierr.Error
+	err = fsClient.RunTransaction(c.Request.Context(), func(ctx context.Context, tx *firestore.Transaction) error {
+		pollErr = nil
+		doc, err := tx.Get(ref)
+		if err != nil {
+			if strings.Contains(err.Error(), "NotFound") {
+				return errTokenInvalid
+			}
+			return err
+		}
+		data := doc.Data()
+		if !apitoken.SameHash(util.GetStringValue(data, "hash"), hash) {
+			return errTokenInvalid
+		}
+		now := time.Now()
+		if now.After(util.GetTimeValue(data, "expiresAt")) {
+			pollErr = errDeviceCodeExpired
+			return nil
+		}
+
+		switch util.GetStringValue(data, "status") {
+		case deviceStatusPending:
+			pollErr = errAuthorizationPending
+			if last := util.GetTimeValue(data, "polledAt"); now.Sub(last) < devicePollInterval*time.Second {
+				pollErr = errSlowDown
+			}
+			return tx.Update(ref, []firestore.Update{{Path: "polledAt", Value: now}})
+		case deviceStatusDenied:
+			pollErr = errAccessDenied
+			return nil
+		case deviceStatusApproved:
+			uid = util.GetStringValue(data, "uid")
+			name = util.GetStringValue(data, "name")
+			scopes = util.ToStringSlice(data["scopes"])
+			return tx.Update(ref, []firestore.Update{{Path: "status", Value: deviceStatusUsed}})
+		}
+		return errTokenInvalid
+	})
+	if errors.Is(err, errTokenInvalid) {
+		apierr.Abort(c, apierr.BadRequest("Invalid device code"))
+		return
+	}
+	if err != nil {
+		apierr.Abort(c, apierr.Internal("Failed to check device code").WithCause(err))
+		return
+	}
+	if pollErr != nil {
+		apierr.Abort(c, pollErr)
+		return
+	}
+
+	grant, err := createAppGrant(c.Request.Context(), fsClient, uid, name, scopes)
+	if err != nil {
+		apierr.Abort(c, apierr.Internal("Failed to create token").WithCause(err))
+		return
+	}
+	writeTokenResponse(c, http.StatusOK, uid, grant.id, grant.refreshToken, scopes)
+}
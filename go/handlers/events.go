@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/gin-gonic/gin"
+
+	"calple/apierr"
+	"calple/apitoken"
+	"calple/events"
+	"calple/telemetry"
+)
+
+const (
+	// comments keep proxies from closing an idle stream
+	eventHeartbeat = 25 * time.Second
+	// a client that can't take a write for this long is gone
+	eventWriteTimeout = 30 * time.Second
+	// how long EventSource waits before reconnecting, in milliseconds
+	eventRetry = 5000
+)
+
+// publish sends an event to the users in uids, when live updates are on
+func publish(c *gin.Context, t events.Type, change events.Change, uids ...string) {
+	if hub, ok := c.Get("events"); ok {
+		hub.(*events.Hub).Publish(t, change, uids...)
+	}
+}
+
+// publishToCouple sends an event to the user and their active partner
+func publishToCouple(c *gin.Context, fsClient *firestore.Client, uid string, t events.Type, change events.Change) {
+	if _, ok := c.Get("events"); !ok {
+		return
+	}
+	partnerUID, _ := activePartnerUID(c.Request.Context(), fsClient, uid)
+	publish(c, t, change, uid, partnerUID)
+}
+
+// Events streams changes to the user's and the partner's data as
+// server-sent events, so the app doesn't have to poll. a reconnect with
+// Last-Event-ID (or ?lastEventId=) gets the events it missed first. API
+// tokens only receive events of the resources they can read.
+func Events(c *gin.Context) {
+	uid := currentUID(c)
+	if uid == "" {
+		apierr.Abort(c, apierr.Unauthorized("Unauthorized"))
+		return
+	}
+	hub, ok := c.Get("events")
+	if !ok {
+		apierr.Abort(c, apierr.Unavailable("Live updates are not available"))
+		return
+	}
+
+	lastEventID := c.GetHeader("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.Query("lastEventId")
+	}
+	sub, replay, err := hub.(*events.Hub).Subscribe(uid, lastEventID)
+	switch {
+	case errors.Is(err, events.ErrTooManySubscribers):
+		apierr.Abort(c, apierr.TooManyRequests("Too many open event streams"))
+		return
+	case err != nil:
+		apierr.Abort(c, apierr.Unavailable("Live updates are not available").WithCause(err))
+		return
+	}
+	defer sub.Close()
+
+	telemetry.EventStreams.Inc()
+	defer telemetry.EventStreams.Dec()
+
+	scopes, scoped := c.Get("authScopes")
+	allowed := func(ev events.Event) bool {
+		resource := ev.Type.Resource()
+		return !scoped || resource == "" || apitoken.Allows(scopes.([]string), resource, false)
+	}
+
+	// the stream outlives the server's write timeout, every write gets its own
+	rc := http.NewResponseController(c.Writer)
+	write := func(format string, args ...interface{}) error {
+		if err := rc.SetWriteDeadline(time.Now().Add(eventWriteTimeout)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			return err
+		}
+		if _, err := fmt.Fprintf(c.Writer, format, args...); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+	send := func(ev events.Event) error {
+		if !allowed(ev) {
+			return nil
+		}
+		data, err := json.Marshal(ev)
+		if err != nil {
+			return err
+		}
+		return write("id: %s\nevent: %s\ndata: %s\n\n", ev.ID, ev.Type, data)
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	// nginx would hold the events back otherwise
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	if err := write("retry: %d\n\n", eventRetry); err != nil {
+		return
+	}
+	for _, ev := range replay {
+		if err := send(ev); err != nil {
+			return
+		}
+	}
+
+	heartbeat := time.NewTicker(eventHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		var err error
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-sub.Done():
+			// fell behind or shutting down, the client reconnects and
+			// catches up from the backlog
+			return
+		case ev := <-sub.Events():
+			err = send(ev)
+		case <-heartbeat.C:
+			err = write(": ping\n\n")
+		}
+		if err != nil {
+			requestLogger(c).Debug("event stream closed", "error", err)
+			return
+		}
+	}
+}
@@ -1,47 +1,45 @@
 package handlers
 
 import (
-	"context"
-	"log" // 1. Add the "log" package
 	"net/http"
 	"time"
 
 	"cloud.google.com/go/firestore"
-	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
 	"google.golang.org/api/iterator"
+
+	"calple/apierr"
 )
 
 type FeedbackPayload struct {
-	FeedbackText string `json:"feedbackText" binding:"required"`
-	Category     string `json:"category" binding:"required"`
+	FeedbackText string `json:"feedbackText" binding:"required,notblank,max=5000"`
+	Category     string `json:"category" binding:"required,max=50"`
 }
 
 type AdminCommentPayload struct {
-	Comment string `json:"comment" binding:"required"`
+	Comment string `json:"comment" binding:"required,notblank,max=5000"`
 }
 
 // SubmitFeedback handles the submission of user feedback.
 func SubmitFeedback(c *gin.Context) {
-	session := sessions.Default(c)
-	uid := session.Get("user_id")
-	if uid == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+	uid := currentUID(c)
+	if uid == "" {
+		apierr.Abort(c, apierr.Unauthorized("User not authenticated"))
 		return
 	}
 
 	var payload FeedbackPayload
 	if err := c.ShouldBindJSON(&payload); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload: " + err.Error()})
+		apierr.Abort(c, apierr.FromBinding(err))
 		return
 	}
 
 	fsClient, ok := c.MustGet("firestore").(*firestore.Client)
 	if !ok {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get Firestore client"})
+		apierr.Abort(c, apierr.Internal("Failed to get Firestore client"))
 		return
 	}
-	ctx := context.Background()
+	ctx := c.Request.Context()
 
 	feedbackData := map[string]interface{}{
 		"feedbackText": payload.FeedbackText,
@@ -50,9 +48,9 @@ func SubmitFeedback(c *gin.Context) {
 		"category":     payload.Category,
 	}
 
-	_, _, err := fsClient.Collection("users").Doc(uid.(string)).Collection("feedback").Add(ctx, feedbackData)
+	_, _, err := fsClient.Collection("users").Doc(uid).Collection("feedback").Add(ctx, feedbackData)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save feedback"})
+		apierr.Abort(c, apierr.Internal("Failed to save feedback").WithCause(err))
 		return
 	}
 
@@ -60,33 +58,29 @@ func SubmitFeedback(c *gin.Context) {
 }
 
 func GetUserFeedback(c *gin.Context) {
-	session := sessions.Default(c)
-	uid := session.Get("user_id")
-	if uid == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+	uid := currentUID(c)
+	if uid == "" {
+		apierr.Abort(c, apierr.Unauthorized("User not authenticated"))
 		return
 	}
 
-	log.Printf("Fetching feedback for UID: %s", uid.(string)) // Add this log
-
 	fsClient, ok := c.MustGet("firestore").(*firestore.Client)
 	if !ok {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get Firestore client"})
+		apierr.Abort(c, apierr.Internal("Failed to get Firestore client"))
 		return
 	}
-	ctx := context.Background()
+	ctx := c.Request.Context()
 
 	feedbackList := make([]map[string]interface{}, 0)
 
-	iter := fsClient.Collection("users").Doc(uid.(string)).Collection("feedback").OrderBy("submittedAt", firestore.Asc).Documents(ctx)
+	iter := fsClient.Collection("users").Doc(uid).Collection("feedback").OrderBy("submittedAt", firestore.Asc).Documents(ctx)
 	for {
 		doc, err := iter.Next()
 		if err == iterator.Done {
 			break
 		}
 		if err != nil {
-			log.Printf("Firestore query error: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to iterate feedback documents"})
+			apierr.Abort(c, apierr.Internal("Failed to iterate feedback documents").WithCause(err))
 			return
 		}
 		data := doc.Data()
@@ -94,7 +88,5 @@ func GetUserFeedback(c *gin.Context) {
 		feedbackList = append(feedbackList, data)
 	}
 
-	log.Printf("Found %d feedback documents for UID: %s", len(feedbackList), uid.(string)) // Add this log
-
 	c.JSON(http.StatusOK, feedbackList)
 }
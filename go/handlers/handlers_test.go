@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+
+	"cloud.google.com/go/firestore"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-contrib/sessions/cookie"
+	"github.com/gin-gonic/gin"
+
+	"calple/apierr"
+	"calple/config"
+	"calple/firebase/firestoremock"
+)
+
+func TestMain(m *testing.M) {
+	// request structs validate with the tags the app registers
+	if err := apierr.RegisterValidators(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Exit(m.Run())
+}
+
+// testFirestore is a client of an empty in-memory database
+func testFirestore(t *testing.T) *firestore.Client {
+	t.Helper()
+	srv, err := firestoremock.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsClient, err := srv.Client(context.Background())
+	if err != nil {
+		srv.Close()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		fsClient.Close()
+		srv.Close()
+	})
+	return fsClient
+}
+
+const testUIDHeader = "X-Test-UID"
+
+// testRouter has the session and the context values the app sets up for
+// handlers, routes are added by the test
+func testRouter(fsClient *firestore.Client) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	cfg := &config.Config{
+		FrontendURL: "http://app.test",
+		APIBaseURL:  "http://api.test",
+		Session:     config.Session{SecretKey: "0123456789abcdef0123456789abcdef"},
+	}
+	router := gin.New()
+	router.Use(sessions.Sessions("test_session", cookie.NewStore([]byte(cfg.Session.SecretKey))))
+	router.Use(func(c *gin.Context) {
+		c.Set("config", cfg)
+		c.Set("firestore", fsClient)
+		// signed in as the user of the header, like a bearer token would
+		if uid := c.GetHeader(testUIDHeader); uid != "" {
+			c.Set("authUID", uid)
+		}
+	})
+	return router
+}
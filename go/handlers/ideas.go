@@ -1,24 +1,26 @@
 package handlers
 
 import (
-	"context"
 	"net/http"
 	"time"
 
 	"cloud.google.com/go/firestore"
-	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
+
+	"calple/apierr"
+	"calple/trash"
+	"calple/util"
 )
 
 type Idea struct {
 	ID          string    `json:"id"`
-	Title       string    `json:"title"`
-	Description string    `json:"description"`
+	Title       string    `json:"title" binding:"required,notblank,max=200"`
+	Description string    `json:"description" binding:"max=5000"`
 	Author      string    `json:"author"`
 	CreatedAt   string    `json:"created_at"`
 	UpdatedAt   string    `json:"updated_at"`
 	Likes       int       `json:"likes"`
-	Tags        []string  `json:"tags"`
+	Tags        []string  `json:"tags" binding:"max=20,dive,max=30"`
 	Comments    []Comment `json:"comments"`
 }
 
@@ -26,7 +28,7 @@ type Comment struct {
 	ID        string `json:"id"`
 	Author    string `json:"author"`
 	CreatedAt string `json:"created_at"`
-	Content   string `json:"content"`
+	Content   string `json:"content" binding:"required,notblank,max=2000"`
 }
 
 // getAllpost; returns all posts from the database
@@ -37,18 +39,18 @@ func GetAllPosts(c *gin.Context) {
 	// get firestore client from context
 	fsClient := c.MustGet("firestore").(*firestore.Client)
 
-	q1, err := fsClient.Collection("ideas").Documents(context.Background()).GetAll()
+	q1, err := fsClient.Collection("ideas").Documents(c.Request.Context()).GetAll()
 
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch posts"})
+		apierr.Abort(c, apierr.Internal("Failed to fetch posts").WithCause(err))
 		return
 	}
 
 	ideas := []Idea{}
-	for _, doc := range q1 {
+	for _, doc := range trash.Live(q1) {
 		var idea Idea
 		if err := doc.DataTo(&idea); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse post data"})
+			apierr.Abort(c, apierr.Internal("Failed to parse post data").WithCause(err))
 			return
 		}
 		idea.ID = doc.Ref.ID
@@ -61,10 +63,9 @@ func GetAllPosts(c *gin.Context) {
 // getPost; get posts that user has created
 // IMPORTANT: this requires user to be authenticated unlike getAllPosts
 func GetPost(c *gin.Context) {
-	session := sessions.Default(c)
-	uid := session.Get("user_id")
-	if uid == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+	uid := currentUID(c)
+	if uid == "" {
+		apierr.Abort(c, apierr.Unauthorized("User not authenticated"))
 		return
 	}
 
@@ -72,19 +73,19 @@ func GetPost(c *gin.Context) {
 	fsClient := c.MustGet("firestore").(*firestore.Client)
 
 	// get user posts from firestore
-	userDocRef := fsClient.Collection("users").Doc(uid.(string))
+	userDocRef := fsClient.Collection("users").Doc(uid)
 	postsRef := userDocRef.Collection("posts")
-	q, err := postsRef.Documents(context.Background()).GetAll()
+	q, err := postsRef.Documents(c.Request.Context()).GetAll()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch posts"})
+		apierr.Abort(c, apierr.Internal("Failed to fetch posts").WithCause(err))
 		return
 	}
 
 	posts := []Idea{}
-	for _, doc := range q {
+	for _, doc := range trash.Live(q) {
 		var post Idea
 		if err := doc.DataTo(&post); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse post data"})
+			apierr.Abort(c, apierr.Internal("Failed to parse post data").WithCause(err))
 			return
 		}
 		post.ID = doc.Ref.ID
@@ -97,26 +98,25 @@ func GetPost(c *gin.Context) {
 // addpost; adds a new post to the database
 // IMPORTANT: this requires user to be authenticated like getPost
 func AddPost(c *gin.Context) {
-	session := sessions.Default(c)
-	uid := session.Get("user_id")
-	if uid == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+	uid := currentUID(c)
+	if uid == "" {
+		apierr.Abort(c, apierr.Unauthorized("User not authenticated"))
 		return
 	}
 
 	fsClient := c.MustGet("firestore").(*firestore.Client)
 
 	// get user email from session
-	userDoc, err := fsClient.Collection("users").Doc(uid.(string)).Get(context.Background())
+	userDoc, err := fsClient.Collection("users").Doc(uid).Get(c.Request.Context())
 	if err != nil || !userDoc.Exists() {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		apierr.Abort(c, apierr.Unauthorized("User not found"))
 		return
 	}
-	userName := userDoc.Data()["name"].(string)
+	userName := util.GetStringValue(userDoc.Data(), "name")
 
 	var newPost Idea
 	if err := c.ShouldBindJSON(&newPost); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid post data"})
+		apierr.Abort(c, apierr.FromBinding(err))
 		return
 	}
 
@@ -126,59 +126,58 @@ func AddPost(c *gin.Context) {
 	newPost.Tags = []string{}
 	newPost.Comments = []Comment{}
 
-	// post title is required
-	if newPost.Title == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Title and description are required"})
-		return
-	}
-
 	// add post to firestore
-	postDocRef, _, err := fsClient.Collection("ideas").Add(context.Background(), newPost)
+	postDocRef, _, err := fsClient.Collection("ideas").Add(c.Request.Context(), newPost)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add post"})
+		apierr.Abort(c, apierr.Internal("Failed to add post").WithCause(err))
 		return
 	}
 	newPost.ID = postDocRef.ID
 
 	// add post to user's posts collection
-	userDocRef := fsClient.Collection("users").Doc(uid.(string))
-	_, err = userDocRef.Collection("posts").Doc(postDocRef.ID).Set(context.Background(), newPost)
+	userDocRef := fsClient.Collection("users").Doc(uid)
+	_, err = userDocRef.Collection("posts").Doc(postDocRef.ID).Set(c.Request.Context(), newPost)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add post to user"})
+		apierr.Abort(c, apierr.Internal("Failed to add post to user").WithCause(err))
 		return
 	}
 
 	c.JSON(http.StatusCreated, newPost)
 }
 
-// deletePost; deletes a post from the database
+// deletePost; moves a post of the user to the trash
 func DeletePost(c *gin.Context) {
-	session := sessions.Default(c)
-	uid := session.Get("user_id")
-	if uid == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+	uid := currentUID(c)
+	if uid == "" {
+		apierr.Abort(c, apierr.Unauthorized("User not authenticated"))
 		return
 	}
 
 	postID := c.Param("id")
 	if postID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Post ID is required"})
+		apierr.Abort(c, apierr.BadRequest("Post ID is required"))
 		return
 	}
 
 	fsClient := c.MustGet("firestore").(*firestore.Client)
 
-	// delete post from firestore
-	postDocRef := fsClient.Collection("ideas").Doc(postID)
-	if _, err := postDocRef.Delete(context.Background()); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete post"})
+	// only the author's posts collection has it, and only the author can
+	// restore it from the trash
+	userPostRef := fsClient.Collection("users").Doc(uid).Collection("posts").Doc(postID)
+	if snap, err := userPostRef.Get(c.Request.Context()); err != nil || trash.Trashed(snap.Data()) {
+		apierr.Abort(c, apierr.NotFound("Post not found"))
 		return
 	}
 
-	// also delete from user's posts collection
-	userDocRef := fsClient.Collection("users").Doc(uid.(string))
-	if _, err := userDocRef.Collection("posts").Doc(postID).Delete(context.Background()); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete post from user"})
+	// move the post to the trash in both places
+	deleted := trash.Delete(uid, time.Now())
+	postDocRef := fsClient.Collection("ideas").Doc(postID)
+	if _, err := postDocRef.Update(c.Request.Context(), deleted); err != nil {
+		apierr.Abort(c, apierr.Internal("Failed to delete post").WithCause(err))
+		return
+	}
+	if _, err := userPostRef.Update(c.Request.Context(), deleted); err != nil {
+		apierr.Abort(c, apierr.Internal("Failed to delete post from user").WithCause(err))
 		return
 	}
 
@@ -187,16 +186,15 @@ func DeletePost(c *gin.Context) {
 
 // UpdatePost; updates an existing post
 func UpdatePost(c *gin.Context) {
-	session := sessions.Default(c)
-	uid := session.Get("user_id")
-	if uid == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+	uid := currentUID(c)
+	if uid == "" {
+		apierr.Abort(c, apierr.Unauthorized("User not authenticated"))
 		return
 	}
 
 	postID := c.Param("id")
 	if postID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Post ID is required"})
+		apierr.Abort(c, apierr.BadRequest("Post ID is required"))
 		return
 	}
 
@@ -204,32 +202,45 @@ func UpdatePost(c *gin.Context) {
 
 	var updatedPost Idea
 	if err := c.ShouldBindJSON(&updatedPost); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid post data"})
+		apierr.Abort(c, apierr.FromBinding(err))
 		return
 	}
 
-	// update post in firestore
-	postDocRef := fsClient.Collection("ideas").Doc(postID)
-	if _, err := postDocRef.Set(context.Background(), updatedPost, firestore.MergeAll); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update post"})
+	// only the author's posts collection has it, like for deleting
+	userPostRef := fsClient.Collection("users").Doc(uid).Collection("posts").Doc(postID)
+	if snap, err := userPostRef.Get(c.Request.Context()); err != nil || trash.Trashed(snap.Data()) {
+		apierr.Abort(c, apierr.NotFound("Post not found"))
 		return
 	}
 
+	// update the post in both places. it is stored under the Go field names,
+	// and the author, likes and comments aren't the editor's to change
+	updates := []firestore.Update{
+		{Path: "Title", Value: updatedPost.Title},
+		{Path: "Description", Value: updatedPost.Description},
+		{Path: "UpdatedAt", Value: updatedPost.UpdatedAt},
+	}
+	for _, ref := range []*firestore.DocumentRef{fsClient.Collection("ideas").Doc(postID), userPostRef} {
+		if _, err := ref.Update(c.Request.Context(), updates); err != nil {
+			apierr.Abort(c, apierr.Internal("Failed to update post").WithCause(err))
+			return
+		}
+	}
+
 	c.JSON(http.StatusOK, gin.H{"message": "Post updated successfully"})
 }
 
 // AddComment; adds a comment to a post
 func AddComment(c *gin.Context) {
-	session := sessions.Default(c)
-	uid := session.Get("user_id")
-	if uid == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+	uid := currentUID(c)
+	if uid == "" {
+		apierr.Abort(c, apierr.Unauthorized("User not authenticated"))
 		return
 	}
 
 	postID := c.Param("id")
 	if postID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Post ID is required"})
+		apierr.Abort(c, apierr.BadRequest("Post ID is required"))
 		return
 	}
 
@@ -237,62 +248,57 @@ func AddComment(c *gin.Context) {
 
 	var newComment Comment
 	if err := c.ShouldBindJSON(&newComment); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid comment data"})
+		apierr.Abort(c, apierr.FromBinding(err))
 		return
 	}
 
-	newComment.Author = uid.(string)
+	newComment.Author = uid
 	newComment.CreatedAt = time.Now().Format(time.RFC3339)
 
-	if newComment.Content == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Comment content is required"})
-		return
-	}
-
 	// add comment to post's comments collection
 	postDocRef := fsClient.Collection("ideas").Doc(postID)
-	commentDocRef, _, err := postDocRef.Collection("comments").Add(context.Background(), newComment)
+	commentDocRef, _, err := postDocRef.Collection("comments").Add(c.Request.Context(), newComment)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add comment"})
+		apierr.Abort(c, apierr.Internal("Failed to add comment").WithCause(err))
 		return
 	}
 	newComment.ID = commentDocRef.ID
 
 	// also add comment to user's comments collection
-	userDocRef := fsClient.Collection("users").Doc(uid.(string))
-	_, err = userDocRef.Collection("comments").Doc(commentDocRef.ID).Set(context.Background(), newComment)
+	userDocRef := fsClient.Collection("users").Doc(uid)
+	_, err = userDocRef.Collection("comments").Doc(commentDocRef.ID).Set(c.Request.Context(), newComment)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add comment to user"})
+		apierr.Abort(c, apierr.Internal("Failed to add comment to user").WithCause(err))
 		return
 	}
 
 	// update post's comments count
-	_, err = postDocRef.Update(context.Background(), []firestore.Update{
+	_, err = postDocRef.Update(c.Request.Context(), []firestore.Update{
 		{Path: "comments_count",
 
 			Value: firestore.Increment(1)},
 	})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update comments count"})
+		apierr.Abort(c, apierr.Internal("Failed to update comments count").WithCause(err))
 		return
 	}
 
 	// increment comments count in user's posts collection
-	_, err = userDocRef.Collection("posts").Doc(postID).Update(context.Background(), []firestore.Update{
+	_, err = userDocRef.Collection("posts").Doc(postID).Update(c.Request.Context(), []firestore.Update{
 		{Path: "comments_count", Value: firestore.Increment(1)},
 	})
 
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update comments count in user's posts"})
+		apierr.Abort(c, apierr.Internal("Failed to update comments count in user's posts").WithCause(err))
 		return
 	}
 
 	// increment comments count in post's comments collection
-	_, err = postDocRef.Update(context.Background(), []firestore.Update{
+	_, err = postDocRef.Update(c.Request.Context(), []firestore.Update{
 		{Path: "comments_count", Value: firestore.Increment(1)},
 	})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update comments count in post's comments"})
+		apierr.Abort(c, apierr.Internal("Failed to update comments count in post's comments").WithCause(err))
 		return
 	}
 
@@ -301,17 +307,16 @@ func AddComment(c *gin.Context) {
 
 // DeleteComment; deletes a comment from a post
 func DeleteComment(c *gin.Context) {
-	session := sessions.Default(c)
-	uid := session.Get("user_id")
-	if uid == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+	uid := currentUID(c)
+	if uid == "" {
+		apierr.Abort(c, apierr.Unauthorized("User not authenticated"))
 		return
 	}
 
 	postID := c.Param("post_id")
 	commentID := c.Param("comment_id")
 	if postID == "" || commentID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Post ID and Comment ID are required"})
+		apierr.Abort(c, apierr.BadRequest("Post ID and Comment ID are required"))
 		return
 	}
 
@@ -320,31 +325,31 @@ func DeleteComment(c *gin.Context) {
 	// delete comment from post's comments collection
 	postDocRef := fsClient.Collection("ideas").Doc(postID)
 	commentDocRef := postDocRef.Collection("comments").Doc(commentID)
-	if _, err := commentDocRef.Delete(context.Background()); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete comment"})
+	if _, err := commentDocRef.Delete(c.Request.Context()); err != nil {
+		apierr.Abort(c, apierr.Internal("Failed to delete comment").WithCause(err))
 		return
 	}
 
 	// also delete from user's comments collection
-	userDocRef := fsClient.Collection("users").Doc(uid.(string))
-	if _, err := userDocRef.Collection("comments").Doc(commentID).Delete(context.Background()); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete comment from user"})
+	userDocRef := fsClient.Collection("users").Doc(uid)
+	if _, err := userDocRef.Collection("comments").Doc(commentID).Delete(c.Request.Context()); err != nil {
+		apierr.Abort(c, apierr.Internal("Failed to delete comment from user").WithCause(err))
 		return
 	}
 
 	// decrement comments count in post's comments collection
-	if _, err := postDocRef.Update(context.Background(), []firestore.Update{
+	if _, err := postDocRef.Update(c.Request.Context(), []firestore.Update{
 		{Path: "comments_count", Value: firestore.Increment(-1)},
 	}); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update comments count"})
+		apierr.Abort(c, apierr.Internal("Failed to update comments count").WithCause(err))
 		return
 	}
 
 	// decrement comments count in user's posts collection
-	if _, err := userDocRef.Collection("posts").Doc(postID).Update(context.Background(), []firestore.Update{
+	if _, err := userDocRef.Collection("posts").Doc(postID).Update(c.Request.Context(), []firestore.Update{
 		{Path: "comments_count", Value: firestore.Increment(-1)},
 	}); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update comments count in user's posts"})
+		apierr.Abort(c, apierr.Internal("Failed to update comments count in user's posts").WithCause(err))
 		return
 	}
 
@@ -353,17 +358,16 @@ func DeleteComment(c *gin.Context) {
 
 // UpdateComment; updates an existing comment
 func UpdateComment(c *gin.Context) {
-	session := sessions.Default(c)
-	uid := session.Get("user_id")
-	if uid == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+	uid := currentUID(c)
+	if uid == "" {
+		apierr.Abort(c, apierr.Unauthorized("User not authenticated"))
 		return
 	}
 
 	postID := c.Param("post_id")
 	commentID := c.Param("comment_id")
 	if postID == "" || commentID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Post ID and Comment ID are required"})
+		apierr.Abort(c, apierr.BadRequest("Post ID and Comment ID are required"))
 		return
 	}
 
@@ -371,14 +375,14 @@ func UpdateComment(c *gin.Context) {
 
 	var updatedComment Comment
 	if err := c.ShouldBindJSON(&updatedComment); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid comment data"})
+		apierr.Abort(c, apierr.FromBinding(err))
 		return
 	}
 
 	// update comment in post's comments collection
 	commentDocRef := fsClient.Collection("ideas").Doc(postID).Collection("comments").Doc(commentID)
-	if _, err := commentDocRef.Set(context.Background(), updatedComment, firestore.MergeAll); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update comment"})
+	if _, err := commentDocRef.Set(c.Request.Context(), updatedComment, firestore.MergeAll); err != nil {
+		apierr.Abort(c, apierr.Internal("Failed to update comment").WithCause(err))
 		return
 	}
 
@@ -387,16 +391,15 @@ func UpdateComment(c *gin.Context) {
 
 // LikePost; increments the likes count for a post
 func LikePost(c *gin.Context) {
-	session := sessions.Default(c)
-	uid := session.Get("user_id")
-	if uid == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+	uid := currentUID(c)
+	if uid == "" {
+		apierr.Abort(c, apierr.Unauthorized("User not authenticated"))
 		return
 	}
 
 	postID := c.Param("id")
 	if postID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Post ID is required"})
+		apierr.Abort(c, apierr.BadRequest("Post ID is required"))
 		return
 	}
 
@@ -404,18 +407,18 @@ func LikePost(c *gin.Context) {
 
 	// increment likes count in post document
 	postDocRef := fsClient.Collection("ideas").Doc(postID)
-	if _, err := postDocRef.Update(context.Background(), []firestore.Update{
+	if _, err := postDocRef.Update(c.Request.Context(), []firestore.Update{
 		{Path: "likes", Value: firestore.Increment(1)},
 	}); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to like post"})
+		apierr.Abort(c, apierr.Internal("Failed to like post").WithCause(err))
 		return
 	}
 	// also increment likes count in user's posts collection
-	userDocRef := fsClient.Collection("users").Doc(uid.(string))
-	if _, err := userDocRef.Collection("posts").Doc(postID).Update(context.Background(), []firestore.Update{
+	userDocRef := fsClient.Collection("users").Doc(uid)
+	if _, err := userDocRef.Collection("posts").Doc(postID).Update(c.Request.Context(), []firestore.Update{
 		{Path: "likes", Value: firestore.Increment(1)},
 	}); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update likes count in user's posts"})
+		apierr.Abort(c, apierr.Internal("Failed to update likes count in user's posts").WithCause(err))
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "Post liked successfully"})
@@ -423,16 +426,15 @@ func LikePost(c *gin.Context) {
 
 // UnlikePost; decrements the likes count for a post
 func UnlikePost(c *gin.Context) {
-	session := sessions.Default(c)
-	uid := session.Get("user_id")
-	if uid == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+	uid := currentUID(c)
+	if uid == "" {
+		apierr.Abort(c, apierr.Unauthorized("User not authenticated"))
 		return
 	}
 
 	postID := c.Param("id")
 	if postID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Post ID is required"})
+		apierr.Abort(c, apierr.BadRequest("Post ID is required"))
 		return
 	}
 
@@ -440,18 +442,18 @@ func UnlikePost(c *gin.Context) {
 
 	// decrement likes count in post document
 	postDocRef := fsClient.Collection("ideas").Doc(postID)
-	if _, err := postDocRef.Update(context.Background(), []firestore.Update{
+	if _, err := postDocRef.Update(c.Request.Context(), []firestore.Update{
 		{Path: "likes", Value: firestore.Increment(-1)},
 	}); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlike post"})
+		apierr.Abort(c, apierr.Internal("Failed to unlike post").WithCause(err))
 		return
 	}
 	// also decrement likes count in user's posts collection
-	userDocRef := fsClient.Collection("users").Doc(uid.(string))
-	if _, err := userDocRef.Collection("posts").Doc(postID).Update(context.Background(), []firestore.Update{
+	userDocRef := fsClient.Collection("users").Doc(uid)
+	if _, err := userDocRef.Collection("posts").Doc(postID).Update(c.Request.Context(), []firestore.Update{
 		{Path: "likes", Value: firestore.Increment(-1)},
 	}); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update likes count in user's posts"})
+		apierr.Abort(c, apierr.Internal("Failed to update likes count in user's posts").WithCause(err))
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "Post unliked successfully"})
@@ -461,7 +463,7 @@ func UnlikePost(c *gin.Context) {
 func GetPostComments(c *gin.Context) {
 	postID := c.Param("id")
 	if postID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Post ID is required"})
+		apierr.Abort(c, apierr.BadRequest("Post ID is required"))
 		return
 	}
 
@@ -469,9 +471,9 @@ func GetPostComments(c *gin.Context) {
 
 	// get comments from post's comments collection
 	postDocRef := fsClient.Collection("ideas").Doc(postID)
-	q, err := postDocRef.Collection("comments").Documents(context.Background()).GetAll()
+	q, err := postDocRef.Collection("comments").Documents(c.Request.Context()).GetAll()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch comments"})
+		apierr.Abort(c, apierr.Internal("Failed to fetch comments").WithCause(err))
 		return
 	}
 
@@ -479,7 +481,7 @@ func GetPostComments(c *gin.Context) {
 	for _, doc := range q {
 		var comment Comment
 		if err := doc.DataTo(&comment); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse comment data"})
+			apierr.Abort(c, apierr.Internal("Failed to parse comment data").WithCause(err))
 			return
 		}
 		comment.ID = doc.Ref.ID
@@ -491,28 +493,27 @@ func GetPostComments(c *gin.Context) {
 
 // bookmarkPost; adds a post to user's bookmarks
 func BookmarkPost(c *gin.Context) {
-	session := sessions.Default(c)
-	uid := session.Get("user_id")
-	if uid == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+	uid := currentUID(c)
+	if uid == "" {
+		apierr.Abort(c, apierr.Unauthorized("User not authenticated"))
 		return
 	}
 
 	postID := c.Param("id")
 	if postID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Post ID is required"})
+		apierr.Abort(c, apierr.BadRequest("Post ID is required"))
 		return
 	}
 
 	fsClient := c.MustGet("firestore").(*firestore.Client)
 
 	// add post to user's bookmarks collection
-	userDocRef := fsClient.Collection("users").Doc(uid.(string))
-	_, err := userDocRef.Collection("bookmarks").Doc(postID).Set(context.Background(), map[string]interface{}{
+	userDocRef := fsClient.Collection("users").Doc(uid)
+	_, err := userDocRef.Collection("bookmarks").Doc(postID).Set(c.Request.Context(), map[string]interface{}{
 		"post_id": postID,
 	})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to bookmark post"})
+		apierr.Abort(c, apierr.Internal("Failed to bookmark post").WithCause(err))
 		return
 	}
 
@@ -521,25 +522,24 @@ func BookmarkPost(c *gin.Context) {
 
 // unbookmarkPost; removes a post from user's bookmarks
 func UnbookmarkPost(c *gin.Context) {
-	session := sessions.Default(c)
-	uid := session.Get("user_id")
-	if uid == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+	uid := currentUID(c)
+	if uid == "" {
+		apierr.Abort(c, apierr.Unauthorized("User not authenticated"))
 		return
 	}
 
 	postID := c.Param("id")
 	if postID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Post ID is required"})
+		apierr.Abort(c, apierr.BadRequest("Post ID is required"))
 		return
 	}
 
 	fsClient := c.MustGet("firestore").(*firestore.Client)
 
 	// remove post from user's bookmarks collection
-	userDocRef := fsClient.Collection("users").Doc(uid.(string))
-	if _, err := userDocRef.Collection("bookmarks").Doc(postID).Delete(context.Background()); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unbookmark post"})
+	userDocRef := fsClient.Collection("users").Doc(uid)
+	if _, err := userDocRef.Collection("bookmarks").Doc(postID).Delete(c.Request.Context()); err != nil {
+		apierr.Abort(c, apierr.Internal("Failed to unbookmark post").WithCause(err))
 		return
 	}
 
@@ -548,20 +548,19 @@ func UnbookmarkPost(c *gin.Context) {
 
 // GetBookmarks; retrieves all bookmarked posts for a user
 func GetBookmarks(c *gin.Context) {
-	session := sessions.Default(c)
-	uid := session.Get("user_id")
-	if uid == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+	uid := currentUID(c)
+	if uid == "" {
+		apierr.Abort(c, apierr.Unauthorized("User not authenticated"))
 		return
 	}
 
 	fsClient := c.MustGet("firestore").(*firestore.Client)
 
 	// get user's bookmarks from firestore
-	userDocRef := fsClient.Collection("users").Doc(uid.(string))
-	q, err := userDocRef.Collection("bookmarks").Documents(context.Background()).GetAll()
+	userDocRef := fsClient.Collection("users").Doc(uid)
+	q, err := userDocRef.Collection("bookmarks").Documents(c.Request.Context()).GetAll()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch bookmarks"})
+		apierr.Abort(c, apierr.Internal("Failed to fetch bookmarks").WithCause(err))
 		return
 	}
 
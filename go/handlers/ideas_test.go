@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// only the author can edit an idea, and editing keeps its author
+// in both places it is stored
+func TestUpdatePostOnlyByAuthor(t *testing.T) {
+	fsClient := testFirestore(t)
+	ctx := context.Background()
+	for uid, name := range map[string]string{"ann": "Ann", "bob": "Bob"} {
+		if _, err := fsClient.Collection("users").Doc(uid).Set(ctx, map[string]interface{}{"name": name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	router := testRouter(fsClient)
+	router.POST("/ideas", AddPost)
+	router.PUT("/ideas/:id", UpdatePost)
+
+	do := func(uid, method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(testUIDHeader, uid)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do("ann", http.MethodPost, "/ideas", `{"title":"Picnic","description":"in the park"}`)
+	var created Idea
+	if w.Code != http.StatusCreated || json.Unmarshal(w.Body.Bytes(), &created) != nil {
+		t.Fatalf("add: %d %s", w.Code, w.Body)
+	}
+
+	if w := do("bob", http.MethodPut, "/ideas/"+created.ID, `{"title":"Mine now"}`); w.Code != http.StatusNotFound {
+		t.Errorf("someone else's edit: %d %s", w.Code, w.Body)
+	}
+	if w := do("ann", http.MethodPut, "/ideas/"+created.ID, `{"title":"Beach picnic","description":"at the lake"}`); w.Code != http.StatusOK {
+		t.Fatalf("author's edit: %d %s", w.Code, w.Body)
+	}
+
+	for _, ref := range []string{"ideas/" + created.ID, "users/ann/posts/" + created.ID} {
+		snap, err := fsClient.Doc(ref).Get(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var idea Idea
+		if err := snap.DataTo(&idea); err != nil {
+			t.Fatal(err)
+		}
+		if idea.Title != "Beach picnic" || idea.Description != "at the lake" || idea.Author != "Ann" {
+			t.Errorf("%s is %+v", ref, idea)
+		}
+	}
+}
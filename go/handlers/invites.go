@@ -0,0 +1,494 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"calple/apierr"
+	"calple/audit"
+	"calple/config"
+	"calple/events"
+	"calple/util"
+)
+
+// invite links are valid for a week unless the inviter asks for less
+const (
+	defaultInviteTTL = 7 * 24 * time.Hour
+	inviteCodeLength = 8
+	// a new code is drawn when one is taken by an unexpired invitation
+	inviteCodeAttempts = 5
+)
+
+// inviteCodes claims each code for one invitation, the document ID is the
+// code and inviteId the invitation it stands for
+const inviteCodes = "invite_codes"
+
+var errInviteCodeTaken = errors.New("invite code taken")
+
+var (
+	errInviteInvalid    = errors.New("invalid invitation")
+	errInviteExpired    = errors.New("invitation expired")
+	errInviteUsed       = errors.New("invitation already used")
+	errInviteRevoked    = errors.New("invitation revoked")
+	errInviteSelf       = errors.New("cannot accept your own invitation")
+	errAlreadyConnected = errors.New("already connected")
+)
+
+type InviteLink struct {
+	ID        string    `json:"id"`
+	Code      string    `json:"code"`
+	Token     string    `json:"token,omitempty"`
+	URL       string    `json:"url,omitempty"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+type CreateInviteLinkRequest struct {
+	// at most 30 days
+	ExpiresInHours int `json:"expiresInHours" binding:"min=0,max=720"`
+}
+
+type RedeemInviteRequest struct {
+	Token string `json:"token" binding:"required_without=Code,max=512"`
+	Code  string `json:"code" binding:"required_without=Token,max=64"`
+}
+
+// signed link token: <inviteID>.<expiryUnix>.<signature>. it is signed
+// with the current secret key and verified with the previous ones too, so
+// links sent before a key rotation keep working.
+func inviteToken(cfg *config.Config, id string, expiresAt time.Time) string {
+	payload := id + "." + strconv.FormatInt(expiresAt.Unix(), 10)
+	return payload + "." + util.Sign(cfg.SessionKeys()[0], payload)
+}
+
+// parseInviteToken verifies the signature and expiry of a link token
+// and returns the invite ID it points to
+func parseInviteToken(cfg *config.Config, token string) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", errInviteInvalid
+	}
+	payload := parts[0] + "." + parts[1]
+	verified := false
+	for _, key := range cfg.SessionKeys() {
+		if util.Verify(key, payload, parts[2]) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return "", errInviteInvalid
+	}
+	exp, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return "", errInviteInvalid
+	}
+	if time.Now().Unix() > exp {
+		return "", errInviteExpired
+	}
+	return parts[0], nil
+}
+
+func inviteURL(cfg *config.Config, token string) string {
+	return cfg.APIBaseURL + "/auth/login?invite=" + url.QueryEscape(token)
+}
+
+func inviteStatus(data map[string]interface{}) string {
+	if _, ok := data["revokedAt"].(time.Time); ok {
+		return "revoked"
+	}
+	if _, ok := data["usedAt"].(time.Time); ok {
+		return "used"
+	}
+	if exp, ok := data["expiresAt"].(time.Time); ok && time.Now().After(exp) {
+		return "expired"
+	}
+	return "open"
+}
+
+// CreateInviteLink creates a shareable invitation that works
+// even when the partner has no account yet
+func CreateInviteLink(c *gin.Context) {
+	uid := currentUID(c)
+	if uid == "" {
+		apierr.Abort(c, apierr.Unauthorized("Unauthorized"))
+		return
+	}
+
+	var req CreateInviteLinkRequest
+	// body is optional
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apierr.Abort(c, apierr.FromBinding(err))
+			return
+		}
+	}
+
+	ttl := defaultInviteTTL
+	if req.ExpiresInHours > 0 {
+		ttl = time.Duration(req.ExpiresInHours) * time.Hour
+	}
+
+	fsClient := c.MustGet("firestore").(*firestore.Client)
+	ctx := c.Request.Context()
+
+	userDoc, err := fsClient.Collection("users").Doc(uid).Get(ctx)
+	if err != nil {
+		apierr.Abort(c, apierr.Internal("Failed to retrieve user").WithCause(err))
+		return
+	}
+	userEmail := util.GetStringValue(userDoc.Data(), "email")
+
+	now := time.Now()
+	expiresAt := now.Add(ttl)
+	inviteRef := fsClient.Collection("invites").NewDoc()
+	var code string
+	for attempt := 0; ; attempt++ {
+		code, err = util.RandomString(inviteCodeLength, util.CodeAlphabet)
+		if err == nil {
+			err = createInvite(ctx, fsClient, inviteRef, code, map[string]interface{}{
+				"inviterUID":   uid,
+				"inviterEmail": userEmail,
+				"code":         code,
+				"createdAt":    now,
+				"expiresAt":    expiresAt,
+			})
+		}
+		if !errors.Is(err, errInviteCodeTaken) || attempt+1 == inviteCodeAttempts {
+			break
+		}
+	}
+	if err != nil {
+		apierr.Abort(c, apierr.Internal("Failed to create invitation").WithCause(err))
+		return
+	}
+
+	token := inviteToken(appConfig(c), inviteRef.ID, expiresAt)
+	c.JSON(http.StatusCreated, gin.H{"invite": InviteLink{
+		ID:        inviteRef.ID,
+		Code:      code,
+		Token:     token,
+		URL:       inviteURL(appConfig(c), token),
+		Status:    "open",
+		CreatedAt: now,
+		ExpiresAt: expiresAt,
+	}})
+}
+
+// createInvite writes the invitation together with the claim on its code.
+// a code still claimed by an unexpired invitation is errInviteCodeTaken,
+// expired claims are taken over.
+func createInvite(ctx context.Context, fsClient *firestore.Client, inviteRef *firestore.DocumentRef, code string, invite map[string]interface{}) error {
+	codeRef := fsClient.Collection(inviteCodes).Doc(code)
+	err := fsClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		claim, err := tx.Get(codeRef)
+		switch {
+		case err == nil:
+			if exp, ok := claim.Data()["expiresAt"].(time.Time); !ok || time.Now().Before(exp) {
+				return errInviteCodeTaken
+			}
+			err = tx.Set(codeRef, map[string]interface{}{"inviteId": inviteRef.ID, "expiresAt": invite["expiresAt"]})
+		case status.Code(err) == codes.NotFound:
+			err = tx.Create(codeRef, map[string]interface{}{"inviteId": inviteRef.ID, "expiresAt": invite["expiresAt"]})
+		}
+		if err != nil {
+			return err
+		}
+		return tx.Create(inviteRef, invite)
+	})
+	// another invitation claimed the code between the read and the commit
+	if status.Code(err) == codes.AlreadyExists {
+		return errInviteCodeTaken
+	}
+	return err
+}
+
+// GetInviteLinks lists invitations created by the current user
+func GetInviteLinks(c *gin.Context) {
+	uid := currentUID(c)
+	if uid == "" {
+		apierr.Abort(c, apierr.Unauthorized("Unauthorized"))
+		return
+	}
+
+	fsClient := c.MustGet("firestore").(*firestore.Client)
+	docs, err := fsClient.Collection("invites").Where("inviterUID", "==", uid).Documents(c.Request.Context()).GetAll()
+	if err != nil {
+		apierr.Abort(c, apierr.Internal("Failed to fetch invitations").WithCause(err))
+		return
+	}
+
+	invites := []InviteLink{}
+	for _, doc := range docs {
+		data := doc.Data()
+		link := InviteLink{
+			ID:     doc.Ref.ID,
+			Code:   util.GetStringValue(data, "code"),
+			Status: inviteStatus(data),
+		}
+		if t, ok := data["createdAt"].(time.Time); ok {
+			link.CreatedAt = t
+		}
+		if t, ok := data["expiresAt"].(time.Time); ok {
+			link.ExpiresAt = t
+		}
+		// only open invitations can still be shared
+		if link.Status == "open" {
+			link.Token = inviteToken(appConfig(c), link.ID, link.ExpiresAt)
+			link.URL = inviteURL(appConfig(c), link.Token)
+		}
+		invites = append(invites, link)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"invites": invites})
+}
+
+// RevokeInviteLink invalidates an unused invitation
+func RevokeInviteLink(c *gin.Context) {
+	uid := currentUID(c)
+	if uid == "" {
+		apierr.Abort(c, apierr.Unauthorized("Unauthorized"))
+		return
+	}
+
+	fsClient := c.MustGet("firestore").(*firestore.Client)
+	inviteRef := fsClient.Collection("invites").Doc(c.Param("id"))
+
+	err := fsClient.RunTransaction(c.Request.Context(), func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := tx.Get(inviteRef)
+		if err != nil {
+			return errInviteInvalid
+		}
+		data := snap.Data()
+		if util.GetStringValue(data, "inviterUID") != uid {
+			return errInviteInvalid
+		}
+		if _, ok := data["usedAt"].(time.Time); ok {
+			return errInviteUsed
+		}
+		return tx.Update(inviteRef, []firestore.Update{{Path: "revokedAt", Value: time.Now()}})
+	})
+
+	switch {
+	case err == nil:
+		c.JSON(http.StatusOK, gin.H{"message": "Invitation revoked"})
+	case errors.Is(err, errInviteInvalid):
+		apierr.Abort(c, apierr.NotFound("Invitation not found"))
+	case errors.Is(err, errInviteUsed):
+		apierr.Abort(c, apierr.Conflict("Invitation has already been used"))
+	default:
+		apierr.Abort(c, apierr.Internal("Failed to revoke invitation").WithCause(err))
+	}
+}
+
+// RedeemInviteLink accepts an invitation for an already signed in user
+func RedeemInviteLink(c *gin.Context) {
+	uid := currentUID(c)
+	if uid == "" {
+		apierr.Abort(c, apierr.Unauthorized("Unauthorized"))
+		return
+	}
+
+	var req RedeemInviteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierr.Abort(c, apierr.FromBinding(err))
+		return
+	}
+
+	fsClient := c.MustGet("firestore").(*firestore.Client)
+	tokenOrCode := req.Token
+	if tokenOrCode == "" {
+		tokenOrCode = req.Code
+	}
+
+	connID, inviterUID, err := redeemInvite(c.Request.Context(), fsClient, appConfig(c), uid, tokenOrCode)
+	if err != nil {
+		apierr.Abort(c, inviteError(err))
+		return
+	}
+	publish(c, events.ConnectionAccepted, events.Change{ID: connID, UserID: uid}, uid, inviterUID)
+	auditConnection(c, fsClient, audit.ConnectionAccept, connID, "", "active", inviterUID)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Invitation accepted", "connectionId": connID})
+}
+
+// inviteError is the API error for a failed redemption
+func inviteError(err error) *apierr.Error {
+	message := strings.ToUpper(err.Error()[:1]) + err.Error()[1:]
+	switch {
+	case errors.Is(err, errInviteInvalid):
+		return apierr.NotFound(message)
+	case errors.Is(err, errInviteExpired), errors.Is(err, errInviteUsed), errors.Is(err, errInviteRevoked):
+		return apierr.Gone(message)
+	case errors.Is(err, errInviteSelf):
+		return apierr.BadRequest(message)
+	case errors.Is(err, errAlreadyConnected):
+		return apierr.Conflict(message)
+	default:
+		return apierr.Internal("Failed to accept invitation").WithCause(err)
+	}
+}
+
+// redeemInvite consumes an invitation and creates an active connection
+// between the inviter and uid. the invite is marked used in the same
+// transaction that writes both connection documents, so it can only
+// ever be redeemed once. it returns the connection and the inviter.
+func redeemInvite(ctx context.Context, fsClient *firestore.Client, cfg *config.Config, uid, tokenOrCode string) (string, string, error) {
+	inviteRef, err := resolveInvite(ctx, fsClient, cfg, tokenOrCode)
+	if err != nil {
+		return "", "", err
+	}
+
+	userRef := fsClient.Collection("users").Doc(uid)
+	var connID, inviterUID, inviterEmail, userEmail string
+
+	err = fsClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		inviteSnap, err := tx.Get(inviteRef)
+		if err != nil {
+			if status.Code(err) == codes.NotFound {
+				return errInviteInvalid
+			}
+			return err
+		}
+		invite := inviteSnap.Data()
+		switch inviteStatus(invite) {
+		case "revoked":
+			return errInviteRevoked
+		case "used":
+			return errInviteUsed
+		case "expired":
+			return errInviteExpired
+		}
+
+		inviterUID = util.GetStringValue(invite, "inviterUID")
+		inviterEmail = util.GetStringValue(invite, "inviterEmail")
+		if inviterUID == uid {
+			return errInviteSelf
+		}
+
+		userSnap, err := tx.Get(userRef)
+		if err != nil {
+			return err
+		}
+		userEmail = util.GetStringValue(userSnap.Data(), "email")
+
+		blockedSnap, err := tx.Get(blockedRef(fsClient, uid, inviterEmail))
+		if err == nil && blockedSnap.Exists() {
+			return errInviteRevoked
+		}
+
+		// refuse if the two users already have a pending or active connection
+		existing, err := tx.Documents(userRef.Collection("connections").Where("partnerUID", "==", inviterUID)).GetAll()
+		if err != nil {
+			return err
+		}
+		for _, doc := range existing {
+			if s := util.GetStringValue(doc.Data(), "status"); s == "pending" || s == "active" {
+				return errAlreadyConnected
+			}
+		}
+
+		// each user can only have a single active partner
+		for _, id := range []string{uid, inviterUID} {
+			active, err := tx.Documents(fsClient.Collection("users").Doc(id).Collection("connections").
+				Where("status", "==", "active").Limit(1)).GetAll()
+			if err != nil {
+				return err
+			}
+			if len(active) > 0 {
+				return errAlreadyConnected
+			}
+		}
+
+		now := time.Now()
+		userConnRef := userRef.Collection("connections").NewDoc()
+		inviterConnRef := fsClient.Collection("users").Doc(inviterUID).Collection("connections").Doc(userConnRef.ID)
+		connID = userConnRef.ID
+
+		if err := tx.Set(inviterConnRef, map[string]interface{}{
+			"partnerEmail": userEmail,
+			"partnerUID":   uid,
+			"role":         "initiator",
+			"status":       "active",
+			"inviteId":     inviteRef.ID,
+			"createdAt":    now,
+			"updatedAt":    now,
+		}); err != nil {
+			return err
+		}
+		if err := tx.Set(userConnRef, map[string]interface{}{
+			"partnerEmail": inviterEmail,
+			"partnerUID":   inviterUID,
+			"role":         "receiver",
+			"status":       "active",
+			"inviteId":     inviteRef.ID,
+			"createdAt":    now,
+			"updatedAt":    now,
+		}); err != nil {
+			return err
+		}
+		return tx.Update(inviteRef, []firestore.Update{
+			{Path: "usedAt", Value: now},
+			{Path: "usedBy", Value: uid},
+			{Path: "connectionId", Value: connID},
+		})
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	shareEvents(ctx, fsClient, inviterUID, uid)
+	return connID, inviterUID, nil
+}
+
+// resolveInvite accepts either a signed link token or a short code
+func resolveInvite(ctx context.Context, fsClient *firestore.Client, cfg *config.Config, tokenOrCode string) (*firestore.DocumentRef, error) {
+	tokenOrCode = strings.TrimSpace(tokenOrCode)
+	if strings.Contains(tokenOrCode, ".") {
+		id, err := parseInviteToken(cfg, tokenOrCode)
+		if err != nil {
+			return nil, err
+		}
+		return fsClient.Collection("invites").Doc(id), nil
+	}
+
+	code := strings.ToUpper(tokenOrCode)
+	if len(code) != inviteCodeLength {
+		return nil, errInviteInvalid
+	}
+	claim, err := fsClient.Collection(inviteCodes).Doc(code).Get(ctx)
+	if err == nil {
+		return fsClient.Collection("invites").Doc(util.GetStringValue(claim.Data(), "inviteId")), nil
+	}
+	if status.Code(err) != codes.NotFound {
+		return nil, fmt.Errorf("lookup invite code: %w", err)
+	}
+
+	// invitations from before codes were claimed
+	docs, err := fsClient.Collection("invites").Where("code", "==", code).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("lookup invite code: %w", err)
+	}
+	// codes are random but short, so prefer the one that is still open
+	for _, doc := range docs {
+		if inviteStatus(doc.Data()) == "open" {
+			return doc.Ref, nil
+		}
+	}
+	if len(docs) > 0 {
+		return docs[0].Ref, nil
+	}
+	return nil, errInviteInvalid
+}
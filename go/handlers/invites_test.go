@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"calple/config"
+)
+
+// links signed before a key rotation still open the invitation
+func TestInviteTokenKeyRotation(t *testing.T) {
+	oldKey := "0123456789abcdef0123456789abcdef"
+	newKey := "fedcba9876543210fedcba9876543210"
+	before := &config.Config{Session: config.Session{SecretKey: oldKey}}
+	rotated := &config.Config{Session: config.Session{SecretKey: newKey, SecretKeyPrevious: []string{oldKey}}}
+	dropped := &config.Config{Session: config.Session{SecretKey: newKey}}
+
+	expires := time.Now().Add(time.Hour)
+	token := inviteToken(before, "inv1", expires)
+	tests := []struct {
+		name  string
+		cfg   *config.Config
+		token string
+		want  error
+	}{
+		{"same key", before, token, nil},
+		{"previous key", rotated, token, nil},
+		{"new key", rotated, inviteToken(rotated, "inv1", expires), nil},
+		{"key no longer kept", dropped, token, errInviteInvalid},
+		{"other invite", rotated, "inv2" + token[len("inv1"):], errInviteInvalid},
+		{"expired", rotated, inviteToken(rotated, "inv1", time.Now().Add(-time.Minute)), errInviteExpired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := parseInviteToken(tt.cfg, tt.token)
+			if !errors.Is(err, tt.want) || (err == nil && id != "inv1") {
+				t.Errorf("parse = %q, %v, want %v", id, err, tt.want)
+			}
+		})
+	}
+}
+
+// a valid link to an invitation that no longer exists is invalid
+func TestRedeemMissingInvite(t *testing.T) {
+	fsClient := testFirestore(t)
+	cfg := &config.Config{Session: config.Session{SecretKey: "0123456789abcdef0123456789abcdef"}}
+	token := inviteToken(cfg, "gone", time.Now().Add(time.Hour))
+	if _, _, err := redeemInvite(context.Background(), fsClient, cfg, "ann", token); !errors.Is(err, errInviteInvalid) {
+		t.Errorf("redeem = %v, want %v", err, errInviteInvalid)
+	}
+}
@@ -0,0 +1,485 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/oauth2"
+
+	"calple/apierr"
+	"calple/audit"
+	"calple/auth"
+	"calple/config"
+	"calple/events"
+	"calple/util"
+)
+
+// an account can sign in with several identities (google, github, ...).
+// each is stored in identities/{provider}:{subject} pointing at the users
+// doc. the identity an account was created with is primary and keeps the
+// account's email up to date; linked ones never change it.
+
+var errIdentityTaken = errors.New("identity is linked to another account")
+
+type LinkedIdentity struct {
+	ID          string    `json:"id"`
+	Provider    string    `json:"provider"`
+	Email       string    `json:"email"`
+	Name        string    `json:"name"`
+	Primary     bool      `json:"primary"`
+	CreatedAt   time.Time `json:"createdAt"`
+	LastLoginAt time.Time `json:"lastLoginAt"`
+}
+
+type AuthProvider struct {
+	Name     string `json:"name"`
+	LoginURL string `json:"loginUrl"`
+}
+
+func identityDocID(provider, subject string) string {
+	// subjects are opaque and may contain slashes
+	return provider + ":" + url.PathEscape(subject)
+}
+
+// providerLoginURL is where a provider's sign in starts, google keeps its original routes
+func providerLoginURL(cfg *config.Config, name string) string {
+	if name == "google" {
+		return cfg.APIBaseURL + "/google/oauth/login"
+	}
+	return cfg.APIBaseURL + "/auth/" + name + "/login"
+}
+
+// GetAuthProviders lists the ways to sign in, so the frontend can show a button for each
+func GetAuthProviders(c *gin.Context) {
+	registry := c.MustGet("authProviders").(*auth.Registry)
+	providers := []AuthProvider{}
+	for _, name := range registry.Names() {
+		providers = append(providers, AuthProvider{Name: name, LoginURL: providerLoginURL(appConfig(c), name)})
+	}
+	// magic links start with a POST of the email address
+	if _, ok := c.Get("mailer"); ok {
+		providers = append(providers, AuthProvider{Name: auth.ProviderEmail, LoginURL: appConfig(c).APIBaseURL + "/auth/magic/request"})
+	}
+	c.JSON(http.StatusOK, gin.H{"providers": providers})
+}
+
+// ChooseLogin starts a sign in without a provider picked, e.g. from an
+// invite link. with a single provider it goes straight there, otherwise
+// the frontend shows the choice and keeps the query (the invite).
+func ChooseLogin(c *gin.Context) {
+	registry := c.MustGet("authProviders").(*auth.Registry)
+	query := c.Request.URL.RawQuery
+	if names := registry.Names(); len(names) == 1 {
+		c.Redirect(http.StatusFound, providerLoginURL(appConfig(c), names[0])+"?"+query)
+		return
+	}
+	c.Redirect(http.StatusFound, appConfig(c).FrontendURL+"/?"+query)
+}
+
+// ProviderLogin redirects to the provider's sign in page.
+//
+//	?invite=   redeemed once signed in
+//	?redirect= where to return afterwards, a frontend path or allowed URL
+//	?link=1    add the identity to the signed in account instead
+func ProviderLogin(c *gin.Context) {
+	providerLogin(c, c.Param("provider"))
+}
+
+func providerLogin(c *gin.Context, name string) {
+	registry := c.MustGet("authProviders").(*auth.Registry)
+	provider, err := registry.Get(name)
+	if err != nil {
+		renderLoginError(c, http.StatusNotFound, "unknown_provider")
+		return
+	}
+
+	// state ties the callback to this browser, the verifier (PKCE) to this
+	// authorization code and the nonce to the ID token issued for it
+	state, err := util.RandomToken(32)
+	if err != nil {
+		renderLoginError(c, http.StatusInternalServerError, "sign_in_failed")
+		return
+	}
+	nonce, err := util.RandomToken(32)
+	if err != nil {
+		renderLoginError(c, http.StatusInternalServerError, "sign_in_failed")
+		return
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	session := sessions.Default(c)
+	session.Set("oauth_provider", provider.Name())
+	session.Set("oauth_state", state)
+	session.Set("oauth_verifier", verifier)
+	session.Set("oauth_nonce", nonce)
+	session.Set("oauth_redirect", safeRedirect(appConfig(c), c.Query("redirect")))
+
+	session.Delete("oauth_link")
+	if c.Query("link") != "" {
+		if session.Get("user_id") == nil {
+			renderLoginError(c, http.StatusUnauthorized, "not_signed_in")
+			return
+		}
+		session.Set("oauth_link", true)
+	}
+
+	session.Delete("invite")
+	if invite := c.Query("invite"); invite != "" {
+		session.Set("invite", invite)
+	}
+
+	if err := session.Save(); err != nil {
+		renderLoginError(c, http.StatusInternalServerError, "sign_in_failed")
+		return
+	}
+
+	authURL, err := provider.AuthCodeURL(c.Request.Context(), auth.AuthRequest{
+		State:     state,
+		Verifier:  verifier,
+		Nonce:     nonce,
+		LoginHint: c.Query("login_hint"),
+	})
+	if err != nil {
+		renderLoginError(c, http.StatusBadGateway, "provider_unavailable")
+		return
+	}
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// ProviderCallback finishes the sign in. it accepts GET and POST, some
+// providers post the result (response_mode=form_post).
+func ProviderCallback(c *gin.Context) {
+	providerCallback(c, c.Param("provider"))
+}
+
+func providerCallback(c *gin.Context, name string) {
+	registry := c.MustGet("authProviders").(*auth.Registry)
+	provider, err := registry.Get(name)
+	if err != nil {
+		renderLoginError(c, http.StatusNotFound, "unknown_provider")
+		return
+	}
+
+	c.Request.ParseForm()
+	params := c.Request.Form
+
+	session := sessions.Default(c)
+	state, _ := session.Get("oauth_state").(string)
+	verifier, _ := session.Get("oauth_verifier").(string)
+	nonce, _ := session.Get("oauth_nonce").(string)
+	sessionProvider, _ := session.Get("oauth_provider").(string)
+	// all of them are single use
+	session.Delete("oauth_state")
+	session.Delete("oauth_verifier")
+	session.Delete("oauth_nonce")
+	session.Delete("oauth_provider")
+
+	if params.Get("error") != "" {
+		session.Save()
+		renderLoginError(c, http.StatusBadRequest, "access_denied")
+		return
+	}
+	if state == "" || sessionProvider != provider.Name() ||
+		subtle.ConstantTimeCompare([]byte(state), []byte(params.Get("state"))) != 1 {
+		session.Save()
+		renderLoginError(c, http.StatusBadRequest, "invalid_state")
+		return
+	}
+
+	ident, err := provider.Exchange(c.Request.Context(), params, auth.AuthRequest{State: state, Verifier: verifier, Nonce: nonce})
+	if err != nil {
+		session.Save()
+		if errors.Is(err, auth.ErrNoEmail) {
+			renderLoginError(c, http.StatusBadRequest, "no_email")
+			return
+		}
+		renderLoginError(c, http.StatusBadGateway, "sign_in_failed")
+		return
+	}
+
+	redirect, err := completeLogin(c, ident)
+	if err != nil {
+		if errors.Is(err, errIdentityTaken) {
+			renderLoginError(c, http.StatusConflict, "identity_taken")
+			return
+		}
+		if errors.Is(err, auth.ErrNoEmail) {
+			renderLoginError(c, http.StatusBadRequest, "no_email")
+			return
+		}
+		renderLoginError(c, http.StatusInternalServerError, "sign_in_failed")
+		return
+	}
+	c.Redirect(http.StatusFound, redirect)
+}
+
+// completeLogin signs in with an identity returned by any provider. it
+// finds or creates the account, keeps the users doc current, sets up the
+// session and redeems a pending invite. returns where to send the browser.
+func completeLogin(c *gin.Context, ident *auth.Identity) (string, error) {
+	fsClient := c.MustGet("firestore").(*firestore.Client)
+	ctx := c.Request.Context()
+	session := sessions.Default(c)
+	redirect, _ := session.Get("oauth_redirect").(string)
+	session.Delete("oauth_redirect")
+	if redirect == "" {
+		redirect = appConfig(c).FrontendURL
+	}
+
+	// anyone can enter an address they don't own at some providers, an
+	// unverified one is neither stored nor used to find an account
+	if !ident.EmailVerified {
+		unverified := *ident
+		unverified.Email = ""
+		ident = &unverified
+	}
+
+	// adding an identity to the signed in account
+	if linking, _ := session.Get("oauth_link").(bool); linking {
+		session.Delete("oauth_link")
+		uid, _ := session.Get("user_id").(string)
+		if uid == "" {
+			return "", errors.New("not signed in")
+		}
+		if err := linkIdentity(ctx, fsClient, c, uid, ident); err != nil {
+			session.Save()
+			return "", err
+		}
+		if err := session.Save(); err != nil {
+			return "", err
+		}
+		return withQuery(redirect, "linked", ident.Provider), nil
+	}
+
+	uid, primary, err := resolveAccount(ctx, fsClient, c, ident)
+	if err != nil {
+		return "", err
+	}
+
+	userDocRef := fsClient.Collection("users").Doc(uid)
+	doc, err := userDocRef.Get(ctx)
+	isReturningUser := false
+	previousEmail := ""
+	if err == nil && doc.Exists() {
+		isReturningUser = true
+		previousEmail, _ = doc.Data()["email"].(string)
+	}
+	// a new account needs a verified address
+	if !isReturningUser && ident.Email == "" {
+		return "", auth.ErrNoEmail
+	}
+
+	now := time.Now()
+	userData := map[string]interface{}{
+		// tokens are kept per identity now
+		"tokens":         firestore.Delete,
+		"returning_user": isReturningUser,
+		"last_login_at":  now,
+	}
+	// only the identity the account was created with speaks for its email
+	if (primary || !isReturningUser) && ident.Email != "" {
+		userData["email"] = ident.Email
+		if ident.Name != "" {
+			userData["name"] = ident.Name
+		}
+	}
+	if !isReturningUser {
+		userData["created_at"] = now
+		userData["sex"] = "female"
+		userData["startedDating"] = nil
+		if ident.Name == "" {
+			userData["name"] = strings.Split(ident.Email, "@")[0]
+		}
+	}
+
+	if _, err := userDocRef.Set(ctx, userData, firestore.MergeAll); err != nil {
+		return "", err
+	}
+
+	// the email at the provider changed, refresh the copies kept for display
+	if isReturningUser && primary && ident.Email != "" && previousEmail != ident.Email {
+		syncUserEmail(ctx, fsClient, uid, previousEmail, ident.Email)
+	}
+
+	session.Set("user_id", uid)
+
+	// signing in through an invite link connects the two users right away
+	if invite, ok := session.Get("invite").(string); ok && invite != "" {
+		session.Delete("invite")
+		if connID, inviterUID, err := redeemInvite(ctx, fsClient, appConfig(c), uid, invite); err != nil {
+			redirect = withQuery(redirect, "invite", inviteError(err).Message)
+		} else {
+			publish(c, events.ConnectionAccepted, events.Change{ID: connID, UserID: uid}, uid, inviterUID)
+			auditConnection(c, fsClient, audit.ConnectionAccept, connID, "", "active", inviterUID)
+			redirect = withQuery(redirect, "invite", "accepted")
+		}
+	}
+
+	if err := session.Save(); err != nil {
+		return "", err
+	}
+	return redirect, nil
+}
+
+// resolveAccount returns the account an identity signs in to, creating the
+// identity (and with it a new account) on first sign in
+func resolveAccount(ctx context.Context, fsClient *firestore.Client, c *gin.Context, ident *auth.Identity) (string, bool, error) {
+	identRef := fsClient.Collection("identities").Doc(identityDocID(ident.Provider, ident.Subject))
+	now := time.Now()
+
+	var uid string
+	var primary bool
+	err := fsClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := tx.Get(identRef)
+		if err == nil && snap.Exists() {
+			data := snap.Data()
+			uid = util.GetStringValue(data, "uid")
+			primary, _ = data["primary"].(bool)
+			updates := []firestore.Update{
+				{Path: "name", Value: ident.Name},
+				{Path: "tokens", Value: sealOAuthTokens(c, ident.Token)},
+				{Path: "lastLoginAt", Value: now},
+			}
+			if ident.Email != "" {
+				updates = append(updates, firestore.Update{Path: "email", Value: ident.Email})
+			}
+			return tx.Update(identRef, updates)
+		}
+		if err != nil && !strings.Contains(err.Error(), "NotFound") {
+			return err
+		}
+		if ident.Email == "" {
+			return auth.ErrNoEmail
+		}
+
+		// a magic link proves the address, so it signs in to the account
+		// that already uses it instead of creating a second one
+		if ident.Provider == auth.ProviderEmail && ident.EmailVerified {
+			existing, err := tx.Documents(fsClient.Collection("users").Where("email", "==", ident.Email).Limit(1)).GetAll()
+			if err != nil {
+				return err
+			}
+			if len(existing) > 0 {
+				uid = existing[0].Ref.ID
+				primary = false
+				return tx.Create(identRef, identityData(c, uid, ident, false, now))
+			}
+		}
+
+		// google accounts were keyed by the google user ID before identities
+		// existed, keep doing that so those accounts are found again
+		if ident.Provider == "google" {
+			uid = ident.Subject
+		} else {
+			uid = fsClient.Collection("users").NewDoc().ID
+		}
+		primary = true
+		return tx.Create(identRef, identityData(c, uid, ident, true, now))
+	})
+	return uid, primary, err
+}
+
+// linkIdentity attaches an identity to an existing account
+func linkIdentity(ctx context.Context, fsClient *firestore.Client, c *gin.Context, uid string, ident *auth.Identity) error {
+	identRef := fsClient.Collection("identities").Doc(identityDocID(ident.Provider, ident.Subject))
+	now := time.Now()
+	return fsClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := tx.Get(identRef)
+		if err == nil && snap.Exists() {
+			if util.GetStringValue(snap.Data(), "uid") != uid {
+				return errIdentityTaken
+			}
+			return tx.Update(identRef, []firestore.Update{{Path: "lastLoginAt", Value: now}})
+		}
+		if err != nil && !strings.Contains(err.Error(), "NotFound") {
+			return err
+		}
+		return tx.Create(identRef, identityData(c, uid, ident, false, now))
+	})
+}
+
+func identityData(c *gin.Context, uid string, ident *auth.Identity, primary bool, now time.Time) map[string]interface{} {
+	data := map[string]interface{}{
+		"uid":         uid,
+		"provider":    ident.Provider,
+		"subject":     ident.Subject,
+		"email":       ident.Email,
+		"name":        ident.Name,
+		"primary":     primary,
+		"createdAt":   now,
+		"lastLoginAt": now,
+	}
+	// Create can't take the Delete sentinel, leave tokens out when they aren't stored
+	if tokens := sealOAuthTokens(c, ident.Token); tokens != firestore.Delete {
+		data["tokens"] = tokens
+	}
+	return data
+}
+
+// GetIdentities lists the sign in methods linked to the current account
+func GetIdentities(c *gin.Context) {
+	uid := currentUID(c)
+	if uid == "" {
+		apierr.Abort(c, apierr.Unauthorized("User not authenticated"))
+		return
+	}
+	fsClient := c.MustGet("firestore").(*firestore.Client)
+
+	docs, err := fsClient.Collection("identities").Where("uid", "==", uid).Documents(c.Request.Context()).GetAll()
+	if err != nil {
+		apierr.Abort(c, apierr.Internal("Failed to fetch identities").WithCause(err))
+		return
+	}
+
+	identities := []LinkedIdentity{}
+	for _, doc := range docs {
+		data := doc.Data()
+		ident := LinkedIdentity{
+			ID:       doc.Ref.ID,
+			Provider: util.GetStringValue(data, "provider"),
+			Email:    util.GetStringValue(data, "email"),
+			Name:     util.GetStringValue(data, "name"),
+		}
+		ident.Primary, _ = data["primary"].(bool)
+		ident.CreatedAt, _ = data["createdAt"].(time.Time)
+		ident.LastLoginAt, _ = data["lastLoginAt"].(time.Time)
+		identities = append(identities, ident)
+	}
+	c.JSON(http.StatusOK, gin.H{"identities": identities})
+}
+
+// UnlinkIdentity removes a linked sign in method, the primary one stays
+func UnlinkIdentity(c *gin.Context) {
+	uid := currentUID(c)
+	if uid == "" {
+		apierr.Abort(c, apierr.Unauthorized("User not authenticated"))
+		return
+	}
+	fsClient := c.MustGet("firestore").(*firestore.Client)
+	ctx := c.Request.Context()
+
+	ref := fsClient.Collection("identities").Doc(c.Param("id"))
+	doc, err := ref.Get(ctx)
+	if err != nil || util.GetStringValue(doc.Data(), "uid") != uid {
+		apierr.Abort(c, apierr.NotFound("Identity not found"))
+		return
+	}
+	if primary, _ := doc.Data()["primary"].(bool); primary {
+		apierr.Abort(c, apierr.BadRequest("Cannot unlink the primary identity"))
+		return
+	}
+
+	if _, err := ref.Delete(ctx); err != nil {
+		apierr.Abort(c, apierr.Internal("Failed to unlink identity").WithCause(err))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Identity unlinked"})
+}
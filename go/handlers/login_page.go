@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"html/template"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"calple/config"
+)
+
+// sign in errors are shown in the browser. the page names what went wrong
+// and a stable code for support, never provider responses, codes or state.
+var loginErrorPage = template.Must(template.New("login_error").Parse(`<!doctype html>
+<html><head><meta charset="utf-8"><meta name="viewport" content="width=device-width, initial-scale=1"><title>Sign in failed - Calple</title></head>
+<body style="font-family: sans-serif; text-align: center; margin-top: 20vh">
+<h1>Sign in failed</h1>
+<p>{{.Message}}</p>
+<p><a href="{{.BackURL}}">Back to Calple</a></p>
+<p style="color: #888; font-size: .8em">error: {{.Code}}</p>
+</body></html>`))
+
+var loginErrorMessages = map[string]string{
+	"unknown_provider":     "This sign in method isn't available.",
+	"access_denied":        "Sign in was cancelled or denied.",
+	"invalid_state":        "Your sign in expired or was started in another window. Please try again.",
+	"provider_unavailable": "The sign in provider can't be reached right now. Please try again later.",
+	"sign_in_failed":       "We couldn't sign you in. Please try again.",
+	"no_email":             "Your account has no verified email address.",
+	"identity_taken":       "That account is already linked to another Calple account.",
+	"not_signed_in":        "Sign in before linking another account.",
+	"link_invalid":         "This sign in link is invalid. Request a new one.",
+	"link_expired":         "This sign in link expired. Request a new one.",
+	"link_used":            "This sign in link was already used. Request a new one.",
+}
+
+func renderLoginError(c *gin.Context, status int, code string) {
+	message, ok := loginErrorMessages[code]
+	if !ok {
+		code, message = "sign_in_failed", loginErrorMessages["sign_in_failed"]
+	}
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Header("Cache-Control", "no-store")
+	c.Status(status)
+	loginErrorPage.Execute(c.Writer, map[string]string{
+		"Message": message,
+		"Code":    code,
+		"BackURL": appConfig(c).FrontendURL,
+	})
+}
+
+// safeRedirect returns where to go after sign in: target when it is a path
+// or a URL on an origin allowed by cfg, FRONTEND_URL otherwise
+func safeRedirect(cfg *config.Config, target string) string {
+	frontendURL := cfg.FrontendURL
+	if target == "" || strings.ContainsAny(target, "\\\r\n") {
+		return frontendURL
+	}
+
+	// a path on the frontend, but not //host which browsers treat as a URL
+	if strings.HasPrefix(target, "/") && !strings.HasPrefix(target, "//") {
+		return strings.TrimRight(frontendURL, "/") + target
+	}
+
+	u, err := url.Parse(target)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.User != nil {
+		return frontendURL
+	}
+	origin := u.Scheme + "://" + u.Host
+	for _, allowed := range cfg.AllowedRedirectOrigins() {
+		if allowed != "" && strings.TrimRight(allowed, "/") == origin {
+			return target
+		}
+	}
+	return frontendURL
+}
+
+// withQuery adds a query parameter to a URL that may already have some
+func withQuery(target, key, value string) string {
+	u, err := url.Parse(target)
+	if err != nil {
+		return target
+	}
+	q := u.Query()
+	q.Set(key, value)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"cloud.google.com/go/firestore"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+
+	"calple/auth"
+)
+
+// signIn runs completeLogin for ident the way the provider callback does
+func signIn(t *testing.T, fsClient *firestore.Client, ident auth.Identity) (uid string, err error) {
+	t.Helper()
+	router := testRouter(fsClient)
+	router.GET("/", func(c *gin.Context) {
+		_, err = completeLogin(c, &ident)
+		uid, _ = sessions.Default(c).Get("user_id").(string)
+	})
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	return uid, err
+}
+
+func TestLoginUnverifiedEmail(t *testing.T) {
+	fsClient := testFirestore(t)
+	ctx := context.Background()
+	victim := fsClient.Collection("users").Doc("victim")
+	if _, err := victim.Set(ctx, map[string]interface{}{"email": "ann@example.com", "name": "Ann"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// an unverified address neither creates an account nor signs in to
+	// the one using it, not even through a later magic link
+	_, err := signIn(t, fsClient, auth.Identity{Provider: "oidc", Subject: "eve", Email: "ann@example.com", Name: "Eve"})
+	if !errors.Is(err, auth.ErrNoEmail) {
+		t.Fatalf("unverified sign in: err = %v, want ErrNoEmail", err)
+	}
+	if _, err := fsClient.Collection("identities").Doc(identityDocID("oidc", "eve")).Get(ctx); err == nil {
+		t.Error("unverified sign in created an identity")
+	}
+	uid, err := signIn(t, fsClient, auth.Identity{Provider: auth.ProviderEmail, Subject: "ann@example.com", Email: "ann@example.com", EmailVerified: true})
+	if err != nil || uid != "victim" {
+		t.Errorf("magic link: uid = %q, err = %v, want victim", uid, err)
+	}
+}
+
+func TestLoginKeepsVerifiedEmail(t *testing.T) {
+	fsClient := testFirestore(t)
+	ctx := context.Background()
+
+	uid, err := signIn(t, fsClient, auth.Identity{Provider: "oidc", Subject: "bob", Email: "bob@example.com", EmailVerified: true, Name: "Bob"})
+	if err != nil || uid == "" {
+		t.Fatalf("first sign in: uid = %q, err = %v", uid, err)
+	}
+
+	// the address changed at the provider but isn't verified there yet
+	again, err := signIn(t, fsClient, auth.Identity{Provider: "oidc", Subject: "bob", Email: "ann@example.com", Name: "Bob"})
+	if err != nil || again != uid {
+		t.Fatalf("second sign in: uid = %q, err = %v, want %q", again, err, uid)
+	}
+	user, err := fsClient.Collection("users").Doc(uid).Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if email := user.Data()["email"]; email != "bob@example.com" {
+		t.Errorf("users email = %v, want bob@example.com", email)
+	}
+	ident, err := fsClient.Collection("identities").Doc(identityDocID("oidc", "bob")).Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if email := ident.Data()["email"]; email != "bob@example.com" {
+		t.Errorf("identity email = %v, want bob@example.com", email)
+	}
+}
@@ -0,0 +1,243 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+
+	"calple/apierr"
+	"calple/auth"
+	"calple/config"
+	"calple/mailer"
+	"calple/util"
+)
+
+// magic links sign in by email without a password. the link carries a
+// random secret; only its hash is stored in magic_links, next to the email,
+// expiry and whether it was used.
+const (
+	magicLinkTTL = 15 * time.Minute
+
+	// requests per address and per client IP, counted from magic_links
+	magicLinksPerEmail  = 3
+	magicLinkEmailReset = 15 * time.Minute
+	magicLinksPerIP     = 10
+	magicLinkIPReset    = time.Hour
+)
+
+var (
+	errMagicLinkInvalid = errors.New("sign in link is invalid")
+	errMagicLinkExpired = errors.New("sign in link expired")
+	errMagicLinkUsed    = errors.New("sign in link was already used")
+	errMagicLinkLimit   = errors.New("too many sign in links requested")
+)
+
+type MagicLinkRequest struct {
+	Email  string `json:"email" binding:"required,email,max=254"`
+	Invite string `json:"invite" binding:"max=512"`
+	// where to go after sign in, same rules as ?redirect= on the provider login
+	Redirect string `json:"redirect" binding:"max=2048"`
+}
+
+// link token: <secret>.<expiryUnix>.<signature>. the signature lets
+// tampered or expired links be rejected without a database read.
+func magicLinkToken(cfg *config.Config, secret string, expiresAt time.Time) string {
+	payload := secret + "." + strconv.FormatInt(expiresAt.Unix(), 10)
+	return payload + "." + util.Sign([]byte(cfg.Session.SecretKey), payload)
+}
+
+func parseMagicLinkToken(cfg *config.Config, token string) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", errMagicLinkInvalid
+	}
+	payload := parts[0] + "." + parts[1]
+	if !util.Verify([]byte(cfg.Session.SecretKey), payload, parts[2]) {
+		return "", errMagicLinkInvalid
+	}
+	exp, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return "", errMagicLinkInvalid
+	}
+	if time.Now().Unix() > exp {
+		return "", errMagicLinkExpired
+	}
+	return parts[0], nil
+}
+
+// RequestMagicLink emails a one-time sign in link. the response is the same
+// whether or not the address has an account.
+func RequestMagicLink(c *gin.Context) {
+	m, ok := c.Get("mailer")
+	if !ok {
+		apierr.Abort(c, apierr.Unavailable("Sign in by email is not available"))
+		return
+	}
+
+	var req MagicLinkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierr.Abort(c, apierr.FromBinding(err))
+		return
+	}
+	email := util.NormalizeEmail(req.Email)
+
+	fsClient := c.MustGet("firestore").(*firestore.Client)
+	ctx := c.Request.Context()
+	now := time.Now()
+	links := fsClient.Collection("magic_links")
+
+	secret, err := util.RandomToken(32)
+	if err != nil {
+		apierr.Abort(c, apierr.Internal("Failed to send sign in link").WithCause(err))
+		return
+	}
+	expiresAt := now.Add(magicLinkTTL)
+	emailHash := util.HashToken(email)
+	ipHash := util.HashToken(c.ClientIP())
+
+	// counted and created in one transaction, so parallel requests can't
+	// all get in under the limit
+	err = fsClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		recentByEmail, err := tx.Documents(links.Where("emailHash", "==", emailHash).Where("createdAt", ">", now.Add(-magicLinkEmailReset))).GetAll()
+		if err != nil {
+			return err
+		}
+		recentByIP, err := tx.Documents(links.Where("ipHash", "==", ipHash).Where("createdAt", ">", now.Add(-magicLinkIPReset))).GetAll()
+		if err != nil {
+			return err
+		}
+		if len(recentByEmail) >= magicLinksPerEmail || len(recentByIP) >= magicLinksPerIP {
+			return errMagicLinkLimit
+		}
+		return tx.Create(links.Doc(util.HashToken(secret)), map[string]interface{}{
+			"email":     email,
+			"emailHash": emailHash,
+			"ipHash":    ipHash,
+			"invite":    req.Invite,
+			"redirect":  safeRedirect(appConfig(c), req.Redirect),
+			"createdAt": now,
+			"expiresAt": expiresAt,
+			"usedAt":    nil,
+		})
+	})
+	if errors.Is(err, errMagicLinkLimit) {
+		apierr.Abort(c, apierr.TooManyRequests("Too many sign in links requested, try again later"))
+		return
+	}
+	if err != nil {
+		apierr.Abort(c, apierr.Internal("Failed to send sign in link").WithCause(err))
+		return
+	}
+
+	link := appConfig(c).APIBaseURL + "/auth/magic/verify?token=" + magicLinkToken(appConfig(c), secret, expiresAt)
+	err = m.(mailer.Mailer).Send(ctx, mailer.Message{
+		To:      email,
+		Subject: "Your Calple sign in link",
+		Text: "Use this link to sign in to Calple:\n\n" + link + "\n\n" +
+			"It expires in 15 minutes and works once. If you didn't ask for it, you can ignore this email.",
+	})
+	if err != nil {
+		apierr.Abort(c, apierr.Upstream("Failed to send sign in link").WithCause(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Check your inbox for a sign in link"})
+}
+
+// the link opens a confirmation page instead of signing in on GET, so mail
+// scanners that prefetch links don't use them up
+var magicLinkPage = template.Must(template.New("magic").Parse(`<!doctype html>
+<html><head><meta charset="utf-8"><meta name="viewport" content="width=device-width, initial-scale=1"><title>Sign in to Calple</title></head>
+<body style="font-family: sans-serif; text-align: center; margin-top: 20vh">
+<form method="post" action="/auth/magic/verify">
+<input type="hidden" name="token" value="{{.}}">
+<button type="submit" style="font-size: 1.2em; padding: .6em 1.4em">Sign in to Calple</button>
+</form>
+</body></html>`))
+
+// ShowMagicLink renders the confirmation page for a link
+func ShowMagicLink(c *gin.Context) {
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Header("Referrer-Policy", "no-referrer")
+	c.Status(http.StatusOK)
+	magicLinkPage.Execute(c.Writer, c.Query("token"))
+}
+
+// VerifyMagicLink consumes a link and signs the user in
+func VerifyMagicLink(c *gin.Context) {
+	secret, err := parseMagicLinkToken(appConfig(c), c.PostForm("token"))
+	if err != nil {
+		renderLoginError(c, http.StatusBadRequest, magicLinkErrorCode(err))
+		return
+	}
+
+	fsClient := c.MustGet("firestore").(*firestore.Client)
+	ref := fsClient.Collection("magic_links").Doc(util.HashToken(secret))
+
+	var email, invite, redirect string
+	err = fsClient.RunTransaction(c.Request.Context(), func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := tx.Get(ref)
+		if err != nil {
+			return errMagicLinkInvalid
+		}
+		data := snap.Data()
+		if data["usedAt"] != nil {
+			return errMagicLinkUsed
+		}
+		if exp, _ := data["expiresAt"].(time.Time); time.Now().After(exp) {
+			return errMagicLinkExpired
+		}
+		email = util.GetStringValue(data, "email")
+		invite = util.GetStringValue(data, "invite")
+		redirect = util.GetStringValue(data, "redirect")
+		return tx.Update(ref, []firestore.Update{{Path: "usedAt", Value: time.Now()}})
+	})
+	if err != nil {
+		if code := magicLinkErrorCode(err); code != "" {
+			renderLoginError(c, http.StatusBadRequest, code)
+			return
+		}
+		renderLoginError(c, http.StatusInternalServerError, "sign_in_failed")
+		return
+	}
+
+	// the link may be opened in another browser than the one that asked for it
+	session := sessions.Default(c)
+	session.Delete("oauth_link")
+	session.Set("oauth_redirect", redirect)
+	if invite != "" {
+		session.Set("invite", invite)
+	}
+
+	next, err := completeLogin(c, &auth.Identity{
+		Provider:      auth.ProviderEmail,
+		Subject:       email,
+		Email:         email,
+		EmailVerified: true,
+	})
+	if err != nil {
+		renderLoginError(c, http.StatusInternalServerError, "sign_in_failed")
+		return
+	}
+	c.Redirect(http.StatusFound, next)
+}
+
+func magicLinkErrorCode(err error) string {
+	switch {
+	case errors.Is(err, errMagicLinkExpired):
+		return "link_expired"
+	case errors.Is(err, errMagicLinkUsed):
+		return "link_used"
+	case errors.Is(err, errMagicLinkInvalid):
+		return "link_invalid"
+	}
+	return ""
+}
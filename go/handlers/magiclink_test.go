@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"cloud.google.com/go/firestore"
+	"github.com/gin-gonic/gin"
+
+	"calple/mailer"
+)
+
+func magicLinkRouter(fsClient *firestore.Client, m *mailer.Memory) *gin.Engine {
+	router := testRouter(fsClient)
+	router.Use(func(c *gin.Context) { c.Set("mailer", m) })
+	router.POST("/auth/magic/request", RequestMagicLink)
+	router.POST("/auth/magic/verify", VerifyMagicLink)
+	return router
+}
+
+func requestMagicLink(router http.Handler, email, ip string) int {
+	req := httptest.NewRequest(http.MethodPost, "/auth/magic/request", strings.NewReader(`{"email":"`+email+`"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.RemoteAddr = ip + ":1234"
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w.Code
+}
+
+func verifyMagicLink(router http.Handler, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/auth/magic/verify", strings.NewReader(url.Values{"token": {token}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+var magicLinkPattern = regexp.MustCompile(`token=(\S+)`)
+
+func TestMagicLinkSignIn(t *testing.T) {
+	fsClient := testFirestore(t)
+	m := &mailer.Memory{}
+	router := magicLinkRouter(fsClient, m)
+
+	if code := requestMagicLink(router, "Ann@Example.com", "192.0.2.1"); code != http.StatusOK {
+		t.Fatalf("request: %d", code)
+	}
+	sent := m.Sent()
+	if len(sent) != 1 || sent[0].To != "ann@example.com" {
+		t.Fatalf("sent %+v", sent)
+	}
+	match := magicLinkPattern.FindStringSubmatch(sent[0].Text)
+	if match == nil {
+		t.Fatalf("no link in %q", sent[0].Text)
+	}
+	token := match[1]
+
+	w := verifyMagicLink(router, token)
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "http://app.test" {
+		t.Fatalf("verify: %d %s", w.Code, w.Header().Get("Location"))
+	}
+	users, err := fsClient.Collection("users").Where("email", "==", "ann@example.com").Documents(context.Background()).GetAll()
+	if err != nil || len(users) != 1 {
+		t.Fatalf("users %d, %v", len(users), err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		code  string
+	}{
+		{"used", token, "link_used"},
+		{"tampered", token[:len(token)-2] + "xx", "link_invalid"},
+		{"garbage", "nope", "link_invalid"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := verifyMagicLink(router, tt.token)
+			if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), tt.code) {
+				t.Errorf("verify: %d, want 400 %s", w.Code, tt.code)
+			}
+		})
+	}
+}
+
+func TestMagicLinkLimits(t *testing.T) {
+	fsClient := testFirestore(t)
+	m := &mailer.Memory{}
+	router := magicLinkRouter(fsClient, m)
+
+	for i := 0; i < magicLinksPerEmail; i++ {
+		if code := requestMagicLink(router, "ann@example.com", "192.0.2.1"); code != http.StatusOK {
+			t.Fatalf("request %d: %d", i, code)
+		}
+	}
+	// the address is normalized before it is counted
+	if code := requestMagicLink(router, "ANN@example.com", "192.0.2.2"); code != http.StatusTooManyRequests {
+		t.Errorf("request over the address limit: %d", code)
+	}
+
+	// one client asking for many addresses
+	for i := 0; i < magicLinksPerIP; i++ {
+		requestMagicLink(router, fmt.Sprintf("user%d@example.com", i), "192.0.2.3")
+	}
+	if code := requestMagicLink(router, "last@example.com", "192.0.2.3"); code != http.StatusTooManyRequests {
+		t.Errorf("request over the IP limit: %d", code)
+	}
+	if n := len(m.Sent()); n != magicLinksPerEmail+magicLinksPerIP {
+		t.Errorf("sent %d mails, want %d", n, magicLinksPerEmail+magicLinksPerIP)
+	}
+}
+
+// requests racing for the last links of an address don't all get one
+func TestMagicLinkLimitConcurrent(t *testing.T) {
+	fsClient := testFirestore(t)
+	m := &mailer.Memory{}
+	router := magicLinkRouter(fsClient, m)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2*magicLinksPerEmail; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			requestMagicLink(router, "ann@example.com", "192.0.2.1")
+		}()
+	}
+	wg.Wait()
+	if n := len(m.Sent()); n != magicLinksPerEmail {
+		t.Errorf("sent %d mails, want %d", n, magicLinksPerEmail)
+	}
+}
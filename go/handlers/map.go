@@ -1,15 +1,16 @@
 package handlers
 
 import (
-	"context"
-	"fmt"
 	"net/http"
 	"time"
 
 	"cloud.google.com/go/firestore"
-	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
 	"google.golang.org/api/iterator"
+
+	"calple/apierr"
+	"calple/events"
+	"calple/trash"
 )
 
 type Pin struct {
@@ -25,25 +26,23 @@ type Pin struct {
 }
 
 type PinRequest struct {
-	Lat         float64 `json:"lat" binding:"required"`
-	Lng         float64 `json:"lng" binding:"required"`
-	Title       string  `json:"title" binding:"required"`
-	Description string  `json:"description"`
-	Location    string  `json:"location"`
-	Date        string  `json:"date" binding:"required"` // YYYY-MM-DD
+	Lat         float64 `json:"lat" binding:"latitude"`
+	Lng         float64 `json:"lng" binding:"longitude"`
+	Title       string  `json:"title" binding:"required,notblank,max=200"`
+	Description string  `json:"description" binding:"max=2000"`
+	Location    string  `json:"location" binding:"max=300"`
+	Date        string  `json:"date" binding:"required,datetime=2006-01-02"`
 }
 
 func GetPins(c *gin.Context) {
-	session := sessions.Default(c)
-	uidInter := session.Get("user_id")
-	if uidInter == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+	uid := currentUID(c)
+	if uid == "" {
+		apierr.Abort(c, apierr.Unauthorized("Unauthorized"))
 		return
 	}
-	uid := uidInter.(string)
 
 	fsClient := c.MustGet("firestore").(*firestore.Client)
-	ctx := context.Background()
+	ctx := c.Request.Context()
 
 	// helper to load pins from a user's subcollection
 	loadPins := func(userID string) ([]Pin, error) {
@@ -62,6 +61,9 @@ func GetPins(c *gin.Context) {
 				}
 				return nil, err
 			}
+			if trash.Trashed(doc.Data()) {
+				continue
+			}
 			var p Pin
 			if err := doc.DataTo(&p); err != nil {
 				return nil, err
@@ -75,7 +77,7 @@ func GetPins(c *gin.Context) {
 	// load own pins
 	userPins, err := loadPins(uid)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load user pins"})
+		apierr.Abort(c, apierr.Internal("Failed to load user pins").WithCause(err))
 		return
 	}
 
@@ -87,7 +89,7 @@ func GetPins(c *gin.Context) {
 		Documents(ctx).
 		GetAll()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query connections"})
+		apierr.Abort(c, apierr.Internal("Failed to query connections").WithCause(err))
 		return
 	}
 
@@ -98,7 +100,7 @@ func GetPins(c *gin.Context) {
 		}
 	}
 
-	fmt.Printf("DEBUG: Loaded %d user pins and %d partner pins\n", len(userPins), len(partnerPins))
+	requestLogger(c).Debug("loaded pins", "user", len(userPins), "partner", len(partnerPins))
 
 	c.JSON(http.StatusOK, gin.H{
 		"pins":        userPins,
@@ -107,22 +109,20 @@ func GetPins(c *gin.Context) {
 }
 
 func CreatePin(c *gin.Context) {
-	session := sessions.Default(c)
-	uidInter := session.Get("user_id")
-	if uidInter == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+	uid := currentUID(c)
+	if uid == "" {
+		apierr.Abort(c, apierr.Unauthorized("Unauthorized"))
 		return
 	}
-	uid := uidInter.(string)
 
 	var req PinRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apierr.Abort(c, apierr.FromBinding(err))
 		return
 	}
 
 	fsClient := c.MustGet("firestore").(*firestore.Client)
-	ctx := context.Background()
+	ctx := c.Request.Context()
 
 	now := time.Now()
 	newPin := map[string]interface{}{
@@ -141,31 +141,30 @@ func CreatePin(c *gin.Context) {
 		Collection("pins").
 		Add(ctx, newPin)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create pin"})
+		apierr.Abort(c, apierr.Internal("Failed to create pin").WithCause(err))
 		return
 	}
 
+	publishToCouple(c, fsClient, uid, events.PinCreated, events.Change{ID: docRef.ID, UserID: uid})
 	c.JSON(http.StatusCreated, gin.H{"id": docRef.ID})
 }
 
 func UpdatePin(c *gin.Context) {
-	session := sessions.Default(c)
-	uidInter := session.Get("user_id")
-	if uidInter == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+	uid := currentUID(c)
+	if uid == "" {
+		apierr.Abort(c, apierr.Unauthorized("Unauthorized"))
 		return
 	}
-	uid := uidInter.(string)
 
 	pinID := c.Param("id")
 	var req PinRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apierr.Abort(c, apierr.FromBinding(err))
 		return
 	}
 
 	fsClient := c.MustGet("firestore").(*firestore.Client)
-	ctx := context.Background()
+	ctx := c.Request.Context()
 
 	updates := []firestore.Update{
 		{Path: "lat", Value: req.Lat},
@@ -177,40 +176,40 @@ func UpdatePin(c *gin.Context) {
 		{Path: "updatedAt", Value: time.Now()},
 	}
 
-	_, err := fsClient.Collection("users").
-		Doc(uid).
-		Collection("pins").
-		Doc(pinID).
-		Update(ctx, updates)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update pin"})
+	pinRef := fsClient.Collection("users").Doc(uid).Collection("pins").Doc(pinID)
+	if snap, err := pinRef.Get(ctx); err != nil || trash.Trashed(snap.Data()) {
+		apierr.Abort(c, apierr.NotFound("Pin not found"))
+		return
+	}
+	if _, err := pinRef.Update(ctx, updates); err != nil {
+		apierr.Abort(c, apierr.Internal("Failed to update pin").WithCause(err))
 		return
 	}
+	publishToCouple(c, fsClient, uid, events.PinUpdated, events.Change{ID: pinID, UserID: uid})
 	c.Status(http.StatusNoContent)
 }
 
-// DeletePin removes a pin by ID.
+// DeletePin moves a pin to the trash.
 func DeletePin(c *gin.Context) {
-	session := sessions.Default(c)
-	uidInter := session.Get("user_id")
-	if uidInter == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+	uid := currentUID(c)
+	if uid == "" {
+		apierr.Abort(c, apierr.Unauthorized("Unauthorized"))
 		return
 	}
-	uid := uidInter.(string)
 
 	pinID := c.Param("id")
 	fsClient := c.MustGet("firestore").(*firestore.Client)
-	ctx := context.Background()
+	ctx := c.Request.Context()
 
-	_, err := fsClient.Collection("users").
-		Doc(uid).
-		Collection("pins").
-		Doc(pinID).
-		Delete(ctx)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete pin"})
+	pinRef := fsClient.Collection("users").Doc(uid).Collection("pins").Doc(pinID)
+	if snap, err := pinRef.Get(ctx); err != nil || trash.Trashed(snap.Data()) {
+		apierr.Abort(c, apierr.NotFound("Pin not found"))
+		return
+	}
+	if _, err := pinRef.Update(ctx, trash.Delete(uid, time.Now())); err != nil {
+		apierr.Abort(c, apierr.Internal("Failed to delete pin").WithCause(err))
 		return
 	}
+	publishToCouple(c, fsClient, uid, events.PinDeleted, events.Change{ID: pinID, UserID: uid})
 	c.Status(http.StatusNoContent)
 }
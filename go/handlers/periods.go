@@ -1,28 +1,28 @@
 package handlers
 
 import (
+	"calple/apierr"
+	"calple/events"
+	"calple/secrets"
 	"calple/util"
-	"context"
-	"fmt"
 	"net/http"
 	"time"
 
 	"cloud.google.com/go/firestore"
-	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
 )
 
 type PeriodDay struct {
 	ID             string    `json:"id"`
 	UserID         string    `json:"userId"`
-	Date           string    `json:"date"`
+	Date           string    `json:"date" binding:"required,datetime=2006-01-02"`
 	IsPeriod       bool      `json:"isPeriod"`
-	Symptoms       []string  `json:"symptoms"`
-	CrampIntensity int64     `json:"crampIntensity"`
-	Mood           []string  `json:"mood"`
-	Activities     []string  `json:"activities"`
-	SexActivity    []string  `json:"sexActivity"`
-	Notes          string    `json:"notes"`
+	Symptoms       []string  `json:"symptoms" binding:"max=30,dive,max=50"`
+	CrampIntensity int64     `json:"crampIntensity" binding:"min=0,max=10"`
+	Mood           []string  `json:"mood" binding:"max=30,dive,max=50"`
+	Activities     []string  `json:"activities" binding:"max=30,dive,max=50"`
+	SexActivity    []string  `json:"sexActivity" binding:"max=30,dive,max=50"`
+	Notes          string    `json:"notes" binding:"max=2000"`
 	CreatedAt      time.Time `json:"createdAt"`
 	UpdatedAt      time.Time `json:"updatedAt"`
 }
@@ -30,28 +30,27 @@ type PeriodDay struct {
 type CycleSettings struct {
 	ID           string    `json:"id"`
 	UserID       string    `json:"userId"`
-	CycleLength  int64     `json:"cycleLength"`
-	PeriodLength int64     `json:"periodLength"`
+	CycleLength  int64     `json:"cycleLength" binding:"min=20,max=45"`
+	PeriodLength int64     `json:"periodLength" binding:"min=1,max=10"`
 	CreatedAt    time.Time `json:"createdAt"`
 	UpdatedAt    time.Time `json:"updatedAt"`
 }
 
 func GetPeriodDays(c *gin.Context) {
-	session := sessions.Default(c)
-	uid := session.Get("user_id")
-	if uid == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+	uid := currentUID(c)
+	if uid == "" {
+		apierr.Abort(c, apierr.Unauthorized("Unauthorized"))
 		return
 	}
 
 	fsClient := c.MustGet("firestore").(*firestore.Client)
-	ctx := context.Background()
+	ctx := c.Request.Context()
 
-	docs, err := fsClient.Collection("users").Doc(uid.(string)).Collection("periodDays").
+	docs, err := fsClient.Collection("users").Doc(uid).Collection("periodDays").
 		Documents(ctx).GetAll()
 
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch period days"})
+		apierr.Abort(c, apierr.Internal("Failed to fetch period days").WithCause(err))
 		return
 	}
 
@@ -59,26 +58,19 @@ func GetPeriodDays(c *gin.Context) {
 	for _, doc := range docs {
 		data := doc.Data()
 
-		var crampIntensity int64
-		if val, ok := data["crampIntensity"]; ok {
-			if intensity, ok := val.(int64); ok {
-				crampIntensity = intensity
-			}
-		}
-
 		periodDays = append(periodDays, PeriodDay{
 			ID:             doc.Ref.ID,
-			UserID:         uid.(string),
-			Date:           data["date"].(string),
-			IsPeriod:       data["isPeriod"].(bool),
+			UserID:         uid,
+			Date:           util.GetStringValue(data, "date"),
+			IsPeriod:       util.GetBoolValue(data, "isPeriod"),
 			Symptoms:       util.ToStringSlice(data["symptoms"]),
-			CrampIntensity: crampIntensity,
+			CrampIntensity: util.GetInt64Value(data, "crampIntensity"),
 			Mood:           util.ToStringSlice(data["mood"]),
 			Activities:     util.ToStringSlice(data["activities"]),
 			SexActivity:    util.ToStringSlice(data["sexActivity"]),
-			Notes:          data["notes"].(string),
-			CreatedAt:      data["createdAt"].(time.Time),
-			UpdatedAt:      data["updatedAt"].(time.Time),
+			Notes:          util.GetStringValue(data, "notes"),
+			CreatedAt:      util.GetTimeValue(data, "createdAt"),
+			UpdatedAt:      util.GetTimeValue(data, "updatedAt"),
 		})
 	}
 
@@ -86,103 +78,58 @@ func GetPeriodDays(c *gin.Context) {
 }
 
 func GetPartnerPeriodDays(c *gin.Context) {
-	session := sessions.Default(c)
-	uid := session.Get("user_id")
-	if uid == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+	uid := currentUID(c)
+	if uid == "" {
+		apierr.Abort(c, apierr.Unauthorized("Unauthorized"))
 		return
 	}
 
 	fsClient := c.MustGet("firestore").(*firestore.Client)
-	ctx := context.Background()
-
-	userDoc, err := fsClient.Collection("users").Doc(uid.(string)).Get(ctx)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve user"})
-		return
-	}
-	userEmail := userDoc.Data()["email"].(string)
-
-	connectionDocs, err := fsClient.Collection("users").Doc(uid.(string)).Collection("connections").
-		Where("status", "==", "active").
-		Documents(ctx).GetAll()
+	ctx := c.Request.Context()
 
-	if err != nil || len(connectionDocs) == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "No active connection found"})
+	partnerUID, err := activePartnerUID(ctx, fsClient, uid)
+	if err != nil || partnerUID == "" {
+		apierr.Abort(c, apierr.NotFound("No active connection found"))
 		return
 	}
 
-	connectionData := connectionDocs[0].Data()
-	partnerEmail := connectionData["partnerEmail"].(string)
-
-	partnerDocs, err := fsClient.Collection("users").
-		Where("email", "==", partnerEmail).
-		Documents(ctx).GetAll()
-
-	if err != nil || len(partnerDocs) == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Partner not found"})
-		return
-	}
-
-	partnerUID := partnerDocs[0].Ref.ID
-	fmt.Printf("DEBUG: GetPartnerPeriodDays - userEmail: %s, partnerEmail: %s, partnerUID: %s\n", userEmail, partnerEmail, partnerUID)
-
 	var userSex string
-	userDoc, userErr := fsClient.Collection("users").Doc(uid.(string)).Get(ctx)
+	userDoc, userErr := fsClient.Collection("users").Doc(uid).Get(ctx)
 	if userErr == nil {
 		if sex, ok := userDoc.Data()["sex"].(string); ok {
 			userSex = sex
 		}
 	}
 
-	var partnerSex string
-	partnerDoc, partnerErr := fsClient.Collection("users").Doc(partnerUID).Get(ctx)
-	if partnerErr == nil {
-		if sex, ok := partnerDoc.Data()["sex"].(string); ok {
-			partnerSex = sex
-		}
-	}
-
-	fmt.Printf("DEBUG: User sex: %s, Partner sex: %s\n", userSex, partnerSex)
-
 	docs, err := fsClient.Collection("users").Doc(partnerUID).Collection("periodDays").
 		Documents(ctx).GetAll()
 
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch partner period days"})
+		apierr.Abort(c, apierr.Internal("Failed to fetch partner period days").WithCause(err))
 		return
 	}
 
-	fmt.Printf("DEBUG: Found %d partner period days\n", len(docs))
-
 	periodDays := []PeriodDay{}
 	for _, doc := range docs {
 		data := doc.Data()
 
-		var crampIntensity int64
-		if val, ok := data["crampIntensity"]; ok {
-			if intensity, ok := val.(int64); ok {
-				crampIntensity = intensity
-			}
-		}
-
 		periodDays = append(periodDays, PeriodDay{
 			ID:             doc.Ref.ID,
 			UserID:         partnerUID,
-			Date:           data["date"].(string),
-			IsPeriod:       data["isPeriod"].(bool),
+			Date:           util.GetStringValue(data, "date"),
+			IsPeriod:       util.GetBoolValue(data, "isPeriod"),
 			Symptoms:       util.ToStringSlice(data["symptoms"]),
-			CrampIntensity: crampIntensity,
+			CrampIntensity: util.GetInt64Value(data, "crampIntensity"),
 			Mood:           util.ToStringSlice(data["mood"]),
 			Activities:     util.ToStringSlice(data["activities"]),
 			SexActivity:    util.ToStringSlice(data["sexActivity"]),
-			Notes:          data["notes"].(string),
-			CreatedAt:      data["createdAt"].(time.Time),
-			UpdatedAt:      data["updatedAt"].(time.Time),
+			Notes:          util.GetStringValue(data, "notes"),
+			CreatedAt:      util.GetTimeValue(data, "createdAt"),
+			UpdatedAt:      util.GetTimeValue(data, "updatedAt"),
 		})
 	}
 
-	fmt.Printf("DEBUG: Returning %d period days\n", len(periodDays))
+	requestLogger(c).Debug("loaded partner period days", "count", len(periodDays))
 
 	c.JSON(http.StatusOK, gin.H{
 		"periodDays": periodDays,
@@ -191,33 +138,27 @@ func GetPartnerPeriodDays(c *gin.Context) {
 }
 
 func CreatePeriodDay(c *gin.Context) {
-	session := sessions.Default(c)
-	uid := session.Get("user_id")
-	if uid == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+	uid := currentUID(c)
+	if uid == "" {
+		apierr.Abort(c, apierr.Unauthorized("Unauthorized"))
 		return
 	}
 
 	fsClient := c.MustGet("firestore").(*firestore.Client)
-	ctx := context.Background()
+	ctx := c.Request.Context()
 
 	var periodDay PeriodDay
 	if err := c.ShouldBindJSON(&periodDay); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
-		return
-	}
-
-	if len(periodDay.Date) != 10 || periodDay.Date[4] != '-' || periodDay.Date[7] != '-' {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid date format. Use YYYY-MM-DD"})
+		apierr.Abort(c, apierr.FromBinding(err))
 		return
 	}
 
-	existingDocs, err := fsClient.Collection("users").Doc(uid.(string)).Collection("periodDays").
+	existingDocs, err := fsClient.Collection("users").Doc(uid).Collection("periodDays").
 		Where("date", "==", periodDay.Date).
 		Documents(ctx).GetAll()
 
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check existing period day"})
+		apierr.Abort(c, apierr.Internal("Failed to check existing period day").WithCause(err))
 		return
 	}
 
@@ -235,27 +176,28 @@ func CreatePeriodDay(c *gin.Context) {
 
 		_, err = existingDocs[0].Ref.Set(ctx, updateData, firestore.MergeAll)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update period day"})
+			apierr.Abort(c, apierr.Internal("Failed to update period day").WithCause(err))
 			return
 		}
 
 		updatedDoc, err := existingDocs[0].Ref.Get(ctx)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve updated period day"})
+			apierr.Abort(c, apierr.Internal("Failed to retrieve updated period day").WithCause(err))
 			return
 		}
 
 		var updatedPeriodDay PeriodDay
 		updatedDoc.DataTo(&updatedPeriodDay)
 		updatedPeriodDay.ID = updatedDoc.Ref.ID
-		updatedPeriodDay.UserID = uid.(string)
+		updatedPeriodDay.UserID = uid
 
+		publishToCouple(c, fsClient, uid, events.PeriodDaySaved, events.Change{ID: updatedPeriodDay.ID, Date: periodDay.Date, UserID: uid})
 		c.JSON(http.StatusOK, updatedPeriodDay)
 		return
 	}
 
 	now := time.Now()
-	docRef, _, err := fsClient.Collection("users").Doc(uid.(string)).Collection("periodDays").Add(ctx, map[string]interface{}{
+	docRef, _, err := fsClient.Collection("users").Doc(uid).Collection("periodDays").Add(ctx, map[string]interface{}{
 		"date":           periodDay.Date,
 		"isPeriod":       periodDay.IsPeriod,
 		"symptoms":       periodDay.Symptoms,
@@ -269,13 +211,15 @@ func CreatePeriodDay(c *gin.Context) {
 	})
 
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create period day"})
+		apierr.Abort(c, apierr.Internal("Failed to create period day").WithCause(err))
 		return
 	}
 
+	publishToCouple(c, fsClient, uid, events.PeriodDaySaved, events.Change{ID: docRef.ID, Date: periodDay.Date, UserID: uid})
+
 	c.JSON(http.StatusCreated, PeriodDay{
 		ID:             docRef.ID,
-		UserID:         uid.(string),
+		UserID:         uid,
 		Date:           periodDay.Date,
 		IsPeriod:       periodDay.IsPeriod,
 		Symptoms:       periodDay.Symptoms,
@@ -290,68 +234,69 @@ func CreatePeriodDay(c *gin.Context) {
 }
 
 func DeletePeriodDay(c *gin.Context) {
-	session := sessions.Default(c)
-	uid := session.Get("user_id")
-	if uid == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+	uid := currentUID(c)
+	if uid == "" {
+		apierr.Abort(c, apierr.Unauthorized("Unauthorized"))
 		return
 	}
 
 	fsClient := c.MustGet("firestore").(*firestore.Client)
-	ctx := context.Background()
+	ctx := c.Request.Context()
 
-	date := c.Param("date")
-	if date == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Date parameter is required"})
+	var param dateParam
+	if err := c.ShouldBindUri(&param); err != nil {
+		apierr.Abort(c, apierr.FromBinding(err))
 		return
 	}
+	date := param.Date
 
-	docs, err := fsClient.Collection("users").Doc(uid.(string)).Collection("periodDays").
+	docs, err := fsClient.Collection("users").Doc(uid).Collection("periodDays").
 		Where("date", "==", date).
 		Documents(ctx).GetAll()
 
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to find period day"})
+		apierr.Abort(c, apierr.Internal("Failed to find period day").WithCause(err))
 		return
 	}
 
 	if len(docs) == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Period day not found"})
+		apierr.Abort(c, apierr.NotFound("Period day not found"))
 		return
 	}
 
 	_, err = docs[0].Ref.Delete(ctx)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete period day"})
+		apierr.Abort(c, apierr.Internal("Failed to delete period day").WithCause(err))
 		return
 	}
 
+	publishToCouple(c, fsClient, uid, events.PeriodDayDeleted, events.Change{ID: docs[0].Ref.ID, Date: date, UserID: uid})
+
 	c.JSON(http.StatusOK, gin.H{"message": "Period day deleted successfully"})
 }
 
 func GetCycleSettings(c *gin.Context) {
-	session := sessions.Default(c)
-	uid := session.Get("user_id")
-	if uid == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+	uid := currentUID(c)
+	if uid == "" {
+		apierr.Abort(c, apierr.Unauthorized("Unauthorized"))
 		return
 	}
 
 	fsClient := c.MustGet("firestore").(*firestore.Client)
-	ctx := context.Background()
+	ctx := c.Request.Context()
 
-	docs, err := fsClient.Collection("users").Doc(uid.(string)).Collection("cycleSettings").
+	docs, err := fsClient.Collection("users").Doc(uid).Collection("cycleSettings").
 		Documents(ctx).GetAll()
 
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch cycle settings"})
+		apierr.Abort(c, apierr.Internal("Failed to fetch cycle settings").WithCause(err))
 		return
 	}
 
 	if len(docs) == 0 {
 		c.JSON(http.StatusOK, gin.H{
 			"cycleSettings": CycleSettings{
-				UserID:       uid.(string),
+				UserID:       uid,
 				CycleLength:  28,
 				PeriodLength: 5,
 			},
@@ -362,48 +307,37 @@ func GetCycleSettings(c *gin.Context) {
 	data := docs[0].Data()
 	settings := CycleSettings{
 		ID:           docs[0].Ref.ID,
-		UserID:       uid.(string),
-		CycleLength:  int64(data["cycleLength"].(int64)),
-		PeriodLength: int64(data["periodLength"].(int64)),
-		CreatedAt:    data["createdAt"].(time.Time),
-		UpdatedAt:    data["updatedAt"].(time.Time),
+		UserID:       uid,
+		CycleLength:  util.GetInt64Value(data, "cycleLength"),
+		PeriodLength: util.GetInt64Value(data, "periodLength"),
+		CreatedAt:    util.GetTimeValue(data, "createdAt"),
+		UpdatedAt:    util.GetTimeValue(data, "updatedAt"),
 	}
 
 	c.JSON(http.StatusOK, gin.H{"cycleSettings": settings})
 }
 
 func UpdateCycleSettings(c *gin.Context) {
-	session := sessions.Default(c)
-	uid := session.Get("user_id")
-	if uid == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+	uid := currentUID(c)
+	if uid == "" {
+		apierr.Abort(c, apierr.Unauthorized("Unauthorized"))
 		return
 	}
 
 	fsClient := c.MustGet("firestore").(*firestore.Client)
-	ctx := context.Background()
+	ctx := c.Request.Context()
 
 	var settings CycleSettings
 	if err := c.ShouldBindJSON(&settings); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
-		return
-	}
-
-	if settings.CycleLength < 20 || settings.CycleLength > 45 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Cycle length must be between 20 and 45 days"})
-		return
-	}
-
-	if settings.PeriodLength < 1 || settings.PeriodLength > 10 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Period length must be between 1 and 10 days"})
+		apierr.Abort(c, apierr.FromBinding(err))
 		return
 	}
 
-	docs, err := fsClient.Collection("users").Doc(uid.(string)).Collection("cycleSettings").
+	docs, err := fsClient.Collection("users").Doc(uid).Collection("cycleSettings").
 		Documents(ctx).GetAll()
 
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check existing settings"})
+		apierr.Abort(c, apierr.Internal("Failed to check existing settings").WithCause(err))
 		return
 	}
 
@@ -415,65 +349,64 @@ func UpdateCycleSettings(c *gin.Context) {
 			{Path: "updatedAt", Value: now},
 		})
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update cycle settings"})
+			apierr.Abort(c, apierr.Internal("Failed to update cycle settings").WithCause(err))
 			return
 		}
 	} else {
-		docRef, _, err := fsClient.Collection("users").Doc(uid.(string)).Collection("cycleSettings").Add(ctx, map[string]interface{}{
+		docRef, _, err := fsClient.Collection("users").Doc(uid).Collection("cycleSettings").Add(ctx, map[string]interface{}{
 			"cycleLength":  settings.CycleLength,
 			"periodLength": settings.PeriodLength,
 			"createdAt":    now,
 			"updatedAt":    now,
 		})
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create cycle settings"})
+			apierr.Abort(c, apierr.Internal("Failed to create cycle settings").WithCause(err))
 			return
 		}
 		settings.ID = docRef.ID
 	}
 
-	settings.UserID = uid.(string)
+	settings.UserID = uid
 	settings.UpdatedAt = now
 
 	c.JSON(http.StatusOK, gin.H{"cycleSettings": settings})
 }
 
 func DebugConnection(c *gin.Context) {
-	session := sessions.Default(c)
-	uid := session.Get("user_id")
-	if uid == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+	uid := currentUID(c)
+	if uid == "" {
+		apierr.Abort(c, apierr.Unauthorized("Unauthorized"))
 		return
 	}
 
 	fsClient := c.MustGet("firestore").(*firestore.Client)
-	ctx := context.Background()
+	ctx := c.Request.Context()
 
-	userDoc, err := fsClient.Collection("users").Doc(uid.(string)).Get(ctx)
+	userDoc, err := fsClient.Collection("users").Doc(uid).Get(ctx)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user data"})
+		apierr.Abort(c, apierr.Internal("Failed to fetch user data").WithCause(err))
 		return
 	}
-	userEmail := userDoc.Data()["email"].(string)
+	userEmail := util.GetStringValue(userDoc.Data(), "email")
 
-	connectionDocs, err := fsClient.Collection("users").Doc(uid.(string)).Collection("connections").
+	connectionDocs, err := fsClient.Collection("users").Doc(uid).Collection("connections").
 		Where("status", "==", "active").
 		Documents(ctx).GetAll()
 
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch connections"})
+		apierr.Abort(c, apierr.Internal("Failed to fetch connections").WithCause(err))
 		return
 	}
 
 	debugInfo := map[string]interface{}{
-		"userId":        uid.(string),
+		"userId":        uid,
 		"userEmail":     userEmail,
 		"hasConnection": len(connectionDocs) > 0,
 	}
 
 	if len(connectionDocs) > 0 {
 		connectionData := connectionDocs[0].Data()
-		debugInfo["connection"] = connectionData
+		debugInfo["connection"] = secrets.Scrub(connectionData)
 		debugInfo["connectionId"] = connectionDocs[0].Ref.ID
 	}
 
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"time"
+
+	"cloud.google.com/go/firestore"
+
+	"calple/util"
+)
+
+// users docs are never returned as is. each audience gets a projection that
+// lists its fields explicitly, so a field added to the doc later stays
+// private until it is added here.
+
+// SelfProfile is what users see about themselves
+type SelfProfile struct {
+	ID            string     `json:"id"`
+	Email         string     `json:"email"`
+	Name          string     `json:"name"`
+	Sex           string     `json:"sex,omitempty"`
+	StartedDating *string    `json:"startedDating"`
+	ReturningUser bool       `json:"returning_user"`
+	CreatedAt     *time.Time `json:"created_at,omitempty"`
+	UpdatedAt     *time.Time `json:"updatedAt,omitempty"`
+	LastLoginAt   *time.Time `json:"last_login_at,omitempty"`
+}
+
+// PartnerProfile is what the active partner sees
+type PartnerProfile struct {
+	ID            string  `json:"id"`
+	Email         string  `json:"email"`
+	Name          string  `json:"name"`
+	Sex           string  `json:"sex,omitempty"`
+	StartedDating *string `json:"startedDating"`
+}
+
+// PublicProfile is what anyone who interacts with the user sees, e.g. the
+// receiver of an invitation
+type PublicProfile struct {
+	ID    string `json:"id"`
+	Email string `json:"email"`
+	Name  string `json:"name"`
+}
+
+func selfProfile(doc *firestore.DocumentSnapshot) SelfProfile {
+	data := doc.Data()
+	returning, _ := data["returning_user"].(bool)
+	return SelfProfile{
+		ID:            doc.Ref.ID,
+		Email:         util.GetStringValue(data, "email"),
+		Name:          util.GetStringValue(data, "name"),
+		Sex:           util.GetStringValue(data, "sex"),
+		StartedDating: optionalString(data, "startedDating"),
+		ReturningUser: returning,
+		CreatedAt:     optionalTime(data, "created_at"),
+		UpdatedAt:     optionalTime(data, "updatedAt"),
+		LastLoginAt:   optionalTime(data, "last_login_at"),
+	}
+}
+
+func partnerProfile(doc *firestore.DocumentSnapshot) PartnerProfile {
+	data := doc.Data()
+	return PartnerProfile{
+		ID:            doc.Ref.ID,
+		Email:         util.GetStringValue(data, "email"),
+		Name:          util.GetStringValue(data, "name"),
+		Sex:           util.GetStringValue(data, "sex"),
+		StartedDating: optionalString(data, "startedDating"),
+	}
+}
+
+func publicProfile(doc *firestore.DocumentSnapshot) PublicProfile {
+	data := doc.Data()
+	return PublicProfile{
+		ID:    doc.Ref.ID,
+		Email: util.GetStringValue(data, "email"),
+		Name:  util.GetStringValue(data, "name"),
+	}
+}
+
+// optionalString keeps the difference between an unset and an empty field
+func optionalString(data map[string]interface{}, key string) *string {
+	s, ok := data[key].(string)
+	if !ok {
+		return nil
+	}
+	return &s
+}
+
+func optionalTime(data map[string]interface{}, key string) *time.Time {
+	t, ok := data[key].(time.Time)
+	if !ok {
+		return nil
+	}
+	return &t
+}
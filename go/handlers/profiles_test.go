@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProfileFields(t *testing.T) {
+	fsClient := testFirestore(t)
+	ctx := context.Background()
+
+	now := time.Now()
+	ref := fsClient.Collection("users").Doc("u1")
+	_, err := ref.Set(ctx, map[string]interface{}{
+		"email":          "ann@example.com",
+		"name":           "Ann",
+		"sex":            "female",
+		"startedDating":  "2023-02-14",
+		"returning_user": true,
+		"created_at":     now,
+		"updatedAt":      now,
+		"last_login_at":  now,
+		// never part of any profile
+		"tokens":       map[string]interface{}{"access_token": "secret"},
+		"pushTokens":   []interface{}{"device"},
+		"partnerEmail": "bob@example.com",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err := ref.Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		profile interface{}
+		fields  string
+	}{
+		{"self", selfProfile(doc), "created_at email id last_login_at name returning_user sex startedDating updatedAt"},
+		{"partner", partnerProfile(doc), "email id name sex startedDating"},
+		{"public", publicProfile(doc), "email id name"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := jsonKeys(t, tt.profile); got != tt.fields {
+				t.Errorf("fields = %q, want %q", got, tt.fields)
+			}
+		})
+	}
+}
+
+func TestProfileUnsetFields(t *testing.T) {
+	fsClient := testFirestore(t)
+	ctx := context.Background()
+
+	ref := fsClient.Collection("users").Doc("u2")
+	if _, err := ref.Set(ctx, map[string]interface{}{"email": "cy@example.com", "name": "Cy"}); err != nil {
+		t.Fatal(err)
+	}
+	doc, err := ref.Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		profile interface{}
+		fields  string
+	}{
+		// startedDating stays, null tells the client it was never set
+		{"self", selfProfile(doc), "email id name returning_user startedDating"},
+		{"partner", partnerProfile(doc), "email id name startedDating"},
+		{"public", publicProfile(doc), "email id name"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := jsonKeys(t, tt.profile); got != tt.fields {
+				t.Errorf("fields = %q, want %q", got, tt.fields)
+			}
+		})
+	}
+}
+
+// the endpoints return the projection of their audience and nothing else
+// of the partner's or inviter's document
+func TestProfileEndpoints(t *testing.T) {
+	fsClient := testFirestore(t)
+	ctx := context.Background()
+
+	now := time.Now()
+	for uid, name := range map[string]string{"ann": "Ann", "bob": "Bob", "cy": "Cy"} {
+		_, err := fsClient.Collection("users").Doc(uid).Set(ctx, map[string]interface{}{
+			"email":          uid + "@example.com",
+			"name":           name,
+			"sex":            "female",
+			"startedDating":  "2023-02-14",
+			"returning_user": true,
+			"created_at":     now,
+			"updatedAt":      now,
+			"last_login_at":  now,
+			"tokens":         map[string]interface{}{"access_token": "secret"},
+			"pushTokens":     []interface{}{"device"},
+			"partnerEmail":   "someone@example.com",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	connect := func(id, a, b, status string) {
+		t.Helper()
+		for _, side := range [][3]string{{a, b, "initiator"}, {b, a, "receiver"}} {
+			_, err := fsClient.Collection("users").Doc(side[0]).Collection("connections").Doc(id).Set(ctx, map[string]interface{}{
+				"partnerUID":   side[1],
+				"partnerEmail": side[1] + "@example.com",
+				"role":         side[2],
+				"status":       status,
+				"createdAt":    now,
+				"expiresAt":    now.Add(pendingInvitationTTL),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	connect("ann-bob", "ann", "bob", "active")
+	connect("cy-ann", "cy", "ann", "pending")
+
+	router := testRouter(fsClient)
+	router.GET("/auth/status", AuthStatus)
+	router.GET("/connection", GetConnection)
+	router.GET("/user/partner", GetPartnerMetadata)
+	router.GET("/connection/pending", GetPendingInvitations)
+
+	// get returns what ann is answered, decoded
+	get := func(path string) map[string]interface{} {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set(testUIDHeader, "ann")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var body map[string]interface{}
+		if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &body) != nil {
+			t.Fatalf("%s: %d %s", path, w.Code, w.Body)
+		}
+		return body
+	}
+
+	invitations, _ := get("/connection/pending")["invitations"].([]interface{})
+	if len(invitations) != 1 {
+		t.Fatalf("invitations %v", invitations)
+	}
+	tests := []struct {
+		name   string
+		value  interface{}
+		fields string
+	}{
+		{"auth status", get("/auth/status")["user"], "created_at email id last_login_at name returning_user sex startedDating updatedAt"},
+		{"connection", get("/connection")["partner"], "email id name sex startedDating"},
+		{"partner metadata", get("/user/partner")["partnerMetadata"], "email id name sex startedDating"},
+		{"pending invitation", invitations[0], "createdAt from_email from_name id role"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := jsonKeys(t, tt.value); got != tt.fields {
+				t.Errorf("fields = %q, want %q", got, tt.fields)
+			}
+		})
+	}
+	if inviter := invitations[0].(map[string]interface{}); inviter["from_email"] != "cy@example.com" || inviter["from_name"] != "Cy" {
+		t.Errorf("invitation %v", inviter)
+	}
+}
+
+// jsonKeys are the sorted top level keys v encodes to
+func jsonKeys(t *testing.T, v interface{}) string {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(b, &m); err != nil {
+		t.Fatal(err)
+	}
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, " ")
+}
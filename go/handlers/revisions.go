@@ -0,0 +1,338 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"calple/apierr"
+	"calple/audit"
+	"calple/events"
+	"calple/trash"
+	"calple/util"
+)
+
+// every edit of an event bumps its version and is kept in the revisions
+// subcollection of the event, events from before versions existed are at
+// version 0. a client sends the version it edits in If-Match (or the
+// version field of the body) and gets a 409 when the event moved on.
+
+// ddayEditable are the fields an edit can change, what a revision keeps
+var ddayEditable = []string{"title", "group", "description", "date", "endDate", "imageUrl", "isAnnual"}
+
+// the most recent revisions listed
+const revisionListLimit = 100
+
+var (
+	errDDayNotFound = errors.New("dday not found")
+	errDDayNotOwner = errors.New("only the creator can edit the dday")
+)
+
+// errDDayVersion is an edit of an event that changed since the version the
+// client had
+type errDDayVersion struct {
+	current int64
+}
+
+func (e errDDayVersion) Error() string {
+	return fmt.Sprintf("dday is at version %d", e.current)
+}
+
+// DDayFields are the editable fields of an event at one version
+type DDayFields struct {
+	Title       string `json:"title" firestore:"title"`
+	Group       string `json:"group" firestore:"group"`
+	Description string `json:"description" firestore:"description"`
+	Date        string `json:"date" firestore:"date"`
+	EndDate     string `json:"endDate" firestore:"endDate"`
+	ImageURL    string `json:"imageUrl" firestore:"imageUrl"`
+	IsAnnual    bool   `json:"isAnnual" firestore:"isAnnual"`
+}
+
+func ddayFieldsOf(data map[string]interface{}) DDayFields {
+	return DDayFields{
+		Title:       util.GetStringValue(data, "title"),
+		Group:       util.GetStringValue(data, "group"),
+		Description: util.GetStringValue(data, "description"),
+		Date:        util.GetStringValue(data, "date"),
+		EndDate:     util.GetStringValue(data, "endDate"),
+		ImageURL:    util.GetStringValue(data, "imageUrl"),
+		IsAnnual:    util.GetBoolValue(data, "isAnnual"),
+	}
+}
+
+func (f DDayFields) updates() []firestore.Update {
+	return []firestore.Update{
+		{Path: "title", Value: f.Title},
+		{Path: "group", Value: f.Group},
+		{Path: "description", Value: f.Description},
+		{Path: "date", Value: f.Date},
+		{Path: "endDate", Value: f.EndDate},
+		{Path: "imageUrl", Value: f.ImageURL},
+		{Path: "isAnnual", Value: f.IsAnnual},
+	}
+}
+
+// DDayRevision is an event as one edit left it
+type DDayRevision struct {
+	Version int64      `json:"version" firestore:"version"`
+	DDay    DDayFields `json:"dday" firestore:"dday"`
+	// Changes against the version before, absent on the oldest revision
+	Changes  map[string]audit.Change `json:"changes,omitempty" firestore:"changes,omitempty"`
+	EditedBy string                  `json:"editedBy" firestore:"editedBy"`
+	EditedAt time.Time               `json:"editedAt" firestore:"editedAt"`
+	// RevertedFrom is the version the edit went back to
+	RevertedFrom *int64 `json:"revertedFrom,omitempty" firestore:"revertedFrom,omitempty"`
+}
+
+func revisionRef(ddayRef *firestore.DocumentRef, version int64) *firestore.DocumentRef {
+	return ddayRef.Collection("revisions").Doc(strconv.FormatInt(version, 10))
+}
+
+// ddayETag is the entity tag of an event at version
+func ddayETag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// expectedVersion is the version the request edits, from If-Match or else
+// from body, -1 when it sent neither and edits whatever is there
+func expectedVersion(c *gin.Context, body *int64) (int64, *apierr.Error) {
+	match := strings.TrimSpace(c.GetHeader("If-Match"))
+	if match == "" || match == "*" {
+		if body != nil {
+			return *body, nil
+		}
+		return -1, nil
+	}
+	v, err := strconv.ParseInt(strings.Trim(strings.TrimPrefix(match, "W/"), `"`), 10, 64)
+	if err != nil || v < 0 {
+		return 0, apierr.BadRequest("Invalid If-Match header")
+	}
+	if body != nil && *body != v {
+		return 0, apierr.BadRequest("If-Match and version disagree")
+	}
+	return v, nil
+}
+
+// editDDay applies updates to the event of uid at ref if it is still at
+// version expected (any version when it is -1), bumps the version and
+// records the revision. it returns the event before and after.
+func editDDay(ctx context.Context, fsClient *firestore.Client, ref *firestore.DocumentRef, uid string, expected int64, updates []firestore.Update, revertedFrom *int64) (before, after map[string]interface{}, err error) {
+	return changeDDay(ctx, fsClient, ref, uid, false, expected, updates, revertedFrom)
+}
+
+// restoreDDay takes the event of uid at ref out of the trash as a new
+// version, so edits based on the version before the delete conflict
+func restoreDDay(ctx context.Context, fsClient *firestore.Client, ref *firestore.DocumentRef, uid string) (before, after map[string]interface{}, err error) {
+	return changeDDay(ctx, fsClient, ref, uid, true, -1, trash.Restore(), nil)
+}
+
+// changeDDay is editDDay for an event that is in the trash or not
+func changeDDay(ctx context.Context, fsClient *firestore.Client, ref *firestore.DocumentRef, uid string, trashed bool, expected int64, updates []firestore.Update, revertedFrom *int64) (before, after map[string]interface{}, err error) {
+	err = fsClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := tx.Get(ref)
+		if err != nil || !snap.Exists() || trash.Trashed(snap.Data()) != trashed {
+			return errDDayNotFound
+		}
+		before = snap.Data()
+		if util.GetStringValue(before, "ownerUID") != uid {
+			return errDDayNotOwner
+		}
+		version := util.GetInt64Value(before, "version")
+		if expected >= 0 && expected != version {
+			return errDDayVersion{current: version}
+		}
+		// the version being edited has no revision when it was created, or
+		// edited before revisions were kept
+		base, err := tx.Get(revisionRef(ref, version))
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+
+		now := time.Now()
+		after = make(map[string]interface{}, len(before)+2)
+		for k, v := range before {
+			after[k] = v
+		}
+		// the transaction may run again, updates is left as it was
+		all := append(append([]firestore.Update{}, updates...),
+			firestore.Update{Path: "version", Value: version + 1},
+			firestore.Update{Path: "updatedAt", Value: now})
+		for _, u := range all {
+			if u.Value == firestore.Delete {
+				delete(after, u.Path)
+				continue
+			}
+			after[u.Path] = u.Value
+		}
+
+		if !base.Exists() {
+			if err := tx.Create(revisionRef(ref, version), DDayRevision{
+				Version:  version,
+				DDay:     ddayFieldsOf(before),
+				EditedBy: util.GetStringValue(before, "ownerUID"),
+				EditedAt: util.GetTimeValue(before, "updatedAt"),
+			}); err != nil {
+				return err
+			}
+		}
+		if err := tx.Update(ref, all); err != nil {
+			return err
+		}
+		return tx.Create(revisionRef(ref, version+1), DDayRevision{
+			Version:      version + 1,
+			DDay:         ddayFieldsOf(after),
+			Changes:      audit.Diff(before, after, ddayEditable...),
+			EditedBy:     uid,
+			EditedAt:     now,
+			RevertedFrom: revertedFrom,
+		})
+	})
+	return before, after, err
+}
+
+// abortDDayEdit answers a failed editDDay
+func abortDDayEdit(c *gin.Context, err error) {
+	var conflict errDDayVersion
+	switch {
+	case errors.Is(err, errDDayNotFound):
+		apierr.Abort(c, apierr.NotFound("D-Day not found"))
+	case errors.Is(err, errDDayNotOwner):
+		apierr.Abort(c, apierr.Forbidden("Only creator can update"))
+	case errors.As(err, &conflict):
+		c.Header("ETag", ddayETag(conflict.current))
+		apierr.Abort(c, apierr.Conflict(fmt.Sprintf("The event was changed in the meantime, it is at version %d", conflict.current)))
+	default:
+		apierr.Abort(c, apierr.Internal("Failed to update event").WithCause(err))
+	}
+}
+
+// ddayFromData is the response body of an event after an edit
+func ddayFromData(id string, data map[string]interface{}) DDay {
+	editable := true
+	if b, ok := data["editable"].(bool); ok {
+		editable = b
+	}
+	return DDay{
+		ID:             id,
+		Title:          util.GetStringValue(data, "title"),
+		Group:          util.GetStringValue(data, "group"),
+		Description:    util.GetStringValue(data, "description"),
+		Date:           util.GetStringValue(data, "date"),
+		EndDate:        util.GetStringValue(data, "endDate"),
+		ImageURL:       util.GetStringValue(data, "imageUrl"),
+		IsAnnual:       util.GetBoolValue(data, "isAnnual"),
+		CreatedBy:      util.GetStringValue(data, "createdBy"),
+		ConnectedUsers: util.ToStringSlice(data["connectedUsers"]),
+		OwnerUID:       util.GetStringValue(data, "ownerUID"),
+		SharedWith:     util.ToStringSlice(data["sharedWith"]),
+		CreatedAt:      util.GetTimeValue(data, "createdAt"),
+		UpdatedAt:      util.GetTimeValue(data, "updatedAt"),
+		Version:        util.GetInt64Value(data, "version"),
+		Editable:       editable,
+	}
+}
+
+// GetDDayRevisions lists the latest revisions of an event the user can see,
+// newest first
+func GetDDayRevisions(c *gin.Context) {
+	uid := currentUID(c)
+	if uid == "" {
+		apierr.Abort(c, apierr.Unauthorized("Unauthorized"))
+		return
+	}
+
+	fsClient := c.MustGet("firestore").(*firestore.Client)
+	ctx := c.Request.Context()
+
+	ddayRef := fsClient.Collection("ddays").Doc(c.Param("id"))
+	snap, err := ddayRef.Get(ctx)
+	if err != nil || !snap.Exists() || trash.Trashed(snap.Data()) {
+		apierr.Abort(c, apierr.NotFound("D-Day not found"))
+		return
+	}
+	data := snap.Data()
+	if util.GetStringValue(data, "ownerUID") != uid && !util.Contains(util.ToStringSlice(data["sharedWith"]), uid) {
+		apierr.Abort(c, apierr.NotFound("D-Day not found"))
+		return
+	}
+
+	docs, err := ddayRef.Collection("revisions").
+		OrderBy("version", firestore.Desc).
+		Limit(revisionListLimit).
+		Documents(ctx).GetAll()
+	if err != nil {
+		apierr.Abort(c, apierr.Internal("Failed to load the event history").WithCause(err))
+		return
+	}
+	revisions := make([]DDayRevision, 0, len(docs))
+	for _, doc := range docs {
+		var rev DDayRevision
+		if err := doc.DataTo(&rev); err != nil {
+			apierr.Abort(c, apierr.Internal("Failed to parse the event history").WithCause(err))
+			return
+		}
+		revisions = append(revisions, rev)
+	}
+
+	c.Header("ETag", ddayETag(util.GetInt64Value(data, "version")))
+	c.JSON(http.StatusOK, gin.H{"revisions": revisions})
+}
+
+// RevertDDay sets an event back to the fields of one of its revisions, as
+// a new revision
+func RevertDDay(c *gin.Context) {
+	uid := currentUID(c)
+	if uid == "" {
+		apierr.Abort(c, apierr.Unauthorized("Unauthorized"))
+		return
+	}
+
+	target, err := strconv.ParseInt(c.Param("version"), 10, 64)
+	if err != nil || target < 0 {
+		apierr.Abort(c, apierr.BadRequest("Invalid version"))
+		return
+	}
+	expected, apiErr := expectedVersion(c, nil)
+	if apiErr != nil {
+		apierr.Abort(c, apiErr)
+		return
+	}
+
+	fsClient := c.MustGet("firestore").(*firestore.Client)
+	ctx := c.Request.Context()
+
+	id := c.Param("id")
+	ddayRef := fsClient.Collection("ddays").Doc(id)
+	revSnap, err := revisionRef(ddayRef, target).Get(ctx)
+	if err != nil || !revSnap.Exists() {
+		apierr.Abort(c, apierr.NotFound("Revision not found"))
+		return
+	}
+	var rev DDayRevision
+	if err := revSnap.DataTo(&rev); err != nil {
+		apierr.Abort(c, apierr.Internal("Failed to parse the revision").WithCause(err))
+		return
+	}
+
+	before, after, err := editDDay(ctx, fsClient, ddayRef, uid, expected, rev.DDay.updates(), &target)
+	if err != nil {
+		abortDDayEdit(c, err)
+		return
+	}
+
+	sharedWith := util.ToStringSlice(before["sharedWith"])
+	publish(c, events.DDayUpdated, events.Change{ID: id, UserID: uid}, append(sharedWith, uid)...)
+	audited(c, fsClient, audit.DDayRevert, audit.Target{Type: "dday", ID: id}, audit.Diff(before, after, ddayEditable...), sharedWith...)
+	c.Header("ETag", ddayETag(util.GetInt64Value(after, "version")))
+	c.JSON(http.StatusOK, gin.H{"dday": ddayFromData(id, after)})
+}
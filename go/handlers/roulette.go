@@ -1,17 +1,18 @@
 package handlers
 
 import (
-	"context"
 	"net/http"
 
 	"cloud.google.com/go/firestore"
 	"github.com/gin-gonic/gin"
+
+	"calple/apierr"
 )
 
 type Roulette struct {
 	ID          string `json:"id"`
-	Title       string `json:"title"`
-	Description string `json:"description"`
+	Title       string `json:"title" binding:"required,notblank,max=200"`
+	Description string `json:"description" binding:"max=2000"`
 }
 
 // getAllpost; returns all posts from the database
@@ -21,10 +22,10 @@ func GetIdeaRoulette(c *gin.Context) {
 	// get firestore client from context
 	fsClient := c.MustGet("firestore").(*firestore.Client)
 
-	q1, err := fsClient.Collection("roulette").Documents(context.Background()).GetAll()
+	q1, err := fsClient.Collection("roulette").Documents(c.Request.Context()).GetAll()
 
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch posts"})
+		apierr.Abort(c, apierr.Internal("Failed to fetch posts").WithCause(err))
 		return
 	}
 
@@ -32,7 +33,7 @@ func GetIdeaRoulette(c *gin.Context) {
 	for _, doc := range q1 {
 		var roulette Roulette
 		if err := doc.DataTo(&roulette); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse post data"})
+			apierr.Abort(c, apierr.Internal("Failed to parse post data").WithCause(err))
 			return
 		}
 		roulette.ID = doc.Ref.ID
@@ -49,14 +50,14 @@ func AddIdeaRoulette(c *gin.Context) {
 
 	var roulette Roulette
 	if err := c.ShouldBindJSON(&roulette); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		apierr.Abort(c, apierr.FromBinding(err))
 		return
 	}
 
 	// add the new idea to the database
-	docRef, _, err := fsClient.Collection("roulette").Add(context.Background(), roulette)
+	docRef, _, err := fsClient.Collection("roulette").Add(c.Request.Context(), roulette)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add idea"})
+		apierr.Abort(c, apierr.Internal("Failed to add idea").WithCause(err))
 		return
 	}
 
@@ -72,14 +73,14 @@ func DeleteIdeaRoulette(c *gin.Context) {
 	// get the ID from the URL parameter
 	id := c.Param("id")
 	if id == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "ID is required"})
+		apierr.Abort(c, apierr.BadRequest("ID is required"))
 		return
 	}
 
 	// delete the idea from the database
-	_, err := fsClient.Collection("roulette").Doc(id).Delete(context.Background())
+	_, err := fsClient.Collection("roulette").Doc(id).Delete(c.Request.Context())
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete idea"})
+		apierr.Abort(c, apierr.Internal("Failed to delete idea").WithCause(err))
 		return
 	}
 
@@ -94,20 +95,20 @@ func EditIdeaRoulette(c *gin.Context) {
 	// get the ID from the URL parameter
 	id := c.Param("id")
 	if id == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "ID is required"})
+		apierr.Abort(c, apierr.BadRequest("ID is required"))
 		return
 	}
 
 	var roulette Roulette
 	if err := c.ShouldBindJSON(&roulette); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		apierr.Abort(c, apierr.FromBinding(err))
 		return
 	}
 
 	// update the idea in the database
-	_, err := fsClient.Collection("roulette").Doc(id).Set(context.Background(), roulette)
+	_, err := fsClient.Collection("roulette").Doc(id).Set(c.Request.Context(), roulette)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update idea"})
+		apierr.Abort(c, apierr.Internal("Failed to update idea").WithCause(err))
 		return
 	}
 
@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+
+	"calple/apierr"
+	"calple/sessionstore"
+)
+
+type SessionInfo struct {
+	ID        string    `json:"id"`
+	Device    string    `json:"device"`
+	UserAgent string    `json:"userAgent"`
+	IP        string    `json:"ip"`
+	CreatedAt time.Time `json:"createdAt"`
+	LastSeen  time.Time `json:"lastSeen"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	Current   bool      `json:"current"`
+}
+
+// GetSessions lists the signed in devices of the current user
+func GetSessions(c *gin.Context) {
+	uid := currentUID(c)
+	if uid == "" {
+		apierr.Abort(c, apierr.Unauthorized("User not authenticated"))
+		return
+	}
+
+	backend := c.MustGet("sessions").(sessionstore.Backend)
+	records, err := backend.List(c.Request.Context(), uid)
+	if err != nil {
+		apierr.Abort(c, apierr.Internal("Failed to list sessions").WithCause(err))
+		return
+	}
+
+	current := sessionstore.CurrentID(sessions.Default(c))
+	now := time.Now()
+	result := []SessionInfo{}
+	for _, rec := range records {
+		if now.After(rec.ExpiresAt) {
+			continue
+		}
+		result = append(result, SessionInfo{
+			ID:        rec.ID,
+			Device:    rec.Device,
+			UserAgent: rec.UserAgent,
+			IP:        rec.IP,
+			CreatedAt: rec.CreatedAt,
+			LastSeen:  rec.LastSeen,
+			ExpiresAt: rec.ExpiresAt,
+			Current:   rec.ID == current,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"sessions": result})
+}
+
+// RevokeSession signs out one of the user's devices
+func RevokeSession(c *gin.Context) {
+	uid := currentUID(c)
+	if uid == "" {
+		apierr.Abort(c, apierr.Unauthorized("User not authenticated"))
+		return
+	}
+
+	backend := c.MustGet("sessions").(sessionstore.Backend)
+	ctx := c.Request.Context()
+	id := c.Param("id")
+
+	rec, err := backend.Load(ctx, id)
+	if err != nil {
+		apierr.Abort(c, apierr.Internal("Failed to load session").WithCause(err))
+		return
+	}
+	// someone else's session looks the same as a missing one
+	if rec == nil || rec.UID != uid {
+		apierr.Abort(c, apierr.NotFound("Session not found"))
+		return
+	}
+
+	session := sessions.Default(c)
+	if id == sessionstore.CurrentID(session) {
+		session.Clear()
+		session.Save()
+	} else if err := backend.Delete(ctx, id); err != nil {
+		apierr.Abort(c, apierr.Internal("Failed to revoke session").WithCause(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Session revoked"})
+}
+
+// RevokeOtherSessions signs out every device except the current one
+func RevokeOtherSessions(c *gin.Context) {
+	uid := currentUID(c)
+	if uid == "" {
+		apierr.Abort(c, apierr.Unauthorized("User not authenticated"))
+		return
+	}
+
+	backend := c.MustGet("sessions").(sessionstore.Backend)
+	n, err := backend.DeleteAll(c.Request.Context(), uid, sessionstore.CurrentID(sessions.Default(c)))
+	if err != nil {
+		apierr.Abort(c, apierr.Internal("Failed to revoke sessions").WithCause(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Other sessions revoked", "revoked": n})
+}
@@ -0,0 +1,476 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/gin-gonic/gin"
+
+	"calple/apierr"
+	"calple/apitoken"
+	"calple/util"
+)
+
+// api_tokens/{id} holds both kinds of bearer token:
+//   - "pat": a personal access token, the hash of its secret is stored
+//   - "app": a refresh grant of a native client, access tokens issued from
+//     it are JWTs that are checked without a lookup
+const (
+	tokenKindPAT = "pat"
+	tokenKindApp = "app"
+
+	defaultPATDays = 90
+
+	// lastUsedAt is only written this often per token
+	tokenTouchInterval = 5 * time.Minute
+)
+
+var errTokenInvalid = errors.New("invalid or revoked token")
+
+type APIToken struct {
+	ID         string     `json:"id"`
+	Kind       string     `json:"kind"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+}
+
+type CreateAPITokenRequest struct {
+	Name   string   `json:"name" binding:"required,notblank,max=100"`
+	Scopes []string `json:"scopes" binding:"required,max=20"`
+	// 90 when not set
+	ExpiresInDays int `json:"expiresInDays" binding:"min=0,max=365"`
+}
+
+type CreateAppTokenRequest struct {
+	Name   string   `json:"name" binding:"required,notblank,max=100"`
+	Scopes []string `json:"scopes" binding:"required,max=20"`
+}
+
+// TokenRequest is the body of /auth/token, a form or JSON body as OAuth
+// clients send either
+type TokenRequest struct {
+	GrantType    string `form:"grant_type" json:"grant_type" binding:"required,oneof=refresh_token urn:ietf:params:oauth:grant-type:device_code"`
+	RefreshToken string `form:"refresh_token" json:"refresh_token" binding:"required_if=GrantType refresh_token,max=256"`
+	DeviceCode   string `form:"device_code" json:"device_code" binding:"required_if=GrantType urn:ietf:params:oauth:grant-type:device_code,max=256"`
+	Scope        string `form:"scope" json:"scope" binding:"max=1000"`
+}
+
+// BearerAuth accepts "Authorization: Bearer <token>" with a personal access
+// token or a JWT access token. a request with a bearer token is
+// authenticated by it alone, the session cookie is not consulted.
+func BearerAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		header := c.GetHeader("Authorization")
+		if header == "" {
+			c.Next()
+			return
+		}
+		scheme, token, _ := strings.Cut(header, " ")
+		token = strings.TrimSpace(token)
+		if !strings.EqualFold(scheme, "Bearer") || token == "" {
+			bearerError(c, "invalid_request", "Authorization header must be a bearer token")
+			return
+		}
+
+		var uid, tokenID string
+		var scopes []string
+		if strings.HasPrefix(token, apitoken.PATPrefix) {
+			pat, err := loadPAT(c, token)
+			if err != nil {
+				bearerError(c, "invalid_token", "Invalid or expired token")
+				return
+			}
+			uid, tokenID = util.GetStringValue(pat.Data(), "uid"), pat.Ref.ID
+			scopes = util.ToStringSlice(pat.Data()["scopes"])
+		} else {
+			claims, err := c.MustGet("tokenSigner").(*apitoken.Signer).Verify(token, time.Now())
+			if err != nil {
+				bearerError(c, "invalid_token", "Invalid or expired token")
+				return
+			}
+			uid, tokenID, scopes = claims.Subject, claims.GrantID, claims.Scopes()
+		}
+
+		c.Set("authUID", uid)
+		c.Set("authTokenID", tokenID)
+		c.Set("authScopes", scopes)
+		c.Next()
+	}
+}
+
+// RequireScope guards a group of routes with resource's scopes. reads need
+// read: or write:, anything else needs write:. session requests pass.
+func RequireScope(resource string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		scopes, ok := c.Get("authScopes")
+		if !ok {
+			c.Next()
+			return
+		}
+		write := c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead
+		if !apitoken.Allows(scopes.([]string), resource, write) {
+			scope := "read:" + resource
+			if write {
+				scope = "write:" + resource
+			}
+			c.Header("WWW-Authenticate", `Bearer error="insufficient_scope", scope="`+scope+`"`)
+			apierr.Abort(c, apierr.Forbidden("Token is missing the "+scope+" scope"))
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireSession keeps account management (tokens, sessions, sign in
+// methods, deleting the account) to browser sessions, a leaked token must
+// not be able to mint more tokens or lock the owner out
+func RequireSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := c.Get("authScopes"); ok {
+			apierr.Abort(c, apierr.Forbidden("This endpoint is not available with an API token"))
+			return
+		}
+		c.Next()
+	}
+}
+
+func bearerError(c *gin.Context, code, message string) {
+	c.Header("WWW-Authenticate", `Bearer realm="calple", error="`+code+`"`)
+	apierr.Abort(c, apierr.Unauthorized(message))
+}
+
+// loadPAT looks up a personal access token and checks it is still usable
+func loadPAT(c *gin.Context, token string) (*firestore.DocumentSnapshot, error) {
+	id, hash, err := apitoken.ParseOpaque(apitoken.PATPrefix, token)
+	if err != nil {
+		return nil, err
+	}
+	fsClient := c.MustGet("firestore").(*firestore.Client)
+	ctx := c.Request.Context()
+	doc, err := fsClient.Collection("api_tokens").Doc(id).Get(ctx)
+	if err != nil {
+		return nil, errTokenInvalid
+	}
+	data := doc.Data()
+	now := time.Now()
+	if util.GetStringValue(data, "kind") != tokenKindPAT ||
+		!apitoken.SameHash(util.GetStringValue(data, "hash"), hash) ||
+		data["revokedAt"] != nil {
+		return nil, errTokenInvalid
+	}
+	if exp, ok := data["expiresAt"].(time.Time); ok && now.After(exp) {
+		return nil, errTokenInvalid
+	}
+	if last, ok := data["lastUsedAt"].(time.Time); !ok || now.Sub(last) > tokenTouchInterval {
+		doc.Ref.Update(ctx, []firestore.Update{{Path: "lastUsedAt", Value: now}})
+	}
+	return doc, nil
+}
+
+// GetAPITokens lists the user's active tokens and the scopes a token can have
+func GetAPITokens(c *gin.Context) {
+	uid := currentUID(c)
+	if uid == "" {
+		apierr.Abort(c, apierr.Unauthorized("User not authenticated"))
+		return
+	}
+
+	fsClient := c.MustGet("firestore").(*firestore.Client)
+	docs, err := fsClient.Collection("api_tokens").Where("uid", "==", uid).Documents(c.Request.Context()).GetAll()
+	if err != nil {
+		apierr.Abort(c, apierr.Internal("Failed to list tokens").WithCause(err))
+		return
+	}
+
+	now := time.Now()
+	tokens := []APIToken{}
+	for _, doc := range docs {
+		data := doc.Data()
+		if data["revokedAt"] != nil {
+			continue
+		}
+		token := APIToken{
+			ID:         doc.Ref.ID,
+			Kind:       util.GetStringValue(data, "kind"),
+			Name:       util.GetStringValue(data, "name"),
+			Scopes:     util.ToStringSlice(data["scopes"]),
+			LastUsedAt: optionalTime(data, "lastUsedAt"),
+			ExpiresAt:  optionalTime(data, "expiresAt"),
+		}
+		if token.ExpiresAt != nil && now.After(*token.ExpiresAt) {
+			continue
+		}
+		if t, ok := data["createdAt"].(time.Time); ok {
+			token.CreatedAt = t
+		}
+		tokens = append(tokens, token)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"tokens": tokens, "availableScopes": apitoken.AllScopes()})
+}
+
+// CreateAPIToken creates a personal access token. the token is only
+// returned in this response.
+func CreateAPIToken(c *gin.Context) {
+	uid := currentUID(c)
+	if uid == "" {
+		apierr.Abort(c, apierr.Unauthorized("User not authenticated"))
+		return
+	}
+
+	var req CreateAPITokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierr.Abort(c, apierr.FromBinding(err))
+		return
+	}
+	scopes, err := apitoken.ParseScopes(req.Scopes)
+	if err != nil {
+		apierr.Abort(c, scopesError(err))
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if req.ExpiresInDays == 0 {
+		req.ExpiresInDays = defaultPATDays
+	}
+
+	fsClient := c.MustGet("firestore").(*firestore.Client)
+	ref := fsClient.Collection("api_tokens").NewDoc()
+	token, hash, err := apitoken.NewOpaque(apitoken.PATPrefix, ref.ID)
+	if err != nil {
+		apierr.Abort(c, apierr.Internal("Failed to create token").WithCause(err))
+		return
+	}
+	now := time.Now()
+	expiresAt := now.AddDate(0, 0, req.ExpiresInDays)
+	_, err = ref.Set(c.Request.Context(), map[string]interface{}{
+		"kind":      tokenKindPAT,
+		"uid":       uid,
+		"name":      name,
+		"scopes":    scopes,
+		"hash":      hash,
+		"createdAt": now,
+		"expiresAt": expiresAt,
+	})
+	if err != nil {
+		apierr.Abort(c, apierr.Internal("Failed to create token").WithCause(err))
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"token": token,
+		"info": APIToken{
+			ID:        ref.ID,
+			Kind:      tokenKindPAT,
+			Name:      name,
+			Scopes:    scopes,
+			CreatedAt: now,
+			ExpiresAt: &expiresAt,
+		},
+	})
+}
+
+// CreateAppToken starts a refresh grant for a native client and returns
+// the first access and refresh token pair
+func CreateAppToken(c *gin.Context) {
+	uid := currentUID(c)
+	if uid == "" {
+		apierr.Abort(c, apierr.Unauthorized("User not authenticated"))
+		return
+	}
+
+	var req CreateAppTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierr.Abort(c, apierr.FromBinding(err))
+		return
+	}
+	scopes, err := apitoken.ParseScopes(req.Scopes)
+	if err != nil {
+		apierr.Abort(c, scopesError(err))
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+
+	fsClient := c.MustGet("firestore").(*firestore.Client)
+	grant, err := createAppGrant(c.Request.Context(), fsClient, uid, name, scopes)
+	if err != nil {
+		apierr.Abort(c, apierr.Internal("Failed to create token").WithCause(err))
+		return
+	}
+	writeTokenResponse(c, http.StatusCreated, uid, grant.id, grant.refreshToken, scopes)
+}
+
+type appGrant struct {
+	id           string
+	refreshToken string
+}
+
+// createAppGrant stores a new refresh grant
+func createAppGrant(ctx context.Context, fsClient *firestore.Client, uid, name string, scopes []string) (*appGrant, error) {
+	ref := fsClient.Collection("api_tokens").NewDoc()
+	refresh, hash, err := apitoken.NewOpaque(apitoken.RefreshPrefix, ref.ID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	_, err = ref.Set(ctx, map[string]interface{}{
+		"kind":        tokenKindApp,
+		"uid":         uid,
+		"name":        name,
+		"scopes":      scopes,
+		"refreshHash": hash,
+		"createdAt":   now,
+		"lastUsedAt":  now,
+		"expiresAt":   now.Add(apitoken.RefreshTokenTTL),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &appGrant{id: ref.ID, refreshToken: refresh}, nil
+}
+
+// RevokeAPIToken revokes a personal access token or an app grant, access
+// tokens issued from a grant stay valid until they expire
+func RevokeAPIToken(c *gin.Context) {
+	uid := currentUID(c)
+	if uid == "" {
+		apierr.Abort(c, apierr.Unauthorized("User not authenticated"))
+		return
+	}
+
+	fsClient := c.MustGet("firestore").(*firestore.Client)
+	ctx := c.Request.Context()
+	ref := fsClient.Collection("api_tokens").Doc(c.Param("id"))
+	doc, err := ref.Get(ctx)
+	// someone else's token looks the same as a missing one
+	if err != nil || util.GetStringValue(doc.Data(), "uid") != uid || doc.Data()["revokedAt"] != nil {
+		apierr.Abort(c, apierr.NotFound("Token not found"))
+		return
+	}
+	if _, err := ref.Update(ctx, []firestore.Update{{Path: "revokedAt", Value: time.Now()}}); err != nil {
+		apierr.Abort(c, apierr.Internal("Failed to revoke token").WithCause(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Token revoked"})
+}
+
+// Token is the OAuth token endpoint of native clients, it exchanges a
+// refresh token or an approved device code (see device.go) for tokens
+func Token(c *gin.Context) {
+	var req TokenRequest
+	if err := c.ShouldBind(&req); err != nil {
+		apierr.Abort(c, apierr.FromBinding(err))
+		return
+	}
+	if req.GrantType == DeviceGrantType {
+		deviceToken(c, req.DeviceCode)
+		return
+	}
+	refreshToken(c, req)
+}
+
+// refreshToken exchanges a refresh token for a new access and refresh
+// token pair (grant_type=refresh_token). refresh tokens are single use,
+// presenting one that was already rotated revokes the whole grant since
+// one of the two holders must be an attacker.
+func refreshToken(c *gin.Context, req TokenRequest) {
+	id, hash, err := apitoken.ParseOpaque(apitoken.RefreshPrefix, req.RefreshToken)
+	if err != nil {
+		apierr.Abort(c, apierr.BadRequest("Invalid refresh token"))
+		return
+	}
+
+	fsClient := c.MustGet("firestore").(*firestore.Client)
+	ctx := c.Request.Context()
+	ref := fsClient.Collection("api_tokens").Doc(id)
+
+	var uid, refresh string
+	var scopes []string
+	reused := false
+	err = fsClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		reused = false
+		doc, err := tx.Get(ref)
+		if err != nil {
+			if strings.Contains(err.Error(), "NotFound") {
+				return errTokenInvalid
+			}
+			return err
+		}
+		data := doc.Data()
+		now := time.Now()
+		if util.GetStringValue(data, "kind") != tokenKindApp || data["revokedAt"] != nil {
+			return errTokenInvalid
+		}
+		if exp, ok := data["expiresAt"].(time.Time); ok && now.After(exp) {
+			return errTokenInvalid
+		}
+		if apitoken.SameHash(util.GetStringValue(data, "previousRefreshHash"), hash) {
+			reused = true
+			return tx.Update(ref, []firestore.Update{{Path: "revokedAt", Value: now}})
+		}
+		if !apitoken.SameHash(util.GetStringValue(data, "refreshHash"), hash) {
+			return errTokenInvalid
+		}
+
+		var newHash string
+		refresh, newHash, err = apitoken.NewOpaque(apitoken.RefreshPrefix, id)
+		if err != nil {
+			return err
+		}
+		uid = util.GetStringValue(data, "uid")
+		scopes = util.ToStringSlice(data["scopes"])
+		return tx.Update(ref, []firestore.Update{
+			{Path: "refreshHash", Value: newHash},
+			{Path: "previousRefreshHash", Value: hash},
+			{Path: "lastUsedAt", Value: now},
+			{Path: "expiresAt", Value: now.Add(apitoken.RefreshTokenTTL)},
+		})
+	})
+	if reused || errors.Is(err, errTokenInvalid) {
+		apierr.Abort(c, apierr.BadRequest("Invalid refresh token"))
+		return
+	}
+	if err != nil {
+		apierr.Abort(c, apierr.Internal("Failed to refresh token").WithCause(err))
+		return
+	}
+
+	// a client can ask for fewer scopes than the grant has, never more
+	if req.Scope != "" {
+		scopes = apitoken.Narrow(scopes, strings.Fields(req.Scope))
+		if len(scopes) == 0 {
+			apierr.Abort(c, apierr.BadRequest("Requested scope is not part of the grant"))
+			return
+		}
+	}
+	writeTokenResponse(c, http.StatusOK, uid, id, refresh, scopes)
+}
+
+// writeTokenResponse answers in the shape of an OAuth token response
+func writeTokenResponse(c *gin.Context, code int, uid, grantID, refresh string, scopes []string) {
+	access, exp, err := c.MustGet("tokenSigner").(*apitoken.Signer).Issue(uid, grantID, scopes, time.Now())
+	if err != nil {
+		apierr.Abort(c, apierr.Internal("Failed to issue token").WithCause(err))
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(code, gin.H{
+		"access_token":  access,
+		"token_type":    "Bearer",
+		"expires_in":    int(time.Until(exp).Seconds()),
+		"refresh_token": refresh,
+		"scope":         strings.Join(scopes, " "),
+	})
+}
+
+// scopesError reports scopes ParseScopes rejected as a field error
+func scopesError(err error) *apierr.Error {
+	return apierr.Validation(apierr.FieldError{Field: "scopes", Message: err.Error()})
+}
@@ -0,0 +1,249 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/gin-gonic/gin"
+
+	"calple/apierr"
+	"calple/apitoken"
+	"calple/audit"
+	"calple/events"
+	"calple/trash"
+	"calple/util"
+)
+
+// TrashItem is a deleted dday, pin, idea or check-in that can still be
+// restored
+type TrashItem struct {
+	Type      string    `json:"type"`
+	ID        string    `json:"id"`
+	Title     string    `json:"title,omitempty"`
+	Date      string    `json:"date,omitempty"`
+	DeletedAt time.Time `json:"deletedAt"`
+	DeletedBy string    `json:"deletedBy"`
+	// PurgeAt is when it is deleted for good
+	PurgeAt time.Time `json:"purgeAt"`
+}
+
+// trashKinds are what can be in a user's trash, with the token scope
+// needed to see them
+var trashKinds = []struct {
+	kind     string
+	resource string
+	query    func(fsClient *firestore.Client, uid string) firestore.Query
+}{
+	{"dday", "ddays", func(fsClient *firestore.Client, uid string) firestore.Query {
+		return fsClient.Collection("ddays").Where("ownerUID", "==", uid)
+	}},
+	{"pin", "pins", func(fsClient *firestore.Client, uid string) firestore.Query {
+		return fsClient.Collection("users").Doc(uid).Collection("pins").Query
+	}},
+	{"idea", "ideas", func(fsClient *firestore.Client, uid string) firestore.Query {
+		return fsClient.Collection("users").Doc(uid).Collection("posts").Query
+	}},
+	{"checkin", "checkins", func(fsClient *firestore.Client, uid string) firestore.Query {
+		return fsClient.Collection("users").Doc(uid).Collection("checkins").Query
+	}},
+}
+
+// TrashResources are the API resources the trash is filtered by for tokens
+func TrashResources() []string {
+	out := make([]string, len(trashKinds))
+	for i, k := range trashKinds {
+		out[i] = k.resource
+	}
+	return out
+}
+
+// GetTrash lists what the user deleted, latest first. API tokens only see
+// the kinds they can read.
+func GetTrash(c *gin.Context) {
+	uid := currentUID(c)
+	if uid == "" {
+		apierr.Abort(c, apierr.Unauthorized("Unauthorized"))
+		return
+	}
+
+	fsClient := c.MustGet("firestore").(*firestore.Client)
+	ctx := c.Request.Context()
+	retention := appConfig(c).Trash.Retention.D()
+	scopes, scoped := c.Get("authScopes")
+
+	items := []TrashItem{}
+	for _, k := range trashKinds {
+		if scoped && !apitoken.Allows(scopes.([]string), k.resource, false) {
+			continue
+		}
+		docs, err := trash.Of(ctx, k.query(fsClient, uid))
+		if err != nil {
+			apierr.Abort(c, apierr.Internal("Failed to load the trash").WithCause(err))
+			return
+		}
+		for _, doc := range docs {
+			data := doc.Data()
+			deletedAt := util.GetTimeValue(data, "deletedAt")
+			items = append(items, TrashItem{
+				Type:      k.kind,
+				ID:        doc.Ref.ID,
+				Title:     util.GetStringValue(data, "title"),
+				Date:      util.GetStringValue(data, "date"),
+				DeletedAt: deletedAt,
+				DeletedBy: util.GetStringValue(data, "deletedBy"),
+				PurgeAt:   deletedAt.Add(retention),
+			})
+		}
+	}
+	sort.SliceStable(items, func(i, j int) bool { return items[i].DeletedAt.After(items[j].DeletedAt) })
+
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
+
+// trashedDoc is the document at ref when it is in the trash
+func trashedDoc(ctx context.Context, ref *firestore.DocumentRef) (*firestore.DocumentSnapshot, bool) {
+	snap, err := ref.Get(ctx)
+	if err != nil || !trash.Trashed(snap.Data()) {
+		return nil, false
+	}
+	return snap, true
+}
+
+// RestoreDDay takes an event of the user out of the trash
+func RestoreDDay(c *gin.Context) {
+	uid := currentUID(c)
+	if uid == "" {
+		apierr.Abort(c, apierr.Unauthorized("Unauthorized"))
+		return
+	}
+
+	fsClient := c.MustGet("firestore").(*firestore.Client)
+	ctx := c.Request.Context()
+
+	id := c.Param("id")
+	before, after, err := restoreDDay(ctx, fsClient, fsClient.Collection("ddays").Doc(id), uid)
+	switch {
+	case errors.Is(err, errDDayNotFound), errors.Is(err, errDDayNotOwner):
+		apierr.Abort(c, apierr.NotFound("D-Day not found in the trash"))
+		return
+	case err != nil:
+		apierr.Abort(c, apierr.Internal("Failed to restore event").WithCause(err))
+		return
+	}
+
+	// it is shared with whoever it was shared with when it was deleted
+	sharedWith := util.ToStringSlice(after["sharedWith"])
+	publish(c, events.DDayRestored, events.Change{ID: id, UserID: uid}, append(sharedWith, uid)...)
+	audited(c, fsClient, audit.DDayRestore, audit.Target{Type: "dday", ID: id},
+		map[string]audit.Change{"deletedAt": {Before: before["deletedAt"]}}, sharedWith...)
+	c.Header("ETag", ddayETag(util.GetInt64Value(after, "version")))
+	c.JSON(http.StatusOK, gin.H{"message": "D-Day restored"})
+}
+
+// RestorePin takes a pin of the user out of the trash
+func RestorePin(c *gin.Context) {
+	uid := currentUID(c)
+	if uid == "" {
+		apierr.Abort(c, apierr.Unauthorized("Unauthorized"))
+		return
+	}
+
+	fsClient := c.MustGet("firestore").(*firestore.Client)
+	ctx := c.Request.Context()
+
+	pinID := c.Param("id")
+	pinRef := fsClient.Collection("users").Doc(uid).Collection("pins").Doc(pinID)
+	if _, ok := trashedDoc(ctx, pinRef); !ok {
+		apierr.Abort(c, apierr.NotFound("Pin not found in the trash"))
+		return
+	}
+	if _, err := pinRef.Update(ctx, trash.Restore()); err != nil {
+		apierr.Abort(c, apierr.Internal("Failed to restore pin").WithCause(err))
+		return
+	}
+
+	publishToCouple(c, fsClient, uid, events.PinRestored, events.Change{ID: pinID, UserID: uid})
+	c.Status(http.StatusNoContent)
+}
+
+// RestorePost takes an idea of the user out of the trash
+func RestorePost(c *gin.Context) {
+	uid := currentUID(c)
+	if uid == "" {
+		apierr.Abort(c, apierr.Unauthorized("User not authenticated"))
+		return
+	}
+
+	fsClient := c.MustGet("firestore").(*firestore.Client)
+	ctx := c.Request.Context()
+
+	postID := c.Param("id")
+	userPostRef := fsClient.Collection("users").Doc(uid).Collection("posts").Doc(postID)
+	if _, ok := trashedDoc(ctx, userPostRef); !ok {
+		apierr.Abort(c, apierr.NotFound("Post not found in the trash"))
+		return
+	}
+	if _, err := fsClient.Collection("ideas").Doc(postID).Update(ctx, trash.Restore()); err != nil {
+		apierr.Abort(c, apierr.Internal("Failed to restore post").WithCause(err))
+		return
+	}
+	if _, err := userPostRef.Update(ctx, trash.Restore()); err != nil {
+		apierr.Abort(c, apierr.Internal("Failed to restore post").WithCause(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Post restored"})
+}
+
+// RestoreCheckin takes the latest deleted check-in of a date out of the
+// trash, unless the day has a check-in again
+func RestoreCheckin(c *gin.Context) {
+	uid := currentUID(c)
+	if uid == "" {
+		apierr.Abort(c, apierr.Unauthorized("Unauthorized"))
+		return
+	}
+
+	var param dateParam
+	if err := c.ShouldBindUri(&param); err != nil {
+		apierr.Abort(c, apierr.FromBinding(err))
+		return
+	}
+
+	fsClient := c.MustGet("firestore").(*firestore.Client)
+	ctx := c.Request.Context()
+
+	docs, err := fsClient.Collection("users").Doc(uid).Collection("checkins").
+		Where("date", "==", param.Date).
+		Documents(ctx).GetAll()
+	if err != nil {
+		apierr.Abort(c, apierr.Internal("Failed to fetch checkin data").WithCause(err))
+		return
+	}
+	if len(trash.Live(docs)) > 0 {
+		apierr.Abort(c, apierr.Conflict("The date already has a checkin"))
+		return
+	}
+
+	var latest *firestore.DocumentSnapshot
+	for _, doc := range docs {
+		if latest == nil || util.GetTimeValue(doc.Data(), "deletedAt").After(util.GetTimeValue(latest.Data(), "deletedAt")) {
+			latest = doc
+		}
+	}
+	if latest == nil {
+		apierr.Abort(c, apierr.NotFound("Checkin not found in the trash"))
+		return
+	}
+	if _, err := latest.Ref.Update(ctx, trash.Restore()); err != nil {
+		apierr.Abort(c, apierr.Internal("Failed to restore checkin").WithCause(err))
+		return
+	}
+
+	publishToCouple(c, fsClient, uid, events.CheckinSaved, events.Change{ID: latest.Ref.ID, Date: param.Date, UserID: uid})
+	c.JSON(http.StatusOK, gin.H{"message": "Checkin restored"})
+}
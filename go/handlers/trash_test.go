@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// a restore is a new version, edits based on the version before the
+// delete conflict
+func TestRestoreDDayVersion(t *testing.T) {
+	fsClient := testFirestore(t)
+	ctx := context.Background()
+	ref := fsClient.Collection("ddays").Doc("d1")
+	_, err := ref.Set(ctx, map[string]interface{}{
+		"title":      "Concert",
+		"date":       "20250601",
+		"ownerUID":   "ann",
+		"sharedWith": []interface{}{"bob"},
+		"version":    int64(1),
+		"createdAt":  time.Now(),
+		"updatedAt":  time.Now(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := testRouter(fsClient)
+	router.PUT("/ddays/:id", UpdateDDay)
+	router.DELETE("/ddays/:id", DeleteDDay)
+	router.POST("/ddays/:id/restore", RestoreDDay)
+
+	do := func(uid, method, path, ifMatch, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(testUIDHeader, uid)
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := do("ann", http.MethodDelete, "/ddays/d1", "", ""); w.Code != http.StatusOK {
+		t.Fatalf("delete: %d %s", w.Code, w.Body)
+	}
+	if w := do("bob", http.MethodPost, "/ddays/d1/restore", "", ""); w.Code != http.StatusNotFound {
+		t.Errorf("restore by someone else: %d %s", w.Code, w.Body)
+	}
+	w := do("ann", http.MethodPost, "/ddays/d1/restore", "", "")
+	if w.Code != http.StatusOK || w.Header().Get("ETag") != `"2"` {
+		t.Fatalf("restore: %d %q %s", w.Code, w.Header().Get("ETag"), w.Body)
+	}
+	if w := do("ann", http.MethodPost, "/ddays/d1/restore", "", ""); w.Code != http.StatusNotFound {
+		t.Errorf("restore of a live event: %d %s", w.Code, w.Body)
+	}
+
+	if w := do("ann", http.MethodPut, "/ddays/d1", `"1"`, `{"title":"Stale"}`); w.Code != http.StatusConflict {
+		t.Errorf("edit of the version before the delete: %d %s", w.Code, w.Body)
+	}
+	if w := do("ann", http.MethodPut, "/ddays/d1", `"2"`, `{"title":"Opera"}`); w.Code != http.StatusOK {
+		t.Errorf("edit of the restored version: %d %s", w.Code, w.Body)
+	}
+
+	snap, err := ref.Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data := snap.Data(); data["title"] != "Opera" || data["deletedAt"] != nil || data["version"] != int64(3) {
+		t.Errorf("event %v", data)
+	}
+	for _, v := range []string{"1", "2", "3"} {
+		if _, err := ref.Collection("revisions").Doc(v).Get(ctx); err != nil {
+			t.Errorf("revision %s: %v", v, err)
+		}
+	}
+}
@@ -9,6 +9,11 @@ import (
 	"cloud.google.com/go/firestore"
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
+
+	"calple/apierr"
+	"calple/audit"
+	"calple/sessionstore"
+	"calple/util"
 )
 
 type UserMetadata struct {
@@ -21,59 +26,54 @@ type UserMetadata struct {
 }
 
 type UpdateUserMetadataRequest struct {
-	Sex           *string `json:"sex,omitempty"`
-	StartedDating *string `json:"startedDating,omitempty"` // YYYY-MM-DD
+	Sex           *string `json:"sex,omitempty" binding:"omitempty,oneof=male female"`
+	StartedDating *string `json:"startedDating,omitempty" binding:"omitempty,datetime=01/02/2006"`
 }
 
 func GetUserMetadata(c *gin.Context) {
-	session := sessions.Default(c)
-	uid := session.Get("user_id")
-	if uid == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+	uid := currentUID(c)
+	if uid == "" {
+		apierr.Abort(c, apierr.Unauthorized("Unauthorized"))
 		return
 	}
 
 	fsClient := c.MustGet("firestore").(*firestore.Client)
-	ctx := context.Background()
+	ctx := c.Request.Context()
 
-	doc, err := fsClient.Collection("users").Doc(uid.(string)).Get(ctx)
+	doc, err := fsClient.Collection("users").Doc(uid).Get(ctx)
 	if err != nil {
 		if strings.Contains(err.Error(), "NotFound") {
-			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			apierr.Abort(c, apierr.NotFound("User not found"))
 			return
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve user"})
+		apierr.Abort(c, apierr.Internal("Failed to retrieve user").WithCause(err))
 		return
 	}
 
-	data := doc.Data()
-
-	c.JSON(http.StatusOK, gin.H{"userMetadata": data})
+	c.JSON(http.StatusOK, gin.H{"userMetadata": selfProfile(doc)})
 }
 
 func UpdateUserMetadata(c *gin.Context) {
-	session := sessions.Default(c)
-	uid := session.Get("user_id")
-	if uid == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+	uid := currentUID(c)
+	if uid == "" {
+		apierr.Abort(c, apierr.Unauthorized("Unauthorized"))
 		return
 	}
 
 	var req UpdateUserMetadataRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apierr.Abort(c, apierr.FromBinding(err))
 		return
 	}
 
 	fsClient := c.MustGet("firestore").(*firestore.Client)
-	ctx := context.Background()
-	uidStr := uid.(string)
+	ctx := c.Request.Context()
 
 	// fetch previous startedDating value
 	// this is needed to determine if we need to create or update event
-	userDoc, err := fsClient.Collection("users").Doc(uidStr).Get(ctx)
+	userDoc, err := fsClient.Collection("users").Doc(uid).Get(ctx)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve user"})
+		apierr.Abort(c, apierr.Internal("Failed to retrieve user").WithCause(err))
 		return
 	}
 	prevStartedDating, _ := userDoc.Data()["startedDating"].(string)
@@ -83,45 +83,36 @@ func UpdateUserMetadata(c *gin.Context) {
 	}
 
 	if req.Sex != nil {
-		if *req.Sex != "male" && *req.Sex != "female" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid value for sex"})
-			return
-		}
 		updateData = append(updateData, firestore.Update{Path: "sex", Value: *req.Sex})
 	}
 
 	if req.StartedDating != nil {
-		_, err := time.Parse("01/02/2006", *req.StartedDating)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid date format for startedDating. Use MM/DD/YYYY"})
-			return
-		}
 		updateData = append(updateData, firestore.Update{Path: "startedDating", Value: *req.StartedDating})
 	}
 
-	userDocRef := fsClient.Collection("users").Doc(uidStr)
+	userDocRef := fsClient.Collection("users").Doc(uid)
 	_, err = userDocRef.Update(ctx, updateData)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user metadata"})
+		apierr.Abort(c, apierr.Internal("Failed to update user metadata").WithCause(err))
 		return
 	}
 
 	// event handling for startedDating
 	if req.StartedDating != nil && *req.StartedDating != prevStartedDating {
-		userEmail := userDoc.Data()["email"].(string)
+		userEmail := util.GetStringValue(userDoc.Data(), "email")
 		ddayTitle := "Anniversary"
 		ddayDate := ""
 		t, _ := time.Parse("01/02/2006", *req.StartedDating)
 		ddayDate = t.Format("20060102")
 
 		ddayQuery := fsClient.Collection("ddays").
-			Where("createdBy", "==", userEmail).
+			Where("ownerUID", "==", uid).
 			Where("title", "==", ddayTitle).
 			Limit(1)
 		ddayDocs, err := ddayQuery.Documents(ctx).GetAll()
 
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check existing event"})
+			apierr.Abort(c, apierr.Internal("Failed to check existing event").WithCause(err))
 			return
 		}
 
@@ -134,125 +125,132 @@ func UpdateUserMetadata(c *gin.Context) {
 				"isAnnual":       true,
 				"createdBy":      userEmail,
 				"connectedUsers": []string{},
+				"ownerUID":       uid,
+				"sharedWith":     []string{},
 				"createdAt":      time.Now(),
 				"updatedAt":      time.Now(),
+				"version":        int64(1),
 				"editable":       false,
 			}
 			_, _, _ = fsClient.Collection("ddays").Add(ctx, newDDay)
 		} else if prevStartedDating != "" && *req.StartedDating != "" && len(ddayDocs) > 0 {
-			ddayRef := ddayDocs[0].Ref
-			_, _ = ddayRef.Update(ctx, []firestore.Update{
+			_, _, _ = editDDay(ctx, fsClient, ddayDocs[0].Ref, uid, -1, []firestore.Update{
 				{Path: "date", Value: ddayDate},
-				{Path: "updatedAt", Value: time.Now()},
-			})
+			}, nil)
 		}
 	}
 
 	// if startedDating updated, also update for partner
 	if req.StartedDating != nil {
-		connectionDocs, _ := fsClient.Collection("users").Doc(uidStr).Collection("connections").
-			Where("status", "==", "active").Limit(1).Documents(ctx).GetAll()
-
-		if len(connectionDocs) > 0 {
-			connectionData := connectionDocs[0].Data()
-			if partnerUID, ok := connectionData["partnerUID"].(string); ok && partnerUID != "" {
-				partnerDocRef := fsClient.Collection("users").Doc(partnerUID)
-				parsedDate := *req.StartedDating
-				partnerDocRef.Update(ctx, []firestore.Update{
-					{Path: "startedDating", Value: parsedDate},
-					{Path: "updatedAt", Value: time.Now()},
-				})
+		if partnerUID, _ := activePartnerUID(ctx, fsClient, uid); partnerUID != "" {
+			partnerDocRef := fsClient.Collection("users").Doc(partnerUID)
+			parsedDate := *req.StartedDating
+			var partnerBefore map[string]interface{}
+			if partnerDoc, err := partnerDocRef.Get(ctx); err == nil {
+				partnerBefore = partnerDoc.Data()
+			}
+			_, err := partnerDocRef.Update(ctx, []firestore.Update{
+				{Path: "startedDating", Value: parsedDate},
+				{Path: "updatedAt", Value: time.Now()},
+			})
+			if changes := audit.Diff(partnerBefore, map[string]interface{}{"startedDating": parsedDate}, "startedDating"); err == nil && len(changes) > 0 {
+				audited(c, fsClient, audit.PartnerMetadataUpdate, audit.Target{Type: "user", ID: partnerUID}, changes, partnerUID)
 			}
 		}
 	}
 
 	updatedDoc, err := userDocRef.Get(ctx)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve updated metadata"})
+		apierr.Abort(c, apierr.Internal("Failed to retrieve updated metadata").WithCause(err))
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"userMetadata": updatedDoc.Data()})
+	if changes := audit.Diff(userDoc.Data(), updatedDoc.Data(), "sex", "startedDating"); len(changes) > 0 {
+		audited(c, fsClient, audit.MetadataUpdate, audit.Target{Type: "user", ID: uid}, changes)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"userMetadata": selfProfile(updatedDoc)})
 }
 
 func GetPartnerMetadata(c *gin.Context) {
-	session := sessions.Default(c)
-	uid := session.Get("user_id")
-	if uid == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+	uid := currentUID(c)
+	if uid == "" {
+		apierr.Abort(c, apierr.Unauthorized("Unauthorized"))
 		return
 	}
 
 	fsClient := c.MustGet("firestore").(*firestore.Client)
-	ctx := context.Background()
-
-	connectionDocs, err := fsClient.Collection("users").Doc(uid.(string)).Collection("connections").
-		Where("status", "==", "active").
-		Documents(ctx).GetAll()
+	ctx := c.Request.Context()
 
+	partnerUID, err := activePartnerUID(ctx, fsClient, uid)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch connection"})
+		apierr.Abort(c, apierr.Internal("Failed to fetch connection").WithCause(err))
 		return
 	}
 
-	if len(connectionDocs) == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "No partner connection found"})
+	if partnerUID == "" {
+		apierr.Abort(c, apierr.NotFound("No partner connection found"))
 		return
 	}
 
-	connectionData := connectionDocs[0].Data()
-	partnerEmail := connectionData["partnerEmail"].(string)
-
-	partnerUserDocs, err := fsClient.Collection("users").
-		Where("email", "==", partnerEmail).
-		Documents(ctx).GetAll()
-
-	if err != nil || len(partnerUserDocs) == 0 {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch partner data"})
+	partnerDoc, err := fsClient.Collection("users").Doc(partnerUID).Get(ctx)
+	if err != nil {
+		apierr.Abort(c, apierr.Internal("Failed to fetch partner data").WithCause(err))
 		return
 	}
 
-	partnerDoc := partnerUserDocs[0]
-	partnerData := partnerDoc.Data()
-
-	c.JSON(http.StatusOK, gin.H{"partnerMetadata": partnerData})
+	c.JSON(http.StatusOK, gin.H{"partnerMetadata": partnerProfile(partnerDoc)})
 }
 
+const accountDeleteTimeout = 2 * time.Minute
+
 func DeleteUser(c *gin.Context) {
-	session := sessions.Default(c)
-	uid := session.Get("user_id")
-	if uid == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+	uid := currentUID(c)
+	if uid == "" {
+		apierr.Abort(c, apierr.Unauthorized("User not authenticated"))
 		return
 	}
 
 	// get client from context
 	fsClient, ok := c.MustGet("firestore").(*firestore.Client)
 	if !ok {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get Firestore client"})
+		apierr.Abort(c, apierr.Internal("Failed to get Firestore client"))
 		return
 	}
 
-	ctx := context.Background()
-	uidStr := uid.(string)
+	// the cleanup runs to the end even if the client goes away, a half
+	// deleted account is worse than a slow response
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(c.Request.Context()), accountDeleteTimeout)
+	defer cancel()
 
-	userDoc, err := fsClient.Collection("users").Doc(uidStr).Get(ctx)
+	userDoc, err := fsClient.Collection("users").Doc(uid).Get(ctx)
 	if err != nil {
 		// if user doc is not found it might have been already deleted
 		// just proceed with cleanup
 	}
 
+	// partners see the account going in their trail
+	var partners []string
 	if userDoc.Exists() {
 		// remove connections
-		connections, _ := fsClient.Collection("users").Doc(uidStr).Collection("connections").Documents(ctx).GetAll()
+		connections, _ := fsClient.Collection("users").Doc(uid).Collection("connections").Documents(ctx).GetAll()
 		for _, connDoc := range connections {
 			connData := connDoc.Data()
-			if partnerEmail, ok := connData["partnerEmail"].(string); ok {
-				partnerDocs, _ := fsClient.Collection("users").Where("email", "==", partnerEmail).Limit(1).Documents(ctx).GetAll()
-				if len(partnerDocs) > 0 {
-					partnerID := partnerDocs[0].Ref.ID
-					fsClient.Collection("users").Doc(partnerID).Collection("connections").Doc(connDoc.Ref.ID).Delete(ctx)
-				}
+			// ended connections are already history on both sides
+			if status := util.GetStringValue(connData, "status"); status != "pending" && status != "active" {
+				continue
+			}
+			if partnerID := util.GetStringValue(connData, "partnerUID"); partnerID != "" {
+				partners = append(partners, partnerID)
+				// keep the partner's side as history
+				now := time.Now()
+				fsClient.Collection("users").Doc(partnerID).Collection("connections").Doc(connDoc.Ref.ID).Update(ctx, []firestore.Update{
+					{Path: "status", Value: connectionUnlinked},
+					{Path: "endedAt", Value: now},
+					{Path: "endedBy", Value: uid},
+					{Path: "endReason", Value: "account_deleted"},
+					{Path: "updatedAt", Value: now},
+				})
 			}
 		}
 	}
@@ -263,18 +261,72 @@ func DeleteUser(c *gin.Context) {
 	// this is a simplified cleanup for the connections.
 
 	// delete user document from users collection
-	_, err = fsClient.Collection("users").Doc(uidStr).Delete(ctx)
+	_, err = fsClient.Collection("users").Doc(uid).Delete(ctx)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user document from database"})
+		apierr.Abort(c, apierr.Internal("Failed to delete user document from database").WithCause(err))
 		return
 	}
+	audited(c, fsClient, audit.AccountDelete, audit.Target{Type: "user", ID: uid}, nil, partners...)
+
+	// the sign in methods go with the account
+	identities, _ := fsClient.Collection("identities").Where("uid", "==", uid).Documents(ctx).GetAll()
+	for _, doc := range identities {
+		doc.Ref.Delete(ctx)
+	}
 
-	// clear session
+	// and so do its api tokens
+	tokens, _ := fsClient.Collection("api_tokens").Where("uid", "==", uid).Documents(ctx).GetAll()
+	for _, doc := range tokens {
+		doc.Ref.Delete(ctx)
+	}
+
+	// sign out every device, then this one
+	if backend, ok := c.Get("sessions"); ok {
+		backend.(sessionstore.Backend).DeleteAll(ctx, uid, "")
+	}
+	// saving the emptied session deletes it and expires the cookie
+	session := sessions.Default(c)
 	session.Clear()
 	session.Save()
 
-	// clear cookie
-	c.SetCookie("session", "", -1, "/", "", true, true)
-
 	c.JSON(http.StatusOK, gin.H{"message": "User account deleted successfully"})
 }
+
+// syncUserEmail updates the denormalized copies of a user's email after it
+// changed. links between users are keyed by UID, so this only affects what
+// is displayed: the partner's connection doc, dday creator/shared emails
+// and open invite links.
+func syncUserEmail(ctx context.Context, fsClient *firestore.Client, uid, oldEmail, newEmail string) {
+	now := time.Now()
+
+	connections, _ := fsClient.Collection("users").Doc(uid).Collection("connections").Documents(ctx).GetAll()
+	for _, conn := range connections {
+		partnerUID := util.GetStringValue(conn.Data(), "partnerUID")
+		if partnerUID == "" {
+			continue
+		}
+		fsClient.Collection("users").Doc(partnerUID).Collection("connections").Doc(conn.Ref.ID).Update(ctx, []firestore.Update{
+			{Path: "partnerEmail", Value: newEmail},
+			{Path: "updatedAt", Value: now},
+		})
+	}
+
+	owned, _ := fsClient.Collection("ddays").Where("ownerUID", "==", uid).Documents(ctx).GetAll()
+	for _, doc := range owned {
+		doc.Ref.Update(ctx, []firestore.Update{{Path: "createdBy", Value: newEmail}})
+	}
+
+	shared, _ := fsClient.Collection("ddays").Where("sharedWith", "array-contains", uid).Documents(ctx).GetAll()
+	for _, doc := range shared {
+		users := util.Remove(util.ToStringSlice(doc.Data()["connectedUsers"]), oldEmail)
+		if !util.Contains(users, newEmail) {
+			users = append(users, newEmail)
+		}
+		doc.Ref.Update(ctx, []firestore.Update{{Path: "connectedUsers", Value: users}})
+	}
+
+	invites, _ := fsClient.Collection("invites").Where("inviterUID", "==", uid).Documents(ctx).GetAll()
+	for _, doc := range invites {
+		doc.Ref.Update(ctx, []firestore.Update{{Path: "inviterEmail", Value: newEmail}})
+	}
+}
@@ -0,0 +1,72 @@
+package logging
+
+import (
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+const redacted = "[REDACTED]"
+
+var (
+	emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+	// bearer credentials, api tokens and JWTs (three base64url parts)
+	tokenPattern = regexp.MustCompile(`(?i)bearer\s+\S+|calple_(pat|rt)_\S+|eyJ[A-Za-z0-9_\-]+\.[A-Za-z0-9_\-]+\.[A-Za-z0-9_\-]+`)
+)
+
+// keys whose values are always credentials
+var secretKeys = map[string]bool{
+	"authorization": true,
+	"code":          true,
+	"cookie":        true,
+	"nonce":         true,
+	"password":      true,
+	"state":         true,
+	"verifier":      true,
+}
+
+func isSecretKey(key string) bool {
+	key = strings.ToLower(key)
+	return secretKeys[key] ||
+		strings.HasSuffix(key, "token") ||
+		strings.HasSuffix(key, "secret") ||
+		strings.HasSuffix(key, "password")
+}
+
+// redactAttr is the ReplaceAttr hook of every handler
+func redactAttr(groups []string, a slog.Attr) slog.Attr {
+	if isSecretKey(a.Key) {
+		return slog.String(a.Key, redacted)
+	}
+	switch a.Value.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, Redact(a.Value.String()))
+	case slog.KindAny:
+		switch v := a.Value.Any().(type) {
+		case []string:
+			out := make([]string, len(v))
+			for i, s := range v {
+				out[i] = Redact(s)
+			}
+			return slog.Any(a.Key, out)
+		case error:
+			return slog.String(a.Key, Redact(v.Error()))
+		}
+	}
+	return a
+}
+
+// Redact masks email addresses and removes tokens from s
+func Redact(s string) string {
+	s = tokenPattern.ReplaceAllString(s, redacted)
+	return emailPattern.ReplaceAllStringFunc(s, MaskEmail)
+}
+
+// MaskEmail keeps the first character and the domain: j***@example.com
+func MaskEmail(email string) string {
+	local, domain, ok := strings.Cut(email, "@")
+	if !ok || local == "" {
+		return redacted
+	}
+	return local[:1] + "***@" + domain
+}
@@ -0,0 +1,107 @@
+// Package mailer sends transactional email. handlers depend on the Mailer
+// interface only, so sign in by email works offline with the log or memory
+// mailer.
+package mailer
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/smtp"
+	"strings"
+	"sync"
+
+	"calple/config"
+	"calple/logging"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+}
+
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// FromConfig returns an SMTP mailer when SMTP_HOST is set. in development
+// it falls back to logging mail through logger, elsewhere it returns nil.
+func FromConfig(cfg *config.Config, logger *slog.Logger) Mailer {
+	if host := cfg.Mail.SMTPHost; host != "" {
+		return &SMTP{
+			Addr:     net.JoinHostPort(host, cfg.Mail.SMTPPort),
+			Host:     host,
+			Username: cfg.Mail.SMTPUser,
+			Password: cfg.Mail.SMTPPassword,
+			From:     cfg.Mail.From,
+		}
+	}
+	if cfg.IsDevelopment() {
+		return Log{Logger: logger}
+	}
+	return nil
+}
+
+// SMTP sends through an SMTP relay with STARTTLS and PLAIN auth
+type SMTP struct {
+	Addr     string
+	Host     string
+	Username string
+	Password string
+	From     string
+}
+
+func (s *SMTP) Send(ctx context.Context, msg Message) error {
+	var auth smtp.Auth
+	if s.Username != "" {
+		auth = smtp.PlainAuth("", s.Username, s.Password, s.Host)
+	}
+	body := strings.Join([]string{
+		"From: " + s.From,
+		"To: " + msg.To,
+		"Subject: " + msg.Subject,
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+		"",
+		msg.Text,
+	}, "\r\n")
+	return smtp.SendMail(s.Addr, auth, s.From, []string{msg.To}, []byte(body))
+}
+
+// Log logs mail at debug level instead of sending it, for local
+// development. during requests it goes through the request's logger, so the
+// mail carries the request ID.
+type Log struct {
+	Logger *slog.Logger
+}
+
+func (l Log) Send(ctx context.Context, msg Message) error {
+	logger := l.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	logging.FromContextOr(ctx, logger).DebugContext(ctx, "mail",
+		"to", msg.To, "subject", msg.Subject, "text", msg.Text)
+	return nil
+}
+
+// Memory keeps sent mail so it can be inspected
+type Memory struct {
+	mu   sync.Mutex
+	sent []Message
+}
+
+func (m *Memory) Send(ctx context.Context, msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+// Sent returns the messages sent so far
+func (m *Memory) Sent() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Message(nil), m.sent...)
+}
@@ -0,0 +1,38 @@
+package migrate
+
+import (
+	"context"
+
+	"cloud.google.com/go/firestore"
+)
+
+func init() {
+	// ddays created before the editable flag existed are treated as editable by
+	// GetDDays, store that explicitly so queries and clients see the same value
+	Register(Migration{
+		ID:          "0003_dday_editable_default",
+		Description: "set editable=true on ddays missing the field",
+		Query: func(fsClient *firestore.Client) firestore.Query {
+			return fsClient.Collection("ddays").Query
+		},
+		Up: func(ctx context.Context, fsClient *firestore.Client, doc *firestore.DocumentSnapshot) ([]firestore.Update, error) {
+			if _, ok := doc.Data()["editable"]; ok {
+				return nil, nil
+			}
+			return []firestore.Update{
+				{Path: "editable", Value: true},
+				{Path: "editableBackfilled", Value: true},
+			}, nil
+		},
+		// only undo documents this migration touched
+		Down: func(ctx context.Context, fsClient *firestore.Client, doc *firestore.DocumentSnapshot) ([]firestore.Update, error) {
+			if backfilled, _ := doc.Data()["editableBackfilled"].(bool); !backfilled {
+				return nil, nil
+			}
+			return []firestore.Update{
+				{Path: "editable", Value: firestore.Delete},
+				{Path: "editableBackfilled", Value: firestore.Delete},
+			}, nil
+		},
+	})
+}
@@ -0,0 +1,309 @@
+// Package migrate runs versioned, idempotent data migrations over Firestore.
+//
+// each migration walks the documents returned by its Query in document ID
+// order, in batches. progress is recorded in the _migrations collection
+// after every batch, so an interrupted run resumes where it stopped and a
+// finished migration is never applied twice.
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"calple/config"
+)
+
+// collection holding one document per migration ID
+const versionCollection = "_migrations"
+
+const (
+	StatusRunning    = "running"
+	StatusDone       = "done"
+	StatusRolledBack = "rolled_back"
+)
+
+// DocFunc returns the updates for a single document, or nil to leave it as is
+type DocFunc func(ctx context.Context, fsClient *firestore.Client, doc *firestore.DocumentSnapshot) ([]firestore.Update, error)
+
+type Migration struct {
+	// ID orders migrations and identifies them in _migrations, e.g. "0001_connection_partner_uid"
+	ID          string
+	Description string
+
+	// Query selects the documents to visit. it is ordered by document ID by the runner.
+	Query func(fsClient *firestore.Client) firestore.Query
+
+	// Setup runs once before the first batch, e.g. to load lookup tables
+	Setup func(ctx context.Context, fsClient *firestore.Client, cfg *config.Config) error
+
+	Up DocFunc
+	// Down reverts Up for a single document. nil when the migration can't be rolled back.
+	Down DocFunc
+}
+
+var registry = map[string]Migration{}
+
+// Register adds a migration to the registry, called from init in each migration file
+func Register(m Migration) {
+	if _, exists := registry[m.ID]; exists {
+		panic("migrate: duplicate migration " + m.ID)
+	}
+	if m.Query == nil || m.Up == nil {
+		panic("migrate: migration " + m.ID + " needs a Query and an Up func")
+	}
+	registry[m.ID] = m
+}
+
+// All returns every registered migration in ID order
+func All() []Migration {
+	out := make([]Migration, 0, len(registry))
+	for _, m := range registry {
+		out = append(out, m)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+// Get returns a registered migration by ID
+func Get(id string) (Migration, bool) {
+	m, ok := registry[id]
+	return m, ok
+}
+
+// State is what's stored in _migrations/{id}
+type State struct {
+	ID         string    `firestore:"id" json:"id"`
+	Status     string    `firestore:"status" json:"status"`
+	Direction  string    `firestore:"direction" json:"direction"`
+	Cursor     string    `firestore:"cursor" json:"cursor,omitempty"`
+	Processed  int       `firestore:"processed" json:"processed"`
+	Updated    int       `firestore:"updated" json:"updated"`
+	StartedAt  time.Time `firestore:"startedAt" json:"startedAt"`
+	FinishedAt time.Time `firestore:"finishedAt,omitempty" json:"finishedAt,omitempty"`
+}
+
+type Options struct {
+	DryRun    bool
+	BatchSize int
+	// Config is passed to Setup, for migrations that need keys or URLs
+	Config *config.Config
+	// Log receives progress messages, defaults to discarding them
+	Log func(format string, args ...interface{})
+}
+
+type Runner struct {
+	fsClient *firestore.Client
+	opts     Options
+}
+
+func NewRunner(fsClient *firestore.Client, opts Options) *Runner {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 200
+	}
+	if opts.Log == nil {
+		opts.Log = func(string, ...interface{}) {}
+	}
+	return &Runner{fsClient: fsClient, opts: opts}
+}
+
+// Result summarizes one run of a migration
+type Result struct {
+	ID string
+	// Skipped is why the migration did not run, empty when it ran
+	Skipped   string
+	Processed int
+	Updated   int
+}
+
+var ErrIrreversible = errors.New("migration has no rollback")
+
+// LoadState returns the recorded state of a migration, or nil if it never ran
+func (r *Runner) LoadState(ctx context.Context, id string) (*State, error) {
+	snap, err := r.fsClient.Collection(versionCollection).Doc(id).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var state State
+	if err := snap.DataTo(&state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// Up applies every pending migration in order
+func (r *Runner) Up(ctx context.Context) ([]Result, error) {
+	results := []Result{}
+	for _, m := range All() {
+		res, err := r.Apply(ctx, m)
+		results = append(results, res)
+		if err != nil {
+			return results, fmt.Errorf("%s: %w", m.ID, err)
+		}
+	}
+	return results, nil
+}
+
+// Apply runs a single migration forward, resuming from its cursor if it was interrupted
+func (r *Runner) Apply(ctx context.Context, m Migration) (Result, error) {
+	state, err := r.LoadState(ctx, m.ID)
+	if err != nil {
+		return Result{ID: m.ID}, err
+	}
+	if state != nil && state.Status == StatusDone && state.Direction == "up" {
+		return Result{ID: m.ID, Skipped: "already applied"}, nil
+	}
+	// a rolled back or never started migration starts over, an interrupted one resumes
+	if state == nil || state.Direction != "up" || state.Status != StatusRunning {
+		state = &State{ID: m.ID, Direction: "up", StartedAt: time.Now()}
+	}
+	return r.run(ctx, m, m.Up, state)
+}
+
+// Rollback reverts a migration with its Down func
+func (r *Runner) Rollback(ctx context.Context, m Migration) (Result, error) {
+	if m.Down == nil {
+		return Result{ID: m.ID}, ErrIrreversible
+	}
+	state, err := r.LoadState(ctx, m.ID)
+	if err != nil {
+		return Result{ID: m.ID}, err
+	}
+	switch {
+	case state == nil:
+		return Result{ID: m.ID, Skipped: "not applied"}, nil
+	case state.Status == StatusRolledBack:
+		return Result{ID: m.ID, Skipped: "already rolled back"}, nil
+	}
+	if state.Direction != "down" || state.Status != StatusRunning {
+		state = &State{ID: m.ID, Direction: "down", StartedAt: time.Now()}
+	}
+	return r.run(ctx, m, m.Down, state)
+}
+
+func (r *Runner) run(ctx context.Context, m Migration, fn DocFunc, state *State) (Result, error) {
+	res := Result{ID: m.ID}
+	mode := ""
+	if r.opts.DryRun {
+		mode = " (dry-run)"
+	}
+	r.opts.Log("%s %s%s", state.Direction, m.ID, mode)
+	if state.Cursor != "" {
+		r.opts.Log("  resuming after %s (%d processed so far)", state.Cursor, state.Processed)
+	}
+
+	if m.Setup != nil {
+		if err := m.Setup(ctx, r.fsClient, r.opts.Config); err != nil {
+			return res, fmt.Errorf("setup: %w", err)
+		}
+	}
+
+	stateRef := r.fsClient.Collection(versionCollection).Doc(m.ID)
+	state.Status = StatusRunning
+	if !r.opts.DryRun {
+		if _, err := stateRef.Set(ctx, state); err != nil {
+			return res, fmt.Errorf("record state: %w", err)
+		}
+	}
+
+	cursor := state.Cursor
+	for {
+		q := m.Query(r.fsClient).OrderBy(firestore.DocumentID, firestore.Asc).Limit(r.opts.BatchSize)
+		if cursor != "" {
+			q = q.StartAfter(r.fsClient.Doc(cursor))
+		}
+		docs, err := q.Documents(ctx).GetAll()
+		if err != nil {
+			return res, fmt.Errorf("query batch: %w", err)
+		}
+		if len(docs) == 0 {
+			break
+		}
+
+		var bw *firestore.BulkWriter
+		if !r.opts.DryRun {
+			bw = r.fsClient.BulkWriter(ctx)
+		}
+		batchUpdated := 0
+		jobs := []*firestore.BulkWriterJob{}
+		for _, doc := range docs {
+			updates, err := fn(ctx, r.fsClient, doc)
+			if err != nil {
+				if bw != nil {
+					bw.End()
+				}
+				return res, fmt.Errorf("document %s: %w", doc.Ref.Path, err)
+			}
+			res.Processed++
+			if len(updates) == 0 {
+				continue
+			}
+			batchUpdated++
+			if bw != nil {
+				job, err := bw.Update(doc.Ref, updates)
+				if err != nil {
+					bw.End()
+					return res, fmt.Errorf("queue update %s: %w", doc.Ref.Path, err)
+				}
+				jobs = append(jobs, job)
+			}
+		}
+		if bw != nil {
+			bw.End()
+			// don't move the cursor past a batch that didn't fully apply
+			for _, job := range jobs {
+				if _, err := job.Results(); err != nil {
+					return res, fmt.Errorf("apply batch: %w", err)
+				}
+			}
+		}
+
+		cursor = relativePath(docs[len(docs)-1].Ref)
+		res.Updated += batchUpdated
+		state.Cursor = cursor
+		state.Processed += len(docs)
+		state.Updated += batchUpdated
+		r.opts.Log("  batch of %d, %d updated so far", len(docs), res.Updated)
+		if !r.opts.DryRun {
+			if _, err := stateRef.Set(ctx, state); err != nil {
+				return res, fmt.Errorf("record progress: %w", err)
+			}
+		}
+		if len(docs) < r.opts.BatchSize {
+			break
+		}
+	}
+
+	if r.opts.DryRun {
+		return res, nil
+	}
+
+	state.Cursor = ""
+	state.FinishedAt = time.Now()
+	state.Status = StatusDone
+	if state.Direction == "down" {
+		state.Status = StatusRolledBack
+	}
+	if _, err := stateRef.Set(ctx, state); err != nil {
+		return res, fmt.Errorf("record completion: %w", err)
+	}
+	return res, nil
+}
+
+// relativePath turns projects/p/databases/d/documents/a/b into a/b for Client.Doc
+func relativePath(ref *firestore.DocumentRef) string {
+	if i := strings.Index(ref.Path, "/documents/"); i >= 0 {
+		return ref.Path[i+len("/documents/"):]
+	}
+	return ref.Path
+}
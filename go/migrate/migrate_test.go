@@ -0,0 +1,61 @@
+package migrate
+
+import (
+	"context"
+	"testing"
+
+	"cloud.google.com/go/firestore"
+
+	"calple/firebase/firestoremock"
+)
+
+// a skipped run says why it was skipped
+func TestSkipped(t *testing.T) {
+	srv, err := firestoremock.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	ctx := context.Background()
+	fsClient, err := srv.Client(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fsClient.Close()
+
+	if _, err := fsClient.Collection("notes").Doc("n1").Set(ctx, map[string]interface{}{"text": "hi"}); err != nil {
+		t.Fatal(err)
+	}
+	m := Migration{
+		ID:    "0001_test",
+		Query: func(fsClient *firestore.Client) firestore.Query { return fsClient.Collection("notes").Query },
+		Up: func(context.Context, *firestore.Client, *firestore.DocumentSnapshot) ([]firestore.Update, error) {
+			return []firestore.Update{{Path: "migrated", Value: true}}, nil
+		},
+		Down: func(context.Context, *firestore.Client, *firestore.DocumentSnapshot) ([]firestore.Update, error) {
+			return []firestore.Update{{Path: "migrated", Value: firestore.Delete}}, nil
+		},
+	}
+	r := NewRunner(fsClient, Options{})
+
+	steps := []struct {
+		name    string
+		run     func(context.Context, Migration) (Result, error)
+		skipped string
+	}{
+		{"rollback before apply", r.Rollback, "not applied"},
+		{"apply", r.Apply, ""},
+		{"apply again", r.Apply, "already applied"},
+		{"rollback", r.Rollback, ""},
+		{"rollback again", r.Rollback, "already rolled back"},
+	}
+	for _, step := range steps {
+		res, err := step.run(ctx, m)
+		if err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if res.Skipped != step.skipped {
+			t.Errorf("%s: skipped %q, want %q", step.name, res.Skipped, step.skipped)
+		}
+	}
+}
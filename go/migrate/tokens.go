@@ -0,0 +1,51 @@
+package migrate
+
+import (
+	"context"
+	"time"
+
+	"cloud.google.com/go/firestore"
+
+	"calple/config"
+	"calple/secrets"
+)
+
+var tokenKeys secrets.KeyProvider
+
+func init() {
+	// users.tokens used to hold the google tokens in plaintext
+	Register(Migration{
+		ID:          "0005_encrypt_oauth_tokens",
+		Description: "envelope encrypt plaintext users.tokens",
+		Query: func(fsClient *firestore.Client) firestore.Query {
+			return fsClient.Collection("users").Query
+		},
+		Setup: func(ctx context.Context, fsClient *firestore.Client, cfg *config.Config) error {
+			kp, err := secrets.FromConfig(cfg.TokenEncryption)
+			if err != nil {
+				return err
+			}
+			tokenKeys = kp
+			return nil
+		},
+		Up: func(ctx context.Context, fsClient *firestore.Client, doc *firestore.DocumentSnapshot) ([]firestore.Update, error) {
+			raw, ok := doc.Data()["tokens"].(map[string]interface{})
+			if !ok {
+				return nil, nil
+			}
+			if _, sealed := secrets.EnvelopeFromMap(raw); sealed {
+				return nil, nil
+			}
+			tokens := secrets.OAuthTokens{}
+			tokens.AccessToken, _ = raw["access_token"].(string)
+			tokens.RefreshToken, _ = raw["refresh_token"].(string)
+			tokens.Expiry, _ = raw["expiry"].(time.Time)
+			env, err := secrets.SealJSON(ctx, tokenKeys, tokens)
+			if err != nil {
+				return nil, err
+			}
+			return []firestore.Update{{Path: "tokens", Value: env}}, nil
+		},
+		// writing the tokens back in plaintext is exactly what this removes
+	})
+}
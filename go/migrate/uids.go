@@ -0,0 +1,109 @@
+package migrate
+
+import (
+	"context"
+
+	"cloud.google.com/go/firestore"
+
+	"calple/config"
+	"calple/util"
+)
+
+// links between users used to be keyed by email. these migrations backfill
+// UID keys; the emails stay on the documents for display.
+
+// email -> uid, loaded by Setup
+var uidByEmail map[string]string
+
+func loadUIDsByEmail(ctx context.Context, fsClient *firestore.Client, _ *config.Config) error {
+	users, err := fsClient.Collection("users").Documents(ctx).GetAll()
+	if err != nil {
+		return err
+	}
+	uidByEmail = map[string]string{}
+	for _, u := range users {
+		if email := util.GetStringValue(u.Data(), "email"); email != "" {
+			uidByEmail[email] = u.Ref.ID
+		}
+	}
+	return nil
+}
+
+func init() {
+	Register(Migration{
+		ID:          "0001_connection_partner_uid",
+		Description: "set partnerUID on connections from partnerEmail",
+		Query: func(fsClient *firestore.Client) firestore.Query {
+			return fsClient.CollectionGroup("connections").Query
+		},
+		Setup: loadUIDsByEmail,
+		Up: func(ctx context.Context, fsClient *firestore.Client, doc *firestore.DocumentSnapshot) ([]firestore.Update, error) {
+			data := doc.Data()
+			if util.GetStringValue(data, "partnerUID") != "" {
+				return nil, nil
+			}
+			partnerUID, ok := uidByEmail[util.GetStringValue(data, "partnerEmail")]
+			if !ok {
+				// partner account is gone, the connection checker deals with these
+				return nil, nil
+			}
+			return []firestore.Update{{Path: "partnerUID", Value: partnerUID}}, nil
+		},
+		// handlers rely on partnerUID, there is nothing sensible to roll back to
+	})
+
+	Register(Migration{
+		ID:          "0002_dday_owner_uid",
+		Description: "set ownerUID and sharedWith on ddays from createdBy and connectedUsers",
+		Query: func(fsClient *firestore.Client) firestore.Query {
+			return fsClient.Collection("ddays").Query
+		},
+		Setup: loadUIDsByEmail,
+		Up: func(ctx context.Context, fsClient *firestore.Client, doc *firestore.DocumentSnapshot) ([]firestore.Update, error) {
+			data := doc.Data()
+			updates := []firestore.Update{}
+
+			ownerUID := util.GetStringValue(data, "ownerUID")
+			if ownerUID == "" {
+				uid, ok := uidByEmail[util.GetStringValue(data, "createdBy")]
+				if !ok {
+					return nil, nil
+				}
+				ownerUID = uid
+				updates = append(updates,
+					firestore.Update{Path: "ownerUID", Value: uid},
+					firestore.Update{Path: "ownerUIDBackfilled", Value: true})
+			}
+
+			if _, ok := data["sharedWith"]; !ok {
+				sharedWith := []string{}
+				for _, email := range util.ToStringSlice(data["connectedUsers"]) {
+					if uid, ok := uidByEmail[email]; ok && uid != ownerUID && !util.Contains(sharedWith, uid) {
+						sharedWith = append(sharedWith, uid)
+					}
+				}
+				updates = append(updates,
+					firestore.Update{Path: "sharedWith", Value: sharedWith},
+					firestore.Update{Path: "sharedWithBackfilled", Value: true})
+			}
+			return updates, nil
+		},
+		// only undo the fields this migration set, ddays created with them
+		// by the handlers keep them
+		Down: func(ctx context.Context, fsClient *firestore.Client, doc *firestore.DocumentSnapshot) ([]firestore.Update, error) {
+			data := doc.Data()
+			updates := []firestore.Update{}
+			if backfilled, _ := data["ownerUIDBackfilled"].(bool); backfilled {
+				updates = append(updates,
+					firestore.Update{Path: "ownerUID", Value: firestore.Delete},
+					firestore.Update{Path: "ownerUIDBackfilled", Value: firestore.Delete})
+			}
+			if backfilled, _ := data["sharedWithBackfilled"].(bool); backfilled {
+				updates = append(updates,
+					firestore.Update{Path: "sharedWith", Value: firestore.Delete},
+					firestore.Update{Path: "sharedWithBackfilled", Value: firestore.Delete})
+			}
+			return updates, nil
+		},
+	})
+}
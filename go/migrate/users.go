@@ -0,0 +1,77 @@
+package migrate
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+// startedDating is written as MM/DD/YYYY by UpdateUserMetadata, older
+// clients sent YYYY-MM-DD and some documents hold a timestamp
+const startedDatingLayout = "01/02/2006"
+
+var startedDatingLayouts = []string{
+	startedDatingLayout,
+	"1/2/2006",
+	"2006-01-02",
+	"2006/01/02",
+	"20060102",
+	time.RFC3339,
+}
+
+// normalizeStartedDating returns the canonical form of v, and false when v
+// is already canonical, empty or can't be parsed
+func normalizeStartedDating(v interface{}) (string, bool) {
+	switch val := v.(type) {
+	case time.Time:
+		return val.Format(startedDatingLayout), true
+	case string:
+		s := strings.TrimSpace(val)
+		if s == "" {
+			return "", false
+		}
+		if _, err := time.Parse(startedDatingLayout, s); err == nil && s == val {
+			return "", false
+		}
+		for _, layout := range startedDatingLayouts {
+			if t, err := time.Parse(layout, s); err == nil {
+				return t.Format(startedDatingLayout), true
+			}
+		}
+	}
+	return "", false
+}
+
+func init() {
+	Register(Migration{
+		ID:          "0004_started_dating_format",
+		Description: "normalize users.startedDating to MM/DD/YYYY",
+		Query: func(fsClient *firestore.Client) firestore.Query {
+			return fsClient.Collection("users").Query
+		},
+		Up: func(ctx context.Context, fsClient *firestore.Client, doc *firestore.DocumentSnapshot) ([]firestore.Update, error) {
+			data := doc.Data()
+			normalized, changed := normalizeStartedDating(data["startedDating"])
+			if !changed {
+				return nil, nil
+			}
+			return []firestore.Update{
+				{Path: "startedDating", Value: normalized},
+				// keep the original so the migration can be rolled back
+				{Path: "startedDatingOriginal", Value: data["startedDating"]},
+			}, nil
+		},
+		Down: func(ctx context.Context, fsClient *firestore.Client, doc *firestore.DocumentSnapshot) ([]firestore.Update, error) {
+			original, ok := doc.Data()["startedDatingOriginal"]
+			if !ok {
+				return nil, nil
+			}
+			return []firestore.Update{
+				{Path: "startedDating", Value: original},
+				{Path: "startedDatingOriginal", Value: firestore.Delete},
+			}, nil
+		},
+	})
+}
@@ -0,0 +1,236 @@
+package openapi
+
+import (
+	"path"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Mode says which side of the API a type is described for. responses mark
+// the fields that are always present as required; requests take required
+// fields and limits from the binding tags the handlers validate with.
+type Mode int
+
+const (
+	Output Mode = iota
+	Input
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// Generator turns Go types into schemas, structs become named components.
+// generate every response before the first request: a type used both ways
+// gets a second component with an "Input" suffix for the request side.
+// a type named like one of another package is prefixed with its package.
+type Generator struct {
+	schemas   map[string]*Schema
+	responses map[reflect.Type]bool
+	owners    map[string]reflect.Type
+}
+
+func NewGenerator() *Generator {
+	return &Generator{schemas: map[string]*Schema{}, responses: map[reflect.Type]bool{}, owners: map[string]reflect.Type{}}
+}
+
+// Schemas are the components generated so far
+func (g *Generator) Schemas() map[string]*Schema {
+	return g.schemas
+}
+
+// Schema describes the type of v
+func (g *Generator) Schema(v interface{}, mode Mode) *Schema {
+	return g.schema(reflect.TypeOf(v), mode)
+}
+
+// Inline is like Schema but describes a struct in place instead of as a
+// component, for types that only wrap other types
+func (g *Generator) Inline(v interface{}, mode Mode) *Schema {
+	t := reflect.TypeOf(v)
+	if t != nil && t.Kind() == reflect.Struct && t != timeType {
+		return g.object(t, mode)
+	}
+	return g.schema(t, mode)
+}
+
+func (g *Generator) schema(t reflect.Type, mode Mode) *Schema {
+	if t == nil {
+		return &Schema{}
+	}
+	if t == timeType {
+		return &Schema{Type: "string", Format: "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		s := g.schema(t.Elem(), mode)
+		if s.Ref != "" {
+			return &Schema{AllOf: []*Schema{s}, Nullable: true}
+		}
+		s.Nullable = true
+		return s
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number", Format: "double"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		// nil slices are written as null
+		return &Schema{Type: "array", Items: g.schema(t.Elem(), mode), Nullable: mode == Output && t.Kind() == reflect.Slice}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: g.schema(t.Elem(), mode), Nullable: mode == Output}
+	case reflect.Interface:
+		return &Schema{}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.object(t, mode)
+		}
+		return Ref(g.component(t, mode))
+	}
+	return &Schema{}
+}
+
+// component registers the struct under its name and returns the name
+func (g *Generator) component(t reflect.Type, mode Mode) string {
+	name := t.Name()
+	if owner, ok := g.owners[name]; ok && owner != t {
+		pkg := path.Base(t.PkgPath())
+		name = strings.ToUpper(pkg[:1]) + pkg[1:] + name
+	}
+	g.owners[name] = t
+	if mode == Output {
+		g.responses[t] = true
+	} else if g.responses[t] {
+		name += "Input"
+	}
+	if _, ok := g.schemas[name]; !ok {
+		// placeholder first, the type may refer to itself
+		g.schemas[name] = &Schema{}
+		*g.schemas[name] = *g.object(t, mode)
+	}
+	return name
+}
+
+func (g *Generator) object(t reflect.Type, mode Mode) *Schema {
+	s := &Schema{Type: "object", Properties: map[string]*Schema{}}
+	g.fields(s, t, mode)
+	return s
+}
+
+func (g *Generator) fields(s *Schema, t reflect.Type, mode Mode) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			g.fields(s, f.Type, mode)
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		prop := g.schema(f.Type, mode)
+		required := false
+		if mode == Input {
+			required = applyBinding(prop, f.Type, f.Tag.Get("binding"))
+		} else {
+			required = !strings.Contains(opts, "omitempty")
+		}
+		s.Properties[name] = prop
+		if required {
+			s.Required = append(s.Required, name)
+		}
+	}
+}
+
+// applyBinding adds the limits of a binding tag to the schema and reports
+// whether the field is required. rules after "dive" apply to the items.
+func applyBinding(s *Schema, t reflect.Type, tag string) bool {
+	required := false
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	target, kind := s, t.Kind()
+	for _, rule := range strings.Split(tag, ",") {
+		name, param, _ := strings.Cut(rule, "=")
+		switch name {
+		case "required":
+			required = target == s
+		case "notblank":
+			one := 1
+			target.MinLength = &one
+			target.Pattern = `\S`
+		case "dive":
+			if target.Items == nil {
+				return required
+			}
+			target, kind = target.Items, t.Elem().Kind()
+		case "min", "max":
+			n, err := strconv.Atoi(param)
+			if err != nil {
+				continue
+			}
+			limit(target, kind, name == "min", n)
+		case "oneof":
+			target.Enum = strings.Fields(param)
+		case "eq":
+			target.Enum = []string{param}
+		case "email":
+			target.Format = "email"
+		case "datetime":
+			if param == "2006-01-02" {
+				target.Format = "date"
+			} else {
+				target.Description = "formatted like " + param
+			}
+		case "compactdate":
+			target.Pattern = `^([0-9]{8})?$`
+			target.Description = "a date formatted like 20060102, or empty"
+		case "latitude":
+			lo, hi := -90.0, 90.0
+			target.Minimum, target.Maximum = &lo, &hi
+		case "longitude":
+			lo, hi := -180.0, 180.0
+			target.Minimum, target.Maximum = &lo, &hi
+		}
+	}
+	return required
+}
+
+func limit(s *Schema, kind reflect.Kind, min bool, n int) {
+	switch kind {
+	case reflect.String:
+		if min {
+			s.MinLength = &n
+		} else {
+			s.MaxLength = &n
+		}
+	case reflect.Slice, reflect.Array, reflect.Map:
+		if min {
+			s.MinItems = &n
+		} else {
+			s.MaxItems = &n
+		}
+	default:
+		f := float64(n)
+		if min {
+			s.Minimum = &f
+		} else {
+			s.Maximum = &f
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package openapi
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Validate checks a decoded JSON value against a schema of the document and
+// returns one line per mismatch. properties a schema does not declare are
+// reported too, that is how handlers drifting from the spec show up.
+func (d *Document) Validate(s *Schema, v interface{}) []string {
+	var problems []string
+	d.validate(s, v, "$", &problems)
+	return problems
+}
+
+func (d *Document) resolve(s *Schema) *Schema {
+	for s != nil && s.Ref != "" {
+		s = d.Components.Schemas[strings.TrimPrefix(s.Ref, "#/components/schemas/")]
+	}
+	return s
+}
+
+func (d *Document) validate(s *Schema, v interface{}, path string, problems *[]string) {
+	nullable := s != nil && s.Nullable
+	s = d.resolve(s)
+	if s == nil {
+		return
+	}
+	if len(s.AllOf) > 0 {
+		if v == nil && nullable {
+			return
+		}
+		for _, sub := range s.AllOf {
+			d.validate(sub, v, path, problems)
+		}
+		return
+	}
+	if v == nil {
+		if !nullable && s.Type != "" {
+			*problems = append(*problems, path+" is null")
+		}
+		return
+	}
+
+	fail := func(format string, args ...interface{}) {
+		*problems = append(*problems, path+" "+fmt.Sprintf(format, args...))
+	}
+	switch s.Type {
+	case "":
+		return
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			fail("is %s, want string", jsonType(v))
+			return
+		}
+		if len(s.Enum) > 0 && !contains(s.Enum, str) {
+			fail("is %q, want one of %s", str, strings.Join(s.Enum, ", "))
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			fail("is %s, want boolean", jsonType(v))
+		}
+	case "integer", "number":
+		n, ok := v.(float64)
+		if !ok {
+			fail("is %s, want %s", jsonType(v), s.Type)
+		} else if s.Type == "integer" && n != math.Trunc(n) {
+			fail("is %v, want integer", n)
+		}
+	case "array":
+		items, ok := v.([]interface{})
+		if !ok {
+			fail("is %s, want array", jsonType(v))
+			return
+		}
+		for i, item := range items {
+			d.validate(s.Items, item, fmt.Sprintf("%s[%d]", path, i), problems)
+		}
+	case "object":
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			fail("is %s, want object", jsonType(v))
+			return
+		}
+		for _, name := range s.Required {
+			if _, ok := obj[name]; !ok {
+				fail("is missing %q", name)
+			}
+		}
+		keys := make([]string, 0, len(obj))
+		for k := range obj {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if prop, ok := s.Properties[k]; ok {
+				d.validate(prop, obj[k], path+"."+k, problems)
+			} else if s.AdditionalProperties != nil {
+				d.validate(s.AdditionalProperties, obj[k], path+"."+k, problems)
+			} else if s.Properties != nil {
+				fail("has undocumented property %q", k)
+			}
+		}
+	}
+}
+
+func jsonType(v interface{}) string {
+	switch v.(type) {
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return "null"
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
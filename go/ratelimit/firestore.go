@@ -0,0 +1,51 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"calple/util"
+)
+
+// FirestoreStore keeps buckets in the rate_limits collection so all
+// instances share them. document IDs are hashes of the keys, which contain
+// client IPs; a TTL policy on expiresAt removes buckets that refilled.
+// every take is a transaction on the bucket's document, so concurrent
+// requests of one client contend and the losers fail open. that is fine for
+// the low limits of single routes but not for policies on every request,
+// those are Local and stay in memory.
+type FirestoreStore struct {
+	fsClient *firestore.Client
+}
+
+func NewFirestoreStore(fsClient *firestore.Client) *FirestoreStore {
+	return &FirestoreStore{fsClient: fsClient}
+}
+
+func (f *FirestoreStore) Take(ctx context.Context, key string, limit Limit, now time.Time) (Result, error) {
+	ref := f.fsClient.Collection("rate_limits").Doc(util.HashToken(key))
+	var res Result
+	err := f.fsClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		var b bucket
+		doc, err := tx.Get(ref)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		if err == nil {
+			if err := doc.DataTo(&b); err != nil {
+				return err
+			}
+		}
+		res = b.take(limit, now)
+		return tx.Set(ref, map[string]interface{}{
+			"tokens":    b.Tokens,
+			"updated":   b.Updated,
+			"expiresAt": now.Add(limit.refill()),
+		})
+	})
+	return res, err
+}
@@ -0,0 +1,76 @@
+package ratelimit
+
+import (
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+
+	"calple/apierr"
+	"calple/logging"
+)
+
+// Policy is the limit of a group of routes. requests take from the bucket of
+// the client IP and, when signed in, from the bucket of the user; both have
+// to allow the request.
+type Policy struct {
+	Name    string
+	PerIP   Limit
+	PerUser Limit
+	// Local policies count in the memory of each instance whatever the
+	// store, they run on every request and a shared store can't keep up
+	Local bool
+}
+
+// Middleware rejects requests over the policy with 429 and a Retry-After
+// header. when the store fails the request goes through, an outage of the
+// limiter shouldn't take the API down with it.
+func Middleware(store Store, p Policy) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		now := time.Now()
+		var retryAfter time.Duration
+
+		if p.PerIP.enabled() {
+			retryAfter = max(retryAfter, take(c, store, p.Name+":ip:"+c.ClientIP(), p.PerIP, now))
+		}
+		if uid := requestUID(c); uid != "" && p.PerUser.enabled() {
+			retryAfter = max(retryAfter, take(c, store, p.Name+":user:"+uid, p.PerUser, now))
+		}
+
+		if retryAfter > 0 {
+			logging.FromContext(c.Request.Context()).Info("rate limited", "policy", p.Name)
+			seconds := int(math.Ceil(retryAfter.Seconds()))
+			c.Header("Retry-After", strconv.Itoa(seconds))
+			apierr.Abort(c, apierr.TooManyRequests("Too many requests, try again later"))
+			return
+		}
+		c.Next()
+	}
+}
+
+// take returns how long the request has to wait, zero when it is allowed
+func take(c *gin.Context, store Store, key string, limit Limit, now time.Time) time.Duration {
+	res, err := store.Take(c.Request.Context(), key, limit, now)
+	if err != nil {
+		logging.FromContext(c.Request.Context()).Warn("rate limit store failed", "error", err)
+		return 0
+	}
+	if res.Allowed {
+		return 0
+	}
+	return res.RetryAfter
+}
+
+// requestUID is the signed in user, from the bearer token or the session
+func requestUID(c *gin.Context) string {
+	if uid := c.GetString("authUID"); uid != "" {
+		return uid
+	}
+	if s, ok := c.Get(sessions.DefaultKey); ok {
+		uid, _ := s.(sessions.Session).Get("user_id").(string)
+		return uid
+	}
+	return ""
+}
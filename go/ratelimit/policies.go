@@ -0,0 +1,22 @@
+package ratelimit
+
+// the policies of the API. Global applies to every request, the others to
+// the routes that are expensive or can be abused.
+var (
+	Global = Policy{Name: "global", PerIP: PerMinute(300), Local: true}
+
+	// API is every authenticated route
+	API = Policy{Name: "api", PerUser: PerMinute(120), Local: true}
+
+	// Auth covers sign in, callbacks and token refresh
+	Auth = Policy{Name: "auth", PerIP: PerMinute(20)}
+
+	// Invite covers invitations by email and invite link redemption
+	Invite = Policy{Name: "invite", PerIP: PerHour(30), PerUser: PerHour(10)}
+
+	// Feedback limits feedback submissions
+	Feedback = Policy{Name: "feedback", PerIP: PerHour(20), PerUser: PerHour(5)}
+
+	// Upload limits presigned upload URLs
+	Upload = Policy{Name: "upload", PerIP: PerHour(60), PerUser: PerHour(30)}
+)
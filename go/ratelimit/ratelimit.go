@@ -0,0 +1,74 @@
+// Package ratelimit throttles requests with token buckets. a bucket holds up
+// to Burst requests and earns one back every Every; each request takes one.
+// buckets are kept per client IP and per signed in user.
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// Limit is the size and refill speed of a bucket. the zero Limit is no limit.
+type Limit struct {
+	Burst int
+	Every time.Duration
+}
+
+// PerMinute allows n requests a minute, all of them at once if need be
+func PerMinute(n int) Limit {
+	return Limit{Burst: n, Every: time.Minute / time.Duration(n)}
+}
+
+// PerHour allows n requests an hour, all of them at once if need be
+func PerHour(n int) Limit {
+	return Limit{Burst: n, Every: time.Hour / time.Duration(n)}
+}
+
+func (l Limit) enabled() bool {
+	return l.Burst > 0 && l.Every > 0
+}
+
+// refill is how long an empty bucket takes to fill up, after that the bucket
+// is the same as a new one and can be forgotten
+func (l Limit) refill() time.Duration {
+	return time.Duration(l.Burst) * l.Every
+}
+
+// Result of taking a request from a bucket
+type Result struct {
+	Allowed   bool
+	Remaining int
+	// when the next request will be allowed, zero when Allowed
+	RetryAfter time.Duration
+}
+
+// Store keeps the buckets. the memory store is per instance, the firestore
+// store is shared between instances.
+type Store interface {
+	Take(ctx context.Context, key string, limit Limit, now time.Time) (Result, error)
+}
+
+// bucket is the state of one key
+type bucket struct {
+	Tokens  float64   `firestore:"tokens"`
+	Updated time.Time `firestore:"updated"`
+}
+
+// take refills the bucket for the time since it was last used and takes one
+// request out of it
+func (b *bucket) take(l Limit, now time.Time) Result {
+	if b.Updated.IsZero() {
+		b.Tokens = float64(l.Burst)
+	} else if elapsed := now.Sub(b.Updated); elapsed > 0 {
+		b.Tokens = math.Min(float64(l.Burst), b.Tokens+float64(elapsed)/float64(l.Every))
+	}
+	b.Updated = now
+
+	if b.Tokens < 1 {
+		wait := time.Duration((1 - b.Tokens) * float64(l.Every))
+		return Result{RetryAfter: wait}
+	}
+	b.Tokens--
+	return Result{Allowed: true, Remaining: int(b.Tokens)}
+}
@@ -0,0 +1,74 @@
+package secrets
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"calple/config"
+)
+
+// ErrNoKey means TOKEN_ENCRYPTION_KEY is not configured
+var ErrNoKey = errors.New("secrets: TOKEN_ENCRYPTION_KEY is not set")
+
+// EnvKeyProvider wraps data keys with 32 byte keys from the configuration.
+// the current key wraps new data keys, previous keys only unwrap.
+type EnvKeyProvider struct {
+	current string
+	keys    map[string][]byte
+}
+
+// FromConfig uses TOKEN_ENCRYPTION_KEY and, for rotation,
+// TOKEN_ENCRYPTION_KEY_PREVIOUS. keys are base64, 32 bytes once decoded.
+func FromConfig(cfg config.KeySet) (*EnvKeyProvider, error) {
+	if cfg.Key == "" {
+		return nil, ErrNoKey
+	}
+	encoded := append([]string{cfg.Key}, cfg.Previous...)
+
+	p := &EnvKeyProvider{keys: map[string][]byte{}}
+	for i, enc := range encoded {
+		key, err := base64.StdEncoding.DecodeString(enc)
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("secrets: key %d must be 32 base64 encoded bytes", i)
+		}
+		id := keyID(key)
+		p.keys[id] = key
+		if i == 0 {
+			p.current = id
+		}
+	}
+	return p, nil
+}
+
+// keyID names a key without revealing it
+func keyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return "env:" + hex.EncodeToString(sum[:4])
+}
+
+func (p *EnvKeyProvider) WrapKey(ctx context.Context, dek []byte) ([]byte, string, error) {
+	nonce, ciphertext, err := gcmSeal(p.keys[p.current], dek)
+	if err != nil {
+		return nil, "", err
+	}
+	return append(nonce, ciphertext...), p.current, nil
+}
+
+func (p *EnvKeyProvider) UnwrapKey(ctx context.Context, wrapped []byte, id string) ([]byte, error) {
+	key, ok := p.keys[id]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < gcm.NonceSize() {
+		return nil, errors.New("secrets: wrapped key too short")
+	}
+	return gcm.Open(nil, wrapped[:gcm.NonceSize()], wrapped[gcm.NonceSize():], nil)
+}
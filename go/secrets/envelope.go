@@ -0,0 +1,133 @@
+// Package secrets encrypts sensitive fields before they are stored and keeps
+// them out of API responses.
+//
+// values are envelope encrypted: every value gets its own random data key
+// (DEK), the value is sealed with the DEK using AES-256-GCM, and the DEK is
+// wrapped by a KeyProvider. only the wrapped DEK is stored, so rotating or
+// moving the key encryption key never touches the ciphertext.
+package secrets
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+const algorithm = "AES-256-GCM"
+
+// Envelope is the stored form of an encrypted value
+type Envelope struct {
+	Alg        string `firestore:"alg" json:"-"`
+	KeyID      string `firestore:"keyId" json:"-"`
+	WrappedKey []byte `firestore:"wrappedKey" json:"-"`
+	Nonce      []byte `firestore:"nonce" json:"-"`
+	Ciphertext []byte `firestore:"ciphertext" json:"-"`
+}
+
+// KeyProvider wraps and unwraps data keys with a key encryption key. an env
+// key is used locally, a KMS backed provider can implement the same interface.
+type KeyProvider interface {
+	WrapKey(ctx context.Context, dek []byte) (wrapped []byte, keyID string, err error)
+	UnwrapKey(ctx context.Context, wrapped []byte, keyID string) ([]byte, error)
+}
+
+var ErrUnknownKey = errors.New("secrets: unknown key id")
+
+// Seal encrypts plaintext under a fresh data key
+func Seal(ctx context.Context, kp KeyProvider, plaintext []byte) (*Envelope, error) {
+	dek := make([]byte, 32)
+	if _, err := rand.Read(dek); err != nil {
+		return nil, err
+	}
+	nonce, ciphertext, err := gcmSeal(dek, plaintext)
+	if err != nil {
+		return nil, err
+	}
+	wrapped, keyID, err := kp.WrapKey(ctx, dek)
+	if err != nil {
+		return nil, fmt.Errorf("wrap key: %w", err)
+	}
+	return &Envelope{
+		Alg:        algorithm,
+		KeyID:      keyID,
+		WrappedKey: wrapped,
+		Nonce:      nonce,
+		Ciphertext: ciphertext,
+	}, nil
+}
+
+// Open decrypts an envelope created by Seal
+func Open(ctx context.Context, kp KeyProvider, env *Envelope) ([]byte, error) {
+	if env.Alg != algorithm {
+		return nil, fmt.Errorf("secrets: unsupported algorithm %q", env.Alg)
+	}
+	dek, err := kp.UnwrapKey(ctx, env.WrappedKey, env.KeyID)
+	if err != nil {
+		return nil, fmt.Errorf("unwrap key: %w", err)
+	}
+	return gcmOpen(dek, env.Nonce, env.Ciphertext)
+}
+
+// EnvelopeFromMap reads an envelope back from a Firestore field
+func EnvelopeFromMap(m map[string]interface{}) (*Envelope, bool) {
+	env := &Envelope{}
+	env.Alg, _ = m["alg"].(string)
+	env.KeyID, _ = m["keyId"].(string)
+	env.WrappedKey, _ = m["wrappedKey"].([]byte)
+	env.Nonce, _ = m["nonce"].([]byte)
+	env.Ciphertext, _ = m["ciphertext"].([]byte)
+	if env.Alg == "" || env.WrappedKey == nil || env.Ciphertext == nil {
+		return nil, false
+	}
+	return env, true
+}
+
+func gcmSeal(key, plaintext []byte) (nonce, ciphertext []byte, err error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	nonce = make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, nil, err
+	}
+	return nonce, gcm.Seal(nil, nonce, plaintext, nil), nil
+}
+
+func gcmOpen(key, nonce, ciphertext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	return gcm.Open(nil, nonce, ciphertext, nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// SealJSON encrypts v marshalled as JSON
+func SealJSON(ctx context.Context, kp KeyProvider, v interface{}) (*Envelope, error) {
+	plaintext, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return Seal(ctx, kp, plaintext)
+}
+
+// OpenJSON decrypts an envelope created by SealJSON into v
+func OpenJSON(ctx context.Context, kp KeyProvider, env *Envelope, v interface{}) error {
+	plaintext, err := Open(ctx, kp, env)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(plaintext, v)
+}
@@ -0,0 +1,46 @@
+package secrets
+
+// fields that are never sent to clients, at any depth
+var secretFields = map[string]bool{
+	"tokens":        true,
+	"access_token":  true,
+	"refresh_token": true,
+	"id_token":      true,
+	"password":      true,
+	"secret":        true,
+}
+
+// Scrub returns a copy of a document with secret fields and encrypted values
+// removed. use it on every Firestore document that ends up in a response.
+func Scrub(data map[string]interface{}) map[string]interface{} {
+	if data == nil {
+		return nil
+	}
+	out := make(map[string]interface{}, len(data))
+	for k, v := range data {
+		if secretFields[k] {
+			continue
+		}
+		if m, ok := v.(map[string]interface{}); ok {
+			if _, sealed := EnvelopeFromMap(m); sealed {
+				continue
+			}
+		}
+		out[k] = scrubValue(v)
+	}
+	return out
+}
+
+func scrubValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		return Scrub(val)
+	case []interface{}:
+		out := make([]interface{}, len(val))
+		for i, item := range val {
+			out[i] = scrubValue(item)
+		}
+		return out
+	}
+	return v
+}
@@ -0,0 +1,10 @@
+package secrets
+
+import "time"
+
+// OAuthTokens is the plaintext sealed into users/{uid}.tokens
+type OAuthTokens struct {
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	Expiry       time.Time `json:"expiry"`
+}
@@ -0,0 +1,226 @@
+// Package server puts the routes and middleware of the backend together
+// into one handler, cmd starts it and tests serve it in process
+package server
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/gin-contrib/cors"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+
+	"calple/api"
+	"calple/apierr"
+	"calple/apitoken"
+	"calple/auth"
+	"calple/config"
+	"calple/events"
+	"calple/handlers"
+	"calple/logging"
+	"calple/mailer"
+	"calple/ratelimit"
+	"calple/secrets"
+	"calple/sessionstore"
+	"calple/telemetry"
+)
+
+// Deps are what the handlers work with
+type Deps struct {
+	Config    *config.Config
+	Logger    *slog.Logger
+	Firestore *firestore.Client
+	// Sessions holds the sessions the cookies point to
+	Sessions  sessionstore.Backend
+	Providers *auth.Registry
+	Events    *events.Hub
+	// Mailer sends sign in links, nil turns sign in by email off
+	Mailer mailer.Mailer
+	// Secrets encrypts the oauth tokens of sign ins, nil leaves them unstored
+	Secrets secrets.KeyProvider
+	// Limiter keeps the rate limit buckets, nil turns the limits off
+	Limiter ratelimit.Store
+}
+
+// the headers of the TRUSTED_PLATFORM names that hold the client IP
+var trustedPlatforms = map[string]string{
+	"cloudflare": gin.PlatformCloudflare,
+	"appengine":  gin.PlatformGoogleAppEngine,
+	"flyio":      gin.PlatformFlyIO,
+}
+
+// New returns the router of the backend
+func New(d Deps) (*gin.Engine, error) {
+	cfg := d.Config
+
+	router := gin.New()
+	// every request gets an ID (X-Request-ID) that its log records and error
+	// responses carry, panics are logged and answered without internals
+	router.Use(logging.RequestID(d.Logger), telemetry.Middleware(), logging.AccessLog(), apierr.Recovery())
+	router.NoRoute(func(c *gin.Context) {
+		apierr.Abort(c, apierr.NotFound("Not found"))
+	})
+
+	// X-Forwarded-For only counts from the configured proxies, gin would
+	// believe it from anyone
+	if err := router.SetTrustedProxies(cfg.Proxies()); err != nil {
+		return nil, err
+	}
+	router.TrustedPlatform = trustedPlatforms[cfg.Server.TrustedPlatform]
+
+	// session
+	// the cookie only holds a signed session ID, the session itself lives in
+	// the backend. SECRET_KEY signs new cookies, SECRET_KEY_PREVIOUS keeps
+	// cookies signed with rotated out keys valid.
+	keys := cfg.SessionKeys()
+	store := sessionstore.NewStore(d.Sessions, keys...)
+	store.Options(sessions.Options{
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   !cfg.IsDevelopment(),
+		SameSite: func() http.SameSite {
+			if cfg.IsDevelopment() {
+				return http.SameSiteLaxMode
+			}
+			return http.SameSiteNoneMode
+		}(),
+		Domain: cfg.Session.CookieDomain,
+		MaxAge: 12 * 60 * 60,
+	})
+	router.Use(sessionstore.ClientIP(), sessions.Sessions("calple_session", store))
+
+	// access tokens for native clients are signed with keys derived from the
+	// same secrets as the session cookies
+	tokenSigner := apitoken.NewSigner(keys...)
+
+	// CORS
+	corsConfig := cors.Config{
+		AllowOrigins:     cfg.AllowedOrigins(),
+		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE"},
+		AllowHeaders:     []string{"Content-Type", "Authorization", logging.RequestIDHeader, "If-Match"},
+		ExposeHeaders:    []string{"Set-Cookie", logging.RequestIDHeader, "Retry-After", "ETag"},
+		AllowCredentials: true,
+		MaxAge:           12 * time.Hour,
+	}
+	router.Use(cors.New(corsConfig))
+
+	// rate limits, the buckets live where RATE_LIMIT_STORE says except for
+	// the local policies on every request
+	local := ratelimit.NewMemoryStore()
+	limit := func(p ratelimit.Policy) gin.HandlerFunc {
+		if d.Limiter == nil {
+			return func(c *gin.Context) { c.Next() }
+		}
+		if p.Local {
+			return ratelimit.Middleware(local, p)
+		}
+		return ratelimit.Middleware(d.Limiter, p)
+	}
+	router.Use(limit(ratelimit.Global))
+
+	// firestore into context
+	// this middleware sets the firestore client in the context for use in handlers
+	router.Use(func(c *gin.Context) {
+		c.Set("config", cfg)
+		c.Set("firestore", d.Firestore)
+		c.Set("sessions", d.Sessions)
+		c.Set("authProviders", d.Providers)
+		c.Set("tokenSigner", tokenSigner)
+		c.Set("events", d.Events)
+		if d.Mailer != nil {
+			c.Set("mailer", d.Mailer)
+		}
+		if d.Secrets != nil {
+			c.Set("secrets", d.Secrets)
+		}
+		c.Next()
+	})
+
+	// handlers stop waiting on storage after REQUEST_TIMEOUT
+	router.Use(handlers.RequestTimeout(cfg.Server.RequestTimeout.D(), api.StreamPaths("/api", "/api/v1")...))
+
+	// personal access tokens and access tokens from the Authorization header
+	router.Use(handlers.BearerAuth())
+
+	// sign in routes share a tighter per IP limit
+	authLimit := limit(ratelimit.Auth)
+
+	// auth routes
+	router.GET("/google/oauth/login", authLimit, handlers.Login)
+	router.GET("/google/oauth/callback", authLimit, handlers.Callback)
+	router.GET("/google/oauth/logout", handlers.Logout)
+
+	// other identity providers
+	router.GET("/auth/providers", handlers.GetAuthProviders)
+	router.GET("/auth/login", handlers.ChooseLogin)
+	router.GET("/auth/:provider/login", authLimit, handlers.ProviderLogin)
+	router.GET("/auth/:provider/callback", authLimit, handlers.ProviderCallback)
+	router.POST("/auth/:provider/callback", authLimit, handlers.ProviderCallback)
+
+	// magic link sign in
+	router.POST("/auth/magic/request", authLimit, handlers.RequestMagicLink)
+	router.GET("/auth/magic/verify", handlers.ShowMagicLink)
+	router.POST("/auth/magic/verify", authLimit, handlers.VerifyMagicLink)
+
+	// token endpoint of native clients, refresh tokens and device codes
+	router.POST("/auth/token", authLimit, handlers.Token)
+
+	// device sign in for clients without a browser, like the CLI
+	router.POST("/auth/device/code", authLimit, handlers.StartDeviceAuthorization)
+	router.GET("/auth/device", handlers.ShowDeviceAuthorization)
+	router.POST("/auth/device", authLimit, handlers.ConfirmDeviceAuthorization)
+
+	// prometheus metrics, METRICS_TOKEN protects them when set
+	router.GET("/metrics", telemetry.MetricsHandler(cfg.Metrics.Token))
+
+	// health check endpoint
+	router.GET("/health", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{
+			"status":      "healthy",
+			"timestamp":   time.Now().UTC(),
+			"environment": cfg.Env,
+		})
+	})
+
+	// firebase connectivity test endpoint
+	router.GET("/api/health/firebase", func(c *gin.Context) {
+		fsClient := c.MustGet("firestore").(*firestore.Client)
+		ctx := c.Request.Context()
+
+		// try to access Firestore to test connectivity
+		_, err := fsClient.Collection("_health_check").Doc("test").Get(ctx)
+		if err != nil {
+			// this is expected to fail since the document doesn't exist
+			if strings.Contains(err.Error(), "NotFound") {
+				c.JSON(http.StatusOK, gin.H{
+					"status":  "firebase_connected",
+					"message": "Firebase connection is working (document not found is expected)",
+				})
+			} else {
+				apierr.Abort(c, apierr.Unavailable("Firebase connection failed").WithCause(err))
+			}
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"status":  "firebase_connected",
+			"message": "Firebase connection is working",
+		})
+	})
+
+	// the JSON API, one route table serves both versions. /api answers as
+	// it always did, /api/v1 wraps responses in {"data", "meta"} and serves
+	// its OpenAPI document at /api/v1/openapi.json. in development v1
+	// responses are checked against the document.
+	api.Register(router.Group("/api", limit(ratelimit.API)), api.Options{Limit: limit})
+	api.Register(router.Group("/api/v1", limit(ratelimit.API)), api.Options{
+		Versioned:     true,
+		CheckContract: cfg.IsDevelopment(),
+		Limit:         limit,
+	})
+
+	return router, nil
+}
@@ -0,0 +1,200 @@
+// Package servertest serves the backend in process on firestoremock, for
+// tests of the API and of its clients
+package servertest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"sync"
+	"testing"
+
+	"cloud.google.com/go/firestore"
+	"github.com/gin-gonic/gin"
+
+	"calple/apierr"
+	"calple/auth"
+	"calple/config"
+	"calple/events"
+	"calple/firebase/firestoremock"
+	"calple/mailer"
+	"calple/server"
+	"calple/sessionstore"
+)
+
+// Server is a running backend, stopped when the test ends
+type Server struct {
+	URL       string
+	Config    *config.Config
+	Firestore *firestore.Client
+	// Mailer has the mail the server sent, sign in links among it
+	Mailer *mailer.Memory
+}
+
+var (
+	validatorsOnce sync.Once
+	validatorsErr  error
+)
+
+// Start serves the backend the way cmd sets it up, in development mode and
+// without rate limits
+func Start(t testing.TB) *Server {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	validatorsOnce.Do(func() { validatorsErr = apierr.RegisterValidators() })
+	if validatorsErr != nil {
+		t.Fatal(validatorsErr)
+	}
+
+	mock, err := firestoremock.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(mock.Close)
+	fsClient, err := mock.Client(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { fsClient.Close() })
+
+	// the address is known before the router is, links in mail point to it
+	srv := httptest.NewUnstartedServer(nil)
+	cfg := &config.Config{
+		Env:         config.Development,
+		FrontendURL: "http://app.test",
+		APIBaseURL:  "http://" + srv.Listener.Addr().String(),
+		Session:     config.Session{SecretKey: "0123456789abcdef0123456789abcdef", Store: "memory"},
+	}
+	providers, err := auth.FromConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hub := events.NewHub()
+	m := &mailer.Memory{}
+	router, err := server.New(server.Deps{
+		Config:    cfg,
+		Logger:    slog.New(slog.NewTextHandler(io.Discard, nil)),
+		Firestore: fsClient,
+		Sessions:  sessionstore.NewMemoryBackend(),
+		Providers: providers,
+		Events:    hub,
+		Mailer:    m,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.Config.Handler = router
+	srv.Config.RegisterOnShutdown(hub.Close)
+	srv.Start()
+	t.Cleanup(func() {
+		hub.Close()
+		srv.Close()
+	})
+
+	return &Server{URL: srv.URL, Config: cfg, Firestore: fsClient, Mailer: m}
+}
+
+var signInLink = regexp.MustCompile(`token=(\S+)`)
+
+// SignIn signs in by email, creating the account on the first sign in,
+// and returns a client with the session cookie
+func (s *Server) SignIn(t testing.TB, email string) *http.Client {
+	t.Helper()
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{
+		Jar: jar,
+		// the sign in ends with a redirect to the frontend
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+
+	before := len(s.Mailer.Sent())
+	body, _ := json.Marshal(map[string]string{"email": email})
+	resp, err := client.Post(s.URL+"/auth/magic/request", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	sent := s.Mailer.Sent()
+	if resp.StatusCode != http.StatusOK || len(sent) == before {
+		t.Fatalf("sign in link for %s: %s", email, resp.Status)
+	}
+	match := signInLink.FindStringSubmatch(sent[len(sent)-1].Text)
+	if match == nil {
+		t.Fatalf("no sign in link in %q", sent[len(sent)-1].Text)
+	}
+
+	resp, err = client.PostForm(s.URL+"/auth/magic/verify", url.Values{"token": {match[1]}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound || len(jar.Cookies(resp.Request.URL)) == 0 {
+		t.Fatalf("sign in as %s: %s", email, resp.Status)
+	}
+	return client
+}
+
+// UID is the ID of the account of email
+func (s *Server) UID(t testing.TB, email string) string {
+	t.Helper()
+	docs, err := s.Firestore.Collection("users").Where("email", "==", email).Documents(context.Background()).GetAll()
+	if err != nil || len(docs) != 1 {
+		t.Fatalf("account of %s: %d, %v", email, len(docs), err)
+	}
+	return docs[0].Ref.ID
+}
+
+// Token creates a personal access token with scopes through the session
+// of client
+func (s *Server) Token(t testing.TB, client *http.Client, scopes ...string) string {
+	t.Helper()
+	body, _ := json.Marshal(map[string]interface{}{"name": "test", "scopes": scopes})
+	resp, err := client.Post(s.URL+"/api/tokens", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var created struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil || resp.StatusCode != http.StatusCreated {
+		t.Fatalf("create token: %s %v", resp.Status, err)
+	}
+	return created.Token
+}
+
+var deviceCSRF = regexp.MustCompile(`name="csrf" value="([^"]+)"`)
+
+// ApproveDevice approves a device sign in with userCode in the browser
+// session of client, like the user does on /auth/device
+func (s *Server) ApproveDevice(t testing.TB, client *http.Client, userCode string) {
+	t.Helper()
+	resp, err := client.Get(s.URL + "/auth/device?user_code=" + url.QueryEscape(userCode))
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	match := deviceCSRF.FindSubmatch(page)
+	if match == nil {
+		t.Fatalf("device page: %s, no CSRF token", resp.Status)
+	}
+
+	resp, err = client.PostForm(s.URL+"/auth/device", url.Values{
+		"csrf": {string(match[1])}, "user_code": {userCode}, "action": {"approve"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("approve device %s: %s", userCode, resp.Status)
+	}
+}
@@ -0,0 +1,82 @@
+package sessionstore
+
+import (
+	"context"
+	"strings"
+
+	"cloud.google.com/go/firestore"
+)
+
+// FirestoreBackend stores sessions in the sessions collection. expired
+// records are dropped when they are read; a TTL policy on expiresAt cleans
+// up the ones that never are.
+type FirestoreBackend struct {
+	fsClient *firestore.Client
+}
+
+func NewFirestoreBackend(fsClient *firestore.Client) *FirestoreBackend {
+	return &FirestoreBackend{fsClient: fsClient}
+}
+
+func (f *FirestoreBackend) col() *firestore.CollectionRef {
+	return f.fsClient.Collection("sessions")
+}
+
+func (f *FirestoreBackend) Load(ctx context.Context, id string) (*Record, error) {
+	doc, err := f.col().Doc(id).Get(ctx)
+	if err != nil {
+		if strings.Contains(err.Error(), "NotFound") {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var rec Record
+	if err := doc.DataTo(&rec); err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+func (f *FirestoreBackend) Save(ctx context.Context, rec *Record) error {
+	_, err := f.col().Doc(rec.ID).Set(ctx, rec)
+	return err
+}
+
+func (f *FirestoreBackend) Delete(ctx context.Context, id string) error {
+	_, err := f.col().Doc(id).Delete(ctx)
+	return err
+}
+
+func (f *FirestoreBackend) List(ctx context.Context, uid string) ([]*Record, error) {
+	docs, err := f.col().Where("uid", "==", uid).OrderBy("lastSeen", firestore.Desc).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]*Record, 0, len(docs))
+	for _, doc := range docs {
+		var rec Record
+		if err := doc.DataTo(&rec); err != nil {
+			continue
+		}
+		out = append(out, &rec)
+	}
+	return out, nil
+}
+
+func (f *FirestoreBackend) DeleteAll(ctx context.Context, uid, except string) (int, error) {
+	docs, err := f.col().Where("uid", "==", uid).Documents(ctx).GetAll()
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, doc := range docs {
+		if doc.Ref.ID == except {
+			continue
+		}
+		if _, err := doc.Ref.Delete(ctx); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
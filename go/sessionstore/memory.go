@@ -0,0 +1,91 @@
+package sessionstore
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// how often expired sessions are dropped from memory
+const sweepInterval = time.Minute
+
+// MemoryBackend keeps sessions in process memory. for local development and
+// single instance setups, sessions are lost on restart.
+type MemoryBackend struct {
+	mu        sync.Mutex
+	records   map[string]Record
+	lastSweep time.Time
+}
+
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{records: map[string]Record{}}
+}
+
+func (m *MemoryBackend) Load(ctx context.Context, id string) (*Record, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rec, ok := m.records[id]
+	if !ok {
+		return nil, nil
+	}
+	if time.Now().After(rec.ExpiresAt) {
+		delete(m.records, id)
+		return nil, nil
+	}
+	return &rec, nil
+}
+
+func (m *MemoryBackend) Save(ctx context.Context, rec *Record) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if now := time.Now(); now.Sub(m.lastSweep) > sweepInterval {
+		m.sweep(now)
+	}
+	m.records[rec.ID] = *rec
+	return nil
+}
+
+// sweep drops the expired sessions, nobody can load them anymore
+func (m *MemoryBackend) sweep(now time.Time) {
+	for id, rec := range m.records {
+		if now.After(rec.ExpiresAt) {
+			delete(m.records, id)
+		}
+	}
+	m.lastSweep = now
+}
+
+func (m *MemoryBackend) Delete(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.records, id)
+	return nil
+}
+
+func (m *MemoryBackend) List(ctx context.Context, uid string) ([]*Record, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := []*Record{}
+	for _, rec := range m.records {
+		if rec.UID == uid {
+			rec := rec
+			out = append(out, &rec)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].LastSeen.After(out[j].LastSeen) })
+	return out, nil
+}
+
+func (m *MemoryBackend) DeleteAll(ctx context.Context, uid, except string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	for id, rec := range m.records {
+		if rec.UID == uid && id != except {
+			delete(m.records, id)
+			n++
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,303 @@
+// Package sessionstore keeps sessions on the server. the cookie only carries
+// a signed, random session ID; the values, device, IP and last-seen time
+// live in a Backend so sessions can be listed and revoked.
+package sessionstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/securecookie"
+	gsessions "github.com/gorilla/sessions"
+
+	"calple/util"
+)
+
+// lastSeen is only written back when it is older than this, so reads don't
+// turn every request into a write
+const touchInterval = 5 * time.Minute
+
+// session value holding the signed in user, set by the auth handlers
+const uidKey = "user_id"
+
+// Record is one server-side session. ID is the hash of the cookie token, so
+// a leaked record can't be replayed as a cookie.
+type Record struct {
+	ID        string    `firestore:"id" json:"id"`
+	UID       string    `firestore:"uid" json:"-"`
+	Values    []byte    `firestore:"values" json:"-"`
+	UserAgent string    `firestore:"userAgent" json:"userAgent"`
+	Device    string    `firestore:"device" json:"device"`
+	IP        string    `firestore:"ip" json:"ip"`
+	CreatedAt time.Time `firestore:"createdAt" json:"createdAt"`
+	LastSeen  time.Time `firestore:"lastSeen" json:"lastSeen"`
+	ExpiresAt time.Time `firestore:"expiresAt" json:"expiresAt"`
+}
+
+// Backend persists session records
+type Backend interface {
+	// Load returns nil, nil when the session doesn't exist
+	Load(ctx context.Context, id string) (*Record, error)
+	Save(ctx context.Context, rec *Record) error
+	Delete(ctx context.Context, id string) error
+	// List returns the sessions of a user, newest activity first
+	List(ctx context.Context, uid string) ([]*Record, error)
+	// DeleteAll removes every session of a user except the one with ID except
+	DeleteAll(ctx context.Context, uid, except string) (int, error)
+}
+
+// Store implements sessions.Store on top of a Backend
+type Store struct {
+	backend Backend
+	codecs  []securecookie.Codec
+	options *gsessions.Options
+}
+
+// NewStore creates a store. keys sign the session ID cookie: the first key
+// signs new cookies, the others are still accepted so SECRET_KEY can be
+// rotated without logging everybody out.
+func NewStore(backend Backend, keys ...[]byte) *Store {
+	codecs := make([]securecookie.Codec, 0, len(keys))
+	for _, key := range keys {
+		codecs = append(codecs, securecookie.New(key, nil))
+	}
+	return &Store{
+		backend: backend,
+		codecs:  codecs,
+		options: &gsessions.Options{Path: "/", MaxAge: 12 * 60 * 60},
+	}
+}
+
+func (s *Store) Backend() Backend { return s.backend }
+
+func (s *Store) Options(options sessions.Options) {
+	s.options = options.ToGorillaOptions()
+}
+
+// Get returns the session for the request, cached for the request's lifetime
+func (s *Store) Get(r *http.Request, name string) (*gsessions.Session, error) {
+	return gsessions.GetRegistry(r).Get(s, name)
+}
+
+// New loads the session named by the cookie, or starts an empty one when
+// there is no cookie or the session was revoked or expired
+func (s *Store) New(r *http.Request, name string) (*gsessions.Session, error) {
+	session := gsessions.NewSession(s, name)
+	opts := *s.options
+	session.Options = &opts
+	session.IsNew = true
+
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+	var token string
+	if err := securecookie.DecodeMulti(name, cookie.Value, &token, s.codecs...); err != nil {
+		// tampered with or signed by a key that was rotated out
+		return session, nil
+	}
+
+	ctx := r.Context()
+	rec, err := s.backend.Load(ctx, PublicID(token))
+	if err != nil {
+		return session, err
+	}
+	if rec == nil {
+		return session, nil
+	}
+	now := time.Now()
+	if now.After(rec.ExpiresAt) {
+		s.backend.Delete(ctx, rec.ID)
+		return session, nil
+	}
+	if err := decodeValues(rec.Values, &session.Values); err != nil {
+		return session, nil
+	}
+	session.ID = token
+	session.IsNew = false
+
+	ip, ua := clientIP(r), r.UserAgent()
+	if now.Sub(rec.LastSeen) > touchInterval || rec.IP != ip || rec.UserAgent != ua {
+		rec.LastSeen = now
+		rec.IP = ip
+		rec.UserAgent = ua
+		rec.Device = DeviceName(ua)
+		s.backend.Save(ctx, rec)
+	}
+	return session, nil
+}
+
+// Save writes the session to the backend and sets the ID cookie. a session
+// saved with no values (session.Clear() on logout) or a negative MaxAge is
+// deleted on the server and the cookie is expired.
+func (s *Store) Save(r *http.Request, w http.ResponseWriter, session *gsessions.Session) error {
+	ctx := r.Context()
+
+	if session.Options.MaxAge < 0 || len(session.Values) == 0 {
+		if session.ID != "" {
+			if err := s.backend.Delete(ctx, PublicID(session.ID)); err != nil {
+				return err
+			}
+		}
+		opts := *session.Options
+		opts.MaxAge = -1
+		http.SetCookie(w, gsessions.NewCookie(session.Name(), "", &opts))
+		return nil
+	}
+
+	var existing *Record
+	if session.ID != "" {
+		var err error
+		existing, err = s.backend.Load(ctx, PublicID(session.ID))
+		if err != nil {
+			return err
+		}
+	}
+
+	uid, _ := session.Values[uidKey].(string)
+	// new session, revoked in the meantime, or the user changed (login):
+	// issue a fresh ID so a pre-login cookie can't be fixated
+	if existing == nil || existing.UID != uid {
+		if existing != nil {
+			if err := s.backend.Delete(ctx, existing.ID); err != nil {
+				return err
+			}
+		}
+		token, err := util.RandomToken(32)
+		if err != nil {
+			return err
+		}
+		session.ID = token
+		existing = nil
+	}
+
+	values, err := encodeValues(session.Values)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	ua := r.UserAgent()
+	rec := &Record{
+		ID:        PublicID(session.ID),
+		UID:       uid,
+		Values:    values,
+		UserAgent: ua,
+		Device:    DeviceName(ua),
+		IP:        clientIP(r),
+		CreatedAt: now,
+		LastSeen:  now,
+		ExpiresAt: now.Add(time.Duration(session.Options.MaxAge) * time.Second),
+	}
+	if session.Options.MaxAge == 0 {
+		// browser session cookie, still expire it on the server eventually
+		rec.ExpiresAt = now.Add(24 * time.Hour)
+	}
+	if existing != nil {
+		rec.CreatedAt = existing.CreatedAt
+	}
+	if err := s.backend.Save(ctx, rec); err != nil {
+		return err
+	}
+
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.codecs...)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, gsessions.NewCookie(session.Name(), encoded, session.Options))
+	session.IsNew = false
+	return nil
+}
+
+// PublicID is the ID a session is stored and listed under
+func PublicID(token string) string {
+	return util.HashToken(token)
+}
+
+// CurrentID returns the public ID of the session attached to the request
+func CurrentID(session sessions.Session) string {
+	if session.ID() == "" {
+		return ""
+	}
+	return PublicID(session.ID())
+}
+
+func encodeValues(values map[interface{}]interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(values); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeValues(data []byte, values *map[interface{}]interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(values)
+}
+
+type clientIPKey struct{}
+
+// ClientIP passes the client IP gin resolved to the store. gin only takes
+// X-Forwarded-For from the trusted proxies, so the recorded IP can't be
+// made up by the client. it has to run before sessions.Sessions.
+func ClientIP() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), clientIPKey{}, c.ClientIP()))
+		c.Next()
+	}
+}
+
+// clientIP is the IP ClientIP resolved, the peer address without it
+func clientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey{}).(string); ok {
+		return ip
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// DeviceName turns a user agent into something like "Chrome on macOS"
+func DeviceName(ua string) string {
+	browser := "Unknown browser"
+	switch {
+	case strings.Contains(ua, "Edg/"):
+		browser = "Edge"
+	case strings.Contains(ua, "OPR/"):
+		browser = "Opera"
+	case strings.Contains(ua, "Firefox/"):
+		browser = "Firefox"
+	case strings.Contains(ua, "Chrome/"):
+		browser = "Chrome"
+	case strings.Contains(ua, "Safari/"):
+		browser = "Safari"
+	case ua != "":
+		browser = strings.Split(ua, "/")[0]
+	}
+
+	platform := ""
+	switch {
+	case strings.Contains(ua, "iPhone"), strings.Contains(ua, "iPad"):
+		platform = "iOS"
+	case strings.Contains(ua, "Android"):
+		platform = "Android"
+	case strings.Contains(ua, "Mac OS X"):
+		platform = "macOS"
+	case strings.Contains(ua, "Windows"):
+		platform = "Windows"
+	case strings.Contains(ua, "Linux"):
+		platform = "Linux"
+	}
+	if platform == "" {
+		return browser
+	}
+	return browser + " on " + platform
+}
@@ -0,0 +1,200 @@
+package telemetry
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"path"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	pb "cloud.google.com/go/firestore/apiv1/firestorepb"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// FirestoreOptions instrument a Firestore client: every RPC is counted,
+// timed and traced with its operation and collection
+func FirestoreOptions() []option.ClientOption {
+	return []option.ClientOption{
+		option.WithGRPCDialOption(grpc.WithChainUnaryInterceptor(unaryInterceptor)),
+		option.WithGRPCDialOption(grpc.WithChainStreamInterceptor(streamInterceptor)),
+	}
+}
+
+func unaryInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	op, collection := path.Base(method), requestCollection(req)
+	ctx, span := startFirestoreSpan(ctx, op, collection)
+	start := time.Now()
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	finishFirestoreOp(span, op, collection, start, err)
+	return err
+}
+
+// streamed RPCs (RunQuery, BatchGetDocuments) finish when the stream ends,
+// the first request message names the collection
+func streamInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	stream, err := streamer(ctx, desc, cc, method, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &instrumentedStream{ClientStream: stream, ctx: ctx, op: path.Base(method)}, nil
+}
+
+type instrumentedStream struct {
+	grpc.ClientStream
+	ctx        context.Context
+	op         string
+	collection string
+	span       trace.Span
+	start      time.Time
+	done       bool
+}
+
+func (s *instrumentedStream) SendMsg(m interface{}) error {
+	if s.span == nil {
+		s.collection = requestCollection(m)
+		_, s.span = startFirestoreSpan(s.ctx, s.op, s.collection)
+		s.start = time.Now()
+	}
+	return s.ClientStream.SendMsg(m)
+}
+
+func (s *instrumentedStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err != nil && s.span != nil && !s.done {
+		s.done = true
+		if err == io.EOF {
+			finishFirestoreOp(s.span, s.op, s.collection, s.start, nil)
+		} else {
+			finishFirestoreOp(s.span, s.op, s.collection, s.start, err)
+		}
+	}
+	return err
+}
+
+func startFirestoreSpan(ctx context.Context, op, collection string) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, "firestore."+op,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "firestore"),
+			attribute.String("db.operation.name", op),
+			attribute.String("db.collection.name", collection),
+		),
+	)
+}
+
+func finishFirestoreOp(span trace.Span, op, collection string, start time.Time, err error) {
+	code := status.Code(err)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(otelcodes.Error, code.String())
+	}
+	span.End()
+	firestoreOps.WithLabelValues(op, collection, code.String()).Inc()
+	firestoreDuration.WithLabelValues(op, collection).Observe(time.Since(start).Seconds())
+}
+
+// requestCollection names the collection a request touches, by its last
+// collection ID so users/{uid}/connections is "connections". writes to
+// several collections are labeled "mixed".
+func requestCollection(req interface{}) string {
+	switch r := req.(type) {
+	case *pb.GetDocumentRequest:
+		return docCollection(r.GetName())
+	case *pb.UpdateDocumentRequest:
+		return docCollection(r.GetDocument().GetName())
+	case *pb.DeleteDocumentRequest:
+		return docCollection(r.GetName())
+	case *pb.CreateDocumentRequest:
+		return r.GetCollectionId()
+	case *pb.ListDocumentsRequest:
+		return r.GetCollectionId()
+	case *pb.BatchGetDocumentsRequest:
+		if len(r.GetDocuments()) > 0 {
+			return docCollection(r.GetDocuments()[0])
+		}
+	case *pb.RunQueryRequest:
+		return queryCollection(r.GetStructuredQuery())
+	case *pb.RunAggregationQueryRequest:
+		return queryCollection(r.GetStructuredAggregationQuery().GetStructuredQuery())
+	case *pb.CommitRequest:
+		return writesCollection(r.GetWrites())
+	case *pb.BatchWriteRequest:
+		return writesCollection(r.GetWrites())
+	}
+	return "none"
+}
+
+func queryCollection(q *pb.StructuredQuery) string {
+	if from := q.GetFrom(); len(from) > 0 {
+		return from[0].GetCollectionId()
+	}
+	return "none"
+}
+
+func writesCollection(writes []*pb.Write) string {
+	collection := "none"
+	for i, w := range writes {
+		name := w.GetUpdate().GetName()
+		if name == "" {
+			name = w.GetDelete()
+		}
+		if name == "" {
+			name = w.GetTransform().GetDocument()
+		}
+		c := docCollection(name)
+		if i > 0 && c != collection {
+			return "mixed"
+		}
+		collection = c
+	}
+	return collection
+}
+
+// docCollection takes projects/p/databases/d/documents/users/u1/connections/c1
+func docCollection(name string) string {
+	_, rel, ok := strings.Cut(name, "/documents/")
+	if !ok {
+		return "none"
+	}
+	parts := strings.Split(rel, "/")
+	if len(parts) < 2 {
+		return "none"
+	}
+	return parts[len(parts)-2]
+}
+
+// TrackActiveCouples refreshes the active couples gauge from a count query
+// every interval until ctx is done. each couple has two connection docs.
+// the query needs the collection group index on connections.status.
+func TrackActiveCouples(ctx context.Context, fsClient *firestore.Client, interval time.Duration) {
+	refresh := func() {
+		q := fsClient.CollectionGroup("connections").Where("status", "==", "active")
+		res, err := q.NewAggregationQuery().WithCount("n").Get(ctx)
+		if err != nil {
+			slog.Warn("failed to count active couples", "error", err)
+			return
+		}
+		if n, ok := res["n"].(*pb.Value); ok {
+			activeCouples.Set(float64(n.GetIntegerValue() / 2))
+		}
+	}
+	refresh()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			refresh()
+		}
+	}
+}
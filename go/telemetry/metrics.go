@@ -0,0 +1,63 @@
+// Package telemetry exposes Prometheus metrics and OpenTelemetry traces for
+// the HTTP handlers and the Firestore calls they make.
+package telemetry
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// latency buckets from 5ms to 10s, firestore calls are in the lower half
+var latencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "calple_http_requests_total",
+		Help: "HTTP requests by route, method and status code.",
+	}, []string{"method", "route", "status"})
+
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "calple_http_request_duration_seconds",
+		Help:    "HTTP request latency by route and method.",
+		Buckets: latencyBuckets,
+	}, []string{"method", "route"})
+
+	httpInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "calple_http_requests_in_flight",
+		Help: "HTTP requests currently being served.",
+	})
+
+	firestoreOps = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "calple_firestore_operations_total",
+		Help: "Firestore RPCs by operation, collection and gRPC status code.",
+	}, []string{"op", "collection", "code"})
+
+	firestoreDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "calple_firestore_operation_duration_seconds",
+		Help:    "Firestore RPC latency by operation and collection.",
+		Buckets: latencyBuckets,
+	}, []string{"op", "collection"})
+)
+
+// business metrics, counted by the handlers
+var (
+	CheckinsCreated = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "calple_checkins_created_total",
+		Help: "Daily check-ins created. increase() over a day gives check-ins per day.",
+	})
+
+	DDaysCreated = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "calple_ddays_created_total",
+		Help: "D-day events created.",
+	})
+
+	EventStreams = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "calple_event_streams",
+		Help: "Open live update streams.",
+	})
+
+	activeCouples = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "calple_active_couples",
+		Help: "Connections in the active state, refreshed periodically.",
+	})
+)
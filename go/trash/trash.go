@@ -0,0 +1,124 @@
+// Package trash soft deletes documents: deleting sets deletedAt and
+// deletedBy, restoring removes them, and the purge deletes what has been
+// in the trash longer than the retention for good.
+package trash
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+// Delete marks a document as trashed by uid
+func Delete(uid string, now time.Time) []firestore.Update {
+	return []firestore.Update{
+		{Path: "deletedAt", Value: now},
+		{Path: "deletedBy", Value: uid},
+	}
+}
+
+// Restore takes a document out of the trash
+func Restore() []firestore.Update {
+	return []firestore.Update{
+		{Path: "deletedAt", Value: firestore.Delete},
+		{Path: "deletedBy", Value: firestore.Delete},
+	}
+}
+
+// Trashed reports whether the document data is in the trash
+func Trashed(data map[string]interface{}) bool {
+	t, ok := data["deletedAt"].(time.Time)
+	return ok && !t.IsZero()
+}
+
+// Live drops the trashed documents
+func Live(docs []*firestore.DocumentSnapshot) []*firestore.DocumentSnapshot {
+	out := make([]*firestore.DocumentSnapshot, 0, len(docs))
+	for _, doc := range docs {
+		if !Trashed(doc.Data()) {
+			out = append(out, doc)
+		}
+	}
+	return out
+}
+
+// Of lists the documents of q that are in the trash, latest first. the
+// query needs an index on deletedAt with the fields it filters on.
+func Of(ctx context.Context, q firestore.Query) ([]*firestore.DocumentSnapshot, error) {
+	return q.Where("deletedAt", ">", time.Time{}).OrderBy("deletedAt", firestore.Desc).Documents(ctx).GetAll()
+}
+
+// purged are the collections the purge goes through, with the
+// subcollections that go with each document. pins, check-ins and posts
+// live under users and need collection group indexes on deletedAt.
+var purged = []struct {
+	name     string
+	query    func(*firestore.Client) firestore.Query
+	children []string
+}{
+	{"ddays", func(fs *firestore.Client) firestore.Query { return fs.Collection("ddays").Query }, []string{"revisions"}},
+	{"ideas", func(fs *firestore.Client) firestore.Query { return fs.Collection("ideas").Query }, nil},
+	{"posts", func(fs *firestore.Client) firestore.Query { return fs.CollectionGroup("posts").Query }, nil},
+	{"pins", func(fs *firestore.Client) firestore.Query { return fs.CollectionGroup("pins").Query }, nil},
+	{"checkins", func(fs *firestore.Client) firestore.Query { return fs.CollectionGroup("checkins").Query }, nil},
+}
+
+// Purge deletes every document trashed before cutoff and returns how many
+func Purge(ctx context.Context, fsClient *firestore.Client, cutoff time.Time) (int, error) {
+	n := 0
+	for _, c := range purged {
+		docs, err := c.query(fsClient).Where("deletedAt", "<", cutoff).Documents(ctx).GetAll()
+		if err != nil {
+			return n, err
+		}
+		bw := fsClient.BulkWriter(ctx)
+		for _, doc := range docs {
+			refs := []*firestore.DocumentRef{doc.Ref}
+			for _, child := range c.children {
+				children, err := doc.Ref.Collection(child).DocumentRefs(ctx).GetAll()
+				if err != nil {
+					bw.End()
+					return n, err
+				}
+				refs = append(refs, children...)
+			}
+			for _, ref := range refs {
+				if _, err := bw.Delete(ref); err != nil {
+					bw.End()
+					return n, err
+				}
+			}
+		}
+		bw.End()
+		n += len(docs)
+	}
+	return n, nil
+}
+
+// Run purges what was trashed more than retention ago every interval until
+// ctx is done
+func Run(ctx context.Context, fsClient *firestore.Client, retention, interval time.Duration) {
+	purge := func() {
+		n, err := Purge(ctx, fsClient, time.Now().Add(-retention))
+		if err != nil {
+			slog.Warn("failed to purge the trash", "error", err)
+			return
+		}
+		if n > 0 {
+			slog.Info("purged the trash", "documents", n)
+		}
+	}
+	purge()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			purge()
+		}
+	}
+}
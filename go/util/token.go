@@ -0,0 +1,61 @@
+package util
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+)
+
+// alphabet for short codes, without look-alike characters (0/O, 1/I/L)
+const CodeAlphabet = "ABCDEFGHJKMNPQRSTUVWXYZ23456789"
+
+// RandomString returns n characters picked from alphabet using crypto/rand
+func RandomString(n int, alphabet string) (string, error) {
+	// reject bytes past the last full multiple of the alphabet size
+	// so every character is equally likely
+	limit := 256 - 256%len(alphabet)
+	out := make([]byte, 0, n)
+	buf := make([]byte, n)
+	for len(out) < n {
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+		for _, b := range buf {
+			if int(b) < limit && len(out) < n {
+				out = append(out, alphabet[int(b)%len(alphabet)])
+			}
+		}
+	}
+	return string(out), nil
+}
+
+// RandomToken returns a url-safe random token with n bytes of entropy
+func RandomToken(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// Sign returns the url-safe HMAC-SHA256 signature of payload
+func Sign(secret []byte, payload string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks a signature produced by Sign in constant time
+func Verify(secret []byte, payload, sig string) bool {
+	expected := Sign(secret, payload)
+	return hmac.Equal([]byte(expected), []byte(sig))
+}
+
+// HashToken returns the hex sha256 of a token, used as a lookup key
+// so raw tokens never need to be stored
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -1,5 +1,10 @@
 package util
 
+import (
+	"strings"
+	"time"
+)
+
 type StringSlice []string
 
 func Contains(slice []string, s string) bool {
@@ -31,9 +36,11 @@ func ToStringSlice(i interface{}) []string {
 		return nil
 	}
 
-	out := make([]string, len(arr))
-	for idx, val := range arr {
-		out[idx] = val.(string)
+	out := make([]string, 0, len(arr))
+	for _, val := range arr {
+		if str, ok := val.(string); ok {
+			out = append(out, str)
+		}
 	}
 
 	return out
@@ -64,6 +71,11 @@ func IsValidEmail(email string) bool {
 	return false
 }
 
+// NormalizeEmail is the form emails are compared, hashed and stored in
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
 // getStringValue safely extracts a string value from a map, returning empty string if not found or nil
 func GetStringValue(data map[string]interface{}, key string) string {
 	if val, exists := data[key]; exists && val != nil {
@@ -74,6 +86,29 @@ func GetStringValue(data map[string]interface{}, key string) string {
 	return ""
 }
 
+// GetBoolValue safely extracts a bool value from a map, false if missing or not a bool
+func GetBoolValue(data map[string]interface{}, key string) bool {
+	v, _ := data[key].(bool)
+	return v
+}
+
+// GetInt64Value safely extracts an integer value from a map, 0 if missing or not an integer
+func GetInt64Value(data map[string]interface{}, key string) int64 {
+	switch v := data[key].(type) {
+	case int64:
+		return v
+	case float64:
+		return int64(v)
+	}
+	return 0
+}
+
+// GetTimeValue safely extracts a timestamp from a map, the zero time if missing
+func GetTimeValue(data map[string]interface{}, key string) time.Time {
+	v, _ := data[key].(time.Time)
+	return v
+}
+
 // getMapKeys returns a slice of keys from a map
 func GetMapKeys(data map[string]interface{}) []string {
 	keys := make([]string, 0, len(data))